@@ -3,12 +3,24 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
 	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+	_ "time/tzdata" // TIMEZONE works in images without zoneinfo
 
 	"github.com/mallocator/domain-checker/pkg/config"
 	"github.com/mallocator/domain-checker/pkg/dns"
 	"github.com/mallocator/domain-checker/pkg/domain"
 	"github.com/mallocator/domain-checker/pkg/logger"
+	"github.com/mallocator/domain-checker/pkg/metrics"
 	"github.com/mallocator/domain-checker/pkg/notify"
 	"github.com/mallocator/domain-checker/pkg/state"
 	"github.com/mallocator/domain-checker/pkg/whois"
@@ -18,34 +30,351 @@ func main() {
 	// Initialize logger
 	log := logger.New()
 
+	// Parse command line flags, they take precedence over the config file and env
+	flags := parseFlags(os.Args[1:])
+	if flags.version {
+		fmt.Printf("domain-checker %s\n", versionString())
+		return
+	}
+	if flags.printConfigSchema {
+		schema, err := config.Schema()
+		if err != nil {
+			log.Fatalf("Failed to build the config schema: %v", err)
+		}
+		fmt.Println(string(schema))
+		return
+	}
+	if flags.debug {
+		log.SetDebug(true)
+	}
+
 	// Initialize configuration
+	configFile := os.Getenv("CONFIG_FILE")
+	if flags.configFile != "" {
+		configFile = flags.configFile
+	}
 	cfg := config.New(log)
-	if err := cfg.LoadFromFile(os.Getenv("CONFIG_FILE")); err != nil {
+	if err := cfg.LoadFromFile(configFile); err != nil {
 		log.Fatalf("Failed to load config file: %v", err)
 	}
 	cfg.LoadFromEnv()
+	flags.apply(cfg)
+	if err := cfg.LoadSecretFiles(); err != nil {
+		log.Fatalf("Failed to load secret files: %v", err)
+	}
+
+	// Send a test message through each notification channel and exit without checking domains
+	if flags.testNotify {
+		if err := cfg.ValidateSettings(); err != nil {
+			log.Fatalf("Invalid configuration: %v", err)
+		}
+		if err := testNotify(cfg, log); err != nil {
+			log.Fatalf("%v", err)
+		}
+		return
+	}
+
+	// Check a single domain and print the result, without state or notifications
+	if flags.set["check"] {
+		if err := cfg.ValidateSettings(); err != nil {
+			log.Fatalf("Invalid configuration: %v", err)
+		}
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		if err := checkOne(ctx, cfg, log, flags.check, os.Stdout); err != nil {
+			log.Fatalf("%v", err)
+		}
+		return
+	}
+
+	if err := cfg.LoadDomainsFile(); err != nil {
+		log.Fatalf("Failed to load domains file: %v", err)
+	}
+	if err := cfg.Validate(); err != nil {
+		log.Fatalf("Invalid configuration: %v", err)
+	}
+
+	// Cancelled on SIGINT/SIGTERM so running checks can wind down
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// Restore default signal handling once shutdown starts, so a second signal exits immediately
+	context.AfterFunc(ctx, stop)
+
+	// Serve metrics for the lifetime of the process
+	var metricsServer *metrics.Server
+	if cfg.MetricsAddr != "" {
+		metricsServer = metrics.New(cfg, log)
+		if _, err := metricsServer.Start(ctx); err != nil {
+			log.Fatalf("Failed to start metrics server: %v", err)
+		}
+	}
+
+	// Clean up state files, unless this is an ad-hoc check of domains given on the command line
+	cleanup := !flags.set["domains"]
+	if !cleanup {
+		log.Debugf("Skipping state cleanup for domains given on the command line")
+	}
 
+	// Without an interval, check once and exit
+	if cfg.CheckInterval <= 0 {
+		if err := runChecks(ctx, cfg, log, cleanup); err != nil {
+			log.Fatalf("%v", err)
+		}
+		return
+	}
+
+	// Pick up config file changes from the next cycle on
+	var mu sync.Mutex
+	current := cfg
+	if configFile != "" && configFile != config.StdinPath {
+		err := cfg.Watch(ctx, flags.apply, func(next *config.Config) {
+			mu.Lock()
+			current = next
+			mu.Unlock()
+		})
+		if err != nil {
+			log.Warnf("Not watching config file for changes: %v", err)
+		}
+	}
+
+	log.Infof("Running as a daemon, checking every %s", cfg.CheckInterval)
+	for {
+		mu.Lock()
+		cycleCfg := current
+		mu.Unlock()
+
+		// The health check expects the next cycle within the interval this one waits for afterwards
+		if metricsServer != nil {
+			metricsServer.SetConfig(cycleCfg)
+		}
+
+		start := time.Now()
+		log.Infof("Starting check cycle")
+		if err := runChecks(ctx, cycleCfg, log, cleanup); err != nil {
+			log.Errorf("Check cycle failed: %v", err)
+		}
+		log.Infof("Check cycle finished in %s", time.Since(start).Round(time.Millisecond))
+
+		select {
+		case <-ctx.Done():
+			log.Infof("Shutting down")
+			return
+		case <-time.After(cycleCfg.CheckInterval):
+		}
+	}
+}
+
+// runChecks performs a single pass over all configured domains
+func runChecks(ctx context.Context, cfg *config.Config, log *logger.Logger, cleanup bool) error {
 	// Ensure state directory exists
 	if err := os.MkdirAll(cfg.StateDir, 0755); err != nil {
-		log.Fatalf("Failed to create state directory: %v", err)
+		return fmt.Errorf("failed to create state directory: %w", err)
 	}
 
 	// Initialize components
-	stateManager := state.New(cfg, log)
+	stateManager, err := state.Open(cfg, log)
+	if err != nil {
+		return fmt.Errorf("failed to open state backend: %w", err)
+	}
+	defer func() {
+		if err := stateManager.Close(); err != nil {
+			log.Warnf("Failed to close state backend: %v", err)
+		}
+	}()
 	dnsChecker := dns.New(cfg, log)
 	whoisChecker := whois.New(cfg, log)
 	notifier := notify.New(cfg, log)
-
-	// Clean up state files
-	stateManager.Cleanup()
+	notifier.SetContext(ctx)
 
 	// Initialize domain processor
 	processor := domain.New(cfg, log, dnsChecker, whoisChecker, notifier, stateManager)
 
-	log.Infof("Starting domain checker with %d domains", len(cfg.Domains))
+	// Clean up state files
+	if cleanup {
+		processor.Cleanup()
+	}
+
+	log.Infof("Starting domain checker %s with %d domains", versionString(), len(cfg.Domains))
 
 	// Process all domains
-	processor.ProcessAll()
+	report, checkErr := processor.ProcessAll(ctx)
+
+	if cfg.ReportFile != "" {
+		if err := report.WriteFile(cfg.ReportFile); err != nil {
+			log.Errorf("Failed to write report: %v", err)
+		} else {
+			log.Infof("Report written to %s", cfg.ReportFile)
+		}
+	}
+
+	// Send the digest if notifications were batched, and those held during quiet hours once they're over
+	// Notifications that fail are kept and retried by the next run
+	flushErr := notifier.Flush()
 
-	log.Infof("Domain checking completed")
+	log.Infof("Domain checking completed: %s", report.Summary())
+	var errs []error
+	if checkErr != nil {
+		errs = append(errs, fmt.Errorf("domain checks failed:\n%w", checkErr))
+	}
+	if flushErr != nil {
+		errs = append(errs, fmt.Errorf("failed to send held or batched notifications: %w", flushErr))
+	}
+	return errors.Join(errs...)
+}
+
+// testNotify sends a test message through every configured notification channel and logs each outcome
+// Returns an error if no channel is configured or any of them failed
+func testNotify(cfg *config.Config, log *logger.Logger) error {
+	results := notify.New(cfg, log).Test()
+	if len(results) == 0 {
+		return errors.New("no notification channels configured")
+	}
+
+	failed := 0
+	for _, res := range results {
+		if res.Err != nil {
+			failed++
+			log.Errorf("Test notification via %s failed: %v", res.Channel, res.Err)
+			continue
+		}
+		log.Infof("Test notification via %s succeeded", res.Channel)
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d notification channels failed", failed, len(results))
+	}
+	return nil
+}
+
+// checkOne looks up a single domain and prints what was found to w
+// The configured domains, state and notification channels aren't used
+func checkOne(ctx context.Context, cfg *config.Config, log *logger.Logger, name string, w io.Writer) error {
+	processor := domain.New(cfg, log, dns.New(cfg, log), whois.New(cfg, log), nil, nil)
+	res, err := processor.CheckDomain(ctx, name)
+	if res.Source != "" { // nothing to show unless a lookup answered
+		printResult(w, res)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to check %s: %w", res.Domain, err)
+	}
+	return nil
+}
+
+// printResult writes a human-readable summary of a single check
+func printResult(w io.Writer, res domain.Result) {
+	switch {
+	case res.Available:
+		_, _ = fmt.Fprintf(w, "%s: available\n", res.Domain)
+	case res.Source == domain.SourceCert && res.DaysLeft != nil:
+		_, _ = fmt.Fprintf(w, "%s: registered, expiration unknown\n", res.Domain)
+		_, _ = fmt.Fprintf(w, "  TLS cert: %s (%d days left)\n", res.Expiration.Format(time.DateOnly), *res.DaysLeft)
+	case res.DaysLeft != nil && *res.DaysLeft < 0:
+		_, _ = fmt.Fprintf(w, "%s: expired\n", res.Domain)
+		_, _ = fmt.Fprintf(w, "  Expired:  %s (%d days ago)\n", res.Expiration.Format(time.DateOnly), -*res.DaysLeft)
+	case res.DaysLeft != nil:
+		_, _ = fmt.Fprintf(w, "%s: registered\n", res.Domain)
+		_, _ = fmt.Fprintf(w, "  Expires:  %s (%d days left)\n", res.Expiration.Format(time.DateOnly), *res.DaysLeft)
+	default:
+		_, _ = fmt.Fprintf(w, "%s: registered, expiration unknown\n", res.Domain)
+	}
+	if len(res.Statuses) > 0 {
+		_, _ = fmt.Fprintf(w, "  Statuses: %s\n", strings.Join(res.Statuses, ", "))
+	}
+	if res.Source != "" {
+		_, _ = fmt.Fprintf(w, "  Source:   %s\n", res.Source)
+	}
+}
+
+// cliFlags holds the command line options
+type cliFlags struct {
+	configFile    string
+	domains       string
+	thresholdDays int
+	stateDir      string
+	concurrency   int
+	interval      time.Duration
+	reportFile    string
+	dryRun        bool
+	force         bool
+	forceWhois    bool
+	testNotify    bool
+	check         string
+	debug         bool
+	version       bool
+
+	printConfigSchema bool
+
+	// Names of the flags that were given, so explicit zero values still apply
+	set map[string]bool
+}
+
+// parseFlags parses the command line, exiting with usage on invalid flags
+func parseFlags(args []string) *cliFlags {
+	f := &cliFlags{set: make(map[string]bool)}
+	fs := flag.NewFlagSet("domain-checker", flag.ExitOnError)
+	fs.Usage = func() {
+		out := fs.Output()
+		_, _ = fmt.Fprintf(out, "Usage: domain-checker [flags]\n\n")
+		_, _ = fmt.Fprintf(out, "Settings are applied in this order, later ones win:\n")
+		_, _ = fmt.Fprintf(out, "  1. built-in defaults\n  2. config file (-config or CONFIG_FILE)\n  3. environment variables\n  4. command line flags\n\n")
+		_, _ = fmt.Fprintf(out, "Flags:\n")
+		fs.PrintDefaults()
+	}
+
+	fs.StringVar(&f.configFile, "config", "", "JSON or YAML config file, or a comma-separated list merged in order; - reads it from stdin; overrides CONFIG_FILE")
+	fs.StringVar(&f.domains, "domains", "", "comma separated domains to check instead of the configured ones (skips state cleanup)")
+	fs.IntVar(&f.thresholdDays, "threshold-days", 0, "days before expiry to alert, replaces any threshold tiers")
+	fs.StringVar(&f.stateDir, "state-dir", "", "directory for state files")
+	fs.IntVar(&f.concurrency, "concurrency", 0, "domains checked in parallel")
+	fs.DurationVar(&f.interval, "interval", 0, "run as a daemon, checking every interval, e.g. 6h (0 checks once), overrides CHECK_INTERVAL")
+	fs.StringVar(&f.reportFile, "report", "", "write a JSON report of each run to this file, overrides REPORT_FILE")
+	fs.BoolVar(&f.dryRun, "dry-run", false, "check domains and log the notifications that would be sent without sending them, overrides DRY_RUN")
+	fs.BoolVar(&f.force, "force", false, "query WHOIS even for domains whose stored expiration date is still ahead, overrides FORCE_RECHECK")
+	fs.BoolVar(&f.forceWhois, "force-whois", false, "like -force, also skipping the WHOIS cache, overrides FORCE_WHOIS")
+	fs.BoolVar(&f.testNotify, "test-notify", false, "send a test message through every configured notification channel and exit")
+	fs.StringVar(&f.check, "check", "", "check a single domain, print the result and exit without using state or sending notifications")
+	fs.BoolVar(&f.debug, "debug", false, "enable verbose logs")
+	fs.BoolVar(&f.version, "version", false, "print the version and exit")
+	fs.BoolVar(&f.printConfigSchema, "print-config-schema", false, "print a JSON schema of the config file with every setting, its default and env var, and exit")
+
+	_ = fs.Parse(args) // ExitOnError handles failures
+	fs.Visit(func(fl *flag.Flag) { f.set[fl.Name] = true })
+	return f
+}
+
+// apply overrides the config with the flags that were given
+func (f *cliFlags) apply(cfg *config.Config) {
+	if f.set["domains"] {
+		// The list replaces configured domains entirely, including any domains file
+		cfg.Domains = nil
+		cfg.DomainsFile = ""
+		for _, d := range strings.Split(f.domains, ",") {
+			cfg.Domains = append(cfg.Domains, config.DomainEntry{Name: strings.TrimSpace(d)})
+		}
+	}
+	if f.set["threshold-days"] {
+		cfg.ThresholdDays = f.thresholdDays
+		cfg.ThresholdTiers = nil
+	}
+	if f.set["state-dir"] {
+		cfg.StateDir = f.stateDir
+	}
+	if f.set["concurrency"] {
+		cfg.Concurrency = f.concurrency
+	}
+	if f.set["interval"] {
+		cfg.CheckInterval = f.interval
+	}
+	if f.set["report"] {
+		cfg.ReportFile = f.reportFile
+	}
+	if f.set["dry-run"] {
+		cfg.DryRun = f.dryRun
+	}
+	if f.set["force"] {
+		cfg.ForceRecheck = f.force
+	}
+	if f.set["force-whois"] {
+		cfg.ForceWhois = f.forceWhois
+	}
 }
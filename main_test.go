@@ -3,11 +3,20 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"path/filepath"
+	"runtime"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/mallocator/domain-checker/pkg/config"
+	"github.com/mallocator/domain-checker/pkg/domain"
 	"github.com/mallocator/domain-checker/pkg/logger"
 )
 
@@ -60,8 +69,8 @@ func TestConfigLoading(t *testing.T) {
 	if len(cfg.Domains) != 2 {
 		t.Errorf("Expected 2 domains, got %d", len(cfg.Domains))
 	}
-	if cfg.Domains[0] != "example.com" || cfg.Domains[1] != "test.org" {
-		t.Errorf("Expected domains [example.com test.org], got %v", cfg.Domains)
+	if cfg.Domains[0].Name != "example.com" || cfg.Domains[1].Name != "test.org" {
+		t.Errorf("Expected domains [example.com test.org], got %v", cfg.DomainNames())
 	}
 	if cfg.ThresholdDays != 25 {
 		t.Errorf("Expected threshold_days 25, got %d", cfg.ThresholdDays)
@@ -134,8 +143,8 @@ func TestConfigLoadingFromEnv(t *testing.T) {
 	if len(cfg.Domains) != 2 {
 		t.Errorf("Expected 2 domains, got %d", len(cfg.Domains))
 	}
-	if cfg.Domains[0] != "env1.com" || cfg.Domains[1] != "env2.com" {
-		t.Errorf("Expected domains [env1.com env2.com], got %v", cfg.Domains)
+	if cfg.Domains[0].Name != "env1.com" || cfg.Domains[1].Name != "env2.com" {
+		t.Errorf("Expected domains [env1.com env2.com], got %v", cfg.DomainNames())
 	}
 	if cfg.ThresholdDays != 15 {
 		t.Errorf("Expected threshold_days 15, got %d", cfg.ThresholdDays)
@@ -179,3 +188,186 @@ func TestStateDirectoryCreation(t *testing.T) {
 		t.Errorf("State directory was not created")
 	}
 }
+
+// TestFlags tests that command line flags override file and env settings
+func TestFlags(t *testing.T) {
+	log := logger.New()
+	cfg := config.New(log)
+	cfg.Domains = []config.DomainEntry{{Name: "example.com"}}
+	cfg.DomainsFile = "domains.txt"
+	cfg.ThresholdTiers = []int{30, 14}
+	cfg.StateDir = "/data"
+	cfg.Concurrency = 5
+
+	flags := parseFlags([]string{"-domains", "foo.com, bar.com", "-threshold-days", "0", "-state-dir", "/tmp/state", "-concurrency", "2", "-dry-run", "-force", "-force-whois", "-check", "example.org", "-debug"})
+	flags.apply(cfg)
+
+	if names := cfg.DomainNames(); len(names) != 2 || names[0] != "foo.com" || names[1] != "bar.com" {
+		t.Errorf("Expected domains [foo.com bar.com], got %v", names)
+	}
+	if cfg.DomainsFile != "" {
+		t.Errorf("Expected -domains to clear the domains file, got %s", cfg.DomainsFile)
+	}
+	if cfg.ThresholdDays != 0 || cfg.ThresholdTiers != nil {
+		t.Errorf("Expected threshold 0 without tiers, got %d and %v", cfg.ThresholdDays, cfg.ThresholdTiers)
+	}
+	if cfg.StateDir != "/tmp/state" {
+		t.Errorf("Expected state dir /tmp/state, got %s", cfg.StateDir)
+	}
+	if cfg.Concurrency != 2 {
+		t.Errorf("Expected concurrency 2, got %d", cfg.Concurrency)
+	}
+	if !cfg.DryRun {
+		t.Errorf("Expected dry run to be enabled")
+	}
+	if !cfg.ForceRecheck || !cfg.ForceWhois {
+		t.Errorf("Expected forced rechecks, got %v and %v", cfg.ForceRecheck, cfg.ForceWhois)
+	}
+	if !flags.debug {
+		t.Errorf("Expected debug to be set")
+	}
+	if !flags.set["check"] || flags.check != "example.org" {
+		t.Errorf("Expected -check example.org, got %q", flags.check)
+	}
+	if !parseFlags([]string{"-print-config-schema"}).printConfigSchema {
+		t.Errorf("Expected -print-config-schema to be set")
+	}
+
+	// Flags that aren't given leave the config alone
+	cfg = config.New(log)
+	cfg.StateDir = "/data"
+	parseFlags([]string{"-config", "config.yaml"}).apply(cfg)
+	if cfg.StateDir != "/data" || cfg.ThresholdDays != 7 {
+		t.Errorf("Expected untouched config, got state dir %s and threshold %d", cfg.StateDir, cfg.ThresholdDays)
+	}
+}
+
+// TestVersionString tests the version description of builds with and without ldflags
+func TestVersionString(t *testing.T) {
+	defer func(v, c, d string) { version, commit, date = v, c, d }(version, commit, date)
+
+	if !parseFlags([]string{"-version"}).version {
+		t.Errorf("Expected -version to be set")
+	}
+
+	version, commit, date = "v1.2.3", "0123456789abcdef", "2025-05-01T12:00:00Z"
+	want := "v1.2.3 (commit 0123456, built 2025-05-01T12:00:00Z, " + runtime.Version() + ")"
+	if got := versionString(); got != want {
+		t.Errorf("versionString() = %q, want %q", got, want)
+	}
+
+	// Test binaries carry no VCS information, so only the Go version is left
+	version, commit, date = "dev", "", ""
+	if got, want := versionString(), "dev ("+runtime.Version()+")"; got != want {
+		t.Errorf("versionString() = %q, want %q", got, want)
+	}
+}
+
+// TestTestNotify tests sending test messages through the configured channels
+func TestTestNotify(t *testing.T) {
+	log := logger.New()
+
+	ok := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer ok.Close()
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer failing.Close()
+
+	// Nothing to test without a channel
+	cfg := config.New(log)
+	if err := testNotify(cfg, log); err == nil {
+		t.Errorf("Expected an error without notification channels")
+	}
+
+	cfg.WebhookURL = ok.URL
+	if err := testNotify(cfg, log); err != nil {
+		t.Errorf("Expected the test notification to succeed, got %v", err)
+	}
+
+	cfg.WebhookURL = failing.URL
+	cfg.Retries = 1
+	if err := testNotify(cfg, log); err == nil {
+		t.Errorf("Expected an error for a failing webhook")
+	}
+
+	// Half-configured channels fail instead of being skipped
+	cfg = config.New(log)
+	cfg.SMTPHost = "smtp.example.com"
+	if err := testNotify(cfg, log); err == nil {
+		t.Errorf("Expected an error for SMTP without sender and recipient")
+	}
+}
+
+// TestPrintResult tests the summary printed by -check
+func TestPrintResult(t *testing.T) {
+	expiration := time.Date(2030, 8, 13, 4, 0, 0, 0, time.UTC)
+	left, ago := 42, -3
+
+	tests := []struct {
+		name string
+		res  domain.Result
+		want string
+	}{
+		{"available", domain.Result{Domain: "example.com", Available: true, Source: domain.SourceDNS},
+			"example.com: available\n  Source:   dns\n"},
+		{"registered", domain.Result{Domain: "example.com", Expiration: expiration, DaysLeft: &left,
+			Statuses: []string{"clientTransferProhibited", "clientDeleteProhibited"}, Source: domain.SourceWHOIS},
+			"example.com: registered\n  Expires:  2030-08-13 (42 days left)\n" +
+				"  Statuses: clientTransferProhibited, clientDeleteProhibited\n  Source:   whois\n"},
+		{"expired", domain.Result{Domain: "example.com", Expiration: expiration, DaysLeft: &ago, Source: domain.SourceWHOIS},
+			"example.com: expired\n  Expired:  2030-08-13 (3 days ago)\n  Source:   whois\n"},
+		{"no expiration", domain.Result{Domain: "example.com", Statuses: []string{"active"}, Source: domain.SourceWHOIS},
+			"example.com: registered, expiration unknown\n  Statuses: active\n  Source:   whois\n"},
+	}
+
+	for _, tc := range tests {
+		var out bytes.Buffer
+		printResult(&out, tc.res)
+		if out.String() != tc.want {
+			t.Errorf("%s: printResult() =\n%s\nwant\n%s", tc.name, out.String(), tc.want)
+		}
+	}
+}
+
+// TestCheckOne_Invalid tests that -check fails for a malformed name without any lookups
+func TestCheckOne_Invalid(t *testing.T) {
+	log := logger.New()
+	cfg := config.New(log)
+
+	var out bytes.Buffer
+	err := checkOne(context.Background(), cfg, log, "not a domain", &out)
+	if err == nil || !strings.Contains(err.Error(), "not a domain") {
+		t.Errorf("Expected an error for an invalid domain, got %v", err)
+	}
+	if out.Len() != 0 {
+		t.Errorf("Expected no result for an invalid domain, got %q", out.String())
+	}
+}
+
+// TestCheckOne_DNSFailure tests that -check prints nothing when DNS_REQUIRED and no nameserver answers
+func TestCheckOne_DNSFailure(t *testing.T) {
+	// A port nothing listens on, so the nameserver refuses the query
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Skipf("udp4 loopback not available: %v", err)
+	}
+	dead := conn.LocalAddr().String()
+	if err := conn.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	log := logger.New()
+	cfg := config.New(log)
+	cfg.DNSServers = []string{dead}
+	cfg.DNSRequired = true
+	cfg.Retries = 1
+
+	var out bytes.Buffer
+	if err := checkOne(context.Background(), cfg, log, "example.com", &out); err == nil {
+		t.Errorf("Expected an error when DNS fails")
+	}
+	if out.Len() != 0 {
+		t.Errorf("Expected no result when DNS fails, got %q", out.String())
+	}
+}
@@ -0,0 +1,56 @@
+// Package backoff computes the waits between retries for the domain checker application
+package backoff
+
+import (
+	"context"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// Jitter computes exponential backoff delays with up to half of each taken off at random,
+// to keep concurrent callers from retrying in lockstep
+// It's safe for concurrent use and has its own random source, so callers don't share the global one
+type Jitter struct {
+	mu   sync.Mutex
+	rand *rand.Rand
+}
+
+// New creates a new jitter source
+func New() *Jitter {
+	return &Jitter{rand: rand.New(rand.NewSource(time.Now().UnixNano()))}
+}
+
+// Delay returns the wait after the given failed attempt, starting at 0
+// The delay starts at initial and doubles with each attempt up to limit
+func (j *Jitter) Delay(attempt int, initial, limit time.Duration) time.Duration {
+	d := initial
+	for i := 0; i < attempt && d < limit; i++ {
+		d *= 2
+	}
+	d = min(d, limit)
+	if d <= 0 {
+		return 0
+	}
+
+	j.mu.Lock()
+	jitter := time.Duration(j.rand.Int63n(int64(d)/2 + 1))
+	j.mu.Unlock()
+	return d - jitter
+}
+
+// Sleep waits for d or until ctx is done, whichever comes first
+func Sleep(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,47 @@
+package backoff
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestDelay(t *testing.T) {
+	jitter := New()
+	initial, limit := 100*time.Millisecond, time.Second
+
+	for attempt := range 20 {
+		want := min(initial<<min(attempt, 10), limit)
+		for range 20 {
+			if d := jitter.Delay(attempt, initial, limit); d < want/2 || d > want {
+				t.Fatalf("Delay(%d) = %v, want between %v and %v", attempt, d, want/2, want)
+			}
+		}
+	}
+
+	if d := jitter.Delay(3, 0, limit); d != 0 {
+		t.Errorf("Delay() without an initial wait = %v, want 0", d)
+	}
+}
+
+func TestSleep(t *testing.T) {
+	if err := Sleep(context.Background(), time.Millisecond); err != nil {
+		t.Errorf("Sleep() returned error: %v", err)
+	}
+
+	// Cancelling returns right away
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if err := Sleep(ctx, time.Minute); err != context.DeadlineExceeded {
+		t.Errorf("Sleep() after cancel = %v, want %v", err, context.DeadlineExceeded)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Sleep() returned after %v, want right after the cancel", elapsed)
+	}
+
+	// A done context is reported even without a wait
+	if err := Sleep(ctx, 0); err != context.DeadlineExceeded {
+		t.Errorf("Sleep(0) after cancel = %v, want %v", err, context.DeadlineExceeded)
+	}
+}
@@ -0,0 +1,69 @@
+// Package cert provides TLS certificate lookups for the domain checker application
+package cert
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/mallocator/domain-checker/pkg/config"
+	"github.com/mallocator/domain-checker/pkg/idn"
+	"github.com/mallocator/domain-checker/pkg/logger"
+)
+
+// Checker handles TLS certificate lookups
+type Checker struct {
+	cfg *config.Config
+	log *logger.Logger
+
+	// Address the site of a domain is reached at, replaceable in tests
+	addr func(domain string) string
+
+	// Roots the certificates are verified against, nil for the system roots; replaceable in tests
+	rootCAs *x509.CertPool
+}
+
+// New creates a new certificate checker
+func New(cfg *config.Config, log *logger.Logger) *Checker {
+	return &Checker{
+		cfg:  cfg,
+		log:  log,
+		addr: func(domain string) string { return net.JoinHostPort(domain, "443") },
+	}
+}
+
+// Expiry connects to port 443 of a domain and returns when the leaf certificate of its site expires
+// The certificate chain is verified for the domain, so a certificate that's invalid or already expired
+// returns an error rather than a date that says nothing about the domain
+// The handshake is bounded by Timeout and connects directly, without the Proxy
+func (c *Checker) Expiry(ctx context.Context, domain string) (time.Time, error) {
+	name, err := idn.ToASCII(domain)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid domain name %q: %w", domain, err)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, c.cfg.Timeout)
+	defer cancel()
+
+	dialer := &tls.Dialer{Config: &tls.Config{ServerName: name, RootCAs: c.rootCAs}}
+	conn, err := dialer.DialContext(ctx, "tcp", c.addr(name))
+	if err != nil {
+		return time.Time{}, fmt.Errorf("TLS handshake with %s failed: %w", name, err)
+	}
+	defer func() {
+		if err := conn.Close(); err != nil {
+			c.log.Debugf("Failed to close TLS connection to %s: %v", name, err)
+		}
+	}()
+
+	certs := conn.(*tls.Conn).ConnectionState().PeerCertificates
+	if len(certs) == 0 {
+		return time.Time{}, fmt.Errorf("no certificate from %s", name)
+	}
+	leaf := certs[0]
+	c.log.Debugf("TLS certificate of %s issued by %q expires at %s", name, leaf.Issuer.CommonName, leaf.NotAfter.Format(time.RFC3339))
+	return leaf.NotAfter, nil
+}
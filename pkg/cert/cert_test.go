@@ -0,0 +1,76 @@
+package cert
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mallocator/domain-checker/pkg/config"
+	"github.com/mallocator/domain-checker/pkg/logger"
+)
+
+// newChecker returns a checker that reaches every domain at addr
+func newChecker(addr string, timeout time.Duration) *Checker {
+	log := logger.New()
+	cfg := config.New(log)
+	cfg.Timeout = timeout
+
+	c := New(cfg, log)
+	c.addr = func(string) string { return addr }
+	return c
+}
+
+// TestExpiry tests that the expiration of the leaf certificate is returned
+func TestExpiry(t *testing.T) {
+	server := httptest.NewTLSServer(http.NotFoundHandler())
+	defer server.Close()
+	c := newChecker(server.Listener.Addr().String(), 5*time.Second)
+	c.rootCAs = server.Client().Transport.(*http.Transport).TLSClientConfig.RootCAs
+
+	// The test certificate is valid for example.com
+	got, err := c.Expiry(context.Background(), "example.com")
+	if err != nil {
+		t.Fatalf("Expiry() returned error: %v", err)
+	}
+	if want := server.Certificate().NotAfter; !got.Equal(want) {
+		t.Errorf("Expected expiry %s, got %s", want, got)
+	}
+
+	// A certificate that isn't valid for the domain doesn't tell when the domain expires
+	if _, err := c.Expiry(context.Background(), "example.org"); err == nil {
+		t.Errorf("Expected an error for a certificate of another domain")
+	}
+}
+
+// TestExpiry_Timeout tests that a server that never completes the handshake is given up on after Timeout
+func TestExpiry_Timeout(t *testing.T) {
+	// Accepts connections but never answers the handshake
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = listener.Close() }()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			defer func() { _ = conn.Close() }()
+		}
+	}()
+	c := newChecker(listener.Addr().String(), 100*time.Millisecond)
+
+	start := time.Now()
+	_, err = c.Expiry(context.Background(), "example.com")
+	if err == nil || !strings.Contains(err.Error(), "TLS handshake") {
+		t.Errorf("Expected a handshake error, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("Expected the handshake to be abandoned after the timeout, took %s", elapsed)
+	}
+}
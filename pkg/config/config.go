@@ -3,25 +3,102 @@ package config
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"net/mail"
+	"net/netip"
+	"net/url"
 	"os"
+	"path/filepath"
+	"slices"
 	"strconv"
 	"strings"
+	"text/template"
 	"time"
 
+	"sigs.k8s.io/yaml"
+
 	"github.com/mallocator/domain-checker/pkg/logger"
 )
 
+// SMTP connection security modes
+const (
+	SMTPTLSAuto     = "auto"     // STARTTLS when the server offers it, plain otherwise
+	SMTPTLSNone     = "none"     // plain connection
+	SMTPTLSStartTLS = "starttls" // upgrade a plain connection with STARTTLS (usually port 587)
+	SMTPTLSImplicit = "tls"      // TLS from the start (usually port 465)
+)
+
+// DNS record types whose presence marks a registrable domain as taken
+const (
+	RecordSOA = "SOA" // the domain has a zone, even one its registry put on hold
+	RecordNS  = "NS"  // the domain is delegated to nameservers
+	RecordA   = "A"   // the domain resolves to an IPv4 or IPv6 address
+)
+
+// How many of the DNSServers must find no record for a domain to be available, see ResolverConsensus
+const (
+	ConsensusMajority = "majority" // more than half of them
+	ConsensusAll      = "all"      // every one of them
+)
+
 // Config holds application settings
 type Config struct {
-	// List of domains to monitor
-	Domains []string `json:"domains"`
+	// List of domains to monitor, each a name or an object with per-domain overrides
+	Domains []DomainEntry `json:"domains"`
+
+	// Plain text file with additional domains, one per line; see LoadDomainsFile
+	// A relative path in a config file is relative to that file's directory
+	DomainsFile string `json:"domains_file"`
+
+	// Add the domains of a config file or DOMAINS to the ones loaded before instead of replacing them
+	// Takes effect from the config file setting it on; see LoadFromFile
+	MergeDomains bool `json:"merge_domains"`
+
+	// Domains to skip without removing them from Domains or DomainsFile, as exact names or "*.suffix" globs
+	ExcludeDomains []string `json:"exclude_domains"`
 
 	// Number of days before expiration to send notification
 	ThresholdDays int `json:"threshold_days"`
 
+	// Days before expiration for staged reminders, e.g. [30, 14, 3]; replaces ThresholdDays when set
+	ThresholdTiers []int `json:"threshold_tiers"`
+
+	// Days before expiration from which a domain is logged and reported as a warning, without notifying
+	// Only matters above the notification threshold, e.g. twice its days (0 disables warnings)
+	WarnThresholdDays int `json:"warn_threshold_days"`
+
+	// IANA time zone whose calendar days are counted until expiration, e.g. "UTC"; empty uses the local zone
+	Timezone string `json:"timezone"`
+
 	// Directory to store state files
 	StateDir string `json:"state_dir"`
 
+	// Time between checks when running as a daemon (0 checks once and exits)
+	CheckInterval time.Duration `json:"check_interval"`
+
+	// State storage backend, file, sqlite or redis
+	StateBackend string `json:"state_backend"`
+	// Backend connection string, e.g. the SQLite database path (defaults to state.db in StateDir)
+	StateDSN string `json:"state_dsn"`
+
+	// How long to wait for another run to release a domain's state lock
+	// Locks older than this are considered stale and taken over
+	LockTimeout time.Duration `json:"lock_timeout"`
+
+	// How long Cleanup keeps the state of a domain removed from the config, so re-adding it doesn't
+	// notify again (0 removes it right away)
+	CleanupRetention time.Duration `json:"cleanup_retention"`
+
+	// Redis connection for the redis state backend
+	RedisAddr         string        `json:"redis_addr"`
+	RedisPassword     string        `json:"redis_password"`
+	RedisPasswordFile string        `json:"redis_password_file"` // read RedisPassword from this file, e.g. a Docker secret
+	RedisDB           int           `json:"redis_db"`
+	RedisTTL          time.Duration `json:"redis_ttl"` // expire state keys after this long (0 keeps them)
+
 	// SMTP configuration for email notifications
 	SMTPHost  string `json:"smtp_host"`
 	SMTPPort  int    `json:"smtp_port"`
@@ -30,66 +107,708 @@ type Config struct {
 	EmailFrom string `json:"email_from"`
 	EmailTo   string `json:"email_to"`
 
+	// Read SMTPPass from this file, e.g. a Docker secret
+	SMTPPassFile string `json:"smtp_pass_file"`
+
+	// SMTP connection security, one of auto, none, starttls or tls
+	SMTPTLS string `json:"smtp_tls"`
+	// Skip verifying the SMTP server certificate against SMTPHost
+	SMTPInsecureSkipVerify bool `json:"smtp_insecure_skip_verify"`
+
+	// Go text/template for notification messages, e.g. "{{.Domain}}: {{.Event}}"
+	// Fields: Domain, Event, DaysLeft, Expiration, Status, TransferLocked, AutoRenew
+	NotifyTemplate string `json:"notify_template"`
+
+	// Go text/templates for the subject and plain text body of notification emails, e.g.
+	// "[domain-checker] {{.Domain}}: {{.Event}}"; same fields as NotifyTemplate plus Message, the rendered message
+	NotifySubjectTemplate string `json:"notify_subject_template"`
+	NotifyBodyTemplate    string `json:"notify_body_template"`
+
+	// Minimum time between two notifications for the same domain and event (0 disables it)
+	NotifyCooldown time.Duration `json:"notify_cooldown"`
+
+	// Collect email notifications during a run and send them as a single digest
+	NotifyDigest bool `json:"notify_digest"`
+
+	// Channels notified about domains without their own channels: email, webhook or telegram
+	// Empty uses every configured channel
+	NotifyChannels []string `json:"notify_channels"`
+
+	// Consecutive checks a domain has to be found available before notifying, so a lookup glitch isn't reported
+	AvailableConfirmations int `json:"available_confirmations"`
+
+	// Time of day window in Location, e.g. "22:00-07:00", in which notifications are held until a run after it
+	QuietHours string `json:"quiet_hours"`
+
+	// Send available-domain notifications right away even during QuietHours, as someone else may register it
+	QuietHoursBypassAvailable bool `json:"quiet_hours_bypass_available"`
+
+	// Notify once when a registered domain's expiration date can't be determined, e.g. for an unsupported TLD
+	NotifyUnknownExpiry bool `json:"notify_unknown_expiry"`
+
+	// Mention whether WHOIS shows auto-renew and a transfer lock in expiry notifications
+	NotifyRenewalInfo bool `json:"notify_renewal_info"`
+
+	// Record every notification sent in notifications.jsonl in the state directory
+	NotifyHistory bool `json:"notify_history"`
+
+	// Check domains but only log the notifications that would be sent, leaving the notified state alone
+	DryRun bool `json:"dry_run"`
+
+	// Generic webhook receiving a JSON payload per notification
+	WebhookURL     string            `json:"webhook_url"`
+	WebhookHeaders map[string]string `json:"webhook_headers"` // e.g. auth tokens
+
+	// Telegram bot used to deliver notifications to a chat
+	TelegramBotToken     string `json:"telegram_bot_token"`
+	TelegramBotTokenFile string `json:"telegram_bot_token_file"` // read TelegramBotToken from this file
+	TelegramChatID       string `json:"telegram_chat_id"`
+
+	// PagerDuty Events API v2 integration key paging on-call for domains within CriticalThresholdDays
+	PagerDutyRoutingKey     string `json:"pagerduty_routing_key"`
+	PagerDutyRoutingKeyFile string `json:"pagerduty_routing_key_file"` // read PagerDutyRoutingKey from this file
+	PagerDutyURL            string `json:"pagerduty_url"`              // Events API endpoint, e.g. the EU one
+
+	// Days before expiration from which a domain pages through PagerDuty until it's renewed (0 disables paging)
+	CriticalThresholdDays int `json:"critical_threshold_days"`
+
+	// Proxy for WHOIS queries and the webhook and Telegram notifications, e.g. "socks5://proxy:1080"
+	// Plain http:// proxies tunnel WHOIS with CONNECT; empty connects directly, or uses HTTPS_PROXY for notifications
+	Proxy string `json:"proxy"`
+
 	// Retry configuration
-	Retries int           `json:"retries"`
-	Backoff time.Duration `json:"backoff"` // initial backoff duration
+	Retries    int           `json:"retries"`
+	Backoff    time.Duration `json:"backoff"`     // initial backoff duration
+	MaxBackoff time.Duration `json:"max_backoff"` // upper limit for the doubled backoff
 
 	// Concurrency and timeout settings
 	Concurrency int           `json:"concurrency"`
 	Timeout     time.Duration `json:"timeout"` // per lookup timeout
 
+	// Limits for DNS and WHOIS lookups running in parallel (0 uses Concurrency)
+	// The higher of the two bounds the domains checked at once, so either may go beyond Concurrency
+	// WHOIS servers throttle much sooner than nameservers, so it usually needs the lower limit
+	DNSConcurrency   int `json:"dns_concurrency"`
+	WhoisConcurrency int `json:"whois_concurrency"`
+
+	// Upper limit for checking a single domain including all lookups and retries (0 disables the limit)
+	PerDomainTimeout time.Duration `json:"per_domain_timeout"`
+
+	// Nameservers for the DNS lookups as "ip" or "ip:port" ("[ipv6]:port"), tried in order
+	// Empty uses the first nameserver from /etc/resolv.conf
+	DNSServers []string `json:"dns_servers"`
+
+	// Ask all DNSServers at once and report a domain available only when a majority or all of them find no record;
+	// without that consensus it counts as registered. Empty asks the next server only when one fails
+	ResolverConsensus string `json:"resolver_consensus"`
+
+	// Fail DNS lookups instead of querying 8.8.8.8 when DNSServers is empty and resolv.conf has no nameserver
+	StrictResolver bool `json:"strict_resolver"`
+
+	// Skip a domain for the run when its DNS lookup fails, instead of going on with WHOIS
+	// For setups where DNS is the authoritative availability signal
+	DNSRequired bool `json:"dns_required"`
+
+	// DNS record a registrable domain must have to count as taken: SOA (has a zone), NS (is delegated)
+	// or A (resolves to an A or AAAA address). NS and A report registered domains without delegation
+	// or without a website as available; subdomains are always checked for A and AAAA records
+	AvailabilityRecordType string `json:"availability_record_type"`
+
+	// Port for nameservers given without one
+	DNSPort int `json:"dns_port"`
+
+	// How often a DNS query is re-sent to the same nameserver after it timed out
+	DNSRetries int `json:"dns_retries"`
+
+	// Wait before re-sending a DNS query, doubling with each retry up to DNSMaxBackoff with up to half of it
+	// taken off at random, so concurrent lookups don't retry in waves (0 re-sends right away)
+	DNSBackoff    time.Duration `json:"dns_backoff"`
+	DNSMaxBackoff time.Duration `json:"dns_max_backoff"`
+
+	// How long raw WHOIS responses are cached on disk (0 disables the cache)
+	WhoisCacheTTL time.Duration `json:"whois_cache_ttl"`
+
+	// Query WHOIS on every check, even while the expiration date in the state is still ahead, to pick up renewals
+	// early; ForceWhois also skips the WHOIS cache
+	ForceRecheck bool `json:"force_recheck"`
+	ForceWhois   bool `json:"force_whois"`
+
+	// Write each raw WHOIS response to a .whois.txt file per domain in StateDir, to diagnose unsupported formats
+	// The files aren't redacted and may contain the registrant's contact details
+	SaveWhoisRaw bool `json:"save_whois_raw"`
+
+	// Use the expiration of the TLS certificate served on port 443 when WHOIS has no expiration date,
+	// e.g. for ccTLDs that redact it; notifications and reports say it's the certificate's
+	CertFallback bool `json:"cert_fallback"`
+
+	// Maximum WHOIS queries per minute against a single registry (0 disables the limit)
+	WhoisRatePerMinute int `json:"whois_rate_per_minute"`
+
+	// Also query the registrar's WHOIS server a thin registry (e.g. .com) refers to, and prefer its expiration date
+	FollowReferral bool `json:"follow_referral"`
+
+	// WHOIS field holding the expiration date by TLD, e.g. "co.uk": "Expiry date", for registries whose standard
+	// expiration field is missing or inaccurate; the most specific TLD wins and the standard field is used
+	// when the response doesn't have the named one
+	WhoisExpiryFields map[string]string `json:"whois_expiry_fields"`
+
+	// File the JSON report of each run is written to (empty disables it)
+	ReportFile string `json:"report_file"`
+
+	// Address for the Prometheus /metrics endpoint, e.g. ":9090" (empty disables it)
+	MetricsAddr string `json:"metrics_addr"`
+
 	// Logger instance
 	Log *logger.Logger
+
+	// Config path as passed to LoadFromFile and the files it lists, used by Watch
+	path  string
+	files []string
+
+	// Domains by normalized name, built by Validate so Domain doesn't normalize every entry on each lookup
+	domainIndex map[string]DomainEntry
+
+	// Read instead of a file for the StdinPath config path, replaceable in tests
+	stdin io.Reader
 }
 
+// StdinPath is the config path that reads the config from standard input
+const StdinPath = "-"
+
 // New creates a new configuration with default values
 func New(log *logger.Logger) *Config {
 	cfg := &Config{
-		ThresholdDays: 7,
-		StateDir:      "/data",
-		Retries:       3,
-		Backoff:       2 * time.Second,
-		Concurrency:   5,
-		Timeout:       5 * time.Second,
-		Log:           log,
+		ThresholdDays:          7,
+		SMTPTLS:                SMTPTLSAuto,
+		AvailabilityRecordType: RecordSOA,
+		StateDir:               "/data",
+		StateBackend:           "file",
+		LockTimeout:            time.Minute,
+		RedisAddr:              "localhost:6379",
+		Retries:                3,
+		Backoff:                2 * time.Second,
+		MaxBackoff:             time.Minute,
+		Concurrency:            5,
+		Timeout:                5 * time.Second,
+		DNSPort:                53,
+		DNSRetries:             2,
+		DNSBackoff:             100 * time.Millisecond,
+		DNSMaxBackoff:          time.Second,
+		AvailableConfirmations: 1,
+		PagerDutyURL:           "https://events.pagerduty.com/v2/enqueue",
+		FollowReferral:         true,
+		Log:                    log,
+		stdin:                  os.Stdin,
 	}
 
 	return cfg
 }
 
-// LoadFromFile loads configuration from a JSON file
+// LoadFromFile loads configuration from a JSON or YAML file
+// The format is picked by extension (.yaml/.yml for YAML), anything else is read as JSON
+// YAML keys are the same as the JSON ones
+// StdinPath reads JSON or YAML from standard input instead; such a config can't be watched for changes
+// path may list several files separated by commas or the OS path list separator, e.g. a base config
+// and environment-specific overrides. They're loaded in order, each overriding the settings it contains;
+// its domains replace the ones loaded before, or are added to them with MergeDomains
 func (c *Config) LoadFromFile(path string) error {
 	if path == "" {
 		return nil
 	}
+	if path == StdinPath {
+		return c.loadFromStdin()
+	}
+
+	files := splitPaths(path)
+	if slices.Contains(files, StdinPath) {
+		return errors.New("stdin can't be combined with other config files")
+	}
+	for _, file := range files {
+		if err := c.loadFile(file); err != nil {
+			return err
+		}
+	}
+	c.path = path
+	c.files = files
+	return nil
+}
+
+// splitPaths splits a list of config files separated by commas or the OS path list separator
+func splitPaths(list string) []string {
+	var paths []string
+	for _, part := range strings.Split(list, ",") {
+		for _, path := range filepath.SplitList(part) {
+			if path = strings.TrimSpace(path); path != "" {
+				paths = append(paths, path)
+			}
+		}
+	}
+	return paths
+}
 
+// loadFile loads a single config file on top of the current settings
+func (c *Config) loadFile(path string) error {
 	data, err := os.ReadFile(path)
 	if err != nil {
 		return err
 	}
 
-	if err := json.Unmarshal(data, c); err != nil {
-		return err
+	// Kept from the files loaded before unless this one sets them
+	domains, domainsFile := c.Domains, c.DomainsFile
+	c.Domains, c.DomainsFile = nil, ""
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, c)
+	default:
+		err = json.Unmarshal(data, c)
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+
+	switch {
+	case c.Domains == nil:
+		c.Domains = domains
+	case c.MergeDomains:
+		c.Domains = mergeDomains(domains, c.Domains)
 	}
 
+	switch {
+	case c.DomainsFile == "":
+		c.DomainsFile = domainsFile
+	case !filepath.IsAbs(c.DomainsFile):
+		c.DomainsFile = filepath.Join(filepath.Dir(path), c.DomainsFile)
+	}
 	return nil
 }
 
+// loadFromStdin loads configuration piped to standard input
+// The format can't be told by an extension, so it's parsed as YAML, which accepts JSON as well
+func (c *Config) loadFromStdin() error {
+	data, err := io.ReadAll(c.stdin)
+	if err != nil {
+		return fmt.Errorf("read stdin: %w", err)
+	}
+	return yaml.Unmarshal(data, c)
+}
+
 // LoadFromEnv overrides configuration with environment variables
 func (c *Config) LoadFromEnv() {
-	setStringList(&c.Domains, "DOMAINS", ",")
+	setBool(&c.MergeDomains, "MERGE_DOMAINS")
+	var domains []DomainEntry
+	setDomainList(&domains, "DOMAINS")
+	switch {
+	case domains == nil:
+	case c.MergeDomains:
+		// Names from the config files keep their overrides
+		c.Domains = mergeDomains(domains, c.Domains)
+	default:
+		c.Domains = domains
+	}
+	setString(&c.DomainsFile, "DOMAINS_FILE")
+	setStringList(&c.ExcludeDomains, "EXCLUDE_DOMAINS", ",")
 	setInt(&c.ThresholdDays, "THRESHOLD_DAYS")
+	setIntList(&c.ThresholdTiers, "THRESHOLD_TIERS", ",")
+	setInt(&c.WarnThresholdDays, "WARN_THRESHOLD_DAYS")
+	setString(&c.Timezone, "TIMEZONE")
 	setString(&c.StateDir, "STATE_DIR")
+	setDuration(&c.CheckInterval, "CHECK_INTERVAL")
+	setString(&c.StateBackend, "STATE_BACKEND")
+	setString(&c.StateDSN, "STATE_DSN")
+	setDuration(&c.LockTimeout, "LOCK_TIMEOUT")
+	setDuration(&c.CleanupRetention, "CLEANUP_RETENTION")
+	setString(&c.RedisAddr, "REDIS_ADDR")
+	setString(&c.RedisPassword, "REDIS_PASSWORD")
+	setString(&c.RedisPasswordFile, "REDIS_PASSWORD_FILE")
+	setInt(&c.RedisDB, "REDIS_DB")
+	setDuration(&c.RedisTTL, "REDIS_TTL")
 	setString(&c.SMTPHost, "SMTP_HOST")
 	setInt(&c.SMTPPort, "SMTP_PORT")
 	setString(&c.SMTPUser, "SMTP_USER")
 	setString(&c.SMTPPass, "SMTP_PASS")
+	setString(&c.SMTPPassFile, "SMTP_PASS_FILE")
 	setString(&c.EmailFrom, "EMAIL_FROM")
 	setString(&c.EmailTo, "EMAIL_TO")
+	setString(&c.SMTPTLS, "SMTP_TLS")
+	setBool(&c.SMTPInsecureSkipVerify, "SMTP_INSECURE_SKIP_VERIFY")
+	setString(&c.NotifyTemplate, "NOTIFY_TEMPLATE")
+	setString(&c.NotifySubjectTemplate, "NOTIFY_SUBJECT_TEMPLATE")
+	setString(&c.NotifyBodyTemplate, "NOTIFY_BODY_TEMPLATE")
+	setDuration(&c.NotifyCooldown, "NOTIFY_COOLDOWN")
+	setBool(&c.NotifyDigest, "NOTIFY_DIGEST")
+	setStringList(&c.NotifyChannels, "NOTIFY_CHANNELS", ",")
+	setInt(&c.AvailableConfirmations, "AVAILABLE_CONFIRMATIONS")
+	setString(&c.QuietHours, "QUIET_HOURS")
+	setBool(&c.QuietHoursBypassAvailable, "QUIET_HOURS_BYPASS_AVAILABLE")
+	setBool(&c.NotifyUnknownExpiry, "NOTIFY_UNKNOWN_EXPIRY")
+	setBool(&c.NotifyRenewalInfo, "NOTIFY_RENEWAL_INFO")
+	setBool(&c.NotifyHistory, "NOTIFY_HISTORY")
+	setBool(&c.DryRun, "DRY_RUN")
+	setString(&c.WebhookURL, "WEBHOOK_URL")
+	setStringMap(&c.WebhookHeaders, "WEBHOOK_HEADERS", ",", "=")
+	setString(&c.TelegramBotToken, "TELEGRAM_BOT_TOKEN")
+	setString(&c.TelegramBotTokenFile, "TELEGRAM_BOT_TOKEN_FILE")
+	setString(&c.TelegramChatID, "TELEGRAM_CHAT_ID")
+	setString(&c.PagerDutyRoutingKey, "PAGERDUTY_ROUTING_KEY")
+	setString(&c.PagerDutyRoutingKeyFile, "PAGERDUTY_ROUTING_KEY_FILE")
+	setString(&c.PagerDutyURL, "PAGERDUTY_URL")
+	setInt(&c.CriticalThresholdDays, "CRITICAL_THRESHOLD_DAYS")
+	setString(&c.Proxy, "PROXY")
 	setInt(&c.Retries, "RETRIES")
 	setDuration(&c.Backoff, "BACKOFF")
+	setDuration(&c.MaxBackoff, "MAX_BACKOFF")
 	setInt(&c.Concurrency, "CONCURRENCY")
 	setDuration(&c.Timeout, "TIMEOUT")
+	setInt(&c.DNSConcurrency, "DNS_CONCURRENCY")
+	setInt(&c.WhoisConcurrency, "WHOIS_CONCURRENCY")
+	setDuration(&c.PerDomainTimeout, "PER_DOMAIN_TIMEOUT")
+	setStringList(&c.DNSServers, "DNS_SERVERS", ",")
+	setString(&c.ResolverConsensus, "RESOLVER_CONSENSUS")
+	setBool(&c.StrictResolver, "STRICT_RESOLVER")
+	setBool(&c.DNSRequired, "DNS_REQUIRED")
+	setString(&c.AvailabilityRecordType, "AVAILABILITY_RECORD_TYPE")
+	setInt(&c.DNSPort, "DNS_PORT")
+	setInt(&c.DNSRetries, "DNS_RETRIES")
+	setDuration(&c.DNSBackoff, "DNS_BACKOFF")
+	setDuration(&c.DNSMaxBackoff, "DNS_MAX_BACKOFF")
+	setDuration(&c.WhoisCacheTTL, "WHOIS_CACHE_TTL")
+	setBool(&c.ForceRecheck, "FORCE_RECHECK")
+	setBool(&c.ForceWhois, "FORCE_WHOIS")
+	setBool(&c.SaveWhoisRaw, "SAVE_WHOIS_RAW")
+	setBool(&c.CertFallback, "CERT_FALLBACK")
+	setInt(&c.WhoisRatePerMinute, "WHOIS_RATE_PER_MINUTE")
+	setBool(&c.FollowReferral, "FOLLOW_REFERRAL")
+	setStringMap(&c.WhoisExpiryFields, "WHOIS_EXPIRY_FIELDS", ",", "=")
+	setString(&c.MetricsAddr, "METRICS_ADDR")
+	setString(&c.ReportFile, "REPORT_FILE")
+}
+
+// LoadSecretFiles reads secrets from the files given by the *_file settings, the convention for Docker secrets
+// A secret that is set directly takes precedence over its file; trailing newlines in the file are dropped
+func (c *Config) LoadSecretFiles() error {
+	var errs []error
+	for _, secret := range []struct {
+		field string
+		value *string
+		path  string
+	}{
+		{"smtp_pass_file", &c.SMTPPass, c.SMTPPassFile},
+		{"redis_password_file", &c.RedisPassword, c.RedisPasswordFile},
+		{"telegram_bot_token_file", &c.TelegramBotToken, c.TelegramBotTokenFile},
+		{"pagerduty_routing_key_file", &c.PagerDutyRoutingKey, c.PagerDutyRoutingKeyFile},
+	} {
+		if secret.path == "" || *secret.value != "" {
+			continue
+		}
+		data, err := os.ReadFile(secret.path)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", secret.field, err))
+			continue
+		}
+		*secret.value = strings.TrimRight(string(data), "\r\n")
+	}
+	return errors.Join(errs...)
+}
+
+// Validate checks that the settings are usable
+// Call it after all sources have been loaded; every problem found is reported
+func (c *Config) Validate() error {
+	var errs []error
+
+	// Empty entries, e.g. from a trailing comma, are skipped, but there has to be something to check
+	if !slices.ContainsFunc(c.DomainNames(), func(d string) bool { return strings.TrimSpace(d) != "" }) {
+		errs = append(errs, errors.New("domains: at least one domain is required"))
+	}
+	return errors.Join(append(errs, c.ValidateSettings())...)
+}
+
+// ValidateSettings checks the settings like Validate, but doesn't require any domains
+// For modes that don't check the configured domains, like -check and -test-notify
+func (c *Config) ValidateSettings() error {
+	c.indexDomains()
+
+	var errs []error
+
+	if c.ThresholdDays < 0 {
+		errs = append(errs, fmt.Errorf("threshold_days: must be 0 or more, got %d", c.ThresholdDays))
+	}
+	if c.AvailableConfirmations < 1 {
+		errs = append(errs, fmt.Errorf("available_confirmations: must be at least 1, got %d", c.AvailableConfirmations))
+	}
+	if c.WarnThresholdDays < 0 {
+		errs = append(errs, fmt.Errorf("warn_threshold_days: must be 0 or more, got %d", c.WarnThresholdDays))
+	}
+	if c.CriticalThresholdDays < 0 {
+		errs = append(errs, fmt.Errorf("critical_threshold_days: must be 0 or more, got %d", c.CriticalThresholdDays))
+	}
+	for _, tier := range c.ThresholdTiers {
+		if tier < 0 {
+			errs = append(errs, fmt.Errorf("threshold_tiers: must be 0 or more, got %d", tier))
+		}
+	}
+	if _, err := time.LoadLocation(c.Timezone); err != nil {
+		errs = append(errs, fmt.Errorf("timezone: %w", err))
+	}
+	if _, _, err := parseQuietHours(c.QuietHours); err != nil {
+		errs = append(errs, fmt.Errorf("quiet_hours: %w", err))
+	}
+	for _, pattern := range c.ExcludeDomains {
+		if _, err := normalizeExclude(pattern); err != nil {
+			errs = append(errs, fmt.Errorf("exclude_domains: %w", err))
+		}
+	}
+	for _, d := range c.Domains {
+		if d.ThresholdDays != nil && *d.ThresholdDays < 0 {
+			errs = append(errs, fmt.Errorf("threshold_days for %s: must be 0 or more, got %d", d.Name, *d.ThresholdDays))
+		}
+		if d.EmailTo != "" {
+			if _, err := mail.ParseAddress(d.EmailTo); err != nil {
+				errs = append(errs, fmt.Errorf("email_to for %s: invalid address %q: %w", d.Name, d.EmailTo, err))
+			}
+		}
+		if err := validateChannels("channels for "+d.Name, d.Channels); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if err := validateChannels("notify_channels", c.NotifyChannels); err != nil {
+		errs = append(errs, err)
+	}
+	if c.Concurrency < 1 {
+		errs = append(errs, fmt.Errorf("concurrency: must be at least 1, got %d", c.Concurrency))
+	}
+	if c.Timeout <= 0 {
+		errs = append(errs, fmt.Errorf("timeout: must be positive, got %s", c.Timeout))
+	}
+	if c.DNSConcurrency < 0 {
+		errs = append(errs, fmt.Errorf("dns_concurrency: must be 0 or more, got %d", c.DNSConcurrency))
+	}
+	if c.WhoisConcurrency < 0 {
+		errs = append(errs, fmt.Errorf("whois_concurrency: must be 0 or more, got %d", c.WhoisConcurrency))
+	}
+	if c.PerDomainTimeout < 0 {
+		errs = append(errs, fmt.Errorf("per_domain_timeout: must be 0 or more, got %s", c.PerDomainTimeout))
+	}
+	if c.DNSPort < 1 || c.DNSPort > 65535 {
+		errs = append(errs, fmt.Errorf("dns_port: must be between 1 and 65535, got %d", c.DNSPort))
+	}
+	if c.DNSRetries < 0 {
+		errs = append(errs, fmt.Errorf("dns_retries: must be 0 or more, got %d", c.DNSRetries))
+	}
+	if c.DNSBackoff < 0 {
+		errs = append(errs, fmt.Errorf("dns_backoff: must be 0 or more, got %s", c.DNSBackoff))
+	}
+	if c.DNSMaxBackoff < c.DNSBackoff {
+		errs = append(errs, fmt.Errorf("dns_max_backoff: must be at least dns_backoff (%s), got %s", c.DNSBackoff, c.DNSMaxBackoff))
+	}
+	switch strings.ToUpper(c.AvailabilityRecordType) {
+	case "", RecordSOA, RecordNS, RecordA:
+	default:
+		errs = append(errs, fmt.Errorf("availability_record_type: must be %s, %s or %s, got %q",
+			RecordSOA, RecordNS, RecordA, c.AvailabilityRecordType))
+	}
+	for _, tld := range slices.Sorted(maps.Keys(c.WhoisExpiryFields)) {
+		if strings.Trim(tld, ". ") == "" || strings.TrimSpace(c.WhoisExpiryFields[tld]) == "" {
+			errs = append(errs, fmt.Errorf("whois_expiry_fields: needs a TLD and a field name, got %q: %q",
+				tld, c.WhoisExpiryFields[tld]))
+		}
+	}
+	switch strings.ToLower(c.SMTPTLS) {
+	case "", SMTPTLSAuto, SMTPTLSNone, SMTPTLSStartTLS, SMTPTLSImplicit:
+	default:
+		errs = append(errs, fmt.Errorf("smtp_tls: must be %s, %s, %s or %s, got %q",
+			SMTPTLSAuto, SMTPTLSNone, SMTPTLSStartTLS, SMTPTLSImplicit, c.SMTPTLS))
+	}
+	switch strings.ToLower(c.ResolverConsensus) {
+	case "", ConsensusMajority, ConsensusAll:
+	default:
+		errs = append(errs, fmt.Errorf("resolver_consensus: must be %s or %s, got %q",
+			ConsensusMajority, ConsensusAll, c.ResolverConsensus))
+	}
+	if _, err := c.DNSServerAddrs(); err != nil {
+		errs = append(errs, err)
+	}
+	if _, err := c.ProxyURL(); err != nil {
+		errs = append(errs, err)
+	}
+	if c.CheckInterval < 0 {
+		errs = append(errs, fmt.Errorf("check_interval: must be 0 or more, got %s", c.CheckInterval))
+	}
+	if c.CleanupRetention < 0 {
+		errs = append(errs, fmt.Errorf("cleanup_retention: must be 0 or more, got %s", c.CleanupRetention))
+	}
+	if c.Retries < 0 {
+		errs = append(errs, fmt.Errorf("retries: must be 0 or more, got %d", c.Retries))
+	}
+	if c.MaxBackoff <= 0 {
+		errs = append(errs, fmt.Errorf("max_backoff: must be positive, got %v", c.MaxBackoff))
+	}
+
+	// An SMTP host without addresses would silently skip every email
+	if c.SMTPHost != "" {
+		if c.EmailFrom == "" {
+			errs = append(errs, errors.New("email_from: required when smtp_host is set"))
+		}
+		if c.EmailTo == "" {
+			errs = append(errs, errors.New("email_to: required when smtp_host is set"))
+		}
+	}
+	for _, addr := range []struct{ field, value string }{{"email_from", c.EmailFrom}, {"email_to", c.EmailTo}} {
+		if addr.value == "" {
+			continue
+		}
+		if _, err := mail.ParseAddress(addr.value); err != nil {
+			errs = append(errs, fmt.Errorf("%s: invalid address %q: %w", addr.field, addr.value, err))
+		}
+	}
+
+	templates := []struct{ field, text string }{
+		{"notify_template", c.NotifyTemplate},
+		{"notify_subject_template", c.NotifySubjectTemplate},
+		{"notify_body_template", c.NotifyBodyTemplate},
+	}
+	for _, tmpl := range templates {
+		if tmpl.text == "" {
+			continue
+		}
+		if _, err := template.New(tmpl.field).Parse(tmpl.text); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", tmpl.field, err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// DNSServerAddrs parses DNSServers, using DNSPort for entries without a port
+func (c *Config) DNSServerAddrs() ([]netip.AddrPort, error) {
+	var addrs []netip.AddrPort
+	for _, server := range c.DNSServers {
+		server = strings.TrimSpace(server)
+		if server == "" {
+			continue
+		}
+		if addr, err := netip.ParseAddrPort(server); err == nil {
+			addrs = append(addrs, addr)
+			continue
+		}
+		addr, err := netip.ParseAddr(server)
+		if err != nil {
+			return nil, fmt.Errorf("dns_servers: %q isn't an IP address or IP:port", server)
+		}
+		addrs = append(addrs, netip.AddrPortFrom(addr, uint16(c.DNSPort)))
+	}
+	return addrs, nil
+}
+
+// ProxyURL parses Proxy, returning nil if no proxy is configured
+func (c *Config) ProxyURL() (*url.URL, error) {
+	if c.Proxy == "" {
+		return nil, nil
+	}
+	u, err := url.Parse(c.Proxy)
+	if err != nil {
+		return nil, errors.New("proxy: invalid URL") // the error would repeat the URL, including any password
+	}
+	switch u.Scheme {
+	case "http", "socks5", "socks5h":
+	default:
+		return nil, fmt.Errorf("proxy: must be an http, socks5 or socks5h URL, got %q", u.Redacted())
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("proxy: missing host in %q", u.Redacted())
+	}
+	return u, nil
+}
+
+// DNSLimit returns the number of DNS lookups allowed in parallel, Concurrency unless DNSConcurrency is set
+func (c *Config) DNSLimit() int {
+	if c.DNSConcurrency > 0 {
+		return c.DNSConcurrency
+	}
+	return c.Concurrency
+}
+
+// WhoisLimit returns the number of WHOIS lookups allowed in parallel, Concurrency unless WhoisConcurrency is set
+func (c *Config) WhoisLimit() int {
+	if c.WhoisConcurrency > 0 {
+		return c.WhoisConcurrency
+	}
+	return c.Concurrency
+}
+
+// Location returns the time zone for counting days until expiration, time.Local unless Timezone is set
+func (c *Config) Location() *time.Location {
+	if c.Timezone == "" {
+		return time.Local
+	}
+	loc, err := time.LoadLocation(c.Timezone)
+	if err != nil {
+		return time.Local // rejected by Validate
+	}
+	return loc
+}
+
+// DaysBetween returns the number of calendar days from the date of from until t's date in Location
+// It's 0 on the day of t and negative after it, regardless of the time of day
+func (c *Config) DaysBetween(from, t time.Time) int {
+	return calendarDays(from, t, c.Location())
+}
+
+// InQuietHours reports whether t is within QuietHours in Location
+func (c *Config) InQuietHours(t time.Time) bool {
+	start, end, err := parseQuietHours(c.QuietHours)
+	if err != nil || start == end {
+		return false // unset, or rejected by Validate
+	}
+	t = t.In(c.Location())
+	now := time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute
+	if start < end {
+		return now >= start && now < end
+	}
+	// The window spans midnight
+	return now >= start || now < end
+}
+
+// parseQuietHours parses a "HH:MM-HH:MM" window into its start and end as time since midnight
+// An empty window returns equal start and end
+func parseQuietHours(window string) (start, end time.Duration, err error) {
+	if window == "" {
+		return 0, 0, nil
+	}
+	from, to, ok := strings.Cut(window, "-")
+	if !ok {
+		return 0, 0, fmt.Errorf("must be a window like 22:00-07:00, got %q", window)
+	}
+	if start, err = parseTimeOfDay(from); err != nil {
+		return 0, 0, err
+	}
+	if end, err = parseTimeOfDay(to); err != nil {
+		return 0, 0, err
+	}
+	if start == end {
+		return 0, 0, fmt.Errorf("start and end must differ, got %q", window)
+	}
+	return start, end, nil
+}
+
+// parseTimeOfDay parses "HH:MM" as time since midnight
+func parseTimeOfDay(s string) (time.Duration, error) {
+	t, err := time.Parse("15:04", strings.TrimSpace(s))
+	if err != nil {
+		return 0, fmt.Errorf("invalid time of day %q, want HH:MM", s)
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+// calendarDays counts the days between the dates of from and to in loc
+func calendarDays(from, to time.Time, loc *time.Location) int {
+	y1, m1, d1 := from.In(loc).Date()
+	y2, m2, d2 := to.In(loc).Date()
+
+	// Compare the dates at UTC midnight so DST changes don't shorten or lengthen a day
+	start := time.Date(y1, m1, d1, 0, 0, 0, 0, time.UTC)
+	end := time.Date(y2, m2, d2, 0, 0, 0, 0, time.UTC)
+	return int(end.Sub(start) / (24 * time.Hour))
 }
 
 // setStringList sets a []string from env split by sep
@@ -99,6 +818,34 @@ func setStringList(field *[]string, env, sep string) {
 	}
 }
 
+// setIntList sets an []int from env split by sep, leaving the field alone if any item isn't a number
+func setIntList(field *[]int, env, sep string) {
+	if v := os.Getenv(env); v != "" {
+		var list []int
+		for _, item := range strings.Split(v, sep) {
+			i, err := strconv.Atoi(strings.TrimSpace(item))
+			if err != nil {
+				return
+			}
+			list = append(list, i)
+		}
+		*field = list
+	}
+}
+
+// setStringMap sets a map[string]string from env split into pairs by sep and key/value by kvSep
+func setStringMap(field *map[string]string, env, sep, kvSep string) {
+	if v := os.Getenv(env); v != "" {
+		m := make(map[string]string)
+		for _, pair := range strings.Split(v, sep) {
+			if key, value, ok := strings.Cut(pair, kvSep); ok {
+				m[strings.TrimSpace(key)] = strings.TrimSpace(value)
+			}
+		}
+		*field = m
+	}
+}
+
 // setString sets a string field from env
 func setString(field *string, env string) {
 	if v := os.Getenv(env); v != "" {
@@ -115,6 +862,15 @@ func setInt(field *int, env string) {
 	}
 }
 
+// setBool sets a bool field from env
+func setBool(field *bool, env string) {
+	if v := os.Getenv(env); v != "" {
+		if b, err := strconv.ParseBool(v); err == nil {
+			*field = b
+		}
+	}
+}
+
 // setDuration sets a time.Duration field from env
 func setDuration(field *time.Duration, env string) {
 	if v := os.Getenv(env); v != "" {
@@ -0,0 +1,363 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"slices"
+	"strings"
+
+	"golang.org/x/net/publicsuffix"
+
+	"github.com/mallocator/domain-checker/pkg/idn"
+)
+
+// DomainEntry is a monitored domain with optional per-domain overrides
+// In config files it's either a plain domain name or an object with the fields below
+type DomainEntry struct {
+	// Domain name
+	Name string `json:"name"`
+
+	// Days before expiration to notify, overrides the global ThresholdDays when set
+	ThresholdDays *int `json:"threshold_days,omitempty"`
+
+	// Email recipient for this domain, overrides the global EmailTo when set
+	EmailTo string `json:"email_to,omitempty"`
+
+	// Channels notified about this domain, e.g. ["webhook"], overrides the global NotifyChannels when set
+	Channels []string `json:"channels,omitempty"`
+}
+
+// Notification channels a domain can be routed to
+const (
+	ChannelEmail    = "email"
+	ChannelWebhook  = "webhook"
+	ChannelTelegram = "telegram"
+)
+
+// channels lists the valid notification channel names
+var channels = []string{ChannelEmail, ChannelWebhook, ChannelTelegram}
+
+// UnmarshalJSON accepts either a plain domain name or an object
+func (d *DomainEntry) UnmarshalJSON(data []byte) error {
+	var name string
+	if err := json.Unmarshal(data, &name); err == nil {
+		*d = DomainEntry{Name: strings.TrimSpace(name)}
+		return nil
+	}
+
+	// Alias drops the methods so decoding the object doesn't recurse
+	type entry DomainEntry
+	var e entry
+	if err := json.Unmarshal(data, &e); err != nil {
+		return fmt.Errorf("domain must be a name or an object with a name: %w", err)
+	}
+	e.Name = strings.TrimSpace(e.Name)
+	*d = DomainEntry(e)
+	return nil
+}
+
+// DomainNames returns the names of all configured domains
+func (c *Config) DomainNames() []string {
+	names := make([]string, len(c.Domains))
+	for i, d := range c.Domains {
+		names[i] = d.Name
+	}
+	return names
+}
+
+// Domain returns the entry for a domain, or an entry without overrides if it isn't configured
+// Entries are matched by their normalized name, so "Example.COM" in the config matches "example.com"
+// Once the config is validated, entries are looked up in the index; validate again after changing Domains
+func (c *Config) Domain(name string) DomainEntry {
+	if c.domainIndex != nil {
+		if d, ok := c.domainIndex[name]; ok {
+			return d
+		}
+		return DomainEntry{Name: name}
+	}
+
+	for _, d := range c.Domains {
+		if strings.TrimSpace(d.Name) == name {
+			return d
+		}
+		if n, err := NormalizeDomain(d.Name); err == nil && n == name {
+			return d
+		}
+	}
+	return DomainEntry{Name: name}
+}
+
+// indexDomains maps the normalized name of each configured domain to its entry for Domain
+// Invalid names are kept as given; the first entry of a domain listed twice wins, like in Domain
+func (c *Config) indexDomains() {
+	c.domainIndex = make(map[string]DomainEntry, len(c.Domains))
+	for _, d := range c.Domains {
+		name := strings.TrimSpace(d.Name)
+		if n, err := NormalizeDomain(d.Name); err == nil {
+			name = n
+		}
+		if _, ok := c.domainIndex[name]; !ok {
+			c.domainIndex[name] = d
+		}
+	}
+}
+
+// NormalizedDomainNames returns the normalized names of all valid configured domains
+// Invalid entries are left out, see NormalizeDomain
+func (c *Config) NormalizedDomainNames() []string {
+	var names []string
+	for _, d := range c.Domains {
+		if n, err := NormalizeDomain(d.Name); err == nil {
+			names = append(names, n)
+		}
+	}
+	return names
+}
+
+// Excluded reports whether a normalized domain name matches one of ExcludeDomains
+// A "*.suffix" pattern matches every name below suffix, including wildcards, but not suffix itself
+func (c *Config) Excluded(name string) bool {
+	for _, pattern := range c.ExcludeDomains {
+		p, err := normalizeExclude(pattern)
+		if err != nil {
+			continue // rejected by Validate
+		}
+		if name == p {
+			return true
+		}
+		if suffix, ok := strings.CutPrefix(p, "*"); ok && strings.HasSuffix(name, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// normalizeExclude turns an ExcludeDomains pattern into the normalized form Excluded matches against
+// The suffix of a "*.suffix" pattern may be a top-level domain on its own, like "*.test"
+func normalizeExclude(pattern string) (string, error) {
+	p := strings.TrimSpace(pattern)
+	suffix, glob := strings.CutPrefix(p, "*.")
+	if !glob {
+		return NormalizeDomain(p)
+	}
+
+	// Any name below the suffix has to pass the usual checks
+	name, err := NormalizeDomain("x." + suffix)
+	if err != nil {
+		return "", fmt.Errorf("invalid pattern %q", pattern)
+	}
+	return "*." + strings.TrimPrefix(name, "x."), nil
+}
+
+// NormalizeDomain turns a configured domain into the form that is checked and stored
+// It strips a URL scheme, credentials, port and path, lowercases the name and removes a trailing dot
+// Names that aren't valid hostnames with at least two labels are rejected; a leading "*" label marks a wildcard
+func NormalizeDomain(raw string) (string, error) {
+	d := strings.TrimSpace(raw)
+	if i := strings.Index(d, "://"); i >= 0 {
+		d = d[i+3:]
+	}
+	if i := strings.IndexAny(d, "/?#"); i >= 0 {
+		d = d[:i]
+	}
+	if i := strings.LastIndex(d, "@"); i >= 0 {
+		d = d[i+1:]
+	}
+	if host, _, err := net.SplitHostPort(d); err == nil {
+		d = host
+	}
+	d = strings.TrimSuffix(strings.ToLower(d), ".")
+	if d == "" {
+		return "", errors.New("empty domain")
+	}
+
+	// Validate the form used for lookups, which is what has to follow the hostname rules
+	ascii, err := idn.ToASCII(d)
+	if err != nil {
+		return "", fmt.Errorf("invalid domain %q: %w", raw, err)
+	}
+	if len(ascii) > 253 {
+		return "", fmt.Errorf("invalid domain %q: longer than 253 characters", raw)
+	}
+	labels := strings.Split(ascii, ".")
+	if len(labels) < 2 {
+		return "", fmt.Errorf("invalid domain %q: needs a name and a top-level domain", raw)
+	}
+	for i, label := range labels {
+		if i == 0 && label == "*" && len(labels) > 2 {
+			continue
+		}
+		if !validLabel(label) {
+			return "", fmt.Errorf("invalid domain %q: bad label %q", raw, label)
+		}
+	}
+
+	return d, nil
+}
+
+// RegisteredDomain returns the registrable part (eTLD+1) of a normalized name, e.g. bar.co.uk for foo.bar.co.uk
+// Only ICANN suffixes count, so hosts under private suffixes like github.io belong to github.io, which is what WHOIS knows
+// It's the name itself for apex domains and for names without a label in front of their suffix
+func RegisteredDomain(name string) string {
+	ascii, err := idn.ToASCII(strings.TrimPrefix(name, "*."))
+	if err != nil {
+		return name
+	}
+
+	suffix, icann := publicsuffix.PublicSuffix(ascii)
+	for !icann {
+		i := strings.Index(suffix, ".")
+		if i < 0 {
+			break
+		}
+		suffix, icann = publicsuffix.PublicSuffix(suffix[i+1:])
+	}
+
+	// Punycode conversion keeps the labels, so the last labels of the name are its registrable part
+	labels := strings.Split(name, ".")
+	n := strings.Count(suffix, ".") + 2
+	if n > len(labels) || labels[len(labels)-n] == "*" {
+		return name
+	}
+	return strings.Join(labels[len(labels)-n:], ".")
+}
+
+// IsApex reports whether a normalized name is a registrable domain rather than a host or wildcard below one
+func IsApex(name string) bool {
+	return RegisteredDomain(name) == name
+}
+
+// validLabel checks a single ASCII hostname label: 1-63 letters, digits or inner hyphens
+func validLabel(label string) bool {
+	if len(label) == 0 || len(label) > 63 || label[0] == '-' || label[len(label)-1] == '-' {
+		return false
+	}
+	for _, r := range label {
+		if (r < 'a' || r > 'z') && (r < '0' || r > '9') && r != '-' {
+			return false
+		}
+	}
+	return true
+}
+
+// ThresholdFor returns the expiry threshold in days for a domain
+func (c *Config) ThresholdFor(name string) int {
+	if d := c.Domain(name); d.ThresholdDays != nil {
+		return *d.ThresholdDays
+	}
+	return c.ThresholdDays
+}
+
+// TiersFor returns the days before expiration at which a domain is notified, largest first
+// A per-domain threshold is a single tier; otherwise ThresholdTiers, or ThresholdDays if no tiers are set
+func (c *Config) TiersFor(name string) []int {
+	if d := c.Domain(name); d.ThresholdDays != nil {
+		return []int{*d.ThresholdDays}
+	}
+	if len(c.ThresholdTiers) == 0 {
+		return []int{c.ThresholdDays}
+	}
+
+	tiers := slices.Clone(c.ThresholdTiers)
+	slices.Sort(tiers)
+	slices.Reverse(tiers)
+	return slices.Compact(tiers)
+}
+
+// WarnFor reports whether a domain with daysLeft until expiration is within WarnThresholdDays,
+// but not yet within the largest notification tier
+func (c *Config) WarnFor(name string, daysLeft int) bool {
+	return c.WarnThresholdDays > 0 && daysLeft <= c.WarnThresholdDays && daysLeft > c.TiersFor(name)[0]
+}
+
+// Notifies reports whether notifications about a domain are routed to a channel
+// The domain's Channels take precedence over NotifyChannels; with neither set every configured channel is used
+func (c *Config) Notifies(name, channel string) bool {
+	routes := c.NotifyChannels
+	if d := c.Domain(name); len(d.Channels) > 0 {
+		routes = d.Channels
+	}
+	return len(routes) == 0 || slices.ContainsFunc(routes, func(route string) bool {
+		return strings.EqualFold(strings.TrimSpace(route), channel)
+	})
+}
+
+// validateChannels checks that every name in a channel list is a known channel
+func validateChannels(field string, names []string) error {
+	for _, name := range names {
+		if !slices.Contains(channels, strings.ToLower(strings.TrimSpace(name))) {
+			return fmt.Errorf("%s: unknown channel %q, expected one of %s", field, name, strings.Join(channels, ", "))
+		}
+	}
+	return nil
+}
+
+// EmailToFor returns the email recipient for a domain
+func (c *Config) EmailToFor(name string) string {
+	if d := c.Domain(name); d.EmailTo != "" {
+		return d.EmailTo
+	}
+	return c.EmailTo
+}
+
+// LoadDomainsFile appends the domains listed in DomainsFile to Domains
+// Blank lines and lines starting with # are ignored; domains that are already configured are skipped,
+// so entries with overrides in the main config take precedence
+func (c *Config) LoadDomainsFile() error {
+	if c.DomainsFile == "" {
+		return nil
+	}
+
+	data, err := os.ReadFile(c.DomainsFile)
+	if err != nil {
+		return err
+	}
+
+	seen := make(map[string]struct{}, len(c.Domains))
+	for _, name := range c.DomainNames() {
+		seen[strings.TrimSpace(name)] = struct{}{}
+	}
+
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if _, ok := seen[line]; ok {
+			continue
+		}
+		seen[line] = struct{}{}
+		c.Domains = append(c.Domains, DomainEntry{Name: line})
+	}
+
+	return nil
+}
+
+// setDomainList sets the domains from a comma separated env var of plain names
+func setDomainList(field *[]DomainEntry, env string) {
+	var names []string
+	setStringList(&names, env, ",")
+	if names == nil {
+		return
+	}
+
+	domains := make([]DomainEntry, len(names))
+	for i, name := range names {
+		domains[i] = DomainEntry{Name: name}
+	}
+	*field = domains
+}
+
+// mergeDomains appends the added domains to the base ones
+// An added entry replaces a base entry of the same name, so an override file can change its settings
+func mergeDomains(base, added []DomainEntry) []DomainEntry {
+	merged := slices.DeleteFunc(slices.Clone(base), func(b DomainEntry) bool {
+		return slices.ContainsFunc(added, func(a DomainEntry) bool {
+			return strings.EqualFold(strings.TrimSpace(a.Name), strings.TrimSpace(b.Name))
+		})
+	})
+	return append(merged, added...)
+}
@@ -0,0 +1,249 @@
+package config
+
+import (
+	"bytes"
+	"embed"
+	"encoding/json"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"reflect"
+	"regexp"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// sources holds the declarations of the config types, whose field comments describe the settings in the schema
+//
+//go:embed config.go domains.go
+var sources embed.FS
+
+// schemaDialect is the JSON schema version Schema follows
+const schemaDialect = "https://json-schema.org/draft/2020-12/schema"
+
+// jsonSchema is the subset of JSON schema Schema uses
+// x-env names the environment variable overriding a setting
+type jsonSchema struct {
+	Dialect              string        `json:"$schema,omitempty"`
+	Title                string        `json:"title,omitempty"`
+	Description          string        `json:"description,omitempty"`
+	Type                 string        `json:"type,omitempty"`
+	Items                *jsonSchema   `json:"items,omitempty"`
+	OneOf                []*jsonSchema `json:"oneOf,omitempty"`
+	Properties           properties    `json:"properties,omitempty"`
+	Required             []string      `json:"required,omitempty"`
+	AdditionalProperties any           `json:"additionalProperties,omitempty"` // false or a *jsonSchema
+	Default              any           `json:"default,omitempty"`
+	Env                  string        `json:"x-env,omitempty"`
+}
+
+// property is a named entry of the properties of an object schema
+type property struct {
+	name   string
+	schema *jsonSchema
+}
+
+// properties keeps the properties of an object schema in declaration order, which a map can't
+type properties []property
+
+// MarshalJSON writes the properties as an object in their order
+func (p properties) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, prop := range p {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		name, err := json.Marshal(prop.name)
+		if err != nil {
+			return nil, err
+		}
+		value, err := json.Marshal(prop.schema)
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(name)
+		buf.WriteByte(':')
+		buf.Write(value)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+// Schema returns a JSON schema of the config file, describing every setting with its default and,
+// as x-env, the environment variable overriding it
+// It's built from the Config struct, its json tags and field comments, so it follows the code
+func Schema() ([]byte, error) {
+	docs, err := fieldDocs()
+	if err != nil {
+		return nil, err
+	}
+
+	s := objectSchema(reflect.ValueOf(*New(nil)), docs, true)
+	s.Dialect = schemaDialect
+	s.Title = "domain-checker config"
+	s.Description = "Settings of the JSON or YAML config file; environment variables override them"
+
+	// Lets a config file point editors at the schema without failing its validation
+	ref := property{"$schema", &jsonSchema{Type: "string", Description: "Schema of this file for editors, ignored by the checker"}}
+	s.Properties = append(properties{ref}, s.Properties...)
+	return json.MarshalIndent(s, "", "  ")
+}
+
+// objectSchema describes a struct with the defaults from v and the field comments from docs
+// Top-level settings also get their env var
+func objectSchema(v reflect.Value, docs map[string]map[string]string, env bool) *jsonSchema {
+	s := &jsonSchema{Type: "object", AdditionalProperties: false}
+	for i := range v.NumField() {
+		field := v.Type().Field(i)
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "" || name == "-" || !field.IsExported() {
+			continue
+		}
+
+		prop := typeSchema(field.Type, docs)
+		prop.Description = jsonNames(docs[v.Type().Name()][field.Name])
+		if field.Type == reflect.TypeFor[time.Duration]() {
+			prop.Description += ". A number of nanoseconds in a config file, a duration like 5m in the environment"
+		}
+		if value := v.Field(i); !value.IsZero() {
+			prop.Default = value.Interface()
+		}
+		if env {
+			prop.Env = strings.ToUpper(name)
+		}
+		s.Properties = append(s.Properties, property{name, prop})
+	}
+	return s
+}
+
+// typeSchema returns the schema of a config value type
+func typeSchema(t reflect.Type, docs map[string]map[string]string) *jsonSchema {
+	switch {
+	case t == reflect.TypeFor[DomainEntry]():
+		// A plain name or an object with overrides, see DomainEntry.UnmarshalJSON
+		entry := objectSchema(reflect.ValueOf(DomainEntry{}), docs, false)
+		entry.Required = []string{"name"}
+		return &jsonSchema{OneOf: []*jsonSchema{{Type: "string"}, entry}}
+	case t.Kind() == reflect.Pointer:
+		return typeSchema(t.Elem(), docs)
+	case t.Kind() == reflect.Slice:
+		return &jsonSchema{Type: "array", Items: typeSchema(t.Elem(), docs)}
+	case t.Kind() == reflect.Map:
+		return &jsonSchema{Type: "object", AdditionalProperties: typeSchema(t.Elem(), docs)}
+	case t.Kind() == reflect.Bool:
+		return &jsonSchema{Type: "boolean"}
+	case t.Kind() == reflect.Int, t.Kind() == reflect.Int64:
+		return &jsonSchema{Type: "integer"}
+	default:
+		return &jsonSchema{Type: "string"}
+	}
+}
+
+// fieldDocs returns the field comments of the struct types declared in sources, by type and field name
+// A field without a comment above it shares the one of the group it's declared in, without a blank line
+// in between, followed by its line comment
+func fieldDocs() (map[string]map[string]string, error) {
+	fset := token.NewFileSet()
+	docs := make(map[string]map[string]string)
+	files, err := sources.ReadDir(".")
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range files {
+		data, err := sources.ReadFile(f.Name())
+		if err != nil {
+			return nil, err
+		}
+		file, err := parser.ParseFile(fset, f.Name(), data, parser.ParseComments)
+		if err != nil {
+			return nil, err
+		}
+
+		ast.Inspect(file, func(n ast.Node) bool {
+			spec, ok := n.(*ast.TypeSpec)
+			if !ok {
+				return true
+			}
+			st, ok := spec.Type.(*ast.StructType)
+			if !ok {
+				return false
+			}
+
+			fields := make(map[string]string)
+			group, prevLine := "", 0
+			for _, field := range st.Fields.List {
+				line := fset.Position(field.Pos()).Line
+				doc := sentences(field.Doc.Text())
+				switch {
+				case doc != "":
+					group = doc
+				case line > prevLine+1:
+					group = ""
+				}
+				prevLine = fset.Position(field.End()).Line
+
+				if doc == "" {
+					doc = group
+					if comment := sentences(field.Comment.Text()); comment != "" && doc != "" {
+						doc += ": " + comment
+					} else if comment != "" {
+						doc = comment
+					}
+				}
+				for _, name := range field.Names {
+					fields[name.Name] = doc
+				}
+			}
+			docs[spec.Name.Name] = fields
+			return false
+		})
+	}
+	return docs, nil
+}
+
+// sentences joins the lines of a comment, which state one sentence each unless they continue the one before,
+// starting in lowercase or following an "e.g."
+func sentences(comment string) string {
+	var text string
+	for _, line := range strings.Split(strings.TrimSpace(comment), "\n") {
+		line = strings.TrimSpace(line)
+		switch {
+		case text == "":
+			text = line
+		case line != "" && unicode.IsLower([]rune(line)[0]), strings.HasSuffix(text, "."):
+			text += " " + line
+		default:
+			text += ". " + line
+		}
+	}
+	return text
+}
+
+// goName matches a Go identifier starting with an upper case letter
+var goName = regexp.MustCompile(`\b[A-Z][A-Za-z]+\b`)
+
+// jsonNames replaces the Go names of config fields in a comment with the names used in the config file
+// Words starting a sentence are left alone, as they're capitalized either way
+func jsonNames(text string) string {
+	t := reflect.TypeFor[Config]()
+	var out strings.Builder
+	last := 0
+	for _, m := range goName.FindAllStringIndex(text, -1) {
+		if m[0] == 0 || strings.HasSuffix(text[:m[0]], ". ") {
+			continue
+		}
+		field, ok := t.FieldByName(text[m[0]:m[1]])
+		if !ok {
+			continue
+		}
+		if name, _, _ := strings.Cut(field.Tag.Get("json"), ","); name != "" {
+			out.WriteString(text[last:m[0]])
+			out.WriteString(name)
+			last = m[1]
+		}
+	}
+	out.WriteString(text[last:])
+	return out.String()
+}
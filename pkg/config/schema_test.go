@@ -0,0 +1,86 @@
+package config
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/mallocator/domain-checker/pkg/logger"
+)
+
+// schemaProperty is the part of a property schema the tests look at
+type schemaProperty struct {
+	Description string `json:"description"`
+	Type        string `json:"type"`
+	Items       struct {
+		Type string `json:"type"`
+	} `json:"items"`
+	Default any    `json:"default"`
+	Env     string `json:"x-env"`
+}
+
+// TestSchema tests that the schema describes every setting with the env var LoadFromEnv reads for it
+func TestSchema(t *testing.T) {
+	data, err := Schema()
+	if err != nil {
+		t.Fatalf("Schema() returned error: %v", err)
+	}
+	var schema struct {
+		Properties map[string]schemaProperty `json:"properties"`
+	}
+	if err := json.Unmarshal(data, &schema); err != nil {
+		t.Fatalf("Schema() isn't valid JSON: %v", err)
+	}
+
+	defaults := New(logger.New())
+	typ := reflect.TypeFor[Config]()
+	for i := range typ.NumField() {
+		field := typ.Field(i)
+		name := field.Tag.Get("json")
+		if name == "" {
+			continue
+		}
+		prop, ok := schema.Properties[name]
+		if !ok {
+			t.Errorf("%s: missing from the schema", name)
+			continue
+		}
+		if prop.Description == "" {
+			t.Errorf("%s: no description", name)
+		}
+
+		// Setting the env var has to change the field
+		value := map[string]string{"string": "a.example", "boolean": "true", "integer": "12345", "object": "k=v"}[prop.Type]
+		if prop.Type == "array" {
+			value = map[string]string{"string": "a.example,b.example", "integer": "1,2", "": "a.example"}[prop.Items.Type]
+		}
+		if prop.Default == true {
+			value = "false"
+		}
+		if field.Type.Name() == "Duration" {
+			value = "12345s"
+		}
+		t.Setenv(prop.Env, value)
+		cfg := New(logger.New())
+		cfg.LoadFromEnv()
+		if reflect.DeepEqual(reflect.ValueOf(*cfg).Field(i).Interface(), reflect.ValueOf(*defaults).Field(i).Interface()) {
+			t.Errorf("%s: setting %s=%s didn't change the setting", name, prop.Env, value)
+		}
+	}
+
+	if _, ok := schema.Properties["$schema"]; !ok {
+		t.Errorf("Expected config files to be allowed to reference the schema")
+	}
+	if got := schema.Properties["threshold_days"].Default; got != float64(7) {
+		t.Errorf("Expected threshold_days to default to 7, got %v", got)
+	}
+	if got := schema.Properties["lock_timeout"].Default; got != float64(60e9) {
+		t.Errorf("Expected lock_timeout to default to a minute in nanoseconds, got %v", got)
+	}
+	if got := schema.Properties["retries"].Description; got != "Retry configuration" {
+		t.Errorf("Expected retries to share the comment of its group, got %q", got)
+	}
+	if got := schema.Properties["strict_resolver"].Description; got != "Fail DNS lookups instead of querying 8.8.8.8 when dns_servers is empty and resolv.conf has no nameserver" {
+		t.Errorf("Expected config names in the description, got %q", got)
+	}
+}
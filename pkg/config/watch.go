@@ -0,0 +1,133 @@
+package config
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"slices"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// watchDebounce collapses the bursts of events editors produce when saving into a single reload
+const watchDebounce = 200 * time.Millisecond
+
+// Watch reloads the configuration whenever one of the config files or the domains file changes
+// Each reload reads the file, re-applies env overrides and apply, e.g. command line flags, and validates
+// the result; onChange is only called with configs that pass validation, otherwise the error is logged
+// and the old config stays in effect. A domains file named by a reloaded config is watched from then on.
+// Watching stops when ctx is done.
+func (c *Config) Watch(ctx context.Context, apply func(*Config), onChange func(*Config)) error {
+	if c.path == "" {
+		return errors.New("config wasn't loaded from a file")
+	}
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+
+	// Watch the directories rather than the files, editors often save by replacing the file
+	files := make(map[string]struct{})
+	dirs := make(map[string]struct{})
+	watch := func(file string) error {
+		file = filepath.Clean(file)
+		files[file] = struct{}{}
+		dir := filepath.Dir(file)
+		if _, ok := dirs[dir]; ok {
+			return nil
+		}
+		if err := watcher.Add(dir); err != nil {
+			return err
+		}
+		dirs[dir] = struct{}{}
+		return nil
+	}
+	for _, file := range c.watchedFiles() {
+		if err := watch(file); err != nil {
+			_ = watcher.Close()
+			return err
+		}
+	}
+
+	go func() {
+		defer func() {
+			if err := watcher.Close(); err != nil {
+				c.Log.Warnf("Failed to close config watcher: %v", err)
+			}
+		}()
+
+		// Stopped timer that fires once changes have settled
+		reload := time.NewTimer(0)
+		<-reload.C
+
+		for {
+			select {
+			case <-ctx.Done():
+				reload.Stop()
+				return
+			case ev, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				if _, watched := files[filepath.Clean(ev.Name)]; !watched || ev.Op == fsnotify.Chmod {
+					continue
+				}
+				reload.Reset(watchDebounce)
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				c.Log.Warnf("Config watcher error: %v", err)
+			case <-reload.C:
+				next, err := c.reload(apply)
+				if err != nil {
+					c.Log.Errorf("Config reload failed, keeping the current config: %v", err)
+					continue
+				}
+				for _, file := range next.watchedFiles() {
+					if err := watch(file); err != nil {
+						c.Log.Warnf("Not watching %s for changes: %v", file, err)
+					}
+				}
+				c.Log.Infof("Reloaded config from %s", c.path)
+				onChange(next)
+			}
+		}
+	}()
+
+	return nil
+}
+
+// watchedFiles returns the files a change of which reloads the config
+func (c *Config) watchedFiles() []string {
+	files := slices.Clone(c.files)
+	if c.DomainsFile != "" {
+		files = append(files, c.DomainsFile)
+	}
+	return files
+}
+
+// reload builds a new config from the same files, the current environment and apply, if it's not nil
+func (c *Config) reload(apply func(*Config)) (*Config, error) {
+	next := New(c.Log)
+	if err := next.LoadFromFile(c.path); err != nil {
+		return nil, err
+	}
+	next.LoadFromEnv()
+	if apply != nil {
+		apply(next)
+	}
+	if err := next.LoadSecretFiles(); err != nil {
+		return nil, err
+	}
+	if err := next.LoadDomainsFile(); err != nil {
+		return nil, err
+	}
+	// Validating also indexes the reloaded domains for Domain
+	if err := next.Validate(); err != nil {
+		return nil, err
+	}
+	return next, nil
+}
@@ -0,0 +1,150 @@
+package config
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/mallocator/domain-checker/pkg/logger"
+)
+
+func TestWatch(t *testing.T) {
+	dir := t.TempDir()
+	cfgFile := filepath.Join(dir, "config.json")
+	if err := os.WriteFile(cfgFile, []byte(`{"domains":["example.com"]}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := New(logger.New())
+	if err := cfg.LoadFromFile(cfgFile); err != nil {
+		t.Fatalf("LoadFromFile failed: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	changes := make(chan *Config, 10)
+	if err := cfg.Watch(ctx, nil, func(next *Config) { changes <- next }); err != nil {
+		t.Fatalf("Watch failed: %v", err)
+	}
+
+	// A valid change is passed on
+	if err := os.WriteFile(cfgFile, []byte(`{"domains":["example.com","example.org"]}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case next := <-changes:
+		if len(next.Domains) != 2 || next.Domains[1].Name != "example.org" {
+			t.Errorf("Expected reloaded domains [example.com example.org], got %v", next.DomainNames())
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Expected a reload after the config file changed")
+	}
+
+	// An invalid change is dropped and the old config stays
+	if err := os.WriteFile(cfgFile, []byte(`{"domains":["example.com"],"concurrency":0}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case next := <-changes:
+		t.Errorf("Expected no reload for an invalid config, got concurrency %d", next.Concurrency)
+	case <-time.After(3 * watchDebounce):
+	}
+	if len(cfg.Domains) != 1 {
+		t.Errorf("Expected the original config to be untouched, got %v", cfg.DomainNames())
+	}
+}
+
+func TestWatch_NoFile(t *testing.T) {
+	cfg := New(logger.New())
+	if err := cfg.Watch(context.Background(), nil, func(*Config) {}); err == nil {
+		t.Errorf("Expected an error when the config wasn't loaded from a file")
+	}
+}
+
+func TestWatch_Apply(t *testing.T) {
+	// The domains come from outside the file, like with the -domains flag
+	dir := t.TempDir()
+	cfgFile := filepath.Join(dir, "config.json")
+	if err := os.WriteFile(cfgFile, []byte(`{"threshold_days":10}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := New(logger.New())
+	if err := cfg.LoadFromFile(cfgFile); err != nil {
+		t.Fatalf("LoadFromFile failed: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	apply := func(c *Config) { c.Domains = []DomainEntry{{Name: "example.com"}} }
+	changes := make(chan *Config, 10)
+	if err := cfg.Watch(ctx, apply, func(next *Config) { changes <- next }); err != nil {
+		t.Fatalf("Watch failed: %v", err)
+	}
+
+	if err := os.WriteFile(cfgFile, []byte(`{"threshold_days":20}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case next := <-changes:
+		if next.ThresholdDays != 20 || len(next.Domains) != 1 {
+			t.Errorf("Expected threshold 20 with the applied domain, got %d and %v", next.ThresholdDays, next.DomainNames())
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Expected a reload validated with the applied domains")
+	}
+}
+
+func TestWatch_NewDomainsFile(t *testing.T) {
+	dir := t.TempDir()
+	cfgFile := filepath.Join(dir, "config.json")
+	if err := os.WriteFile(cfgFile, []byte(`{"domains":["example.com"]}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := New(logger.New())
+	if err := cfg.LoadFromFile(cfgFile); err != nil {
+		t.Fatalf("LoadFromFile failed: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	changes := make(chan *Config, 10)
+	if err := cfg.Watch(ctx, nil, func(next *Config) { changes <- next }); err != nil {
+		t.Fatalf("Watch failed: %v", err)
+	}
+
+	// A domains file in another directory, named by the reloaded config
+	domainsFile := filepath.Join(t.TempDir(), "domains.txt")
+	if err := os.WriteFile(domainsFile, []byte("example.org\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	data := `{"domains":["example.com"],"domains_file":` + strconv.Quote(domainsFile) + `}`
+	if err := os.WriteFile(cfgFile, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-changes:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Expected a reload after the config file changed")
+	}
+
+	// Changes to the new domains file are picked up
+	if err := os.WriteFile(domainsFile, []byte("example.org\nexample.net\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case next := <-changes:
+		if len(next.Domains) != 3 {
+			t.Errorf("Expected 3 domains after the domains file changed, got %v", next.DomainNames())
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Expected a reload after the new domains file changed")
+	}
+}
@@ -0,0 +1,79 @@
+package dns
+
+import (
+	"context"
+	"sync"
+
+	"golang.org/x/sync/semaphore"
+
+	"github.com/mallocator/domain-checker/pkg/idn"
+)
+
+// Result is the outcome of checking a single domain in a batch
+type Result struct {
+	Available bool
+	Err       error
+}
+
+// IsAvailableBatch checks many domains concurrently and returns the result for each of them, keyed by the given name
+// At most DNSLimit lookups run at once, and each domain is given up on after PerDomainTimeout if one is set
+// Names that are the same after conversion to ASCII, e.g. differing only in case, are looked up once
+// Once ctx is done no new lookups are started; the remaining domains get ctx's error
+func (c *Checker) IsAvailableBatch(ctx context.Context, domains []string) map[string]Result {
+	// Group the names by what's actually queried
+	names := make(map[string][]string)
+	var order []string
+	for _, domain := range domains {
+		key, err := idn.ToASCII(domain)
+		if err != nil {
+			key = domain // the lookup fails with the same error
+		}
+		if _, ok := names[key]; !ok {
+			order = append(order, key)
+		}
+		names[key] = append(names[key], domain)
+	}
+
+	var mu sync.Mutex
+	results := make(map[string]Result, len(domains))
+	record := func(key string, res Result) {
+		mu.Lock()
+		defer mu.Unlock()
+		for _, domain := range names[key] {
+			results[domain] = res
+		}
+	}
+
+	sem := semaphore.NewWeighted(int64(c.cfg.DNSLimit()))
+	var wg sync.WaitGroup
+	for _, key := range order {
+		if err := sem.Acquire(ctx, 1); err != nil {
+			record(key, Result{Err: err})
+			continue
+		}
+		if err := ctx.Err(); err != nil { // Acquire may succeed with a free slot even though ctx is done
+			sem.Release(1)
+			record(key, Result{Err: err})
+			continue
+		}
+
+		wg.Add(1)
+		go func(key string) {
+			defer wg.Done()
+			defer sem.Release(1)
+
+			lookupCtx := ctx
+			if c.cfg.PerDomainTimeout > 0 {
+				var cancel context.CancelFunc
+				lookupCtx, cancel = context.WithTimeout(ctx, c.cfg.PerDomainTimeout)
+				defer cancel()
+			}
+
+			available, err := c.IsAvailable(lookupCtx, names[key][0])
+			record(key, Result{Available: available, Err: err})
+		}(key)
+	}
+	wg.Wait()
+
+	return results
+}
@@ -0,0 +1,86 @@
+package dns
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/mallocator/domain-checker/pkg/config"
+	"github.com/mallocator/domain-checker/pkg/logger"
+)
+
+func TestIsAvailableBatch(t *testing.T) {
+	// Only taken.com is registered
+	var inFlight, maxInFlight atomic.Int32
+	server := newMockServer(t, "udp4", func(q mockQuery) mockReply {
+		n := inFlight.Add(1)
+		defer inFlight.Add(-1)
+		for {
+			m := maxInFlight.Load()
+			if n <= m || maxInFlight.CompareAndSwap(m, n) {
+				break
+			}
+		}
+		if q.name == "taken.com" {
+			return mockReply{ancount: 1}
+		}
+		return mockReply{rcode: rcodeNameError}
+	})
+
+	log := logger.New()
+	cfg := config.New(log)
+	cfg.Timeout = time.Second
+	cfg.DNSConcurrency = 2
+	cfg.DNSServers = []string{server.addr.String()}
+	checker := New(cfg, log)
+
+	domains := []string{"taken.com", "free.com", "Free.com", "other.org", "bad_name..com"}
+	results := checker.IsAvailableBatch(context.Background(), domains)
+
+	if len(results) != len(domains) {
+		t.Fatalf("Expected a result for each of %d domains, got %v", len(domains), results)
+	}
+	if res := results["taken.com"]; res.Err != nil || res.Available {
+		t.Errorf("Expected taken.com to be registered, got %+v", res)
+	}
+	for _, domain := range []string{"free.com", "Free.com", "other.org"} {
+		if res := results[domain]; res.Err != nil || !res.Available {
+			t.Errorf("Expected %s to be available, got %+v", domain, res)
+		}
+	}
+	if res := results["bad_name..com"]; res.Err == nil {
+		t.Errorf("Expected an error for an invalid name, got %+v", res)
+	}
+
+	// free.com and Free.com share a lookup
+	if got := len(server.received()); got != 3 {
+		t.Errorf("Expected 3 queries, got %d: %+v", got, server.received())
+	}
+	if got := maxInFlight.Load(); got > 2 {
+		t.Errorf("Expected at most 2 lookups at once, got %d", got)
+	}
+}
+
+func TestIsAvailableBatch_Cancelled(t *testing.T) {
+	server := newMockServer(t, "udp4", func(mockQuery) mockReply { return mockReply{ancount: 1} })
+
+	log := logger.New()
+	cfg := config.New(log)
+	cfg.DNSServers = []string{server.addr.String()}
+	checker := New(cfg, log)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	results := checker.IsAvailableBatch(ctx, []string{"example.com", "example.org"})
+	for _, domain := range []string{"example.com", "example.org"} {
+		if res := results[domain]; !errors.Is(res.Err, context.Canceled) {
+			t.Errorf("Expected %s to report the cancellation, got %+v", domain, res)
+		}
+	}
+	if got := len(server.received()); got != 0 {
+		t.Errorf("Expected no queries once cancelled, got %d", got)
+	}
+}
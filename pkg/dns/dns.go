@@ -5,48 +5,261 @@ import (
 	"bufio"
 	"context"
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"net"
+	"net/netip"
 	"os"
 	"strings"
+	"sync"
+	"time"
 
+	"github.com/mallocator/domain-checker/pkg/backoff"
 	"github.com/mallocator/domain-checker/pkg/config"
+	"github.com/mallocator/domain-checker/pkg/idn"
 	"github.com/mallocator/domain-checker/pkg/logger"
+	"github.com/mallocator/domain-checker/pkg/metrics"
 )
 
 // Checker handles DNS operations
 type Checker struct {
 	cfg *config.Config
 	log *logger.Logger
+
+	// Resolver config, replaceable in tests
+	resolvConf string
+
+	// Logs the missing resolver once rather than for every lookup
+	strictOnce sync.Once
+
+	// Jitter for the backoff between retries
+	jitter *backoff.Jitter
 }
 
 // New creates a new DNS checker
 func New(cfg *config.Config, log *logger.Logger) *Checker {
 	return &Checker{
-		cfg: cfg,
-		log: log,
+		cfg:        cfg,
+		log:        log,
+		resolvConf: "/etc/resolv.conf",
+		jitter:     backoff.New(),
 	}
 }
 
-// IsAvailable does DNS SOA lookup with context timeout
-// Returns true if the domain is available (no SOA record found)
-func (c *Checker) IsAvailable(domain string) (bool, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), c.cfg.Timeout)
-	defer cancel()
+// DNS record type codes used in queries
+const (
+	typeA    uint16 = 1
+	typeNS   uint16 = 2
+	typeSOA  uint16 = 6
+	typeAAAA uint16 = 28
+	typeOPT  uint16 = 41 // EDNS0 pseudo-record
+)
+
+// ednsBufferSize is the UDP payload size advertised with EDNS0 and the size of the read buffer
+const ednsBufferSize = 4096
+
+// ednsRecordSize is the length of the OPT record createDNSQuery appends to a query
+const ednsRecordSize = 11
+
+// DNS response codes that answer the query
+const (
+	rcodeSuccess   = 0 // NOERROR
+	rcodeNameError = 3 // NXDOMAIN
+)
+
+// rcodeFormatError is the FORMERR response code, which servers without EDNS0 may answer a query with an OPT record
+const rcodeFormatError = 1
+
+// IsAvailable does DNS lookups with context timeout
+// Registrable domains are checked for the AvailabilityRecordType, an SOA record by default; subdomains and
+// wildcards for an A or AAAA record, as an SOA lookup below the apex would find the parent zone
+// Returns true if the domain is available (no matching record found)
+// Cancelling ctx aborts the lookup
+func (c *Checker) IsAvailable(ctx context.Context, domain string) (bool, error) {
+	available := true
+	var err error
+	for _, recordType := range c.recordTypes(domain) {
+		var found bool
+		found, err = c.lookup(ctx, domain, recordType)
+		if err != nil || found {
+			available = false
+			break
+		}
+	}
+	if err != nil && ctx.Err() == nil {
+		metrics.DNSError()
+	}
+	return available, err
+}
 
-	// Read DNS server from /etc/resolv.conf
-	dnsServer, err := c.getNameserver()
+// typeName returns the mnemonic of a record type for logging
+func typeName(recordType uint16) string {
+	switch recordType {
+	case typeA:
+		return "A"
+	case typeNS:
+		return "NS"
+	case typeSOA:
+		return "SOA"
+	case typeAAAA:
+		return "AAAA"
+	}
+	return fmt.Sprintf("TYPE%d", recordType)
+}
+
+// recordTypes returns the record types whose presence means a name is taken
+func (c *Checker) recordTypes(domain string) []uint16 {
+	if !config.IsApex(domain) {
+		return []uint16{typeA, typeAAAA}
+	}
+	switch strings.ToUpper(c.cfg.AvailabilityRecordType) {
+	case config.RecordNS:
+		return []uint16{typeNS}
+	case config.RecordA:
+		return []uint16{typeA, typeAAAA}
+	default:
+		return []uint16{typeSOA}
+	}
+}
+
+// lookup sends a query and reports whether the answer had any records
+// With several nameservers configured, the next one is asked when one can't be reached or can't answer
+func (c *Checker) lookup(ctx context.Context, domain string, recordType uint16) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+
+	servers, err := c.nameservers()
 	if err != nil {
 		return false, fmt.Errorf("failed to read DNS config: %w", err)
 	}
 
-	// Create a DNS query for SOA record
-	query := c.createDNSQuery(domain, 6) // 6 is the type code for SOA records
+	// Create a DNS query for the record type
+	query, err := c.query(domain, recordType)
+	if err != nil {
+		return false, err
+	}
+
+	if c.cfg.ResolverConsensus != "" && len(servers) > 1 {
+		return c.consensus(ctx, domain, recordType, servers, query)
+	}
+
+	var errs []error
+	for _, server := range servers {
+		found, err := c.ask(ctx, domain, recordType, server, query)
+		if err != nil {
+			if ctx.Err() != nil {
+				return false, ctx.Err()
+			}
+			errs = append(errs, err)
+			continue
+		}
+		return found, nil
+	}
+	return false, errors.Join(errs...)
+}
+
+// ask sends a query to a single nameserver and reports whether the answer had any records
+func (c *Checker) ask(ctx context.Context, domain string, recordType uint16, server netip.AddrPort, query []byte) (bool, error) {
+	response, err := c.exchange(ctx, server, query)
+	if err == nil && rejectedEDNS(query, response) {
+		c.log.Debugf("DNS server %s doesn't support EDNS0, asking again without it for %s", server, domain)
+		response, err = c.exchange(ctx, server, withoutEDNS(query))
+	}
+	if err != nil {
+		c.log.Debugf("DNS server %s failed for %s: %v", server, domain, err)
+		return false, err
+	}
+
+	// Parse the response to check for records
+	found, err := c.parseSOAResponse(response)
+	if err != nil {
+		c.log.Debugf("DNS server %s failed for %s: %v", server, domain, err)
+		return false, err
+	}
+	c.log.Debugf("DNS %s lookup for %s via %s: rcode %d, %d answers", typeName(recordType), domain, server,
+		response[3]&0x0f, binary.BigEndian.Uint16(response[6:8]))
+	return found, nil
+}
+
+// consensus asks all nameservers at once and reports no record only when as many of them as ResolverConsensus
+// requires found none; short of that, a record any of them found counts, so one flaky resolver can't report a
+// registered domain available
+// Returns an error if neither answer has enough support, e.g. when most servers failed
+func (c *Checker) consensus(ctx context.Context, domain string, recordType uint16, servers []netip.AddrPort, query []byte) (bool, error) {
+	type answer struct {
+		found bool
+		err   error
+	}
+	answers := make([]answer, len(servers))
+	var wg sync.WaitGroup
+	for i, server := range servers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			found, err := c.ask(ctx, domain, recordType, server, query)
+			answers[i] = answer{found, err}
+		}()
+	}
+	wg.Wait()
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+
+	var found, missing []string
+	var errs []error
+	for i, a := range answers {
+		switch {
+		case a.err != nil:
+			errs = append(errs, fmt.Errorf("%s: %w", servers[i], a.err))
+		case a.found:
+			found = append(found, servers[i].String())
+		default:
+			missing = append(missing, servers[i].String())
+		}
+	}
+
+	required := len(servers)
+	if strings.EqualFold(c.cfg.ResolverConsensus, config.ConsensusMajority) {
+		required = len(servers)/2 + 1
+	}
+	if len(found) > 0 && len(missing) > 0 {
+		c.log.Warnf("DNS servers disagree on the %s record of %s: found by %s, not by %s", typeName(recordType), domain,
+			strings.Join(found, ", "), strings.Join(missing, ", "))
+	}
+	switch {
+	case len(missing) >= required:
+		return false, nil
+	case len(found) > 0:
+		return true, nil
+	default:
+		return false, fmt.Errorf("%w: only %d of %d DNS servers found no %s record, %d needed: %w", ErrNoConsensus,
+			len(missing), len(servers), typeName(recordType), required, errors.Join(errs...))
+	}
+}
+
+// nameservers returns the configured DNS servers, or the one from resolv.conf if none are configured
+func (c *Checker) nameservers() ([]netip.AddrPort, error) {
+	servers, err := c.cfg.DNSServerAddrs()
+	if err != nil || len(servers) > 0 {
+		return servers, err
+	}
+
+	addr, err := c.getNameserver()
+	if err != nil {
+		return nil, err
+	}
+	return []netip.AddrPort{netip.AddrPortFrom(addr, uint16(c.cfg.DNSPort))}, nil
+}
 
+// exchange sends a query to a single nameserver and returns the raw response
+// UDP packets can get lost, so the query is re-sent up to DNSRetries times when no response arrives in time,
+// each time after the backoff
+func (c *Checker) exchange(ctx context.Context, server netip.AddrPort, query []byte) ([]byte, error) {
 	// Send the query to the DNS server
-	conn, err := net.DialUDP("udp", nil, &net.UDPAddr{IP: dnsServer, Port: 53})
+	conn, err := net.DialUDP("udp", nil, net.UDPAddrFromAddrPort(server))
 	if err != nil {
-		return false, fmt.Errorf("failed to connect to DNS server: %w", err)
+		return nil, fmt.Errorf("failed to connect to DNS server: %w", err)
 	}
 	defer func() {
 		if err := conn.Close(); err != nil {
@@ -54,42 +267,72 @@ func (c *Checker) IsAvailable(domain string) (bool, error) {
 		}
 	}()
 
-	// Set deadline based on context
-	if deadline, ok := ctx.Deadline(); ok {
-		if err := conn.SetDeadline(deadline); err != nil {
-			c.log.Warnf("Failed to set deadline for DNS connection: %v", err)
+	// Unblock reads and writes right away if ctx is cancelled before the deadline
+	stop := context.AfterFunc(ctx, func() {
+		_ = conn.SetDeadline(time.Now())
+	})
+	defer stop()
+
+	for attempt := 0; ; attempt++ {
+		response, err := c.roundTrip(ctx, conn, query)
+		var netErr net.Error
+		if err == nil || attempt >= c.cfg.DNSRetries || ctx.Err() != nil || !errors.As(err, &netErr) || !netErr.Timeout() {
+			return response, err
+		}
+		c.log.Debugf("DNS query to %s timed out, retrying (%d/%d)", server, attempt+1, c.cfg.DNSRetries)
+		if err := backoff.Sleep(ctx, c.backoff(attempt)); err != nil {
+			return nil, err
 		}
 	}
+}
+
+// backoff returns the wait after the given timed out attempt, starting at 0
+// The delay doubles with each attempt up to DNSMaxBackoff, minus some jitter
+func (c *Checker) backoff(attempt int) time.Duration {
+	return c.jitter.Delay(attempt, c.cfg.DNSBackoff, c.cfg.DNSMaxBackoff)
+}
+
+// roundTrip sends the query once and waits up to the per-query timeout for the response
+// An earlier ctx deadline is left to the cancellation in exchange, so a lookup cut short by ctx always reports ctx.Err
+func (c *Checker) roundTrip(ctx context.Context, conn *net.UDPConn, query []byte) ([]byte, error) {
+	if err := conn.SetDeadline(time.Now().Add(c.cfg.Timeout)); err != nil {
+		c.log.Warnf("Failed to set deadline for DNS connection: %v", err)
+	}
+
+	// ctx may have been cancelled before the deadline was set, overriding the one set on cancellation
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
 
 	// Send the query
-	_, err = conn.Write(query)
+	_, err := conn.Write(query)
 	if err != nil {
-		return false, fmt.Errorf("failed to send DNS query: %w", err)
+		return nil, fmt.Errorf("failed to send DNS query: %w", err)
 	}
 
 	// Receive the response
-	response := make([]byte, 512) // Standard DNS message size
+	response := make([]byte, ednsBufferSize)
 	n, err := conn.Read(response)
-	if err != nil {
-		return false, fmt.Errorf("failed to receive DNS response: %w", err)
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() && ctx.Err() == nil {
+		return nil, fmt.Errorf("%w after %s: %w", ErrTimeout, c.cfg.Timeout, err)
 	}
-
-	// Parse the response to check for SOA records
-	hasSOA, err := c.parseSOAResponse(response[:n])
 	if err != nil {
-		return false, fmt.Errorf("failed to parse DNS response: %w", err)
+		return nil, fmt.Errorf("failed to receive DNS response: %w", err)
 	}
-
-	// Domain is available if there's no SOA record
-	return !hasSOA, nil
+	return response[:n], nil
 }
 
-// getNameserver reads the first nameserver from /etc/resolv.conf
-func (c *Checker) getNameserver() (net.IP, error) {
-	file, err := os.Open("/etc/resolv.conf")
+// defaultNameserver is used when resolv.conf can't be read or has no usable nameserver
+var defaultNameserver = netip.MustParseAddr("8.8.8.8")
+
+// getNameserver reads the first usable nameserver from resolv.conf
+// IPv4 and IPv6 addresses are accepted, including IPv6 link-local addresses with a zone like fe80::1%eth0
+func (c *Checker) getNameserver() (netip.Addr, error) {
+	file, err := os.Open(c.resolvConf)
 	if err != nil {
 		// If we can't open the file, default to Google's public DNS
-		return net.ParseIP("8.8.8.8"), nil
+		return c.fallbackNameserver(err)
 	}
 	defer func() {
 		if err := file.Close(); err != nil {
@@ -105,16 +348,41 @@ func (c *Checker) getNameserver() (net.IP, error) {
 		// Look for nameserver lines
 		fields := strings.Fields(line)
 		if len(fields) >= 2 && fields[0] == "nameserver" {
-			return net.ParseIP(fields[1]), nil
+			addr, err := netip.ParseAddr(fields[1])
+			if err != nil {
+				c.log.Debugf("Ignoring nameserver %q in %s: %v", fields[1], c.resolvConf, err)
+				continue
+			}
+			return addr.Unmap(), nil
 		}
 	}
 
 	if err := scanner.Err(); err != nil {
-		return nil, err
+		return netip.Addr{}, err
 	}
 
 	// Default to Google's public DNS if no nameserver found
-	return net.ParseIP("8.8.8.8"), nil
+	return c.fallbackNameserver(fmt.Errorf("no usable nameserver in %s", c.resolvConf))
+}
+
+// fallbackNameserver returns the default nameserver for when resolv.conf has none, or an error with StrictResolver
+func (c *Checker) fallbackNameserver(reason error) (netip.Addr, error) {
+	if !c.cfg.StrictResolver {
+		return defaultNameserver, nil
+	}
+	c.strictOnce.Do(func() {
+		c.log.Errorf("No DNS resolver configured and strict_resolver is set, not falling back to %s: %v", defaultNameserver, reason)
+	})
+	return netip.Addr{}, fmt.Errorf("%w: %w", ErrNoResolver, reason)
+}
+
+// query builds the query for a domain, converting internationalized names to punycode first
+func (c *Checker) query(domain string, recordType uint16) ([]byte, error) {
+	name, err := idn.ToASCII(domain)
+	if err != nil {
+		return nil, fmt.Errorf("invalid domain name %q: %w", domain, err)
+	}
+	return c.createDNSQuery(name, recordType), nil
 }
 
 // createDNSQuery creates a minimal DNS query for the specified domain and record type
@@ -126,7 +394,7 @@ func (c *Checker) createDNSQuery(domain string, recordType uint16) []byte {
 		0x00, 0x01, // QDCOUNT: 1 question
 		0x00, 0x00, // ANCOUNT: 0 answers
 		0x00, 0x00, // NSCOUNT: 0 authority records
-		0x00, 0x00, // ARCOUNT: 0 additional records
+		0x00, 0x01, // ARCOUNT: 1 additional record, the EDNS0 OPT record
 	}
 
 	// Add the domain name in DNS format (length-prefixed labels)
@@ -145,19 +413,120 @@ func (c *Checker) createDNSQuery(domain string, recordType uint16) []byte {
 	// QCLASS: IN (Internet)
 	query = append(query, 0x00, 0x01)
 
+	// EDNS0 OPT record advertising a larger UDP payload than the classic 512 bytes
+	query = append(query, 0x00) // Name: root
+	query = binary.BigEndian.AppendUint16(query, typeOPT)
+	query = binary.BigEndian.AppendUint16(query, ednsBufferSize) // Class: UDP payload size
+	query = append(query, 0x00, 0x00, 0x00, 0x00)                // TTL: extended RCODE, version 0, no flags
+	query = append(query, 0x00, 0x00)                            // RDLENGTH: no options
+
 	return query
 }
 
-// parseSOAResponse checks if the DNS response contains an SOA record
+// rejectedEDNS reports whether a response is a server without EDNS0 refusing the OPT record of the query,
+// a FORMERR that doesn't echo an OPT record back
+func rejectedEDNS(query, response []byte) bool {
+	return len(response) >= 12 && response[3]&0x0f == rcodeFormatError &&
+		binary.BigEndian.Uint16(query[10:12]) > 0 && binary.BigEndian.Uint16(response[10:12]) == 0
+}
+
+// withoutEDNS returns a copy of a query made by createDNSQuery without its OPT record
+// Answers are then limited to the classic 512 bytes, so more of them may come back truncated
+func withoutEDNS(query []byte) []byte {
+	plain := append([]byte{}, query[:len(query)-ednsRecordSize]...)
+	binary.BigEndian.PutUint16(plain[10:12], 0) // ARCOUNT: no additional records
+	return plain
+}
+
+// parseSOAResponse checks if the DNS response contains an answer, e.g. the SOA record that was queried
+// The question and answer sections are walked, so a malformed response returns an error with the offending offset
+// instead of being trusted for its header
 func (c *Checker) parseSOAResponse(response []byte) (bool, error) {
 	if len(response) < 12 {
-		return false, fmt.Errorf("response too short")
+		return false, fmt.Errorf("%w: too short", ErrMalformedResponse)
+	}
+
+	// Only "no error" and "name doesn't exist" say anything about the domain;
+	// other codes like SERVFAIL or REFUSED mean the server couldn't answer
+	switch rcode := response[3] & 0x0f; rcode {
+	case rcodeSuccess, rcodeNameError:
+	default:
+		return false, fmt.Errorf("%w: rcode %d", ErrServerFailure, rcode)
+	}
+
+	// Only the answer section counts; the OPT record servers echo back is in the additional section
+	answers, err := countAnswers(response)
+	truncated := response[2]&0x02 != 0
+	if err != nil && !truncated {
+		return false, fmt.Errorf("%w: %w", ErrMalformedResponse, err)
+	}
+
+	// A truncated response without complete answers doesn't tell whether there would have been any
+	if answers == 0 && truncated {
+		return false, ErrTruncated
+	}
+
+	// Any answer means the name has records, e.g. the SOA record or a CNAME pointing elsewhere
+	return answers > 0, nil
+}
+
+// countAnswers walks the question and answer sections and returns the number of complete answer records
+// On a malformed section it returns the answers read up to that point along with the error
+func countAnswers(msg []byte) (int, error) {
+	qdcount := int(binary.BigEndian.Uint16(msg[4:6]))
+	ancount := int(binary.BigEndian.Uint16(msg[6:8]))
+
+	off := 12
+	for i := range qdcount {
+		end, err := skipName(msg, off)
+		if err != nil {
+			return 0, fmt.Errorf("malformed question %d: %w", i+1, err)
+		}
+		if end+4 > len(msg) {
+			return 0, fmt.Errorf("malformed question %d: type and class cut off at offset %d", i+1, end)
+		}
+		off = end + 4
 	}
 
-	// Extract the number of answers from the response header
-	ancount := binary.BigEndian.Uint16(response[6:8])
+	for i := range ancount {
+		end, err := skipName(msg, off)
+		if err != nil {
+			return i, fmt.Errorf("malformed answer %d: %w", i+1, err)
+		}
+		// Type, class, TTL and data length precede the record data
+		if end+10 > len(msg) {
+			return i, fmt.Errorf("malformed answer %d: record header cut off at offset %d", i+1, end)
+		}
+		rdlength := int(binary.BigEndian.Uint16(msg[end+8 : end+10]))
+		if end+10+rdlength > len(msg) {
+			return i, fmt.Errorf("malformed answer %d: %d bytes of record data cut off at offset %d", i+1, rdlength, end+10)
+		}
+		off = end + 10 + rdlength
+	}
+	return ancount, nil
+}
 
-	// If there are any answers, assume there's an SOA record
-	// This is a simplification - a full implementation would parse the answer section
-	return ancount > 0, nil
+// skipName returns the offset just past the domain name starting at off
+// A compression pointer ends the name, so it isn't followed
+func skipName(msg []byte, off int) (int, error) {
+	for {
+		if off >= len(msg) {
+			return 0, fmt.Errorf("name cut off at offset %d", off)
+		}
+		size := int(msg[off])
+		switch size & 0xc0 {
+		case 0x00: // label of size bytes, or the root label ending the name
+			if size == 0 {
+				return off + 1, nil
+			}
+			off += 1 + size
+		case 0xc0: // pointer to a name elsewhere in the message
+			if off+2 > len(msg) {
+				return 0, fmt.Errorf("name pointer cut off at offset %d", off)
+			}
+			return off + 2, nil
+		default:
+			return 0, fmt.Errorf("invalid label type 0x%02x at offset %d", size&0xc0, off)
+		}
+	}
 }
@@ -1,9 +1,19 @@
 package dns
 
 import (
+	"bytes"
+	"context"
 	"encoding/binary"
+	"errors"
+	"math/rand"
 	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/mallocator/domain-checker/pkg/config"
 	"github.com/mallocator/domain-checker/pkg/logger"
@@ -19,9 +29,9 @@ func TestCreateDNSQuery(t *testing.T) {
 		recordType uint16
 		wantLen    int
 	}{
-		{"example.com", 6, 29}, // 12 (header) + 1 (len) + 7 (example) + 1 (len) + 3 (com) + 1 (null) + 2 (type) + 2 (class) = 29
-		{"test.co.uk", 6, 28},  // 12 (header) + 1 (len) + 4 (test) + 1 (len) + 2 (co) + 1 (len) + 2 (uk) + 1 (null) + 2 (type) + 2 (class) = 28
-		{"a.b.c", 6, 23},       // 12 (header) + 1 (len) + 1 (a) + 1 (len) + 1 (b) + 1 (len) + 1 (c) + 1 (null) + 2 (type) + 2 (class) = 23
+		{"example.com", 6, 40}, // 12 (header) + 1 (len) + 7 (example) + 1 (len) + 3 (com) + 1 (null) + 2 (type) + 2 (class) + 11 (OPT) = 40
+		{"test.co.uk", 6, 39},  // 12 (header) + 1 (len) + 4 (test) + 1 (len) + 2 (co) + 1 (len) + 2 (uk) + 1 (null) + 2 (type) + 2 (class) + 11 (OPT) = 39
+		{"a.b.c", 6, 34},       // 12 (header) + 1 (len) + 1 (a) + 1 (len) + 1 (b) + 1 (len) + 1 (c) + 1 (null) + 2 (type) + 2 (class) + 11 (OPT) = 34
 	}
 
 	for _, tc := range tests {
@@ -44,34 +54,119 @@ func TestCreateDNSQuery(t *testing.T) {
 			t.Errorf("createDNSQuery(%q, %d) has incorrect QDCOUNT", tc.domain, tc.recordType)
 		}
 
+		if binary.BigEndian.Uint16(query[10:12]) != 1 { // ARCOUNT
+			t.Errorf("createDNSQuery(%q, %d) has incorrect ARCOUNT", tc.domain, tc.recordType)
+		}
+
 		// Check record type
-		typePos := len(query) - 4 // Type is 4 bytes from the end (2 for type, 2 for class)
+		questionEnd := len(query) - 11 // The OPT record follows the question
+		typePos := questionEnd - 4     // Type is 4 bytes from the end of the question (2 for type, 2 for class)
 		if binary.BigEndian.Uint16(query[typePos:typePos+2]) != tc.recordType {
 			t.Errorf("createDNSQuery(%q, %d) has incorrect record type", tc.domain, tc.recordType)
 		}
 
 		// Check class (should be 1 for IN)
-		if binary.BigEndian.Uint16(query[len(query)-2:]) != 1 {
+		if binary.BigEndian.Uint16(query[questionEnd-2:questionEnd]) != 1 {
 			t.Errorf("createDNSQuery(%q, %d) has incorrect class", tc.domain, tc.recordType)
 		}
+
+		// Check the EDNS0 OPT record: root name, type 41, 4096 byte payload, no extended flags or options
+		wantOPT := []byte{0x00, 0x00, 0x29, 0x10, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00}
+		if !bytes.Equal(query[questionEnd:], wantOPT) {
+			t.Errorf("createDNSQuery(%q, %d) has OPT record %x, want %x", tc.domain, tc.recordType, query[questionEnd:], wantOPT)
+		}
 	}
 }
 
+func TestQuery_IDN(t *testing.T) {
+	log := logger.New()
+	cfg := config.New(log)
+	checker := New(cfg, log)
+
+	tests := []struct {
+		domain string
+		want   string
+	}{
+		{"münchen.de", "xn--mnchen-3ya.de"},
+		{"bücher.example", "xn--bcher-kva.example"},
+		{"例え.jp", "xn--r8jz45g.jp"},
+		{"Example.COM", "example.com"},
+	}
+
+	for _, tc := range tests {
+		query, err := checker.query(tc.domain, typeSOA)
+		if err != nil {
+			t.Errorf("query(%q) returned error: %v", tc.domain, err)
+			continue
+		}
+		if want := checker.createDNSQuery(tc.want, typeSOA); !bytes.Equal(query, want) {
+			t.Errorf("query(%q) wasn't built from %s", tc.domain, tc.want)
+		}
+	}
+
+	if _, err := checker.query("bad_name..com", typeSOA); err == nil {
+		t.Errorf("Expected an error for an invalid domain name")
+	}
+}
+
+func TestRecordTypes(t *testing.T) {
+	log := logger.New()
+	cfg := config.New(log)
+	checker := New(cfg, log)
+
+	tests := []struct {
+		mode   string
+		domain string
+		want   []uint16
+	}{
+		{config.RecordSOA, "example.com", []uint16{typeSOA}},
+		{config.RecordSOA, "example.co.uk", []uint16{typeSOA}},
+		{config.RecordSOA, "münchen.de", []uint16{typeSOA}},
+		{config.RecordSOA, "www.example.com", []uint16{typeA, typeAAAA}},
+		{config.RecordSOA, "www.example.co.uk", []uint16{typeA, typeAAAA}},
+		{config.RecordSOA, "*.example.com", []uint16{typeA, typeAAAA}},
+		{"", "example.com", []uint16{typeSOA}},
+		{config.RecordNS, "example.com", []uint16{typeNS}},
+		{"ns", "example.co.uk", []uint16{typeNS}},
+		{config.RecordNS, "www.example.com", []uint16{typeA, typeAAAA}},
+		{config.RecordA, "example.com", []uint16{typeA, typeAAAA}},
+	}
+
+	for _, tc := range tests {
+		cfg.AvailabilityRecordType = tc.mode
+		if got := checker.recordTypes(tc.domain); !slices.Equal(got, tc.want) {
+			t.Errorf("recordTypes(%q) with %q = %v, want %v", tc.domain, tc.mode, got, tc.want)
+		}
+	}
+}
+
+// soaQuestion is the question section of an SOA query for example.com
+var soaQuestion = []byte{
+	0x07, 'e', 'x', 'a', 'm', 'p', 'l', 'e', 0x03, 'c', 'o', 'm', 0x00,
+	0x00, 0x06, // QTYPE: SOA
+	0x00, 0x01, // QCLASS: IN
+}
+
 func TestParseSOAResponse(t *testing.T) {
 	log := logger.New()
 	cfg := config.New(log)
 	checker := New(cfg, log)
 
 	// Test case 1: Response with SOA record (ancount > 0)
-	responseWithSOA := []byte{
+	responseWithSOA := append([]byte{
 		0x00, 0x01, // ID
 		0x81, 0x80, // Flags
 		0x00, 0x01, // QDCOUNT
 		0x00, 0x01, // ANCOUNT (1 answer)
 		0x00, 0x00, // NSCOUNT
 		0x00, 0x00, // ARCOUNT
-		// Rest of the response doesn't matter for this test
-	}
+	}, soaQuestion...)
+	responseWithSOA = append(responseWithSOA,
+		0xc0, 0x0c, // Name: pointer to the question
+		0x00, 0x06, 0x00, 0x01, // Type SOA, class IN
+		0x00, 0x00, 0x0e, 0x10, // TTL
+		0x00, 0x02, 0x00, 0x00, // 2 bytes of record data
+	)
 	hasSOA, err := checker.parseSOAResponse(responseWithSOA)
 	if err != nil {
 		t.Errorf("parseSOAResponse() returned error: %v", err)
@@ -81,15 +176,14 @@ func TestParseSOAResponse(t *testing.T) {
 	}
 
 	// Test case 2: Response without SOA record (ancount = 0)
-	responseWithoutSOA := []byte{
+	responseWithoutSOA := append([]byte{
 		0x00, 0x01, // ID
 		0x81, 0x80, // Flags
 		0x00, 0x01, // QDCOUNT
 		0x00, 0x00, // ANCOUNT (0 answers)
 		0x00, 0x00, // NSCOUNT
 		0x00, 0x00, // ARCOUNT
-		// Rest of the response doesn't matter for this test
-	}
+	}, soaQuestion...)
 	hasSOA, err = checker.parseSOAResponse(responseWithoutSOA)
 	if err != nil {
 		t.Errorf("parseSOAResponse() returned error: %v", err)
@@ -98,7 +192,27 @@ func TestParseSOAResponse(t *testing.T) {
 		t.Errorf("parseSOAResponse() = %v, want false", hasSOA)
 	}
 
-	// Test case 3: Response too short
+	// Test case 3: Response with the OPT record echoed in the additional section only
+	responseWithOPT := append([]byte{
+		0x00, 0x01, // ID
+		0x81, 0x80, // Flags
+		0x00, 0x01, // QDCOUNT
+		0x00, 0x00, // ANCOUNT (0 answers)
+		0x00, 0x00, // NSCOUNT
+		0x00, 0x01, // ARCOUNT (OPT)
+	}, soaQuestion...)
+	responseWithOPT = append(responseWithOPT, 0x00, 0x00, 0x29, 0x10, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00)
+	if hasSOA, err = checker.parseSOAResponse(responseWithOPT); err != nil || hasSOA {
+		t.Errorf("parseSOAResponse() with only an OPT record = %v, %v, want false", hasSOA, err)
+	}
+
+	// Test case 4: Truncated response without answers
+	truncated := []byte{0x00, 0x01, 0x83, 0x80, 0x00, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00}
+	if _, err = checker.parseSOAResponse(truncated); err == nil {
+		t.Errorf("parseSOAResponse() did not return error for a truncated response")
+	}
+
+	// Test case 5: Response too short
 	responseTooShort := []byte{0x00, 0x01}
 	_, err = checker.parseSOAResponse(responseTooShort)
 	if err == nil {
@@ -106,6 +220,85 @@ func TestParseSOAResponse(t *testing.T) {
 	}
 }
 
+func TestParseSOAResponse_Malformed(t *testing.T) {
+	log := logger.New()
+	checker := New(config.New(log), log)
+
+	header := func(flags byte, qdcount, ancount byte) []byte {
+		return []byte{0x00, 0x01, flags, 0x80, 0x00, qdcount, 0x00, ancount, 0x00, 0x00, 0x00, 0x00}
+	}
+	answer := []byte{0xc0, 0x0c, 0x00, 0x06, 0x00, 0x01, 0x00, 0x00, 0x0e, 0x10, 0x00, 0x02, 0x00, 0x00}
+	concat := func(parts ...[]byte) []byte { return slices.Concat(parts...) }
+
+	tests := []struct {
+		name     string
+		response []byte
+		want     bool
+		wantErr  string
+	}{
+		{"answer claimed but missing", concat(header(0x81, 1, 1), soaQuestion), false, "malformed answer 1: name cut off at offset 29"},
+		{"question cut off", concat(header(0x81, 1, 1), soaQuestion[:10]), false, "malformed question 1: name cut off at offset 24"},
+		{"question type cut off", concat(header(0x81, 1, 0), soaQuestion[:14]), false, "malformed question 1: type and class cut off at offset 25"},
+		{"invalid label type", concat(header(0x81, 1, 0), []byte{0x40}), false, "invalid label type 0x40 at offset 12"},
+		{"name pointer cut off", concat(header(0x81, 1, 1), soaQuestion, []byte{0xc0}), false, "name pointer cut off at offset 29"},
+		{"record header cut off", concat(header(0x81, 1, 1), soaQuestion, answer[:8]), false, "record header cut off at offset 31"},
+		{"record data cut off", concat(header(0x81, 1, 1), soaQuestion, answer[:13]), false, "2 bytes of record data cut off at offset 41"},
+		{"second answer missing", concat(header(0x81, 1, 2), soaQuestion, answer), false, "malformed answer 2"},
+		{"two answers", concat(header(0x81, 1, 2), soaQuestion, answer, answer), true, ""},
+		// A truncated response still counts the answers that arrived complete
+		{"truncated after an answer", concat(header(0x83, 1, 2), soaQuestion, answer, answer[:5]), true, ""},
+		{"truncated in the first answer", concat(header(0x83, 1, 1), soaQuestion, answer[:5]), false, "response truncated"},
+	}
+
+	for _, tc := range tests {
+		got, err := checker.parseSOAResponse(tc.response)
+		if tc.wantErr == "" {
+			if err != nil || got != tc.want {
+				t.Errorf("%s: parseSOAResponse() = %v, %v; want %v", tc.name, got, err, tc.want)
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+			t.Errorf("%s: parseSOAResponse() returned %v, want error containing %q", tc.name, err, tc.wantErr)
+		}
+	}
+}
+
+// TestParseSOAResponse_Random feeds random and randomly damaged responses to the parser, which must never panic
+func TestParseSOAResponse_Random(t *testing.T) {
+	log := logger.New()
+	checker := New(config.New(log), log)
+
+	valid := slices.Concat([]byte{0x00, 0x01, 0x81, 0x80, 0x00, 0x01, 0x00, 0x01, 0x00, 0x00, 0x00, 0x00}, soaQuestion,
+		[]byte{0xc0, 0x0c, 0x00, 0x06, 0x00, 0x01, 0x00, 0x00, 0x0e, 0x10, 0x00, 0x02, 0x00, 0x00})
+
+	rng := rand.New(rand.NewSource(1))
+	for i := range 20000 {
+		var response []byte
+		if i%2 == 0 {
+			// Random bytes of random length
+			response = make([]byte, rng.Intn(64))
+			rng.Read(response)
+		} else {
+			// A valid response with a few bytes changed and possibly cut short
+			response = slices.Clone(valid)
+			for range 1 + rng.Intn(3) {
+				response[rng.Intn(len(response))] = byte(rng.Intn(256))
+			}
+			response = response[:rng.Intn(len(response)+1)]
+		}
+
+		func() {
+			defer func() {
+				if r := recover(); r != nil {
+					t.Fatalf("parseSOAResponse(%x) panicked: %v", response, r)
+				}
+			}()
+			_, _ = checker.parseSOAResponse(response)
+		}()
+	}
+}
+
 func TestGetNameserver(t *testing.T) {
 	log := logger.New()
 	cfg := config.New(log)
@@ -117,16 +310,461 @@ func TestGetNameserver(t *testing.T) {
 	if err != nil {
 		t.Errorf("getNameserver() returned error: %v", err)
 	}
-	if ip == nil {
-		t.Errorf("getNameserver() returned nil IP")
+	if !ip.IsValid() {
+		t.Errorf("getNameserver() returned invalid IP: %v", ip)
+	}
+}
+
+func TestGetNameserver_ResolvConf(t *testing.T) {
+	log := logger.New()
+	cfg := config.New(log)
+	checker := New(cfg, log)
+
+	tests := []struct {
+		content string
+		want    string
+	}{
+		{"nameserver 192.168.1.1\n", "192.168.1.1"},
+		{"# IPv6 only\nsearch example.com\nnameserver ::1\nnameserver 10.0.0.1\n", "::1"},
+		{"nameserver 2001:4860:4860::8888\n", "2001:4860:4860::8888"},
+		{"nameserver fe80::1%eth0\n", "fe80::1%eth0"},
+		{"nameserver ::ffff:10.0.0.1\n", "10.0.0.1"},
+		{"nameserver not-an-ip\nnameserver ::1\n", "::1"},
+		{"search example.com\n", "8.8.8.8"},
+	}
+
+	for _, tc := range tests {
+		checker.resolvConf = filepath.Join(t.TempDir(), "resolv.conf")
+		if err := os.WriteFile(checker.resolvConf, []byte(tc.content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		got, err := checker.getNameserver()
+		if err != nil {
+			t.Errorf("getNameserver() for %q returned error: %v", tc.content, err)
+			continue
+		}
+		if got.String() != tc.want {
+			t.Errorf("getNameserver() for %q = %s, want %s", tc.content, got, tc.want)
+		}
+	}
+}
+
+func TestIsAvailable_StrictResolver(t *testing.T) {
+	log := logger.New()
+	var out, errOut bytes.Buffer
+	log.SetOutput(&out, &errOut)
+
+	cfg := config.New(log)
+	cfg.StrictResolver = true
+	checker := New(cfg, log)
+
+	for _, resolvConf := range []string{"search example.com\n", ""} {
+		checker.resolvConf = filepath.Join(t.TempDir(), "resolv.conf")
+		if resolvConf != "" {
+			if err := os.WriteFile(checker.resolvConf, []byte(resolvConf), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		available, err := checker.IsAvailable(context.Background(), "example.com")
+		if !errors.Is(err, ErrNoResolver) || available {
+			t.Errorf("IsAvailable() with resolv.conf %q = %v, %v; want a missing resolver error", resolvConf, available, err)
+		}
+	}
+
+	// Logged once, not for every lookup
+	if got := strings.Count(out.String()+errOut.String(), "No DNS resolver configured"); got != 1 {
+		t.Errorf("Expected the missing resolver to be logged once, got %d times", got)
+	}
+}
+
+func TestIsAvailable_IPv6Nameserver(t *testing.T) {
+	server := newMockServer(t, "udp6", func(mockQuery) mockReply { return mockReply{ancount: 1} })
+
+	log := logger.New()
+	cfg := config.New(log)
+	cfg.DNSPort = int(server.addr.Port())
+	checker := New(cfg, log)
+	checker.resolvConf = filepath.Join(t.TempDir(), "resolv.conf")
+	if err := os.WriteFile(checker.resolvConf, []byte("nameserver ::1\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	available, err := checker.IsAvailable(context.Background(), "example.com")
+	if err != nil {
+		t.Fatalf("IsAvailable() through an IPv6 nameserver returned error: %v", err)
+	}
+	if available {
+		t.Errorf("Expected example.com to be registered according to the fake nameserver")
 	}
+}
+
+func TestIsAvailable_RecordTypes(t *testing.T) {
+	// Registered: example.com has an SOA, www.example.com an AAAA record; everything else is empty
+	server := newMockServer(t, "udp4", func(q mockQuery) mockReply {
+		if q.recordType == typeSOA || q.recordType == typeAAAA {
+			return mockReply{ancount: 1}
+		}
+		return mockReply{}
+	})
+	empty := newMockServer(t, "udp4", func(mockQuery) mockReply { return mockReply{} })
+
+	log := logger.New()
+	cfg := config.New(log)
+	checker := New(cfg, log)
 
-	// The IP should be either from resolv.conf or the default (8.8.8.8)
-	if ip != nil {
-		// Check if the IP is valid by comparing with Google's DNS or checking if it's a valid IP
-		googleDNS := net.ParseIP("8.8.8.8")
-		if !ip.Equal(googleDNS) && net.ParseIP(ip.String()) == nil {
-			t.Errorf("getNameserver() returned invalid IP: %v", ip)
+	tests := []struct {
+		servers []string
+		domain  string
+		want    bool
+	}{
+		{[]string{server.addr.String()}, "example.com", false},
+		{[]string{server.addr.String()}, "www.example.com", false},
+		{[]string{empty.addr.String()}, "example.com", true},
+		{[]string{empty.addr.String()}, "www.example.com", true},
+	}
+
+	for _, tc := range tests {
+		cfg.DNSServers = tc.servers
+		available, err := checker.IsAvailable(context.Background(), tc.domain)
+		if err != nil {
+			t.Errorf("IsAvailable(%q) via %v returned error: %v", tc.domain, tc.servers, err)
+			continue
+		}
+		if available != tc.want {
+			t.Errorf("IsAvailable(%q) via %v = %v, want %v", tc.domain, tc.servers, available, tc.want)
+		}
+	}
+
+	// Servers without a port use DNSPort
+	cfg.DNSServers = []string{"127.0.0.1"}
+	cfg.DNSPort = int(server.addr.Port())
+	if available, err := checker.IsAvailable(context.Background(), "example.com"); err != nil || available {
+		t.Errorf("IsAvailable() via DNSPort = %v, %v, want registered", available, err)
+	}
+}
+
+func TestIsAvailable_AvailabilityRecordType(t *testing.T) {
+	// held.com has a zone its registry put on hold, parked.com is delegated without an address,
+	// site.com has a website
+	records := map[string][]uint16{
+		"held.com":   {typeSOA},
+		"parked.com": {typeSOA, typeNS},
+		"site.com":   {typeSOA, typeNS, typeA},
+	}
+	server := newMockServer(t, "udp4", func(q mockQuery) mockReply {
+		if slices.Contains(records[q.name], q.recordType) {
+			return mockReply{ancount: 1}
+		}
+		return mockReply{}
+	})
+
+	log := logger.New()
+	cfg := config.New(log)
+	cfg.DNSServers = []string{server.addr.String()}
+	checker := New(cfg, log)
+
+	tests := []struct {
+		mode   string
+		domain string
+		want   bool
+	}{
+		{config.RecordSOA, "held.com", false},
+		{config.RecordSOA, "parked.com", false},
+		{config.RecordSOA, "free.com", true},
+		{config.RecordNS, "held.com", true},
+		{config.RecordNS, "parked.com", false},
+		{config.RecordNS, "site.com", false},
+		{config.RecordA, "parked.com", true},
+		{config.RecordA, "site.com", false},
+	}
+
+	for _, tc := range tests {
+		cfg.AvailabilityRecordType = tc.mode
+		available, err := checker.IsAvailable(context.Background(), tc.domain)
+		if err != nil {
+			t.Errorf("IsAvailable(%q) with %s returned error: %v", tc.domain, tc.mode, err)
+			continue
+		}
+		if available != tc.want {
+			t.Errorf("IsAvailable(%q) with %s = %v, want %v", tc.domain, tc.mode, available, tc.want)
+		}
+	}
+}
+
+func TestIsAvailable_NameserverFallback(t *testing.T) {
+	server := newMockServer(t, "udp4", func(mockQuery) mockReply { return mockReply{ancount: 1} })
+
+	// A port nothing listens on, so the first server refuses the query
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	dead := conn.LocalAddr().String()
+	if err := conn.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	log := logger.New()
+	cfg := config.New(log)
+	cfg.Timeout = time.Second
+	cfg.DNSServers = []string{dead, server.addr.String()}
+	checker := New(cfg, log)
+
+	available, err := checker.IsAvailable(context.Background(), "example.com")
+	if err != nil || available {
+		t.Errorf("IsAvailable() = %v, %v, want the second server's answer", available, err)
+	}
+
+	// Without a working server the errors are returned
+	cfg.DNSServers = []string{dead}
+	if _, err := checker.IsAvailable(context.Background(), "example.com"); err == nil {
+		t.Errorf("Expected an error when no nameserver answers")
+	}
+}
+
+func TestIsAvailable_WithoutEDNS(t *testing.T) {
+	// Rejects queries with an OPT record as a format error, like servers that predate EDNS0
+	server := newMockServer(t, "udp4", func(q mockQuery) mockReply {
+		if q.edns {
+			return mockReply{rcode: rcodeFormatError}
+		}
+		return mockReply{ancount: 1}
+	})
+
+	log := logger.New()
+	cfg := config.New(log)
+	cfg.Timeout = time.Second
+	cfg.DNSServers = []string{server.addr.String()}
+	checker := New(cfg, log)
+
+	available, err := checker.IsAvailable(context.Background(), "example.com")
+	if err != nil || available {
+		t.Errorf("IsAvailable() = %v, %v, want the answer to the query without EDNS0", available, err)
+	}
+	if queries := server.received(); len(queries) != 2 || !queries[0].edns || queries[1].edns {
+		t.Errorf("Expected a query with EDNS0 and one without, got %+v", queries)
+	}
+
+	// A format error for the plain query too is a server failure, asked only once more
+	broken := newMockServer(t, "udp4", func(mockQuery) mockReply { return mockReply{rcode: rcodeFormatError} })
+	cfg.DNSServers = []string{broken.addr.String()}
+	if _, err := checker.IsAvailable(context.Background(), "example.com"); !errors.Is(err, ErrServerFailure) {
+		t.Errorf("IsAvailable() error = %v, want %v", err, ErrServerFailure)
+	}
+	if queries := broken.received(); len(queries) != 2 {
+		t.Errorf("Expected 2 queries, got %d", len(queries))
+	}
+}
+
+func TestIsAvailable_MockServer(t *testing.T) {
+	log := logger.New()
+	cfg := config.New(log)
+	cfg.Timeout = time.Second
+	checker := New(cfg, log)
+
+	tests := []struct {
+		name    string
+		reply   mockReply
+		want    bool
+		wantErr bool
+	}{
+		{"has SOA", mockReply{ancount: 1}, false, false},
+		{"no SOA", mockReply{}, true, false},
+		{"name doesn't exist", mockReply{rcode: rcodeNameError}, true, false},
+		{"server failure", mockReply{rcode: 2}, false, true},
+		{"refused", mockReply{rcode: 5}, false, true},
+	}
+
+	for _, tc := range tests {
+		server := newMockServer(t, "udp4", func(mockQuery) mockReply { return tc.reply })
+		cfg.DNSServers = []string{server.addr.String()}
+
+		available, err := checker.IsAvailable(context.Background(), "Example.com")
+		if (err != nil) != tc.wantErr {
+			t.Errorf("%s: IsAvailable() error = %v, wantErr %v", tc.name, err, tc.wantErr)
+			continue
+		}
+		if available != tc.want {
+			t.Errorf("%s: IsAvailable() = %v, want %v", tc.name, available, tc.want)
+		}
+		if got := server.received(); len(got) != 1 || got[0] != (mockQuery{name: "example.com", recordType: typeSOA, edns: true}) {
+			t.Errorf("%s: Expected a single SOA query for example.com, got %+v", tc.name, got)
+		}
+	}
+
+	// A server that can't answer is skipped in favor of the next one
+	failing := newMockServer(t, "udp4", func(mockQuery) mockReply { return mockReply{rcode: 2} })
+	working := newMockServer(t, "udp4", func(mockQuery) mockReply { return mockReply{ancount: 1} })
+	cfg.DNSServers = []string{failing.addr.String(), working.addr.String()}
+	if available, err := checker.IsAvailable(context.Background(), "example.com"); err != nil || available {
+		t.Errorf("IsAvailable() = %v, %v, want the working server's answer", available, err)
+	}
+}
+
+func TestIsAvailable_ResolverConsensus(t *testing.T) {
+	taken := newMockServer(t, "udp4", func(mockQuery) mockReply { return mockReply{ancount: 1} })
+	free := newMockServer(t, "udp4", func(mockQuery) mockReply { return mockReply{rcode: rcodeNameError} })
+	free2 := newMockServer(t, "udp4", func(mockQuery) mockReply { return mockReply{rcode: rcodeNameError} })
+	failing := newMockServer(t, "udp4", func(mockQuery) mockReply { return mockReply{rcode: 2} })
+
+	log := logger.New()
+	cfg := config.New(log)
+	cfg.Timeout = time.Second
+	checker := New(cfg, log)
+
+	tests := []struct {
+		name      string
+		consensus string
+		servers   []*mockServer
+		want      bool
+		wantErr   bool
+	}{
+		{"off, first answer wins", "", []*mockServer{free, taken}, true, false},
+		{"majority free", config.ConsensusMajority, []*mockServer{free, taken, free2}, true, false},
+		{"majority taken", config.ConsensusMajority, []*mockServer{free, taken, taken}, false, false},
+		{"split counts as taken", config.ConsensusMajority, []*mockServer{free, taken}, false, false},
+		{"all free", config.ConsensusAll, []*mockServer{free, free2}, true, false},
+		{"all but one free", config.ConsensusAll, []*mockServer{free, free2, taken}, false, false},
+		{"majority despite a failure", config.ConsensusMajority, []*mockServer{free, free2, failing}, true, false},
+		{"too few answers", config.ConsensusAll, []*mockServer{free, failing}, false, true},
+	}
+
+	for _, tc := range tests {
+		cfg.ResolverConsensus = tc.consensus
+		cfg.DNSServers = nil
+		for _, server := range tc.servers {
+			cfg.DNSServers = append(cfg.DNSServers, server.addr.String())
+		}
+
+		available, err := checker.IsAvailable(context.Background(), "example.com")
+		if (err != nil) != tc.wantErr {
+			t.Errorf("%s: IsAvailable() error = %v, wantErr %v", tc.name, err, tc.wantErr)
+			continue
+		}
+		if available != tc.want {
+			t.Errorf("%s: IsAvailable() = %v, want %v", tc.name, available, tc.want)
+		}
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	log := logger.New()
+	cfg := config.New(log)
+	cfg.DNSBackoff = 100 * time.Millisecond
+	cfg.DNSMaxBackoff = time.Second
+	checker := New(cfg, log)
+
+	for attempt := range 20 {
+		want := min(cfg.DNSBackoff<<min(attempt, 10), cfg.DNSMaxBackoff)
+		for range 20 {
+			if d := checker.backoff(attempt); d < want/2 || d > want {
+				t.Fatalf("backoff(%d) = %v, want between %v and %v", attempt, d, want/2, want)
+			}
+		}
+	}
+
+	cfg.DNSBackoff = 0
+	if d := checker.backoff(3); d != 0 {
+		t.Errorf("backoff() without DNSBackoff = %v, want 0", d)
+	}
+}
+
+func TestIsAvailable_RetryBackoff(t *testing.T) {
+	log := logger.New()
+	cfg := config.New(log)
+	cfg.Timeout = 50 * time.Millisecond
+	cfg.DNSRetries = 1
+	cfg.DNSBackoff = 400 * time.Millisecond
+	cfg.DNSMaxBackoff = time.Second
+
+	// Drops the first query, so the answer only comes after a retry
+	var sent []time.Time
+	var mu sync.Mutex
+	server := newMockServer(t, "udp4", func(mockQuery) mockReply {
+		mu.Lock()
+		defer mu.Unlock()
+		sent = append(sent, time.Now())
+		if len(sent) == 1 {
+			return mockReply{drop: true}
+		}
+		return mockReply{ancount: 1}
+	})
+	cfg.DNSServers = []string{server.addr.String()}
+	checker := New(cfg, log)
+
+	if _, err := checker.IsAvailable(context.Background(), "example.com"); err != nil {
+		t.Fatalf("IsAvailable() returned error: %v", err)
+	}
+	mu.Lock()
+	times := slices.Clone(sent)
+	mu.Unlock()
+	if len(times) != 2 {
+		t.Fatalf("Expected 2 queries, got %d", len(times))
+	}
+	if gap := times[1].Sub(times[0]); gap < cfg.Timeout+cfg.DNSBackoff/2 {
+		t.Errorf("Expected the retry after the timeout and at least half the backoff, got it after %v", gap)
+	}
+
+	// Cancelling during the backoff returns right away
+	cfg.DNSBackoff = time.Minute
+	cfg.DNSMaxBackoff = time.Minute
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	mu.Lock()
+	sent = nil
+	mu.Unlock()
+
+	start := time.Now()
+	_, err := checker.IsAvailable(ctx, "example.com")
+	if elapsed := time.Since(start); !errors.Is(err, context.DeadlineExceeded) || elapsed > time.Second {
+		t.Errorf("IsAvailable() = %v after %v, want the context error right away", err, elapsed)
+	}
+}
+
+func TestIsAvailable_RetryOnTimeout(t *testing.T) {
+	log := logger.New()
+	cfg := config.New(log)
+	cfg.Timeout = 200 * time.Millisecond
+	checker := New(cfg, log)
+
+	tests := []struct {
+		name      string
+		retries   int
+		drops     int
+		wantErr   bool
+		wantSends int
+	}{
+		{"first packet lost", 1, 1, false, 2},
+		{"all packets lost", 1, 5, true, 2},
+		{"retries disabled", 0, 1, true, 1},
+		{"no loss", 2, 0, false, 1},
+	}
+
+	for _, tc := range tests {
+		var mu sync.Mutex
+		dropped := 0
+		server := newMockServer(t, "udp4", func(mockQuery) mockReply {
+			mu.Lock()
+			defer mu.Unlock()
+			if dropped < tc.drops {
+				dropped++
+				return mockReply{drop: true}
+			}
+			return mockReply{ancount: 1}
+		})
+		cfg.DNSServers = []string{server.addr.String()}
+		cfg.DNSRetries = tc.retries
+
+		available, err := checker.IsAvailable(context.Background(), "example.com")
+		if (err != nil) != tc.wantErr {
+			t.Errorf("%s: IsAvailable() error = %v, wantErr %v", tc.name, err, tc.wantErr)
+		}
+		if available {
+			t.Errorf("%s: IsAvailable() = true, want false", tc.name)
+		}
+		if got := len(server.received()); got != tc.wantSends {
+			t.Errorf("%s: Expected %d queries, got %d", tc.name, tc.wantSends, got)
 		}
 	}
 }
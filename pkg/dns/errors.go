@@ -0,0 +1,24 @@
+package dns
+
+import "errors"
+
+// Errors DNS lookups wrap, so callers can tell failures apart with errors.Is
+var (
+	// ErrTimeout is returned when a nameserver didn't respond within Timeout, retries included
+	ErrTimeout = errors.New("DNS query timed out")
+
+	// ErrServerFailure is returned when a nameserver answered with a code like SERVFAIL or REFUSED
+	ErrServerFailure = errors.New("DNS server couldn't answer")
+
+	// ErrMalformedResponse is returned when a response can't be parsed
+	ErrMalformedResponse = errors.New("malformed DNS response")
+
+	// ErrTruncated is returned when a response was cut off before any complete answer
+	ErrTruncated = errors.New("DNS response truncated")
+
+	// ErrNoResolver is returned when StrictResolver is set and no nameserver is configured
+	ErrNoResolver = errors.New("no DNS resolver configured")
+
+	// ErrNoConsensus is returned when too few nameservers answered to reach the ResolverConsensus
+	ErrNoConsensus = errors.New("no DNS consensus")
+)
@@ -0,0 +1,73 @@
+package dns
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/mallocator/domain-checker/pkg/config"
+	"github.com/mallocator/domain-checker/pkg/logger"
+)
+
+func TestIsAvailable_Errors(t *testing.T) {
+	log := logger.New()
+	cfg := config.New(log)
+	cfg.Timeout = 100 * time.Millisecond
+	cfg.DNSRetries = 0
+	checker := New(cfg, log)
+
+	servfail := newMockServer(t, "udp4", func(mockQuery) mockReply { return mockReply{rcode: 2} })
+	silent := newMockServer(t, "udp4", func(mockQuery) mockReply { return mockReply{drop: true} })
+	free := newMockServer(t, "udp4", func(mockQuery) mockReply { return mockReply{rcode: rcodeNameError} })
+
+	tests := []struct {
+		name      string
+		servers   []*mockServer
+		consensus string
+		want      error
+	}{
+		{"server failure", []*mockServer{servfail}, "", ErrServerFailure},
+		{"timeout", []*mockServer{silent}, "", ErrTimeout},
+		{"no consensus", []*mockServer{free, servfail}, config.ConsensusAll, ErrNoConsensus},
+	}
+	for _, tc := range tests {
+		cfg.ResolverConsensus = tc.consensus
+		cfg.DNSServers = nil
+		for _, server := range tc.servers {
+			cfg.DNSServers = append(cfg.DNSServers, server.addr.String())
+		}
+		if _, err := checker.IsAvailable(context.Background(), "example.com"); !errors.Is(err, tc.want) {
+			t.Errorf("%s: IsAvailable() error = %v, want %v", tc.name, err, tc.want)
+		}
+	}
+
+	// A lookup cut short by its context reports that instead of a timeout
+	cfg.ResolverConsensus = ""
+	cfg.DNSServers = []string{silent.addr.String()}
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := checker.IsAvailable(ctx, "example.com"); errors.Is(err, ErrTimeout) || !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("IsAvailable() error = %v, want the context's", err)
+	}
+}
+
+func TestParseSOAResponse_Errors(t *testing.T) {
+	checker := New(config.New(logger.New()), logger.New())
+
+	tests := []struct {
+		name     string
+		response []byte
+		want     error
+	}{
+		{"too short", []byte{0x00, 0x01, 0x81}, ErrMalformedResponse},
+		{"refused", []byte{0x00, 0x01, 0x81, 0x85, 0, 0, 0, 0, 0, 0, 0, 0}, ErrServerFailure},
+		{"truncated", []byte{0x00, 0x01, 0x83, 0x80, 0x00, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00}, ErrTruncated},
+		{"question cut off", []byte{0x00, 0x01, 0x81, 0x80, 0x00, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x07}, ErrMalformedResponse},
+	}
+	for _, tc := range tests {
+		if _, err := checker.parseSOAResponse(tc.response); !errors.Is(err, tc.want) {
+			t.Errorf("%s: parseSOAResponse() error = %v, want %v", tc.name, err, tc.want)
+		}
+	}
+}
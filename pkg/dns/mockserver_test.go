@@ -0,0 +1,132 @@
+package dns
+
+import (
+	"encoding/binary"
+	"net"
+	"net/netip"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// mockReply is what the mock server answers to a query
+type mockReply struct {
+	ancount uint16 // number of answer records claimed in the header
+	rcode   byte   // response code, e.g. rcodeNameError
+	drop    bool   // don't answer, like a lost packet
+}
+
+// mockQuery is a query the mock server received
+type mockQuery struct {
+	name       string
+	recordType uint16
+	edns       bool // the query had an OPT record
+}
+
+// mockServer is a UDP nameserver on a loopback port that replies according to a handler
+type mockServer struct {
+	addr netip.AddrPort
+
+	mu      sync.Mutex
+	queries []mockQuery
+}
+
+// newMockServer starts a mock nameserver on the loopback address of network (udp4 or udp6)
+// It's stopped when the test ends
+func newMockServer(t *testing.T, network string, handler func(q mockQuery) mockReply) *mockServer {
+	ip := net.IPv4(127, 0, 0, 1)
+	if network == "udp6" {
+		ip = net.IPv6loopback
+	}
+	conn, err := net.ListenUDP(network, &net.UDPAddr{IP: ip})
+	if err != nil {
+		t.Skipf("%s loopback not available: %v", network, err)
+	}
+	t.Cleanup(func() {
+		if err := conn.Close(); err != nil {
+			t.Errorf("Failed to close mock nameserver: %v", err)
+		}
+	})
+
+	s := &mockServer{addr: conn.LocalAddr().(*net.UDPAddr).AddrPort()}
+	go func() {
+		buf := make([]byte, 512)
+		for {
+			n, client, err := conn.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+			q, ok := decodeQuery(buf[:n])
+			if !ok {
+				continue // not a query we can answer, let the client time out
+			}
+			s.mu.Lock()
+			s.queries = append(s.queries, q)
+			s.mu.Unlock()
+
+			reply := handler(q)
+			if reply.drop {
+				continue
+			}
+			_, _ = conn.WriteToUDP(mockResponse(buf[:n], q.recordType, reply), client)
+		}
+	}()
+
+	return s
+}
+
+// received returns the queries received so far
+func (s *mockServer) received() []mockQuery {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]mockQuery{}, s.queries...)
+}
+
+// mockResponse answers a query decoded by decodeQuery with the question and reply.ancount records of recordType
+func mockResponse(query []byte, recordType uint16, reply mockReply) []byte {
+	// Keep the header and question, dropping the OPT record of the query
+	end := 12
+	for query[end] != 0 {
+		end += 1 + int(query[end])
+	}
+	response := append([]byte{}, query[:end+5]...)
+	response[2] = 0x81               // QR, RD
+	response[3] = 0x80 | reply.rcode // RA, RCODE
+	binary.BigEndian.PutUint16(response[6:8], reply.ancount)
+	binary.BigEndian.PutUint16(response[10:12], 0)
+
+	for range reply.ancount {
+		response = append(response, 0xc0, 0x0c) // Name: pointer to the question
+		response = binary.BigEndian.AppendUint16(response, recordType)
+		response = append(response, 0x00, 0x01, 0x00, 0x00, 0x0e, 0x10) // Class IN, TTL 3600
+		response = append(response, 0x00, 0x04, 192, 0, 2, 1)           // 4 bytes of record data
+	}
+	return response
+}
+
+// decodeQuery reads the question of a DNS query
+func decodeQuery(msg []byte) (mockQuery, bool) {
+	if len(msg) < 12 || binary.BigEndian.Uint16(msg[4:6]) != 1 {
+		return mockQuery{}, false
+	}
+
+	var labels []string
+	pos := 12
+	for pos < len(msg) && msg[pos] != 0 {
+		size := int(msg[pos])
+		if pos+1+size > len(msg) {
+			return mockQuery{}, false
+		}
+		labels = append(labels, string(msg[pos+1:pos+1+size]))
+		pos += 1 + size
+	}
+	if pos+5 > len(msg) {
+		return mockQuery{}, false
+	}
+
+	return mockQuery{
+		name:       strings.Join(labels, "."),
+		recordType: binary.BigEndian.Uint16(msg[pos+1 : pos+3]),
+		edns:       binary.BigEndian.Uint16(msg[10:12]) > 0,
+	}, true
+}
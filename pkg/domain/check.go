@@ -0,0 +1,89 @@
+package domain
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/mallocator/domain-checker/pkg/config"
+	"github.com/mallocator/domain-checker/pkg/whois"
+)
+
+// Result is the outcome of a CheckDomain call
+type Result struct {
+	Domain     string
+	Available  bool
+	Expiration time.Time // zero if the domain is available or neither WHOIS nor CertFallback has an expiration date
+	DaysLeft   *int      // nil when the expiration is unknown
+	Statuses   []string  // EPP status codes from WHOIS, e.g. clientTransferProhibited
+	Source     string    // SourceDNS, SourceWHOIS or SourceCert when Expiration is the TLS certificate's, "" if no lookup answered
+
+	// Renewal risk hints from WHOIS, nil when the WHOIS data doesn't tell
+	TransferLocked *bool
+	AutoRenew      *bool
+}
+
+// CheckDomain looks up a single domain and returns what was found
+// Unlike ProcessDomain it doesn't send notifications, read or write state, or update the report,
+// which makes it usable when embedding the checker as a library
+// A WHOIS response without an expiration date returns an error along with the statuses that were found
+func (p *Processor) CheckDomain(ctx context.Context, domain string) (Result, error) {
+	name, err := config.NormalizeDomain(domain)
+	if err != nil {
+		return Result{Domain: domain}, err
+	}
+	res := Result{Domain: name}
+
+	// WHOIS is needed unless DNS finds the domain available, so don't wait for DNS to start it
+	pending := p.startWhois(ctx, name)
+	defer pending.stop()
+
+	available, _, err := p.isAvailable(ctx, name)
+	if ctx.Err() != nil {
+		return res, ctx.Err()
+	}
+	if err != nil && p.cfg.DNSRequired {
+		return res, fmt.Errorf("DNS lookup failed: %w", err)
+	}
+	if err != nil {
+		p.log.Debugf("DNS lookup error for %s, falling back to WHOIS: %v", name, err)
+	} else if available {
+		res.Available = true
+		res.Source = SourceDNS
+		return res, nil
+	}
+
+	info, err := pending.wait()
+	if err != nil && !whois.IsPermanent(err) {
+		return res, err
+	}
+	if err == nil {
+		res.Source = SourceWHOIS
+	}
+	res.Statuses = info.Statuses
+	res.TransferLocked = info.TransferLocked
+	res.AutoRenew = info.AutoRenew
+	if err == nil && info.ExpirationDate.IsZero() {
+		err = whois.ErrNoExpiration
+	}
+
+	// Without a registration expiry, CertFallback uses the TLS certificate's
+	expiration := info.ExpirationDate
+	if err != nil {
+		if !p.cfg.CertFallback {
+			return res, err
+		}
+		certExpiration, certErr := p.cert.Expiry(ctx, name)
+		if certErr != nil {
+			return res, errors.Join(err, certErr)
+		}
+		res.Source = SourceCert
+		expiration = certExpiration
+	}
+
+	daysLeft := p.cfg.DaysBetween(p.now(), expiration)
+	res.Expiration = expiration
+	res.DaysLeft = &daysLeft
+	return res, nil
+}
@@ -0,0 +1,45 @@
+package domain
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/mallocator/domain-checker/pkg/config"
+	"github.com/mallocator/domain-checker/pkg/dns"
+	"github.com/mallocator/domain-checker/pkg/logger"
+	"github.com/mallocator/domain-checker/pkg/whois"
+)
+
+func TestCheckDomain_Invalid(t *testing.T) {
+	log := logger.New()
+	cfg := config.New(log)
+
+	// No notifier or state is needed to check a domain
+	processor := New(cfg, log, dns.New(cfg, log), whois.New(cfg, log), nil, nil)
+
+	res, err := processor.CheckDomain(context.Background(), "not a domain")
+	if err == nil {
+		t.Errorf("Expected an error for an invalid domain, got %+v", res)
+	}
+	if res.Domain != "not a domain" || res.Available || res.DaysLeft != nil {
+		t.Errorf("Expected an empty result for an invalid domain, got %+v", res)
+	}
+}
+
+func TestCheckDomain_Cancelled(t *testing.T) {
+	log := logger.New()
+	cfg := config.New(log)
+	processor := New(cfg, log, dns.New(cfg, log), whois.New(cfg, log), nil, nil)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	res, err := processor.CheckDomain(ctx, "https://Example.com/")
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("CheckDomain() error = %v, want context.Canceled", err)
+	}
+	if res.Domain != "example.com" || res.Available {
+		t.Errorf("Expected a normalized result that isn't available, got %+v", res)
+	}
+}
@@ -0,0 +1,28 @@
+package domain
+
+import "time"
+
+// Clock tells the current time, so tests can check time-based behavior at a fixed time
+type Clock interface {
+	Now() time.Time
+}
+
+// realClock is the wall clock
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+// SetClock replaces the clock used for expiry, freshness and cooldown decisions
+func (p *Processor) SetClock(clock Clock) {
+	p.clock = clock
+}
+
+// now returns the current time from the clock, or the wall clock if none is set
+func (p *Processor) now() time.Time {
+	if p.clock == nil {
+		return time.Now()
+	}
+	return p.clock.Now()
+}
@@ -2,14 +2,21 @@
 package domain
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"slices"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"golang.org/x/sync/semaphore"
+
+	"github.com/mallocator/domain-checker/pkg/cert"
 	"github.com/mallocator/domain-checker/pkg/config"
-	"github.com/mallocator/domain-checker/pkg/dns"
 	"github.com/mallocator/domain-checker/pkg/logger"
+	"github.com/mallocator/domain-checker/pkg/metrics"
 	"github.com/mallocator/domain-checker/pkg/notify"
 	"github.com/mallocator/domain-checker/pkg/state"
 	"github.com/mallocator/domain-checker/pkg/whois"
@@ -19,106 +26,885 @@ import (
 type Processor struct {
 	cfg      *config.Config
 	log      *logger.Logger
-	dns      *dns.Checker
-	whois    *whois.Checker
-	notifier *notify.Notifier
-	state    *state.Manager
+	dns      DNSChecker
+	whois    WhoisChecker
+	cert     certChecker
+	notifier Notifier
+	state    state.Backend
+	clock    Clock
+
+	// Limit the DNS and WHOIS lookups running in parallel, each lookup weighs 1
+	dnsSem   *semaphore.Weighted
+	whoisSem *semaphore.Weighted
+
+	// Domains found available or within the warning threshold in the current ProcessAll cycle
+	available atomic.Int64
+	warnings  atomic.Int64
+
+	// Results of the current or last ProcessAll cycle
+	report *Report
+}
+
+// DNSChecker tells whether a domain is available, implemented by *dns.Checker
+type DNSChecker interface {
+	IsAvailable(ctx context.Context, domain string) (bool, error)
+}
+
+// WhoisChecker looks up the registration data of a domain, implemented by *whois.Checker
+// It may also be a Cleaner to remove the files it keeps for domains that are no longer configured
+type WhoisChecker interface {
+	GetDomainInfo(ctx context.Context, domain string) (whois.DomainInfo, error)
+}
+
+// Cleaner removes the files kept for domains that are no longer configured
+type Cleaner interface {
+	Cleanup()
+}
+
+// Notifier sends the notifications of a domain, implemented by *notify.Notifier
+// It may also be a Pager to page critical expiries, and a HistoryReader to add the last alerts to the report
+type Notifier interface {
+	Notify(ev notify.Notification) error
+}
+
+// Pager opens an incident for a critical expiry and resolves it after a renewal
+type Pager interface {
+	PagerDutyConfigured() bool
+	Page(ev notify.Notification) error
+	Resolve(domain string) error
+}
+
+// HistoryReader returns the notifications sent so far
+type HistoryReader interface {
+	History() ([]notify.HistoryEntry, error)
+}
+
+// certChecker looks up when the TLS certificate of a domain's site expires, replaceable in tests
+type certChecker interface {
+	Expiry(ctx context.Context, domain string) (time.Time, error)
 }
 
 // New creates a new domain processor
-func New(cfg *config.Config, log *logger.Logger, dnsChecker *dns.Checker, 
-	whoisChecker *whois.Checker, notifier *notify.Notifier, stateManager *state.Manager) *Processor {
+func New(cfg *config.Config, log *logger.Logger, dnsChecker DNSChecker,
+	whoisChecker WhoisChecker, notifier Notifier, stateManager state.Backend) *Processor {
 	return &Processor{
 		cfg:      cfg,
 		log:      log,
 		dns:      dnsChecker,
 		whois:    whoisChecker,
+		cert:     cert.New(cfg, log),
 		notifier: notifier,
 		state:    stateManager,
+		clock:    realClock{},
+		dnsSem:   semaphore.NewWeighted(int64(cfg.DNSLimit())),
+		whoisSem: semaphore.NewWeighted(int64(cfg.WhoisLimit())),
 	}
 }
 
 // ProcessAll processes all domains with controlled concurrency
-func (p *Processor) ProcessAll() {
-	// Create a semaphore to limit concurrency
-	sem := make(chan struct{}, p.cfg.Concurrency)
+// Once ctx is done no new domain checks are started; checks already running are waited for
+// Returns the report of the run and the errors of all domains that couldn't be checked, joined,
+// including configured names that aren't valid domains; interrupted checks aren't errors
+func (p *Processor) ProcessAll(ctx context.Context) (*Report, error) {
+	p.available.Store(0)
+	p.warnings.Store(0)
+	p.report = newReport()
+	p.resolveUnchecked()
+
+	// Limit the domains checked in parallel, each check weighs 1
+	// More checks than either lookup limit allows would only wait for a free lookup slot, and a
+	// lookup limit above Concurrency, e.g. many DNS lookups but few WHOIS ones, lets that many run
+	sem := semaphore.NewWeighted(int64(max(p.cfg.DNSLimit(), p.cfg.WhoisLimit())))
 	var wg sync.WaitGroup
 
+	// Errors of the individual domains
+	var errsMu sync.Mutex
+	var errs []error
+
+	// Names already started, so a domain listed twice (e.g. in the config and the domains file) is checked once
+	seen := make(map[string]bool)
+
 	// Process each domain concurrently, but limited by the semaphore
-	for _, d := range p.cfg.Domains {
-		domain := strings.TrimSpace(d)
-		if domain == "" {
+	for _, d := range p.cfg.DomainNames() {
+		if strings.TrimSpace(d) == "" {
 			p.log.Debugf("Skipping empty domain")
 			continue
 		}
+		domain, err := config.NormalizeDomain(d)
+		if err != nil {
+			p.log.Warnf("Skipping %v", err)
+			errsMu.Lock() // checks started earlier may be appending their errors
+			errs = append(errs, err)
+			errsMu.Unlock()
+			continue
+		}
+		if seen[domain] {
+			p.log.Debugf("Skipping duplicate domain %q", d)
+			continue
+		}
+		seen[domain] = true
+		if p.cfg.Excluded(domain) {
+			p.log.Debugf("Skipping excluded domain %s", domain)
+			continue
+		}
 
-		wg.Add(1)
-		sem <- struct{}{} // Acquire semaphore
+		// Acquire semaphore, unless we're shutting down
+		if err := sem.Acquire(ctx, 1); err != nil {
+			break
+		}
+		if ctx.Err() != nil { // Acquire may succeed with a free slot even though ctx is done
+			sem.Release(1)
+			break
+		}
 
+		wg.Add(1)
 		go func(dom string) {
 			defer wg.Done()
-			defer func() { <-sem }() // Release semaphore
+			defer sem.Release(1)
 
-			p.ProcessDomain(dom)
+			if err := p.ProcessDomain(ctx, dom); err != nil {
+				errsMu.Lock()
+				errs = append(errs, fmt.Errorf("%s: %w", dom, err))
+				errsMu.Unlock()
+			}
 		}(domain)
 	}
 
+	if ctx.Err() != nil {
+		p.log.Infof("Stopped starting new checks: %v", context.Cause(ctx))
+	}
+
 	// Wait for all goroutines to complete
 	wg.Wait()
+
+	// Only a cycle that wasn't interrupted shows the checker is alive
+	if ctx.Err() == nil {
+		metrics.CycleCompleted()
+	}
+
+	metrics.SetDomainsAvailable(int(p.available.Load()))
+	metrics.SetDomainsWarning(int(p.warnings.Load()))
+	p.addLastAlerts()
+	p.report.finish()
+
+	// Report errors in a stable order regardless of which check finished first
+	slices.SortFunc(errs, func(a, b error) int { return strings.Compare(a.Error(), b.Error()) })
+	return p.report, errors.Join(errs...)
 }
 
+// addLastAlerts adds the last successful notification of each domain from the history to the report
+func (p *Processor) addLastAlerts() {
+	reader, ok := p.notifier.(HistoryReader)
+	if !p.cfg.NotifyHistory || !ok {
+		return
+	}
+
+	history, err := reader.History()
+	if err != nil {
+		p.log.Warnf("Failed to read notification history: %v", err)
+		return
+	}
+
+	alerts := make(map[string]Alert)
+	for _, entry := range history {
+		if entry.Success {
+			alerts[entry.Domain] = Alert{Time: entry.Time, Event: entry.Event, Channel: entry.Channel}
+		}
+	}
+	p.report.addAlerts(alerts)
+}
+
+// Report returns the results of the last ProcessAll run, or nil if it hasn't run yet
+func (p *Processor) Report() *Report {
+	return p.report
+}
+
+// Sources recorded in the state for the lookup that decided a domain's status
+const (
+	SourceDNS   = "dns"   // availability from the DNS lookup
+	SourceWHOIS = "whois" // expiration fetched from WHOIS
+	SourceState = "state" // expiration cached in the state file
+	SourceCert  = "cert"  // expiration of the site's TLS certificate, WHOIS had none
+)
+
 // ProcessDomain checks availability and expiry for a single domain
-func (p *Processor) ProcessDomain(domain string) {
+// The outcome of the check is recorded in the domain's state, unless ctx was cancelled during the check
+// Returns the error that kept the domain from being checked, or nil if the check succeeded or was interrupted
+func (p *Processor) ProcessDomain(ctx context.Context, domain string) error {
 	p.log.Infof("Checking %s", domain)
+
+	// Keep other runs from updating the same state while we work on it
+	if locker, ok := p.state.(state.Locker); ok {
+		unlock, err := locker.Lock(domain)
+		if err != nil {
+			p.log.Warnf("Skipping %s: %v", domain, err)
+			if p.report != nil {
+				p.report.add(DomainResult{Domain: domain, Error: err.Error(), ErrorKind: errorKind(err)})
+			}
+			return err
+		}
+		defer unlock()
+	}
+
 	domainState := p.state.Load(domain)
 
+	// Keep a slow registry from stalling the run; waiting for the lock is bounded by LockTimeout instead
+	checkCtx := ctx
+	if p.cfg.PerDomainTimeout > 0 {
+		var cancel context.CancelFunc
+		checkCtx, cancel = context.WithTimeout(ctx, p.cfg.PerDomainTimeout)
+		defer cancel()
+	}
+
+	var times lookupTimes
+	source, err := p.checkDomain(checkCtx, domain, &domainState, &times)
+	if ctx.Err() != nil {
+		p.log.Infof("Check of %s interrupted: %v", domain, context.Cause(ctx))
+		return nil
+	}
+	kind := errorKind(err)
+	if err != nil && checkCtx.Err() != nil {
+		err = fmt.Errorf("check timed out after %s", p.cfg.PerDomainTimeout)
+		kind = ErrorTimeout
+	}
+	if err != nil {
+		p.log.Warnf("Failed to check %s: %v", domain, err)
+	}
+
+	metrics.DomainChecked()
+	domainState.LastChecked = p.now()
+	domainState.OrphanedAt = time.Time{} // checked, so it's configured again
+	domainState.LastSource = source
+	domainState.LastError = ""
+	if err != nil {
+		domainState.LastError = err.Error()
+	}
+	p.state.Save(domain, domainState)
+
+	if p.report != nil {
+		res := result(p.cfg, p.now(), domain, source, domainState)
+		res.DNSSeconds = times.dns.Seconds()
+		res.WhoisSeconds = times.whois.Seconds()
+		res.ErrorKind = kind
+		p.report.add(res)
+	}
+	return err
+}
+
+// lookupTimes is how long the lookups of a domain check took, zero for those it didn't wait for
+type lookupTimes struct {
+	dns, whois time.Duration
+}
+
+// result builds the report entry for a checked domain
+func result(cfg *config.Config, now time.Time, domain, source string, st state.DomainState) DomainResult {
+	res := DomainResult{Domain: domain, Source: source, Error: st.LastError}
+	if source == SourceDNS && st.LastError == "" {
+		res.Available = true
+		return res
+	}
+	expiration := st.Expiration
+	if source == SourceCert {
+		expiration = st.CertExpiration
+	}
+	if !expiration.IsZero() {
+		daysLeft := cfg.DaysBetween(now, expiration)
+		res.Expiration = expiration
+		res.DaysLeft = &daysLeft
+		res.Expiring = daysLeft <= cfg.TiersFor(domain)[0]
+		res.Warning = cfg.WarnFor(domain, daysLeft)
+	}
+	res.RenewedAt = st.LastRenewal()
+	res.TransferLocked = st.TransferLocked
+	res.AutoRenew = st.AutoRenew
+	return res
+}
+
+// checkDomain runs the availability and expiry checks for a domain, recording how long the lookups took in times
+// Returns the source that decided the outcome
+func (p *Processor) checkDomain(ctx context.Context, domain string, domainState *state.DomainState, times *lookupTimes) (string, error) {
+	// Check if we already have a valid expiration date, which a forced recheck asks WHOIS about again
+	storedExpiration := !domainState.Expiration.IsZero() && domainState.Expiration.After(p.now())
+	hasValidExpiration := storedExpiration && !p.cfg.ForceRecheck && !p.cfg.ForceWhois
+
+	// Without one, WHOIS is needed unless DNS finds the domain available, so start it alongside the DNS lookup
+	// With a WHOIS rate limit it waits for DNS instead, so a lookup that turns out unnecessary doesn't use up a slot
+	var pending *whoisLookup
+	if !hasValidExpiration && p.cfg.WhoisRatePerMinute <= 0 {
+		pending = p.startWhois(ctx, domain)
+		defer pending.stop()
+	}
+
 	// First check if the domain is available
-	available, err := p.dns.IsAvailable(domain)
+	available, took, err := p.isAvailable(ctx, domain)
+	times.dns = took
+	if ctx.Err() != nil {
+		return SourceDNS, ctx.Err()
+	}
+	if err != nil && p.cfg.DNSRequired {
+		return SourceDNS, fmt.Errorf("DNS lookup failed: %w", err)
+	}
 	if err != nil {
-		p.log.Warnf("DNS SOA lookup error for %s: %v", domain, err)
+		p.log.Warnf("DNS lookup error for %s: %v", domain, err)
 	} else if available {
-		p.handleAvailable(domain, &domainState)
-		return
+		p.handleAvailable(domain, domainState)
+		return SourceDNS, nil
+	}
+	if err == nil {
+		// Registered again, so a later availability has to be confirmed from scratch
+		// It's only notified again once WHOIS shows a new registration, so a flapping resolver doesn't re-alert
+		domainState.AvailableStreak = 0
 	}
 
-	// Check if we already have a valid expiration date
-	hasValidExpiration := !domainState.Expiration.IsZero() && domainState.Expiration.After(time.Now())
+	if hasValidExpiration {
+		// Use the cached expiration date
+		p.log.Debugf("Using the expiration date of %s from state", domain)
+		p.handleExpiry(domain, domainState.Expiration, domainState)
+		return SourceState, nil
+	}
 
-	if !hasValidExpiration {
-		// Get expiration date from WHOIS
-		expDate, err := p.whois.GetExpirationDate(domain)
-		if err != nil {
-			p.log.Warnf("Failed to get expiration date for %s: %v", domain, err)
+	// Get expiration date and statuses from WHOIS
+	if pending == nil {
+		pending = p.startWhois(ctx, domain)
+		defer pending.stop()
+	}
+	info, err := pending.wait()
+	times.whois = pending.took
+	if err != nil && storedExpiration && ctx.Err() == nil {
+		// Only forced rechecks get here with a date, which still holds when WHOIS fails
+		p.log.Warnf("WHOIS recheck of %s failed, using the expiration date from state: %v", domain, err)
+		p.handleExpiry(domain, domainState.Expiration, domainState)
+		return SourceState, nil
+	}
+	if whois.IsPermanent(err) {
+		if p.certExpiry(ctx, domain, domainState) {
+			return SourceCert, nil
+		}
+		p.handleUnknownExpiry(domain, domainState)
+		return SourceWHOIS, fmt.Errorf("expiration date can't be looked up: %w", err)
+	}
+	if err != nil {
+		return SourceWHOIS, fmt.Errorf("failed to get expiration date: %w", err)
+	}
+
+	p.handleStatuses(domain, info.Statuses, domainState)
+	domainState.TransferLocked = info.TransferLocked
+	domainState.AutoRenew = info.AutoRenew
+
+	if info.ExpirationDate.IsZero() {
+		if p.certExpiry(ctx, domain, domainState) {
+			return SourceCert, nil
+		}
+		p.handleUnknownExpiry(domain, domainState)
+		return SourceWHOIS, fmt.Errorf("failed to get expiration date: %w", whois.ErrNoExpiration)
+	}
+
+	// A date moving forward is a renewal, whose reminders start over; registries rarely move it back
+	switch previous := domainState.RecordExpiration(info.ExpirationDate, p.now()); {
+	case previous.IsZero(), previous.Equal(info.ExpirationDate):
+	case info.ExpirationDate.After(previous):
+		p.log.Infof("→ %s was renewed, its expiration moved from %s to %s", domain,
+			previous.Format(time.RFC3339), info.ExpirationDate.Format(time.RFC3339))
+		restartReminders(domainState)
+	default:
+		p.log.Warnf("→ %s expiration moved back from %s to %s", domain,
+			previous.Format(time.RFC3339), info.ExpirationDate.Format(time.RFC3339))
+	}
+
+	// Save the expiration date in the state, notifying again should it get lost later
+	domainState.Expiration = info.ExpirationDate
+	if !domainState.CertExpiration.IsZero() {
+		// The registration expiry replaces the certificate's, so its reminders start over
+		domainState.CertExpiration = time.Time{}
+		restartReminders(domainState)
+	}
+	domainState.NotifiedUnknownExpiry = false
+
+	// An expiration date outside of deletion after the domain lapsed is a new registration, whose lapse is notified again
+	if domainState.NotifiedAvailable && deletionStatus(info.Statuses) == "" {
+		domainState.NotifiedAvailable = false
+	}
+	p.state.Save(domain, *domainState)
+	p.handleExpiry(domain, info.ExpirationDate, domainState)
+	return SourceWHOIS, nil
+}
+
+// certExpiry handles the expiry of a domain's TLS certificate in place of the registration expiry WHOIS doesn't have
+// Returns false without CertFallback or if the certificate couldn't be checked, leaving the expiry unknown
+func (p *Processor) certExpiry(ctx context.Context, domain string, domainState *state.DomainState) bool {
+	if !p.cfg.CertFallback {
+		return false
+	}
+	notAfter, err := p.cert.Expiry(ctx, domain)
+	if err != nil {
+		p.log.Warnf("Failed to get the TLS certificate expiration of %s: %v", domain, err)
+		return false
+	}
+
+	p.log.Infof("→ %s has no expiration date in WHOIS, using its TLS certificate", domain)
+	// A renewed certificate, or one replacing the registration expiry, is a new deadline
+	if !notAfter.Equal(domainState.CertExpiration) {
+		domainState.CertExpiration = notAfter
+		restartReminders(domainState)
+		p.state.Save(domain, *domainState)
+	}
+	p.handleExpiry(domain, notAfter, domainState)
+	return true
+}
+
+// whoisLookup is a WHOIS lookup running in the background
+type whoisLookup struct {
+	cancel context.CancelFunc
+	done   chan struct{} // closed once info and err are set
+
+	info whois.DomainInfo
+	err  error
+	took time.Duration // time spent in the lookup, not waiting for a slot
+}
+
+// startWhois starts looking up the WHOIS data of a domain in the background
+// Callers must call stop once they're done with the lookup
+func (p *Processor) startWhois(ctx context.Context, domain string) *whoisLookup {
+	ctx, cancel := context.WithCancel(ctx)
+	l := &whoisLookup{cancel: cancel, done: make(chan struct{})}
+	go func() {
+		defer close(l.done)
+		if l.err = p.whoisSem.Acquire(ctx, 1); l.err != nil {
 			return
 		}
+		defer p.whoisSem.Release(1)
+		start := time.Now()
+		l.info, l.err = p.whois.GetDomainInfo(ctx, domain)
+		l.took = time.Since(start)
+		if ctx.Err() == nil {
+			// Lookups cut short because DNS found the domain available say nothing about the registry
+			metrics.ObserveLookup(SourceWHOIS, l.took)
+			p.log.Debugf("WHOIS lookup of %s took %s", domain, l.took)
+		}
+	}()
+	return l
+}
 
-		// Save the expiration date in the state
-		domainState.Expiration = expDate
-		p.state.Save(domain, domainState)
-		p.handleExpiry(domain, expDate, &domainState)
-	} else {
-		// Use the cached expiration date
-		p.handleExpiry(domain, domainState.Expiration, &domainState)
+// isAvailable does the DNS lookup of a domain once there's a free DNS slot
+// Returns how long the lookup took, not counting the wait for the slot
+func (p *Processor) isAvailable(ctx context.Context, domain string) (bool, time.Duration, error) {
+	if err := p.dnsSem.Acquire(ctx, 1); err != nil {
+		return false, 0, err
+	}
+	defer p.dnsSem.Release(1)
+
+	start := time.Now()
+	available, err := p.dns.IsAvailable(ctx, domain)
+	took := time.Since(start)
+	metrics.ObserveLookup(SourceDNS, took)
+	p.log.Debugf("DNS lookup of %s took %s", domain, took)
+	return available, took, err
+}
+
+// wait blocks until the lookup has finished and returns its result
+func (l *whoisLookup) wait() (whois.DomainInfo, error) {
+	<-l.done
+	return l.info, l.err
+}
+
+// stop cancels the lookup if it's still running and waits for it to return, so it can't outlive the check
+func (l *whoisLookup) stop() {
+	l.cancel()
+	<-l.done
+}
+
+// Outcomes of sendNotification
+const (
+	notifySent       = iota
+	notifySuppressed // the same event was notified within the cooldown
+	notifyFailed
+)
+
+// sendNotification sends a notification unless the same event was notified within the cooldown
+// Returns notifySent, notifySuppressed or notifyFailed
+func (p *Processor) sendNotification(ev notify.Notification, state *state.DomainState) int {
+	// Each deletion status is its own event, so moving from one to the next isn't suppressed
+	key := ev.Event
+	if ev.Status != "" {
+		key += ":" + ev.Status
+	}
+
+	if last, ok := state.LastNotified[key]; ok && p.cfg.NotifyCooldown > 0 && p.now().Sub(last) < p.cfg.NotifyCooldown {
+		p.log.Infof("Suppressing %s notification for %s, last one was sent at %s", key, ev.Domain, last.Format(time.RFC3339))
+		return notifySuppressed
+	}
+
+	if err := p.notifier.Notify(ev); err != nil {
+		p.log.Errorf("Failed to send notification for %s: %v", ev.Domain, err)
+		return notifyFailed
+	}
+
+	if state.LastNotified == nil {
+		state.LastNotified = make(map[string]time.Time)
+	}
+	state.LastNotified[key] = p.now()
+	return notifySent
+}
+
+// notified describes how the state records that a notification was sent
+type notified struct {
+	sent   func(*state.DomainState) bool // whether the state records it
+	mark   func(*state.DomainState)      // records it
+	unmark func(*state.DomainState)      // reverts mark
+}
+
+// boolFlag records a notification in a flag of the state
+func boolFlag(flag func(*state.DomainState) *bool) notified {
+	return notified{
+		sent:   func(st *state.DomainState) bool { return *flag(st) },
+		mark:   func(st *state.DomainState) { *flag(st) = true },
+		unmark: func(st *state.DomainState) { *flag(st) = false },
+	}
+}
+
+// Notifications recorded in a flag of the state
+var (
+	notifiedAvailable     = boolFlag(func(st *state.DomainState) *bool { return &st.NotifiedAvailable })
+	notifiedUnknownExpiry = boolFlag(func(st *state.DomainState) *bool { return &st.NotifiedUnknownExpiry })
+	notifiedExpired       = boolFlag(func(st *state.DomainState) *bool { return &st.NotifiedExpired })
+)
+
+// notifiedStatus records the notification about entering a deletion status, which replaces the previous one
+func notifiedStatus(status, previous string) notified {
+	return notified{
+		sent:   func(st *state.DomainState) bool { return st.NotifiedStatus == status },
+		mark:   func(st *state.DomainState) { st.NotifiedStatus = status },
+		unmark: func(st *state.DomainState) { st.NotifiedStatus = previous },
 	}
 }
 
+// notifiedTiers records the notification about crossing threshold tiers
+func notifiedTiers(crossed []int, notifiedExpiry bool) notified {
+	return notified{
+		sent: func(st *state.DomainState) bool {
+			return !slices.ContainsFunc(crossed, func(tier int) bool { return !slices.Contains(st.NotifiedTiers, tier) })
+		},
+		mark: func(st *state.DomainState) {
+			for _, tier := range crossed {
+				if !slices.Contains(st.NotifiedTiers, tier) {
+					st.NotifiedTiers = append(st.NotifiedTiers, tier)
+				}
+			}
+			st.NotifiedExpiry = true
+		},
+		unmark: func(st *state.DomainState) {
+			st.NotifiedTiers = slices.DeleteFunc(st.NotifiedTiers, func(tier int) bool { return slices.Contains(crossed, tier) })
+			st.NotifiedExpiry = notifiedExpiry
+		},
+	}
+}
+
+// notifyOnce sends a notification that's recorded in the state and saves the state
+// The notification is claimed in the stored state before it's sent, so an overlapping run that loaded the
+// same state doesn't send it again; the claim is reverted if sending fails or the cooldown suppresses it,
+// so a later check sends it
+// Returns whether this call sent the notification
+func (p *Processor) notifyOnce(ev notify.Notification, st *state.DomainState, n notified) bool {
+	if p.dryRun(ev) {
+		return false
+	}
+
+	claimed := false
+	err := state.Update(p.state, ev.Domain, func(stored *state.DomainState) bool {
+		claimed = !n.sent(stored)
+		if claimed {
+			n.mark(stored)
+		}
+		return claimed
+	})
+	if err != nil {
+		// Better a duplicate than a missed notification
+		p.log.Warnf("Failed to claim the %s notification for %s, sending it anyway: %v", ev.Event, ev.Domain, err)
+		claimed = true
+	}
+	if !claimed {
+		p.log.Infof("Skipping %s notification for %s, another run already sent it", ev.Event, ev.Domain)
+		n.mark(st)
+		return false
+	}
+
+	if p.sendNotification(ev, st) == notifySent {
+		n.mark(st)
+		p.state.Save(ev.Domain, *st)
+		return true
+	}
+
+	err = state.Update(p.state, ev.Domain, func(stored *state.DomainState) bool {
+		n.unmark(stored)
+		return true
+	})
+	if err != nil {
+		p.log.Warnf("Failed to release the %s notification for %s: %v", ev.Event, ev.Domain, err)
+	}
+	return false
+}
+
+// dryRun logs the notification a real run would send and reports whether DryRun is enabled
+// Callers return without sending or updating the notified state when it is
+func (p *Processor) dryRun(ev notify.Notification) bool {
+	if !p.cfg.DryRun {
+		return false
+	}
+	detail := ""
+	switch ev.Event {
+	case notify.EventExpiring:
+		detail = fmt.Sprintf(" (%d days left)", ev.DaysLeft)
+	case notify.EventExpired:
+		detail = fmt.Sprintf(" (%d days ago)", -ev.DaysLeft)
+	case notify.EventStatus:
+		detail = fmt.Sprintf(" (%s)", ev.Status)
+	}
+	p.log.Infof("[DRY RUN] would notify %s for %s%s", ev.Event, ev.Domain, detail)
+	return true
+}
+
 // handleAvailable processes available domain notifications
 func (p *Processor) handleAvailable(domain string, state *state.DomainState) {
 	p.log.Infof("→ %s is available", domain)
+	p.available.Add(1)
+	metrics.DeleteExpiryDays(domain)
+	state.AvailableStreak++
 	if !state.NotifiedAvailable {
-		p.notifier.Send(domain, fmt.Sprintf("Domain %s is now available!", domain))
-		state.NotifiedAvailable = true
+		if remaining := p.cfg.AvailableConfirmations - state.AvailableStreak; remaining > 0 {
+			p.log.Infof("→ %s needs %d more checks finding it available before notifying", domain, remaining)
+			return
+		}
+		// A domain we've seen registered lapsed, so the alert says until when
+		ev := notify.Notification{Domain: domain, Event: notify.EventAvailable, Expiration: state.Expiration}
+		if !ev.Expiration.IsZero() {
+			p.log.Infof("→ %s was registered until %s", domain, ev.Expiration.Format(time.RFC3339))
+		}
+		p.notifyOnce(ev, state, notifiedAvailable)
+	}
+
+	// Once that's notified, the lapsed registration is over and a new one is tracked from scratch
+	// An incident paged about its expiry is over with it
+	if state.NotifiedAvailable && (!state.Expiration.IsZero() || !state.CertExpiration.IsZero() || state.PagerDutyTriggered) {
+		p.resolvePage(domain, state)
+		forgetRegistration(state)
+	}
+}
+
+// handleUnknownExpiry notifies once that a registered domain's expiration date can't be determined,
+// so monitoring that can never alert about the expiry doesn't go unnoticed
+func (p *Processor) handleUnknownExpiry(domain string, state *state.DomainState) {
+	if !p.cfg.NotifyUnknownExpiry || state.NotifiedUnknownExpiry {
+		return
+	}
+
+	p.notifyOnce(notify.Notification{Domain: domain, Event: notify.EventUnknownExpiry}, state, notifiedUnknownExpiry)
+}
+
+// deletionStatuses are the EPP statuses of a domain on its way to being released,
+// ordered from the latest stage to the earliest
+var deletionStatuses = []string{"pendingDelete", "redemptionPeriod"}
+
+// deletionStatus returns the most advanced deletion status in the list, or empty if there is none
+func deletionStatus(statuses []string) string {
+	for _, want := range deletionStatuses {
+		for _, st := range statuses {
+			if strings.EqualFold(st, want) {
+				return want
+			}
+		}
+	}
+	return ""
+}
+
+// handleStatuses notifies once each time a domain enters a new deletion status
+func (p *Processor) handleStatuses(domain string, statuses []string, state *state.DomainState) {
+	status := deletionStatus(statuses)
+	if status == state.NotifiedStatus {
+		return
+	}
+
+	if status == "" {
+		state.NotifiedStatus = ""
 		p.state.Save(domain, *state)
+		return
 	}
+
+	p.log.Infof("→ %s is in %s", domain, status)
+	ev := notify.Notification{Domain: domain, Event: notify.EventStatus, Status: status}
+	p.notifyOnce(ev, state, notifiedStatus(status, state.NotifiedStatus))
 }
 
 // handleExpiry processes expiry notifications
+// Each threshold tier is notified once; crossing several tiers at once sends a single notification
 func (p *Processor) handleExpiry(domain string, expDate time.Time, state *state.DomainState) {
+	if expDate.Before(p.now()) {
+		p.handleExpired(domain, expDate, state)
+		return
+	}
+
+	// An expired domain that shows up with a new date was renewed, so its reminders start over
+	if state.NotifiedExpired {
+		p.log.Infof("→ %s was renewed", domain)
+		restartReminders(state)
+		p.state.Save(domain, *state)
+	}
+
 	p.log.Infof("→ %s expires at %s", domain, expDate.Format(time.RFC3339))
-	daysLeft := int(time.Until(expDate).Hours() / 24)
-	if daysLeft <= p.cfg.ThresholdDays && !state.NotifiedExpiry {
-		p.notifier.Send(domain, fmt.Sprintf("Domain %s expires in %d days", domain, daysLeft))
-		state.NotifiedExpiry = true
+	daysLeft := p.cfg.DaysBetween(p.now(), expDate)
+	metrics.SetExpiryDays(domain, daysLeft)
+	p.log.Debugf("%s has %d days left in %s, notifying at %v days left", domain, daysLeft, p.cfg.Location(), p.cfg.TiersFor(domain))
+
+	ev := notify.Notification{Domain: domain, Event: notify.EventExpiring, DaysLeft: daysLeft, Expiration: expDate,
+		TransferLocked: state.TransferLocked, AutoRenew: state.AutoRenew, CertBased: expDate.Equal(state.CertExpiration)}
+	p.handleCritical(ev, state)
+
+	// Heads-up ahead of the notification threshold, only logged and reported
+	if p.cfg.WarnFor(domain, daysLeft) {
+		p.log.Warnf("→ %s expires in %d days, within the warning threshold of %d days", domain, daysLeft, p.cfg.WarnThresholdDays)
+		p.warnings.Add(1)
+		return
+	}
+
+	var crossed []int
+	for _, tier := range p.cfg.TiersFor(domain) {
+		if daysLeft <= tier && !slices.Contains(state.NotifiedTiers, tier) {
+			crossed = append(crossed, tier)
+		}
+	}
+	if len(crossed) == 0 {
+		return
+	}
+
+	// State from before tiers only knows that the expiry was notified, so don't repeat it
+	if state.NotifiedExpiry && len(state.NotifiedTiers) == 0 {
+		state.NotifiedTiers = crossed
 		p.state.Save(domain, *state)
+		return
+	}
+
+	p.notifyOnce(ev, state, notifiedTiers(crossed, state.NotifiedExpiry))
+}
+
+// handleExpired notifies once that a domain's expiration date has passed while it's still registered,
+// e.g. during the grace or redemption period
+func (p *Processor) handleExpired(domain string, expDate time.Time, state *state.DomainState) {
+	p.log.Infof("→ %s expired at %s", domain, expDate.Format(time.RFC3339))
+	daysLeft := p.cfg.DaysBetween(p.now(), expDate)
+	metrics.SetExpiryDays(domain, daysLeft)
+	p.log.Debugf("%s expired %d days ago in %s", domain, -daysLeft, p.cfg.Location())
+
+	// The deletion status was notified just before, if the registry reports one
+	ev := notify.Notification{Domain: domain, Event: notify.EventExpired, DaysLeft: daysLeft, Expiration: expDate,
+		Status: state.NotifiedStatus, TransferLocked: state.TransferLocked, AutoRenew: state.AutoRenew,
+		CertBased: expDate.Equal(state.CertExpiration)}
+	p.handleCritical(ev, state)
+	if state.NotifiedExpired {
+		return
+	}
+	p.notifyOnce(ev, state, notifiedExpired)
+}
+
+// handleCritical pages through PagerDuty once a domain is within CriticalThresholdDays, and resolves the
+// incident once its expiration moves out of that window, e.g. after a renewal
+// Pages aren't subject to the notification cooldown; PagerDuty deduplicates them by domain
+func (p *Processor) handleCritical(ev notify.Notification, state *state.DomainState) {
+	pager, ok := p.notifier.(Pager)
+	if !ok || !pager.PagerDutyConfigured() {
+		return
+	}
+
+	critical := ev.DaysLeft <= p.cfg.CriticalThresholdDays
+	switch {
+	case critical && !state.PagerDutyTriggered:
+		if p.cfg.DryRun {
+			p.log.Infof("[DRY RUN] would page for %s (%d days left)", ev.Domain, ev.DaysLeft)
+			return
+		}
+		if err := pager.Page(ev); err != nil {
+			p.log.Errorf("Failed to page for %s: %v", ev.Domain, err)
+			return
+		}
+		state.PagerDutyTriggered = true
+	case !critical && state.PagerDutyTriggered:
+		if !p.resolvePage(ev.Domain, state) {
+			return
+		}
+	default:
+		return
+	}
+	p.state.Save(ev.Domain, *state)
+}
+
+// resolvePage resolves the PagerDuty incident open for a domain, if any, and reports whether the state changed
+// Callers save the state when it did
+func (p *Processor) resolvePage(domain string, state *state.DomainState) bool {
+	pager, ok := p.notifier.(Pager)
+	if !ok || !pager.PagerDutyConfigured() || !state.PagerDutyTriggered {
+		return false
 	}
-}
\ No newline at end of file
+	if p.cfg.DryRun {
+		p.log.Infof("[DRY RUN] would resolve the PagerDuty incident for %s", domain)
+		return false
+	}
+	if err := pager.Resolve(domain); err != nil {
+		p.log.Errorf("Failed to resolve the PagerDuty incident for %s: %v", domain, err)
+		return false
+	}
+	state.PagerDutyTriggered = false
+	return true
+}
+
+// Cleanup removes the state of domains no longer configured, judging CleanupRetention by the clock,
+// and the files the WHOIS checker keeps for them
+func (p *Processor) Cleanup() {
+	p.state.Cleanup(p.now())
+	if cleaner, ok := p.whois.(Cleaner); ok {
+		cleaner.Cleanup()
+	}
+}
+
+// resolveUnchecked resolves the PagerDuty incidents of domains that are no longer checked, because
+// they were removed from the config or excluded
+func (p *Processor) resolveUnchecked() {
+	if pager, ok := p.notifier.(Pager); !ok || !pager.PagerDutyConfigured() {
+		return
+	}
+	domains, err := p.state.List()
+	if err != nil {
+		p.log.Warnf("Could not list state to resolve PagerDuty incidents: %v", err)
+		return
+	}
+
+	configured := make(map[string]bool, len(p.cfg.Domains))
+	for _, d := range p.cfg.NormalizedDomainNames() {
+		configured[d] = true
+	}
+	for _, domain := range domains {
+		if configured[domain] && !p.cfg.Excluded(domain) {
+			continue
+		}
+		if st := p.state.Load(domain); p.resolvePage(domain, &st) {
+			p.state.Save(domain, st)
+		}
+	}
+}
+
+// forgetRegistration clears what the state knows about a domain's registration once it lapsed, so a new
+// registration isn't mistaken for a renewal or checked against the old expiration date
+func forgetRegistration(st *state.DomainState) {
+	restartReminders(st)
+	st.Expiration = time.Time{}
+	st.CertExpiration = time.Time{}
+	st.ExpirationHistory = nil
+	st.NotifiedUnknownExpiry = false
+	st.NotifiedStatus = ""
+	st.TransferLocked = nil
+	st.AutoRenew = nil
+}
+
+// restartReminders forgets the expiry notifications sent, so a new expiration date is notified from scratch
+func restartReminders(st *state.DomainState) {
+	st.NotifiedExpired = false
+	st.NotifiedExpiry = false
+	st.NotifiedTiers = nil
+}
@@ -1,7 +1,21 @@
 package domain
 
 import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -54,6 +68,40 @@ func TestNew(t *testing.T) {
 	}
 }
 
+// recordingNotifier records the notifications the processor sends
+type recordingNotifier struct {
+	mu     sync.Mutex
+	events []notify.Notification
+}
+
+func (r *recordingNotifier) Notify(ev notify.Notification) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.events = append(r.events, ev)
+	return nil
+}
+
+// sent returns the domain and event of each notification sent so far, e.g. "example.com available"
+func (r *recordingNotifier) sent() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var sent []string
+	for _, ev := range r.events {
+		sent = append(sent, ev.Domain+" "+ev.Event)
+	}
+	return sent
+}
+
+// last returns the last notification sent
+func (r *recordingNotifier) last() notify.Notification {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.events) == 0 {
+		return notify.Notification{}
+	}
+	return r.events[len(r.events)-1]
+}
+
 // TestHandleAvailable tests the handleAvailable method
 func TestHandleAvailable(t *testing.T) {
 	// Create a temporary directory for state files
@@ -71,8 +119,7 @@ func TestHandleAvailable(t *testing.T) {
 	cfg := config.New(log)
 	cfg.StateDir = tmpDir
 
-	// Create a notifier that we can track
-	notifier := notify.New(cfg, log)
+	notifier := &recordingNotifier{}
 	stateManager := state.New(cfg, log)
 
 	processor := &Processor{
@@ -89,7 +136,10 @@ func TestHandleAvailable(t *testing.T) {
 	// Call the method we're testing
 	processor.handleAvailable(domain, domainState)
 
-	// Verify the state was updated
+	// Verify the notification was sent and the state was updated
+	if sent := notifier.sent(); !slices.Equal(sent, []string{"example.com " + notify.EventAvailable}) {
+		t.Errorf("Expected an available notification, got %q", sent)
+	}
 	if !domainState.NotifiedAvailable {
 		t.Errorf("Expected NotifiedAvailable to be true, got false")
 	}
@@ -100,10 +150,13 @@ func TestHandleAvailable(t *testing.T) {
 	// Call the method again
 	processor.handleAvailable(domain, domainState)
 
-	// State should still be true
+	// State should still be true, without notifying again
 	if !domainState.NotifiedAvailable {
 		t.Errorf("Expected NotifiedAvailable to still be true, got false")
 	}
+	if sent := notifier.sent(); len(sent) != 1 {
+		t.Errorf("Expected no further notification, got %q", sent)
+	}
 }
 
 // TestHandleExpiry tests the handleExpiry method
@@ -124,7 +177,7 @@ func TestHandleExpiry(t *testing.T) {
 	cfg.StateDir = tmpDir
 	cfg.ThresholdDays = 30
 
-	notifier := notify.New(cfg, log)
+	notifier := &recordingNotifier{}
 	stateManager := state.New(cfg, log)
 
 	processor := &Processor{
@@ -142,7 +195,10 @@ func TestHandleExpiry(t *testing.T) {
 
 	processor.handleExpiry(domain, expDate, domainState)
 
-	// Verify the state was updated
+	// Verify the notification was sent and the state was updated
+	if sent := notifier.sent(); !slices.Equal(sent, []string{"example.com " + notify.EventExpiring}) {
+		t.Errorf("Expected an expiring notification, got %q", sent)
+	}
 	if !domainState.NotifiedExpiry {
 		t.Errorf("Expected NotifiedExpiry to be true, got false")
 	}
@@ -167,15 +223,70 @@ func TestHandleExpiry(t *testing.T) {
 	if domainState.NotifiedExpiry {
 		t.Errorf("Expected NotifiedExpiry to be false, got true")
 	}
+
+	// Neither the repeated check nor the one outside the threshold notified
+	if sent := notifier.sent(); len(sent) != 1 {
+		t.Errorf("Expected a single notification, got %q", sent)
+	}
 }
 
-// TestProcessDomain tests the ProcessDomain method
-// Note: This is a simplified test that doesn't make actual DNS or WHOIS queries
-func TestProcessDomain(t *testing.T) {
-	// Skip this test in normal runs since it would make external calls
-	// Uncomment to run manually when needed
-	t.Skip("Skipping TestProcessDomain as it would make external calls")
+// TestHandleNotifyFailure tests that flags stay unset when the notification fails
+func TestHandleNotifyFailure(t *testing.T) {
+	// Create a temporary directory for state files
+	tmpDir, err := os.MkdirTemp("", "domain_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := os.RemoveAll(tmpDir); err != nil {
+			t.Errorf("Failed to remove temporary directory: %v", err)
+		}
+	}()
+
+	// Webhook that always fails
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	log := logger.New()
+	cfg := config.New(log)
+	cfg.StateDir = tmpDir
+	cfg.ThresholdDays = 30
+	cfg.Retries = 1
+	cfg.WebhookURL = server.URL
+
+	processor := &Processor{
+		cfg:      cfg,
+		log:      log,
+		notifier: notify.New(cfg, log),
+		state:    state.New(cfg, log),
+	}
+
+	domain := "example.com"
+	domainState := &state.DomainState{}
+
+	processor.handleAvailable(domain, domainState)
+	if domainState.NotifiedAvailable {
+		t.Errorf("Expected NotifiedAvailable to stay false after a failed notification")
+	}
+	if processor.state.Load(domain).NotifiedAvailable {
+		t.Errorf("Expected the claimed notification to be released in the stored state after it failed")
+	}
+
+	processor.handleExpiry(domain, time.Now().Add(time.Hour*24*15), domainState)
+	if domainState.NotifiedExpiry {
+		t.Errorf("Expected NotifiedExpiry to stay false after a failed notification")
+	}
+
+	processor.handleStatuses(domain, []string{"pendingDelete"}, domainState)
+	if domainState.NotifiedStatus != "" {
+		t.Errorf("Expected NotifiedStatus to stay empty after a failed notification, got %q", domainState.NotifiedStatus)
+	}
+}
 
+// TestNotifyCooldown tests that repeated notifications are suppressed within the cooldown
+func TestNotifyCooldown(t *testing.T) {
 	// Create a temporary directory for state files
 	tmpDir, err := os.MkdirTemp("", "domain_test")
 	if err != nil {
@@ -190,29 +301,195 @@ func TestProcessDomain(t *testing.T) {
 	log := logger.New()
 	cfg := config.New(log)
 	cfg.StateDir = tmpDir
+	cfg.NotifyCooldown = 24 * time.Hour
 
-	dnsChecker := dns.New(cfg, log)
-	whoisChecker := whois.New(cfg, log)
-	notifier := notify.New(cfg, log)
+	notifier := &recordingNotifier{}
 	stateManager := state.New(cfg, log)
+	processor := &Processor{
+		cfg:      cfg,
+		log:      log,
+		notifier: notifier,
+		state:    stateManager,
+	}
 
-	processor := New(cfg, log, dnsChecker, whoisChecker, notifier, stateManager)
-
-	// Test with a domain that likely exists
 	domain := "example.com"
-	processor.ProcessDomain(domain)
 
-	// We can't easily assert on the results since we don't know the actual state
-	// of the domain, but at least we can verify the function runs without errors
+	// Test case 1: Notified an hour ago, e.g. before a state reset of the flags
+	domainState := &state.DomainState{
+		LastNotified: map[string]time.Time{notify.EventAvailable: time.Now().Add(-time.Hour)},
+	}
+	processor.handleAvailable(domain, domainState)
+	if sent := notifier.sent(); len(sent) != 0 {
+		t.Errorf("Expected notification to be suppressed, got %q", sent)
+	}
+	if domainState.NotifiedAvailable || processor.state.Load(domain).NotifiedAvailable {
+		t.Errorf("Expected NotifiedAvailable to stay false after suppression, so it's sent once the cooldown passed")
+	}
+
+	// A suppressed expiry reminder doesn't count its tiers as notified either
+	domainState = &state.DomainState{
+		LastNotified: map[string]time.Time{notify.EventExpiring: time.Now().Add(-time.Hour)},
+	}
+	processor.handleExpiry(domain, time.Now().Add(time.Hour*24*5), domainState)
+	if sent := notifier.sent(); len(sent) != 0 {
+		t.Errorf("Expected notification to be suppressed, got %q", sent)
+	}
+	if domainState.NotifiedExpiry || len(domainState.NotifiedTiers) != 0 {
+		t.Errorf("Expected no tiers to be marked notified after suppression, got %v", domainState.NotifiedTiers)
+	}
+
+	// Test case 2: Last notification is older than the cooldown
+	domainState = &state.DomainState{
+		LastNotified: map[string]time.Time{notify.EventAvailable: time.Now().Add(-48 * time.Hour)},
+	}
+	stateManager.Save(domain, *domainState) // the stored state this run loaded
+	processor.handleAvailable(domain, domainState)
+	if sent := notifier.sent(); len(sent) != 1 {
+		t.Errorf("Expected 1 notification, got %q", sent)
+	}
+	if time.Since(domainState.LastNotified[notify.EventAvailable]) > time.Minute {
+		t.Errorf("Expected LastNotified to be updated, got %s", domainState.LastNotified[notify.EventAvailable])
+	}
+
+	// Verify the timestamp was persisted
+	saved := stateManager.Load(domain)
+	if !saved.LastNotified[notify.EventAvailable].Equal(domainState.LastNotified[notify.EventAvailable]) {
+		t.Errorf("Expected saved LastNotified %s, got %s", domainState.LastNotified[notify.EventAvailable], saved.LastNotified[notify.EventAvailable])
+	}
+
+	// Test case 3: A different event isn't affected by the cooldown
+	processor.handleExpiry(domain, time.Now().Add(24*time.Hour), domainState)
+	if sent := notifier.sent(); len(sent) != 2 {
+		t.Errorf("Expected 2 notifications, got %q", sent)
+	}
 }
 
-// TestProcessAll tests the ProcessAll method
-// Note: This is a simplified test that doesn't make actual DNS or WHOIS queries
-func TestProcessAll(t *testing.T) {
-	// Skip this test in normal runs since it would make external calls
-	// Uncomment to run manually when needed
-	t.Skip("Skipping TestProcessAll as it would make external calls")
+// TestHandleStatuses tests the handleStatuses method
+func TestHandleExpiry_PerDomainThreshold(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "domain_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := os.RemoveAll(tmpDir); err != nil {
+			t.Errorf("Failed to remove temporary directory: %v", err)
+		}
+	}()
+
+	log := logger.New()
+	cfg := config.New(log)
+	cfg.StateDir = tmpDir
+	cfg.ThresholdDays = 7
+	critical := 30
+	cfg.Domains = []config.DomainEntry{{Name: "critical.com", ThresholdDays: &critical}, {Name: "example.com"}}
+
+	processor := &Processor{
+		cfg:      cfg,
+		log:      log,
+		notifier: notify.New(cfg, log),
+		state:    state.New(cfg, log),
+	}
+
+	expDate := time.Now().Add(time.Hour * 24 * 20)
+
+	// 20 days left is within the 30 day override
+	criticalState := &state.DomainState{}
+	processor.handleExpiry("critical.com", expDate, criticalState)
+	if !criticalState.NotifiedExpiry {
+		t.Errorf("Expected critical.com to be notified with its 30 day threshold")
+	}
+
+	// but outside the global 7 days
+	defaultState := &state.DomainState{}
+	processor.handleExpiry("example.com", expDate, defaultState)
+	if defaultState.NotifiedExpiry {
+		t.Errorf("Expected example.com not to be notified with the global 7 day threshold")
+	}
+}
+
+func TestHandleExpiry_Tiers(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "domain_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := os.RemoveAll(tmpDir); err != nil {
+			t.Errorf("Failed to remove temporary directory: %v", err)
+		}
+	}()
+
+	// Webhook that counts deliveries
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+	}))
+	defer server.Close()
+
+	log := logger.New()
+	cfg := config.New(log)
+	cfg.StateDir = tmpDir
+	cfg.WebhookURL = server.URL
+	cfg.ThresholdTiers = []int{3, 30, 14}
+
+	stateManager := state.New(cfg, log)
+	processor := &Processor{
+		cfg:      cfg,
+		log:      log,
+		notifier: notify.New(cfg, log),
+		state:    stateManager,
+	}
+
+	domain := "example.com"
+
+	// Each run loads the saved state, like separate invocations would
+	runs := []struct {
+		daysLeft int
+		calls    int32
+		tiers    []int
+	}{
+		{40, 0, nil},
+		{25, 1, []int{30}},
+		{20, 1, []int{30}},
+		{10, 2, []int{30, 14}},
+		{2, 3, []int{30, 14, 3}},
+		{1, 3, []int{30, 14, 3}},
+	}
+	for _, run := range runs {
+		domainState := stateManager.Load(domain)
+		expDate := time.Now().Add(time.Duration(run.daysLeft)*24*time.Hour + time.Hour)
+		processor.handleExpiry(domain, expDate, &domainState)
+
+		if got := atomic.LoadInt32(&calls); got != run.calls {
+			t.Errorf("%d days left: expected %d deliveries in total, got %d", run.daysLeft, run.calls, got)
+		}
+		saved := stateManager.Load(domain)
+		if fmt.Sprint(saved.NotifiedTiers) != fmt.Sprint(run.tiers) {
+			t.Errorf("%d days left: expected notified tiers %v, got %v", run.daysLeft, run.tiers, saved.NotifiedTiers)
+		}
+	}
+
+	// Crossing several tiers at once sends one notification
+	other := &state.DomainState{}
+	processor.handleExpiry("example.org", time.Now().Add(5*24*time.Hour+time.Hour), other)
+	if got := atomic.LoadInt32(&calls); got != 4 {
+		t.Errorf("Expected a single delivery for crossing two tiers, got %d in total", got)
+	}
+	if fmt.Sprint(other.NotifiedTiers) != fmt.Sprint([]int{30, 14}) {
+		t.Errorf("Expected notified tiers [30 14], got %v", other.NotifiedTiers)
+	}
+
+	// Legacy state that was already notified doesn't notify again
+	legacy := &state.DomainState{NotifiedExpiry: true}
+	processor.handleExpiry("example.net", time.Now().Add(10*24*time.Hour+time.Hour), legacy)
+	if got := atomic.LoadInt32(&calls); got != 4 {
+		t.Errorf("Expected no delivery for legacy notified state, got %d in total", got)
+	}
+	if fmt.Sprint(legacy.NotifiedTiers) != fmt.Sprint([]int{30, 14}) {
+		t.Errorf("Expected legacy state to record tiers [30 14], got %v", legacy.NotifiedTiers)
+	}
+}
 
+func TestHandleStatuses(t *testing.T) {
 	// Create a temporary directory for state files
 	tmpDir, err := os.MkdirTemp("", "domain_test")
 	if err != nil {
@@ -227,20 +504,1490 @@ func TestProcessAll(t *testing.T) {
 	log := logger.New()
 	cfg := config.New(log)
 	cfg.StateDir = tmpDir
-	cfg.Domains = []string{"example.com", "google.com", ""}
-	cfg.Concurrency = 2
 
-	dnsChecker := dns.New(cfg, log)
-	whoisChecker := whois.New(cfg, log)
 	notifier := notify.New(cfg, log)
 	stateManager := state.New(cfg, log)
 
+	processor := &Processor{
+		cfg:      cfg,
+		log:      log,
+		notifier: notifier,
+		state:    stateManager,
+	}
+
+	domain := "example.com"
+	domainState := &state.DomainState{}
+
+	// Test case 1: Regular statuses don't trigger anything
+	processor.handleStatuses(domain, []string{"clientTransferProhibited"}, domainState)
+	if domainState.NotifiedStatus != "" {
+		t.Errorf("Expected NotifiedStatus to be empty, got %q", domainState.NotifiedStatus)
+	}
+
+	// Test case 2: Entering redemption is recorded
+	processor.handleStatuses(domain, []string{"redemptionPeriod"}, domainState)
+	if domainState.NotifiedStatus != "redemptionPeriod" {
+		t.Errorf("Expected NotifiedStatus to be redemptionPeriod, got %q", domainState.NotifiedStatus)
+	}
+
+	// Test case 3: Moving on to pending delete is a new transition
+	processor.handleStatuses(domain, []string{"redemptionPeriod", "pendingDelete"}, domainState)
+	if domainState.NotifiedStatus != "pendingDelete" {
+		t.Errorf("Expected NotifiedStatus to be pendingDelete, got %q", domainState.NotifiedStatus)
+	}
+
+	// Verify the flag was persisted
+	if saved := stateManager.Load(domain); saved.NotifiedStatus != "pendingDelete" {
+		t.Errorf("Expected saved NotifiedStatus to be pendingDelete, got %q", saved.NotifiedStatus)
+	}
+
+	// Test case 4: Leaving the deletion statuses resets the flag
+	processor.handleStatuses(domain, []string{"ok"}, domainState)
+	if domainState.NotifiedStatus != "" {
+		t.Errorf("Expected NotifiedStatus to be reset, got %q", domainState.NotifiedStatus)
+	}
+}
+
+// TestDeletionStatus tests picking the most advanced deletion status
+func TestDeletionStatus(t *testing.T) {
+	tests := []struct {
+		statuses []string
+		want     string
+	}{
+		{nil, ""},
+		{[]string{"ok"}, ""},
+		{[]string{"redemptionPeriod"}, "redemptionPeriod"},
+		{[]string{"PENDINGDELETE"}, "pendingDelete"},
+		{[]string{"redemptionPeriod", "pendingDelete"}, "pendingDelete"},
+	}
+	for _, tc := range tests {
+		if got := deletionStatus(tc.statuses); got != tc.want {
+			t.Errorf("deletionStatus(%v) = %q, want %q", tc.statuses, got, tc.want)
+		}
+	}
+}
+
+// fakeDNS answers DNS lookups from a fixed set of available domains
+type fakeDNS struct {
+	available map[string]bool
+}
+
+func (f *fakeDNS) IsAvailable(_ context.Context, domain string) (bool, error) {
+	return f.available[domain], nil
+}
+
+// fakeWhois answers WHOIS lookups with fixed expiration dates, failing for any other domain
+// A zero date stands for a registered domain whose WHOIS data has no expiration date
+type fakeWhois struct {
+	expirations map[string]time.Time
+
+	mu    sync.Mutex
+	calls map[string]int
+}
+
+func (f *fakeWhois) GetDomainInfo(_ context.Context, domain string) (whois.DomainInfo, error) {
+	f.mu.Lock()
+	if f.calls == nil {
+		f.calls = make(map[string]int)
+	}
+	f.calls[domain]++
+	f.mu.Unlock()
+
+	expiration, ok := f.expirations[domain]
+	if !ok {
+		return whois.DomainInfo{}, fmt.Errorf("no WHOIS data for %s", domain)
+	}
+	return whois.DomainInfo{ExpirationDate: expiration}, nil
+}
+
+// lookups returns how often the domain was looked up
+func (f *fakeWhois) lookups(domain string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.calls[domain]
+}
+
+// TestProcessDomain tests the outcomes of checking a domain with canned DNS and WHOIS answers
+func TestProcessDomain(t *testing.T) {
+	log := logger.New()
+	cfg := config.New(log)
+	cfg.StateDir = t.TempDir()
+	cfg.ThresholdDays = 30
+
+	soon := time.Now().Add(10*24*time.Hour + time.Hour)
+	later := time.Now().Add(365 * 24 * time.Hour)
+	dnsChecker := &fakeDNS{available: map[string]bool{"free.com": true}}
+	whoisChecker := &fakeWhois{expirations: map[string]time.Time{"soon.com": soon, "later.com": later}}
+	notifier := &recordingNotifier{}
+	stateManager := state.New(cfg, log)
+	processor := New(cfg, log, dnsChecker, whoisChecker, notifier, stateManager)
+
+	tests := []struct {
+		domain     string
+		source     string
+		expiration time.Time
+		event      string
+		err        bool
+	}{
+		{"free.com", SourceDNS, time.Time{}, notify.EventAvailable, false},
+		{"soon.com", SourceWHOIS, soon, notify.EventExpiring, false},
+		{"later.com", SourceWHOIS, later, "", false},
+		{"broken.com", SourceWHOIS, time.Time{}, "", true},
+	}
+	for _, tc := range tests {
+		before := len(notifier.sent())
+		err := processor.ProcessDomain(context.Background(), tc.domain)
+		if (err != nil) != tc.err {
+			t.Errorf("%s: ProcessDomain() error = %v, want error %v", tc.domain, err, tc.err)
+		}
+
+		st := stateManager.Load(tc.domain)
+		if st.LastSource != tc.source || !st.Expiration.Equal(tc.expiration) {
+			t.Errorf("%s: Expected source %q and expiration %s, got %+v", tc.domain, tc.source, tc.expiration, st)
+		}
+
+		got := notifier.sent()[before:]
+		switch {
+		case tc.event == "" && len(got) != 0:
+			t.Errorf("%s: Expected no notification, got %q", tc.domain, got)
+		case tc.event != "" && !slices.Equal(got, []string{tc.domain + " " + tc.event}):
+			t.Errorf("%s: Expected a %s notification, got %q", tc.domain, tc.event, got)
+		}
+	}
+
+	// The stored expiration is used from then on, without asking WHOIS again
+	if err := processor.ProcessDomain(context.Background(), "later.com"); err != nil {
+		t.Errorf("ProcessDomain() returned error: %v", err)
+	}
+	if got := whoisChecker.lookups("later.com"); got != 1 {
+		t.Errorf("Expected no further WHOIS lookup with a stored expiration, got %d lookups", got)
+	}
+	if st := stateManager.Load("later.com"); st.LastSource != SourceState {
+		t.Errorf("Expected source %q, got %q", SourceState, st.LastSource)
+	}
+}
+
+// TestProcessDomain_Renewal tests that an expiration date moving forward counts as a renewal, restarting reminders
+func TestProcessDomain_Renewal(t *testing.T) {
+	log := logger.New()
+	cfg := config.New(log)
+	cfg.StateDir = t.TempDir()
+
+	// The stored expiration passed, so WHOIS is asked again and reports the renewed date
+	old := time.Now().Add(-24 * time.Hour)
+	renewed := old.AddDate(1, 0, 0)
+	stateManager := state.New(cfg, log)
+	stateManager.Save("renewed.com", state.DomainState{Expiration: old, NotifiedTiers: []int{30, 7}, NotifiedExpired: true})
+	whoisChecker := &fakeWhois{expirations: map[string]time.Time{"renewed.com": renewed}}
+	processor := New(cfg, log, &fakeDNS{}, whoisChecker, &recordingNotifier{}, stateManager)
+
+	if err := processor.ProcessDomain(context.Background(), "renewed.com"); err != nil {
+		t.Fatalf("ProcessDomain() returned error: %v", err)
+	}
+	st := stateManager.Load("renewed.com")
+	if len(st.ExpirationHistory) != 2 || !st.ExpirationHistory[1].Expiration.Equal(renewed) {
+		t.Errorf("Expected the old and renewed expiration in the history, got %+v", st.ExpirationHistory)
+	}
+	if st.NotifiedTiers != nil || st.NotifiedExpired {
+		t.Errorf("Expected the reminders to start over, got %+v", st)
+	}
+	if res := result(cfg, time.Now(), "renewed.com", st.LastSource, st); res.RenewedAt.IsZero() {
+		t.Errorf("Expected the report to show the renewal, got %+v", res)
+	}
+}
+
+// TestProcessDomain_Lapsed tests that a registered domain becoming available is notified with its last expiration,
+// and again each time it lapses after being registered anew
+func TestProcessDomain_Lapsed(t *testing.T) {
+	log := logger.New()
+	cfg := config.New(log)
+	cfg.StateDir = t.TempDir()
+	cfg.ThresholdDays = 30
+
+	expiration := time.Now().Add(10 * 24 * time.Hour).UTC().Truncate(time.Second)
+	dnsChecker := &fakeDNS{available: map[string]bool{}}
+	whoisChecker := &fakeWhois{expirations: map[string]time.Time{"lapsed.com": expiration}}
+	notifier := &recordingNotifier{}
+	stateManager := state.New(cfg, log)
 	processor := New(cfg, log, dnsChecker, whoisChecker, notifier, stateManager)
 
-	// This is more of an integration test to ensure ProcessAll doesn't crash
-	processor.ProcessAll()
+	// Registered and expiring
+	if err := processor.ProcessDomain(context.Background(), "lapsed.com"); err != nil {
+		t.Fatalf("ProcessDomain() returned error: %v", err)
+	}
+	if st := stateManager.Load("lapsed.com"); !st.Expiration.Equal(expiration) || st.NotifiedTiers == nil {
+		t.Fatalf("Expected the expiry to be tracked and notified, got %+v", st)
+	}
+
+	// Deleted before it expired
+	dnsChecker.available["lapsed.com"] = true
+	if err := processor.ProcessDomain(context.Background(), "lapsed.com"); err != nil {
+		t.Fatalf("ProcessDomain() returned error: %v", err)
+	}
+	if got, want := notifier.sent(), []string{"lapsed.com " + notify.EventExpiring, "lapsed.com " + notify.EventAvailable}; !slices.Equal(got, want) {
+		t.Errorf("Expected notifications %q, got %q", want, got)
+	}
+	if ev := notifier.last(); !ev.Expiration.Equal(expiration) {
+		t.Errorf("Expected the available notification to name the expiration %s, got %s", expiration, ev.Expiration)
+	}
+	st := stateManager.Load("lapsed.com")
+	if !st.NotifiedAvailable || !st.Expiration.IsZero() || st.NotifiedTiers != nil || st.ExpirationHistory != nil {
+		t.Errorf("Expected the registration to be forgotten once the domain is available, got %+v", st)
+	}
+
+	// A resolver flapping back to registered without WHOIS showing a new registration doesn't re-alert
+	delete(whoisChecker.expirations, "lapsed.com")
+	dnsChecker.available["lapsed.com"] = false
+	if err := processor.ProcessDomain(context.Background(), "lapsed.com"); err == nil {
+		t.Errorf("Expected an error without WHOIS data")
+	}
+	dnsChecker.available["lapsed.com"] = true
+	if err := processor.ProcessDomain(context.Background(), "lapsed.com"); err != nil {
+		t.Fatalf("ProcessDomain() returned error: %v", err)
+	}
+	if got := notifier.sent(); len(got) != 2 {
+		t.Errorf("Expected no new notification after the resolver flapped, got %q", got)
+	}
+
+	// Registered anew, then lapsing again is notified again
+	renewed := expiration.AddDate(1, 0, 0)
+	whoisChecker.expirations["lapsed.com"] = renewed
+	dnsChecker.available["lapsed.com"] = false
+	if err := processor.ProcessDomain(context.Background(), "lapsed.com"); err != nil {
+		t.Fatalf("ProcessDomain() returned error: %v", err)
+	}
+	if st := stateManager.Load("lapsed.com"); st.NotifiedAvailable || !st.Expiration.Equal(renewed) {
+		t.Errorf("Expected the new registration to be tracked, got %+v", st)
+	}
+	dnsChecker.available["lapsed.com"] = true
+	if err := processor.ProcessDomain(context.Background(), "lapsed.com"); err != nil {
+		t.Fatalf("ProcessDomain() returned error: %v", err)
+	}
+	if got := notifier.sent(); len(got) != 3 || got[2] != "lapsed.com "+notify.EventAvailable {
+		t.Errorf("Expected a second available notification, got %q", got)
+	}
+	if ev := notifier.last(); !ev.Expiration.Equal(renewed) {
+		t.Errorf("Expected the available notification to name the expiration %s, got %s", renewed, ev.Expiration)
+	}
+}
+
+// TestProcessDomain_ForceRecheck tests that ForceRecheck asks WHOIS despite a valid stored expiration date
+func TestProcessDomain_ForceRecheck(t *testing.T) {
+	log := logger.New()
+	cfg := config.New(log)
+	cfg.StateDir = t.TempDir()
+	cfg.ForceRecheck = true
+
+	stored := time.Now().Add(100 * 24 * time.Hour)
+	renewed := stored.AddDate(1, 0, 0)
+	stateManager := state.New(cfg, log)
+	stateManager.Save("renewed.com", state.DomainState{Expiration: stored})
+	stateManager.Save("down.com", state.DomainState{Expiration: stored})
+	whoisChecker := &fakeWhois{expirations: map[string]time.Time{"renewed.com": renewed}}
+	processor := New(cfg, log, &fakeDNS{}, whoisChecker, &recordingNotifier{}, stateManager)
+
+	if err := processor.ProcessDomain(context.Background(), "renewed.com"); err != nil {
+		t.Fatalf("ProcessDomain() returned error: %v", err)
+	}
+	if got := whoisChecker.lookups("renewed.com"); got != 1 {
+		t.Errorf("Expected a WHOIS lookup despite the stored expiration, got %d", got)
+	}
+	if st := stateManager.Load("renewed.com"); !st.Expiration.Equal(renewed) || st.LastSource != SourceWHOIS {
+		t.Errorf("Expected the renewed expiration from WHOIS, got %+v", st)
+	}
 
-	// We can't easily assert on the results since ProcessAll uses goroutines
-	// and we don't have a way to wait for them to complete in this test
-	// But at least we can verify the function runs without panicking
+	// A failed recheck keeps using the stored date
+	if err := processor.ProcessDomain(context.Background(), "down.com"); err != nil {
+		t.Errorf("Expected the stored expiration to be used when WHOIS fails, got %v", err)
+	}
+	if st := stateManager.Load("down.com"); !st.Expiration.Equal(stored) || st.LastSource != SourceState {
+		t.Errorf("Expected the stored expiration from source %q, got %+v", SourceState, st)
+	}
+}
+
+// TestProcessAll tests that every configured domain is checked once and counted in the report
+func TestProcessAll(t *testing.T) {
+	log := logger.New()
+	cfg := config.New(log)
+	cfg.StateDir = t.TempDir()
+	cfg.ThresholdDays = 30
+	cfg.Concurrency = 2
+	cfg.Domains = []config.DomainEntry{{Name: "soon.com"}, {Name: "free.com"}, {Name: ""}, {Name: "later.com"},
+		{Name: "broken.com"}, {Name: "SOON.com"}}
+
+	dnsChecker := &fakeDNS{available: map[string]bool{"free.com": true}}
+	whoisChecker := &fakeWhois{expirations: map[string]time.Time{
+		"soon.com":  time.Now().Add(10 * 24 * time.Hour),
+		"later.com": time.Now().Add(365 * 24 * time.Hour),
+	}}
+	notifier := &recordingNotifier{}
+	processor := New(cfg, log, dnsChecker, whoisChecker, notifier, state.New(cfg, log))
+
+	report, err := processor.ProcessAll(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "broken.com") {
+		t.Errorf("Expected the error of broken.com, got %v", err)
+	}
+	want := ReportTotals{Checked: 4, Available: 1, Expiring: 1, Errors: 1}
+	if report.Totals != want {
+		t.Errorf("Expected totals %+v, got %+v", want, report.Totals)
+	}
+	for _, domain := range []string{"soon.com", "later.com", "broken.com"} {
+		if got := whoisChecker.lookups(domain); got != 1 {
+			t.Errorf("Expected a single WHOIS lookup for %s, got %d", domain, got)
+		}
+	}
+	sent := notifier.sent()
+	slices.Sort(sent)
+	if want := []string{"free.com " + notify.EventAvailable, "soon.com " + notify.EventExpiring}; !slices.Equal(sent, want) {
+		t.Errorf("Expected notifications %q, got %q", want, sent)
+	}
+}
+
+// TestProcessAll_LookupTimes tests that the report shows how long the lookups of each domain took
+func TestProcessAll_LookupTimes(t *testing.T) {
+	log := logger.New()
+	cfg := config.New(log)
+	cfg.StateDir = t.TempDir()
+	cfg.Domains = []config.DomainEntry{{Name: "free.com"}, {Name: "taken.com"}}
+
+	dnsChecker := &fakeDNS{available: map[string]bool{"free.com": true}}
+	whoisChecker := &fakeWhois{expirations: map[string]time.Time{"taken.com": time.Now().Add(365 * 24 * time.Hour)}}
+	processor := New(cfg, log, dnsChecker, whoisChecker, &recordingNotifier{}, state.New(cfg, log))
+
+	report, err := processor.ProcessAll(context.Background())
+	if err != nil {
+		t.Fatalf("ProcessAll() returned error: %v", err)
+	}
+	if len(report.Domains) != 2 {
+		t.Fatalf("Expected 2 results, got %+v", report.Domains)
+	}
+	for _, res := range report.Domains {
+		if res.DNSSeconds <= 0 || res.WhoisSeconds < 0 {
+			t.Errorf("%s: Expected positive lookup times, got DNS %v and WHOIS %v", res.Domain, res.DNSSeconds, res.WhoisSeconds)
+		}
+	}
+	// WHOIS isn't waited for once DNS finds the domain available
+	if free, taken := report.Domains[0], report.Domains[1]; free.WhoisSeconds != 0 || taken.WhoisSeconds <= 0 {
+		t.Errorf("Expected a WHOIS time only for taken.com, got %v and %v", free.WhoisSeconds, taken.WhoisSeconds)
+	}
+}
+
+// TestProcessAll_Cancelled tests that no checks start once the context is done
+func TestProcessAll_Cancelled(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	log := logger.New()
+	cfg := config.New(log)
+	cfg.StateDir = tmpDir
+	cfg.Domains = []config.DomainEntry{{Name: "example.com"}, {Name: "example.org"}}
+
+	stateManager := state.New(cfg, log)
+	processor := New(cfg, log, dns.New(cfg, log), whois.New(cfg, log), notify.New(cfg, log), stateManager)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := processor.ProcessAll(ctx); err != nil {
+		t.Errorf("Expected no error for an interrupted run, got %v", err)
+	}
+
+	domains, err := stateManager.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(domains) != 0 {
+		t.Errorf("Expected no domains to be checked after cancellation, got state for %v", domains)
+	}
+	if report := processor.Report(); report == nil || report.Totals.Checked != 0 {
+		t.Errorf("Expected an empty report after cancellation, got %+v", report)
+	}
+}
+
+// TestProcessDomain_Cancelled tests that an interrupted check isn't recorded as the domain's outcome
+func TestProcessDomain_Cancelled(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	log := logger.New()
+	cfg := config.New(log)
+	cfg.StateDir = tmpDir
+
+	stateManager := state.New(cfg, log)
+	processor := New(cfg, log, dns.New(cfg, log), whois.New(cfg, log), notify.New(cfg, log), stateManager)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	start := time.Now()
+	if err := processor.ProcessDomain(ctx, "example.com"); err != nil {
+		t.Errorf("Expected no error for an interrupted check, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("ProcessDomain() took %s with a cancelled context", elapsed)
+	}
+
+	if st := stateManager.Load("example.com"); !st.LastChecked.IsZero() || st.LastError != "" {
+		t.Errorf("Expected no recorded outcome, got LastChecked=%s LastError=%q", st.LastChecked, st.LastError)
+	}
+}
+
+// TestProcessAll_InvalidDomains tests that malformed entries are skipped with a warning
+func TestProcessAll_InvalidDomains(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	log := logger.New()
+	var out, errOut bytes.Buffer
+	log.SetOutput(&out, &errOut)
+
+	cfg := config.New(log)
+	cfg.StateDir = tmpDir
+	cfg.Domains = []config.DomainEntry{{Name: "not a domain"}, {Name: "localhost"}, {Name: ""}}
+
+	stateManager := state.New(cfg, log)
+	processor := New(cfg, log, dns.New(cfg, log), whois.New(cfg, log), notify.New(cfg, log), stateManager)
+	_, err := processor.ProcessAll(context.Background())
+
+	for _, want := range []string{`Skipping invalid domain "not a domain"`, `Skipping invalid domain "localhost"`} {
+		if !strings.Contains(errOut.String(), want) {
+			t.Errorf("Expected warning %q, got %q", want, errOut.String())
+		}
+	}
+	if err == nil || !strings.Contains(err.Error(), `"not a domain"`) || !strings.Contains(err.Error(), `"localhost"`) {
+		t.Errorf("Expected both invalid domains in the returned error, got %v", err)
+	}
+	if domains, err := stateManager.List(); err != nil || len(domains) != 0 {
+		t.Errorf("Expected no state for invalid domains, got %v (%v)", domains, err)
+	}
+}
+
+// TestProcessAll_ReturnsErrors tests that domains that couldn't be checked fail the run
+func TestProcessAll_ReturnsErrors(t *testing.T) {
+	log := logger.New()
+	cfg := config.New(log)
+	cfg.StateDir = filepath.Join(t.TempDir(), "missing") // taking the domain's lock fails
+	cfg.Domains = []config.DomainEntry{{Name: "example.com"}}
+
+	processor := New(cfg, log, dns.New(cfg, log), whois.New(cfg, log), notify.New(cfg, log), state.New(cfg, log))
+
+	report, err := processor.ProcessAll(context.Background())
+	if err == nil || !strings.HasPrefix(err.Error(), "example.com: ") {
+		t.Errorf("Expected an error for example.com, got %v", err)
+	}
+	if report == nil || report.Totals.Errors != 1 || len(report.Domains) != 1 {
+		t.Errorf("Expected the failure in the report, got %+v", report)
+	}
+}
+
+// TestProcessAll_MixedErrors tests that invalid names and failing checks are all returned
+// Run with -race: the invalid names are recorded while earlier checks are still failing
+func TestProcessAll_MixedErrors(t *testing.T) {
+	log := logger.New()
+	var out, errOut syncBuffer
+	log.SetOutput(&out, &errOut)
+
+	cfg := config.New(log)
+	cfg.StateDir = filepath.Join(t.TempDir(), "missing") // taking the domains' locks fails
+	cfg.Domains = []config.DomainEntry{
+		{Name: "example.com"}, {Name: "not a domain"}, {Name: "example.org"}, {Name: "localhost"}, {Name: "example.net"},
+	}
+
+	processor := New(cfg, log, dns.New(cfg, log), whois.New(cfg, log), notify.New(cfg, log), state.New(cfg, log))
+
+	_, err := processor.ProcessAll(context.Background())
+	if err == nil {
+		t.Fatal("Expected errors for every entry")
+	}
+	for _, want := range []string{"example.com: ", `"not a domain"`, "example.org: ", `"localhost"`, "example.net: "} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("Expected %q in the returned error, got %v", want, err)
+		}
+	}
+}
+
+// syncBuffer is a bytes.Buffer that can be written from several goroutines, e.g. by concurrent checks
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+// TestProcessAll_Duplicates tests that a domain listed several times is checked once
+func TestProcessAll_Duplicates(t *testing.T) {
+	log := logger.New()
+	var out, errOut syncBuffer
+	log.SetOutput(&out, &errOut)
+	log.SetDebug(true)
+
+	cfg := config.New(log)
+	cfg.StateDir = filepath.Join(t.TempDir(), "missing") // every check fails, but is still reported
+	cfg.Domains = []config.DomainEntry{{Name: "example.com"}, {Name: "Example.COM"}, {Name: "example.org"}, {Name: " example.com. "}}
+
+	processor := New(cfg, log, dns.New(cfg, log), whois.New(cfg, log), notify.New(cfg, log), state.New(cfg, log))
+
+	report, err := processor.ProcessAll(context.Background())
+	if report == nil || len(report.Domains) != 2 {
+		t.Fatalf("Expected example.com and example.org to be checked once each, got %+v", report)
+	}
+	if err == nil || strings.Count(err.Error(), "example.com: ") != 1 {
+		t.Errorf("Expected a single error for example.com, got %v", err)
+	}
+	for _, want := range []string{`Skipping duplicate domain "Example.COM"`, `Skipping duplicate domain " example.com. "`} {
+		if !strings.Contains(errOut.String(), want) {
+			t.Errorf("Expected debug message %q, got %q", want, errOut.String())
+		}
+	}
+}
+
+// TestProcessAll_Excluded tests that domains matching ExcludeDomains, including ones from the domains file, are skipped
+func TestProcessAll_Excluded(t *testing.T) {
+	log := logger.New()
+	var out, errOut syncBuffer
+	log.SetOutput(&out, &errOut)
+	log.SetDebug(true)
+
+	cfg := config.New(log)
+	cfg.StateDir = t.TempDir()
+	cfg.DNSServers = []string{newNameserver(t, 0, 0)} // every domain is available, so WHOIS isn't needed
+	cfg.Domains = []config.DomainEntry{{Name: "example.com"}, {Name: "Paused.com"}}
+	cfg.DomainsFile = filepath.Join(cfg.StateDir, "domains.txt")
+	if err := os.WriteFile(cfg.DomainsFile, []byte("example.org\nshop.staging.example.net\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := cfg.LoadDomainsFile(); err != nil {
+		t.Fatal(err)
+	}
+	cfg.ExcludeDomains = []string{"paused.com", "*.staging.example.net"}
+
+	stateManager := state.New(cfg, log)
+	processor := New(cfg, log, dns.New(cfg, log), whois.New(cfg, log), notify.New(cfg, log), stateManager)
+	report, err := processor.ProcessAll(context.Background())
+	if err != nil {
+		t.Fatalf("ProcessAll() returned error: %v", err)
+	}
+
+	var checked []string
+	for _, res := range report.Domains {
+		checked = append(checked, res.Domain)
+	}
+	slices.Sort(checked)
+	if want := []string{"example.com", "example.org"}; !slices.Equal(checked, want) {
+		t.Errorf("Expected only %v to be checked, got %v", want, checked)
+	}
+	for _, domain := range []string{"paused.com", "shop.staging.example.net"} {
+		if want := "Skipping excluded domain " + domain; !strings.Contains(errOut.String(), want) {
+			t.Errorf("Expected debug message %q, got %q", want, errOut.String())
+		}
+		if st := stateManager.Load(domain); !st.LastChecked.IsZero() {
+			t.Errorf("Expected no state for excluded %s, got %+v", domain, st)
+		}
+	}
+}
+
+// TestDryRun tests that dry runs send nothing and leave the notified state alone
+func TestDryRun(t *testing.T) {
+	// Webhook that counts deliveries
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+	}))
+	defer server.Close()
+
+	log := logger.New()
+	var out bytes.Buffer
+	log.SetOutput(&out, nil)
+
+	cfg := config.New(log)
+	cfg.StateDir = t.TempDir()
+	cfg.ThresholdDays = 30
+	cfg.WebhookURL = server.URL
+	cfg.DryRun = true
+
+	processor := &Processor{
+		cfg:      cfg,
+		log:      log,
+		notifier: notify.New(cfg, log),
+		state:    state.New(cfg, log),
+	}
+
+	domainState := &state.DomainState{}
+	processor.handleAvailable("free.com", domainState)
+	processor.handleExpiry("taken.com", time.Now().Add(15*24*time.Hour), domainState)
+	processor.handleStatuses("taken.com", []string{"pendingDelete"}, domainState)
+
+	if got := atomic.LoadInt32(&calls); got != 0 {
+		t.Errorf("Expected no notifications in a dry run, got %d", got)
+	}
+	if domainState.NotifiedAvailable || domainState.NotifiedExpiry || len(domainState.NotifiedTiers) != 0 ||
+		domainState.NotifiedStatus != "" || len(domainState.LastNotified) != 0 {
+		t.Errorf("Expected the notified state to be untouched, got %+v", domainState)
+	}
+	for _, want := range []string{
+		"[DRY RUN] would notify available for free.com",
+		"[DRY RUN] would notify expiring for taken.com (15 days left)",
+		"[DRY RUN] would notify status for taken.com (pendingDelete)",
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("Expected log line %q, got %q", want, out.String())
+		}
+	}
+
+	// The same calls notify once dry run is off
+	cfg.DryRun = false
+	processor.handleAvailable("free.com", domainState)
+	if got := atomic.LoadInt32(&calls); got != 1 || !domainState.NotifiedAvailable {
+		t.Errorf("Expected a notification without dry run, got %d", got)
+	}
+}
+
+// TestHandleExpiry_Expired tests that a past expiration date is notified once as expired, not as expiring
+func TestHandleExpiry_Expired(t *testing.T) {
+	// Webhook that records the messages
+	var messages []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var payload struct{ Message string }
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			t.Errorf("Failed to decode webhook payload: %v", err)
+		}
+		messages = append(messages, payload.Message)
+	}))
+	defer server.Close()
+
+	log := logger.New()
+	cfg := config.New(log)
+	cfg.StateDir = t.TempDir()
+	cfg.ThresholdDays = 30
+	cfg.WebhookURL = server.URL
+
+	processor := &Processor{
+		cfg:      cfg,
+		log:      log,
+		notifier: notify.New(cfg, log),
+		state:    state.New(cfg, log),
+	}
+
+	domain := "example.com"
+	domainState := &state.DomainState{NotifiedStatus: "redemptionPeriod"}
+
+	processor.handleExpiry(domain, time.Now().Add(-5*24*time.Hour), domainState)
+	processor.handleExpiry(domain, time.Now().Add(-5*24*time.Hour), domainState)
+	if len(messages) != 1 || messages[0] != "Domain example.com expired 5 days ago and is in redemptionPeriod" {
+		t.Errorf("Expected a single expired notification, got %q", messages)
+	}
+	if !domainState.NotifiedExpired || domainState.NotifiedExpiry || len(domainState.NotifiedTiers) != 0 {
+		t.Errorf("Expected only the expired flag to be set, got %+v", domainState)
+	}
+
+	// A renewal resets the flags, so the new term is notified again
+	processor.handleExpiry(domain, time.Now().Add(10*24*time.Hour), domainState)
+	if domainState.NotifiedExpired || !domainState.NotifiedExpiry || len(messages) != 2 {
+		t.Errorf("Expected the renewed domain to be notified as expiring, got %+v and %q", domainState, messages)
+	}
+}
+
+// TestHandleCritical tests that a domain within CriticalThresholdDays pages once and resolves the incident after a renewal
+func TestHandleCritical(t *testing.T) {
+	var actions []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var event struct {
+			EventAction string `json:"event_action"`
+			DedupKey    string `json:"dedup_key"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&event); err != nil {
+			t.Errorf("Failed to decode PagerDuty event: %v", err)
+		}
+		actions = append(actions, event.EventAction+" "+event.DedupKey)
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer server.Close()
+
+	log := logger.New()
+	cfg := config.New(log)
+	cfg.StateDir = t.TempDir()
+	cfg.ThresholdDays = 30
+	cfg.CriticalThresholdDays = 7
+	cfg.PagerDutyRoutingKey = "R0UT1NG"
+	cfg.PagerDutyURL = server.URL
+
+	stateManager := state.New(cfg, log)
+	processor := &Processor{
+		cfg:      cfg,
+		log:      log,
+		notifier: notify.New(cfg, log),
+		state:    stateManager,
+	}
+
+	domain := "example.com"
+	domainState := &state.DomainState{}
+
+	// Expiring, but not yet critical
+	processor.handleExpiry(domain, time.Now().Add(20*24*time.Hour), domainState)
+	if len(actions) != 0 {
+		t.Errorf("Expected no page outside the critical window, got %q", actions)
+	}
+
+	// Paged once, however often it's checked within the window
+	processor.handleExpiry(domain, time.Now().Add(5*24*time.Hour), domainState)
+	processor.handleExpiry(domain, time.Now().Add(5*24*time.Hour), domainState)
+	processor.handleExpiry(domain, time.Now().Add(-24*time.Hour), domainState)
+	if len(actions) != 1 || actions[0] != "trigger example.com" {
+		t.Errorf("Expected a single trigger, got %q", actions)
+	}
+	if !stateManager.Load(domain).PagerDutyTriggered {
+		t.Errorf("Expected the open incident to be saved in the state")
+	}
+
+	// Renewed out of the window
+	processor.handleExpiry(domain, time.Now().Add(365*24*time.Hour), domainState)
+	processor.handleExpiry(domain, time.Now().Add(365*24*time.Hour), domainState)
+	if len(actions) != 2 || actions[1] != "resolve example.com" {
+		t.Errorf("Expected the incident to be resolved once, got %q", actions)
+	}
+	if stateManager.Load(domain).PagerDutyTriggered {
+		t.Errorf("Expected the resolved incident to be saved in the state")
+	}
+}
+
+// TestResolvePage_Unchecked tests that incidents are resolved for domains that lapsed, were removed from the config or excluded
+func TestResolvePage_Unchecked(t *testing.T) {
+	var actions []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var event struct {
+			EventAction string `json:"event_action"`
+			DedupKey    string `json:"dedup_key"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&event); err != nil {
+			t.Errorf("Failed to decode PagerDuty event: %v", err)
+		}
+		actions = append(actions, event.EventAction+" "+event.DedupKey)
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer server.Close()
+
+	log := logger.New()
+	cfg := config.New(log)
+	cfg.StateDir = t.TempDir()
+	cfg.CriticalThresholdDays = 7
+	cfg.PagerDutyRoutingKey = "R0UT1NG"
+	cfg.PagerDutyURL = server.URL
+	cfg.Domains = []config.DomainEntry{{Name: "lapsed.com"}, {Name: "removed.com"}, {Name: "excluded.com"}}
+
+	stateManager := state.New(cfg, log)
+	processor := &Processor{
+		cfg:      cfg,
+		log:      log,
+		notifier: notify.New(cfg, log),
+		state:    stateManager,
+	}
+
+	states := make(map[string]*state.DomainState)
+	for _, domain := range []string{"lapsed.com", "removed.com", "excluded.com"} {
+		states[domain] = &state.DomainState{}
+		processor.handleExpiry(domain, time.Now().Add(5*24*time.Hour), states[domain])
+	}
+	if len(actions) != 3 {
+		t.Fatalf("Expected three incidents, got %q", actions)
+	}
+
+	// Lapsed and available again
+	processor.handleAvailable("lapsed.com", states["lapsed.com"])
+	stateManager.Save("lapsed.com", *states["lapsed.com"])
+	if len(actions) != 4 || actions[3] != "resolve lapsed.com" || states["lapsed.com"].PagerDutyTriggered {
+		t.Errorf("Expected the incident of the lapsed domain to be resolved, got %q", actions)
+	}
+
+	// Removed and excluded
+	cfg.ExcludeDomains = []string{"excluded.com"}
+	cfg.Domains = []config.DomainEntry{{Name: "excluded.com"}}
+	for range 2 {
+		if _, err := processor.ProcessAll(context.Background()); err != nil {
+			t.Fatalf("ProcessAll failed: %v", err)
+		}
+	}
+	if len(actions) != 6 || !slices.Contains(actions, "resolve removed.com") || !slices.Contains(actions, "resolve excluded.com") {
+		t.Errorf("Expected the incidents of the removed and excluded domains to be resolved once, got %q", actions)
+	}
+	if stateManager.Load("excluded.com").PagerDutyTriggered {
+		t.Errorf("Expected the resolved incident to be saved in the state")
+	}
+}
+
+// TestHandleExpiry_Warning tests that a domain within the warning threshold is logged and counted, but not notified
+func TestHandleExpiry_Warning(t *testing.T) {
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+	}))
+	defer server.Close()
+
+	log := logger.New()
+	var out, errOut syncBuffer
+	log.SetOutput(&out, &errOut)
+
+	cfg := config.New(log)
+	cfg.StateDir = t.TempDir()
+	cfg.ThresholdDays = 30
+	cfg.WarnThresholdDays = 60
+	cfg.WebhookURL = server.URL
+
+	processor := &Processor{
+		cfg:      cfg,
+		log:      log,
+		notifier: notify.New(cfg, log),
+		state:    state.New(cfg, log),
+	}
+
+	domainState := &state.DomainState{}
+	processor.handleExpiry("example.com", time.Now().Add(45*24*time.Hour), domainState)
+	if got := atomic.LoadInt32(&calls); got != 0 || domainState.NotifiedExpiry {
+		t.Errorf("Expected no notification for a warning, got %d calls and %+v", got, domainState)
+	}
+	logged := out.String() + errOut.String()
+	if !strings.Contains(logged, "example.com expires in 45 days, within the warning threshold of 60 days") {
+		t.Errorf("Expected a warning to be logged, got %q", logged)
+	}
+	if got := processor.warnings.Load(); got != 1 {
+		t.Errorf("Expected 1 warning counted, got %d", got)
+	}
+
+	// Within the notification threshold it's an alert instead
+	processor.handleExpiry("example.com", time.Now().Add(15*24*time.Hour), domainState)
+	if got := atomic.LoadInt32(&calls); got != 1 || processor.warnings.Load() != 1 {
+		t.Errorf("Expected a notification and no further warning, got %d calls and %d warnings", got, processor.warnings.Load())
+	}
+}
+
+// newNameserver starts a UDP nameserver on loopback that answers every query after delay
+// with ancount answer records, and returns its address
+func newNameserver(t *testing.T, delay time.Duration, ancount uint16) string {
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Skipf("udp4 loopback not available: %v", err)
+	}
+	t.Cleanup(func() {
+		if err := conn.Close(); err != nil {
+			t.Errorf("Failed to close nameserver: %v", err)
+		}
+	})
+
+	go func() {
+		buf := make([]byte, 512)
+		for {
+			n, client, err := conn.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+			// Keep the header and question, dropping the OPT record of the query
+			end := 12
+			for end < n && buf[end] != 0 {
+				end += 1 + int(buf[end])
+			}
+			if end+5 > n {
+				continue
+			}
+			response := append([]byte{}, buf[:end+5]...)
+			response[2], response[3] = 0x81, 0x80 // response, no error
+			binary.BigEndian.PutUint16(response[6:8], ancount)
+			binary.BigEndian.PutUint16(response[10:12], 0)
+			for range ancount {
+				// Pointer to the question, type and class of the question, TTL and 4 bytes of data
+				response = append(response, 0xc0, 0x0c)
+				response = append(response, buf[end+1:end+5]...)
+				response = append(response, 0x00, 0x00, 0x0e, 0x10, 0x00, 0x04, 192, 0, 2, 1)
+			}
+
+			// Answer queries in parallel, like a real nameserver
+			go func() {
+				time.Sleep(delay)
+				_, _ = conn.WriteToUDP(response, client)
+			}()
+		}
+	}()
+
+	return conn.LocalAddr().String()
+}
+
+// cacheWhois writes a WHOIS cache entry for domain with an expiration far in the future,
+// so lookups with WhoisCacheTTL set don't need the network
+func cacheWhois(t *testing.T, stateDir, domain string) {
+	cacheWhoisRaw(t, stateDir, domain, "Domain Name: "+strings.ToUpper(domain)+"\nRegistry Expiry Date: 2099-08-13T04:00:00Z\n")
+}
+
+// cacheWhoisRaw writes a WHOIS cache entry for domain with the given response
+func cacheWhoisRaw(t *testing.T, stateDir, domain, raw string) {
+	cached, err := json.Marshal(map[string]any{
+		"domain":     domain,
+		"raw":        raw,
+		"fetched_at": time.Now(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(stateDir, state.FileName(domain)+".whois")
+	if err := os.WriteFile(path, cached, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestProcessDomain_ConcurrentLookups tests that the DNS lookup decides whether the WHOIS data started alongside it is used
+func TestProcessDomain_ConcurrentLookups(t *testing.T) {
+	tests := []struct {
+		name       string
+		ancount    uint16
+		wantSource string
+		wantExpiry bool
+	}{
+		{"registered", 1, SourceWHOIS, true},
+		{"available", 0, SourceDNS, false},
+	}
+
+	for _, tc := range tests {
+		log := logger.New()
+		var out, errOut syncBuffer
+		log.SetOutput(&out, &errOut)
+		log.SetDebug(true)
+
+		cfg := config.New(log)
+		cfg.StateDir = t.TempDir()
+		cfg.WhoisCacheTTL = time.Hour // WHOIS is answered from the cache, so no network is needed
+		nameserver := newNameserver(t, 100*time.Millisecond, tc.ancount)
+		cfg.DNSServers = []string{nameserver}
+
+		cacheWhois(t, cfg.StateDir, "example.com")
+
+		stateManager := state.New(cfg, log)
+		processor := New(cfg, log, dns.New(cfg, log), whois.New(cfg, log), notify.New(cfg, log), stateManager)
+		if err := processor.ProcessDomain(context.Background(), "example.com"); err != nil {
+			t.Errorf("%s: ProcessDomain() returned error: %v", tc.name, err)
+			continue
+		}
+
+		st := stateManager.Load("example.com")
+		if st.LastSource != tc.wantSource {
+			t.Errorf("%s: Expected source %q, got %q", tc.name, tc.wantSource, st.LastSource)
+		}
+		if st.Expiration.IsZero() == tc.wantExpiry {
+			t.Errorf("%s: Expected expiration set = %v, got %s", tc.name, tc.wantExpiry, st.Expiration)
+		}
+
+		// The debug trace explains the outcome
+		trace := []string{fmt.Sprintf("DNS SOA lookup for example.com via %s: rcode 0, %d answers", nameserver, tc.ancount)}
+		if tc.wantExpiry {
+			trace = append(trace,
+				"Using cached WHOIS data for example.com",
+				`Parsed WHOIS date "2099-08-13T04:00:00Z" with layout "2006-01-02T15:04:05Z07:00"`,
+				"example.com has ",
+			)
+		}
+		for _, want := range trace {
+			if !strings.Contains(errOut.String(), want) {
+				t.Errorf("%s: Expected debug trace to contain %q, got %q", tc.name, want, errOut.String())
+			}
+		}
+	}
+}
+
+// TestProcessDomain_RateLimitedWhois tests that with a WHOIS rate limit, WHOIS waits for DNS rather than using up a slot
+func TestProcessDomain_RateLimitedWhois(t *testing.T) {
+	log := logger.New()
+	cfg := config.New(log)
+	cfg.StateDir = t.TempDir()
+	cfg.WhoisRatePerMinute = 10
+
+	later := time.Now().Add(365 * 24 * time.Hour)
+	dnsChecker := &fakeDNS{available: map[string]bool{"free.com": true}}
+	whoisChecker := &fakeWhois{expirations: map[string]time.Time{"free.com": later, "taken.com": later}}
+	processor := New(cfg, log, dnsChecker, whoisChecker, &recordingNotifier{}, state.New(cfg, log))
+
+	for _, domain := range []string{"free.com", "taken.com"} {
+		if err := processor.ProcessDomain(context.Background(), domain); err != nil {
+			t.Errorf("%s: ProcessDomain() returned error: %v", domain, err)
+		}
+	}
+	if n := whoisChecker.lookups("free.com"); n != 0 {
+		t.Errorf("Expected no WHOIS lookup for a domain DNS found available, got %d", n)
+	}
+	if n := whoisChecker.lookups("taken.com"); n != 1 {
+		t.Errorf("Expected one WHOIS lookup for a registered domain, got %d", n)
+	}
+}
+
+// TestProcessDomain_OverlappingRuns tests that runs sharing the state notify about an available domain only once
+func TestProcessDomain_OverlappingRuns(t *testing.T) {
+	const runs = 8
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		time.Sleep(10 * time.Millisecond) // keep the runs overlapping while the notification is sent
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	log := logger.New()
+	cfg := config.New(log)
+	cfg.StateDir = t.TempDir()
+	cfg.StateBackend = state.BackendSQLite
+	cfg.StateDSN = filepath.Join(cfg.StateDir, "state.db")
+	cfg.WebhookURL = server.URL
+	cfg.DNSServers = []string{newNameserver(t, 0, 0)}
+
+	// Each run opens its own backend, like separate processes sharing the database
+	var wg sync.WaitGroup
+	for range runs {
+		backend, err := state.NewSQLite(cfg, log)
+		if err != nil {
+			t.Fatalf("Failed to open state: %v", err)
+		}
+		processor := New(cfg, log, dns.New(cfg, log), whois.New(cfg, log), notify.New(cfg, log), backend)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() {
+				if err := backend.Close(); err != nil {
+					t.Errorf("Close() returned error: %v", err)
+				}
+			}()
+			if err := processor.ProcessDomain(context.Background(), "example.com"); err != nil {
+				t.Errorf("ProcessDomain() returned error: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if got := atomic.LoadInt32(&calls); got != 1 {
+		t.Errorf("Expected exactly 1 notification from %d overlapping runs, got %d", runs, got)
+	}
+}
+
+// fakeCert is a certificate checker returning a fixed expiration
+// TestProcessDomain_DNSRequired tests that a failed DNS lookup falls back to WHOIS unless DNSRequired is set
+func TestProcessDomain_DNSRequired(t *testing.T) {
+	log := logger.New()
+	cfg := config.New(log)
+	cfg.StateDir = t.TempDir()
+	cfg.Timeout = 50 * time.Millisecond
+	cfg.DNSRetries = 0
+	cfg.WhoisCacheTTL = time.Hour                               // WHOIS is answered from the cache, so no network is needed
+	cfg.DNSServers = []string{newNameserver(t, time.Second, 1)} // answers too late, so every lookup fails
+	cacheWhoisRaw(t, cfg.StateDir, "example.com", "Domain Name: EXAMPLE.COM\nRegistry Expiry Date: 2099-01-01T00:00:00Z\n")
+
+	stateManager := state.New(cfg, log)
+	processor := New(cfg, log, dns.New(cfg, log), whois.New(cfg, log), nil, stateManager)
+
+	// Falls back to WHOIS by default
+	if err := processor.ProcessDomain(context.Background(), "example.com"); err != nil {
+		t.Fatalf("ProcessDomain() returned error: %v", err)
+	}
+	if st := stateManager.Load("example.com"); st.LastSource != SourceWHOIS || st.Expiration.IsZero() {
+		t.Errorf("Expected the expiration from WHOIS, got %+v", st)
+	}
+
+	// Skipped for the run with DNSRequired, with the error recorded
+	cfg.DNSRequired = true
+	stateManager.Save("example.com", state.DomainState{})
+	err := processor.ProcessDomain(context.Background(), "example.com")
+	if err == nil || !strings.Contains(err.Error(), "DNS lookup failed") {
+		t.Errorf("Expected a DNS lookup error, got %v", err)
+	}
+	st := stateManager.Load("example.com")
+	if st.LastSource != SourceDNS || !strings.Contains(st.LastError, "DNS lookup failed") || !st.Expiration.IsZero() {
+		t.Errorf("Expected the DNS error in the state without consulting WHOIS, got %+v", st)
+	}
+	if res := result(cfg, time.Now(), "example.com", st.LastSource, st); res.Available || res.Error == "" {
+		t.Errorf("Expected a failed report entry, got %+v", res)
+	}
+}
+
+type fakeCert struct {
+	expiry time.Time
+	err    error
+}
+
+func (c *fakeCert) Expiry(context.Context, string) (time.Time, error) {
+	return c.expiry, c.err
+}
+
+// TestProcessDomain_CertFallback tests that the TLS certificate stands in for an expiration date WHOIS doesn't have
+func TestProcessDomain_CertFallback(t *testing.T) {
+	var messages []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var payload struct{ Message string }
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			t.Errorf("Failed to decode webhook payload: %v", err)
+		}
+		messages = append(messages, payload.Message)
+	}))
+	defer server.Close()
+
+	log := logger.New()
+	cfg := config.New(log)
+	cfg.StateDir = t.TempDir()
+	cfg.ThresholdDays = 30
+	cfg.WebhookURL = server.URL
+	cfg.DNSServers = []string{newNameserver(t, 0, 1)}
+
+	stateManager := state.New(cfg, log)
+	whoisChecker := &fakeWhois{expirations: map[string]time.Time{"example.com": {}}}
+	processor := New(cfg, log, dns.New(cfg, log), whoisChecker, notify.New(cfg, log), stateManager)
+	certs := &fakeCert{expiry: time.Now().Add(10 * 24 * time.Hour)}
+	processor.cert = certs
+
+	// Off by default, so the expiration stays unknown
+	if err := processor.ProcessDomain(context.Background(), "example.com"); err == nil {
+		t.Errorf("Expected an error without an expiration date")
+	}
+	if len(messages) != 0 {
+		t.Errorf("Expected no notification without CertFallback, got %q", messages)
+	}
+
+	cfg.CertFallback = true
+	if err := processor.ProcessDomain(context.Background(), "example.com"); err != nil {
+		t.Fatalf("ProcessDomain() returned error: %v", err)
+	}
+	want := "TLS certificate of example.com (registration expiry unknown) expires in 10 days"
+	if len(messages) != 1 || messages[0] != want {
+		t.Errorf("Expected the notification %q, got %q", want, messages)
+	}
+	st := stateManager.Load("example.com")
+	if st.LastSource != SourceCert || !st.CertExpiration.Equal(certs.expiry) || !st.Expiration.IsZero() {
+		t.Errorf("Expected the certificate expiration from source %q, got %+v", SourceCert, st)
+	}
+	if res := result(cfg, time.Now(), "example.com", st.LastSource, st); res.Source != SourceCert || !res.Expiration.Equal(certs.expiry) {
+		t.Errorf("Expected the report to show the certificate expiration, got %+v", res)
+	}
+
+	// The same certificate isn't notified again, a renewed one starts over
+	if err := processor.ProcessDomain(context.Background(), "example.com"); err != nil || len(messages) != 1 {
+		t.Errorf("Expected no repeated notification, got %v and %q", err, messages)
+	}
+	certs.expiry = time.Now().Add(20 * 24 * time.Hour)
+	if err := processor.ProcessDomain(context.Background(), "example.com"); err != nil || len(messages) != 2 {
+		t.Errorf("Expected the renewed certificate to be notified, got %v and %q", err, messages)
+	}
+
+	// A certificate that can't be checked leaves the expiration unknown
+	certs.err = errors.New("handshake failed")
+	if err := processor.ProcessDomain(context.Background(), "example.com"); err == nil {
+		t.Errorf("Expected an error when the certificate can't be checked either")
+	}
+}
+
+// TestProcessDomain_Timeout tests that a domain taking longer than PerDomainTimeout is abandoned with an error
+func TestProcessDomain_Timeout(t *testing.T) {
+	log := logger.New()
+	cfg := config.New(log)
+	cfg.StateDir = t.TempDir()
+	cfg.WhoisCacheTTL = time.Hour
+	cfg.PerDomainTimeout = 100 * time.Millisecond
+	cfg.DNSServers = []string{newNameserver(t, time.Second, 1)} // well within the lookup timeout, but too slow for the domain
+	cacheWhois(t, cfg.StateDir, "example.com")
+
+	stateManager := state.New(cfg, log)
+	processor := New(cfg, log, dns.New(cfg, log), whois.New(cfg, log), notify.New(cfg, log), stateManager)
+	processor.report = newReport()
+
+	start := time.Now()
+	err := processor.ProcessDomain(context.Background(), "example.com")
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("ProcessDomain() took %s with a %s per-domain timeout", elapsed, cfg.PerDomainTimeout)
+	}
+	if err == nil || !strings.Contains(err.Error(), "timed out after 100ms") {
+		t.Errorf("Expected a timeout error, got %v", err)
+	}
+
+	if st := stateManager.Load("example.com"); !strings.Contains(st.LastError, "timed out") || st.LastChecked.IsZero() {
+		t.Errorf("Expected the timeout to be recorded, got LastChecked=%s LastError=%q", st.LastChecked, st.LastError)
+	}
+	if len(processor.report.Domains) != 1 || processor.report.Domains[0].Error == "" {
+		t.Errorf("Expected the timeout in the report, got %+v", processor.report.Domains)
+	}
+}
+
+// TestProcessAll_CancelWhileWaiting tests that domains waiting for a free slot aren't started once the context is done
+func TestProcessAll_CancelWhileWaiting(t *testing.T) {
+	log := logger.New()
+	var out, errOut syncBuffer
+	log.SetOutput(&out, &errOut)
+
+	cfg := config.New(log)
+	cfg.StateDir = t.TempDir()
+	cfg.WhoisCacheTTL = time.Hour
+	cfg.Concurrency = 1
+	cfg.DNSServers = []string{newNameserver(t, time.Second, 1)}
+	cfg.Domains = []config.DomainEntry{{Name: "example.com"}, {Name: "example.org"}}
+	cacheWhois(t, cfg.StateDir, "example.com")
+	cacheWhois(t, cfg.StateDir, "example.org")
+
+	processor := New(cfg, log, dns.New(cfg, log), whois.New(cfg, log), notify.New(cfg, log), state.New(cfg, log))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	if _, err := processor.ProcessAll(ctx); err != nil {
+		t.Errorf("Expected no error for an interrupted run, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("ProcessAll() took %s after cancellation", elapsed)
+	}
+	if strings.Contains(out.String(), "Checking example.org") {
+		t.Errorf("Expected example.org not to be started, got %q", out.String())
+	}
+}
+
+// TestProcessDomain_UnknownExpiry tests the one-time notification for a registered domain without an expiration date
+func TestProcessDomain_UnknownExpiry(t *testing.T) {
+	var posts atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		posts.Add(1)
+	}))
+	defer server.Close()
+
+	log := logger.New()
+	cfg := config.New(log)
+	cfg.StateDir = t.TempDir()
+	cfg.WebhookURL = server.URL
+	cfg.NotifyUnknownExpiry = true
+	cfg.DNSServers = []string{newNameserver(t, 0, 1)}
+
+	stateManager := state.New(cfg, log)
+	whoisChecker := &fakeWhois{expirations: map[string]time.Time{"example.com": {}}}
+	processor := New(cfg, log, dns.New(cfg, log), whoisChecker, notify.New(cfg, log), stateManager)
+
+	for i := 0; i < 2; i++ {
+		if err := processor.ProcessDomain(context.Background(), "example.com"); err == nil {
+			t.Errorf("Expected an error for the missing expiration date")
+		}
+	}
+	if got := posts.Load(); got != 1 {
+		t.Errorf("Expected a single notification, got %d", got)
+	}
+	if st := stateManager.Load("example.com"); !st.NotifiedUnknownExpiry {
+		t.Errorf("Expected NotifiedUnknownExpiry to be saved")
+	}
+
+	// Once the date shows up, losing it again is notified again
+	whoisChecker.expirations["example.com"] = time.Date(2099, 8, 13, 4, 0, 0, 0, time.UTC)
+	if err := processor.ProcessDomain(context.Background(), "example.com"); err != nil {
+		t.Errorf("ProcessDomain() returned error: %v", err)
+	}
+	if st := stateManager.Load("example.com"); st.NotifiedUnknownExpiry {
+		t.Errorf("Expected NotifiedUnknownExpiry to be reset once the expiration date is known")
+	}
+}
+
+// TestProcessAll_LookupLimits tests that DNS and WHOIS lookups are limited separately, beyond Concurrency
+func TestProcessAll_LookupLimits(t *testing.T) {
+	log := logger.New()
+	cfg := config.New(log)
+	cfg.StateDir = t.TempDir()
+	cfg.WhoisCacheTTL = time.Hour
+	cfg.Concurrency = 1
+	cfg.DNSConcurrency = 4
+	cfg.WhoisConcurrency = 1
+	cfg.DNSServers = []string{newNameserver(t, 200*time.Millisecond, 0)}
+	for _, name := range []string{"example.com", "example.org", "example.net", "example.io"} {
+		cfg.Domains = append(cfg.Domains, config.DomainEntry{Name: name})
+		cacheWhois(t, cfg.StateDir, name)
+	}
+
+	processor := New(cfg, log, dns.New(cfg, log), whois.New(cfg, log), notify.New(cfg, log), state.New(cfg, log))
+
+	// The DNS limit lets all four domains be checked at once, even though Concurrency is 1 and only one WHOIS lookup may run
+	start := time.Now()
+	report, err := processor.ProcessAll(context.Background())
+	if err != nil {
+		t.Fatalf("ProcessAll() returned error: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 600*time.Millisecond {
+		t.Errorf("Expected the DNS lookups to run in parallel, took %s", elapsed)
+	}
+	if report.Totals.Available != 4 {
+		t.Errorf("Expected 4 available domains, got %+v", report.Totals)
+	}
+
+	// Without a higher lookup limit, Concurrency bounds the domains checked at once
+	cfg.DNSConcurrency = 0
+	start = time.Now()
+	if _, err := processor.ProcessAll(context.Background()); err != nil {
+		t.Fatalf("ProcessAll() returned error: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < 800*time.Millisecond {
+		t.Errorf("Expected the domains to be checked one at a time, took %s", elapsed)
+	}
+
+	// A WHOIS lookup waits for the slot held by another one, while DNS lookups go ahead
+	if err := processor.whoisSem.Acquire(context.Background(), 1); err != nil {
+		t.Fatal(err)
+	}
+	defer processor.whoisSem.Release(1)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+	defer cancel()
+	pending := processor.startWhois(ctx, "example.com")
+	defer pending.stop()
+
+	if available, _, err := processor.isAvailable(ctx, "example.com"); err != nil || !available {
+		t.Errorf("isAvailable() = %v, %v; want available while WHOIS is waiting", available, err)
+	}
+	if _, err := pending.wait(); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected the WHOIS lookup to wait for a free slot until the deadline, got %v", err)
+	}
+}
+
+// fixedClock is a Clock that only moves when the test sets it
+type fixedClock struct {
+	now time.Time
+}
+
+func (c *fixedClock) Now() time.Time {
+	return c.now
+}
+
+// TestCleanup_Clock tests that the retention of removed domains' state is judged by the processor's clock
+func TestCleanup_Clock(t *testing.T) {
+	log := logger.New()
+	cfg := config.New(log)
+	cfg.StateDir = t.TempDir()
+	cfg.Domains = []config.DomainEntry{{Name: "example.com"}}
+	cfg.CleanupRetention = 24 * time.Hour
+
+	stateManager := state.New(cfg, log)
+	stateManager.Save("removed.com", state.DomainState{})
+	clock := &fixedClock{now: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+	processor := New(cfg, log, nil, nil, &recordingNotifier{}, stateManager)
+	processor.SetClock(clock)
+
+	processor.Cleanup()
+	if st := stateManager.Load("removed.com"); !st.OrphanedAt.Equal(clock.now) {
+		t.Errorf("Expected removed.com to be marked orphaned at %s, got %s", clock.now, st.OrphanedAt)
+	}
+
+	clock.now = clock.now.Add(24 * time.Hour)
+	processor.Cleanup()
+	if domains, err := stateManager.List(); err != nil || len(domains) != 0 {
+		t.Errorf("Expected removed.com to be removed after the retention, got %v (%v)", domains, err)
+	}
+}
+
+// TestHandleExpiry_Clock tests tier crossing, expiry and renewal at exact times without depending on the wall clock
+func TestHandleExpiry_Clock(t *testing.T) {
+	var messages []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var payload struct{ Message string }
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			t.Errorf("Failed to decode webhook payload: %v", err)
+		}
+		messages = append(messages, payload.Message)
+	}))
+	defer server.Close()
+
+	log := logger.New()
+	cfg := config.New(log)
+	cfg.StateDir = t.TempDir()
+	cfg.Timezone = "UTC"
+	cfg.ThresholdTiers = []int{30, 7}
+	cfg.WebhookURL = server.URL
+
+	clock := &fixedClock{}
+	processor := New(cfg, log, nil, nil, notify.New(cfg, log), state.New(cfg, log))
+	processor.SetClock(clock)
+
+	expiration := time.Date(2024, 3, 31, 12, 0, 0, 0, time.UTC)
+	domainState := &state.DomainState{}
+	steps := []struct {
+		now  time.Time
+		want string // message sent at this time, if any
+	}{
+		{time.Date(2024, 2, 29, 23, 59, 0, 0, time.UTC), ""}, // 31 days left
+		{time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC), "Domain example.com expires in 30 days"},
+		{time.Date(2024, 3, 23, 23, 59, 0, 0, time.UTC), ""}, // 8 days left
+		{time.Date(2024, 3, 24, 0, 0, 0, 0, time.UTC), "Domain example.com expires in 7 days"},
+		{time.Date(2024, 3, 31, 11, 59, 0, 0, time.UTC), ""}, // expires today, already notified
+		{time.Date(2024, 3, 31, 12, 0, 1, 0, time.UTC), "Domain example.com expired today"},
+		{time.Date(2024, 4, 2, 0, 0, 0, 0, time.UTC), ""},
+	}
+	for _, step := range steps {
+		clock.now = step.now
+		sent := len(messages)
+		processor.handleExpiry("example.com", expiration, domainState)
+
+		switch {
+		case step.want == "" && len(messages) != sent:
+			t.Errorf("At %s: Expected no notification, got %q", step.now, messages[sent:])
+		case step.want != "" && (len(messages) != sent+1 || messages[sent] != step.want):
+			t.Errorf("At %s: Expected %q, got %q", step.now, step.want, messages[sent:])
+		}
+	}
+
+	// Renewing starts the reminders over, and the time of the last one comes from the clock
+	renewed := expiration.AddDate(1, 0, 0)
+	clock.now = time.Date(2024, 4, 3, 0, 0, 0, 0, time.UTC)
+	processor.handleExpiry("example.com", renewed, domainState)
+	if domainState.NotifiedExpired || len(domainState.NotifiedTiers) != 0 {
+		t.Errorf("Expected the renewal to reset the reminders, got %+v", domainState)
+	}
+	if got := domainState.LastNotified[notify.EventExpired]; !got.Equal(time.Date(2024, 3, 31, 12, 0, 1, 0, time.UTC)) {
+		t.Errorf("Expected the expired notification to be recorded at the clock's time, got %s", got)
+	}
+}
+
+// TestProcessDomain_AvailableConfirmations tests that availability is only notified once it was seen on enough consecutive runs
+func TestProcessDomain_AvailableConfirmations(t *testing.T) {
+	var posts atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		posts.Add(1)
+	}))
+	defer server.Close()
+
+	log := logger.New()
+	cfg := config.New(log)
+	cfg.StateDir = t.TempDir()
+	cfg.WhoisCacheTTL = time.Hour
+	cfg.WebhookURL = server.URL
+	cfg.AvailableConfirmations = 3
+	cfg.DNSServers = []string{newNameserver(t, 0, 0)}
+	cacheWhois(t, cfg.StateDir, "example.com")
+
+	// Same settings, but the domain is registered
+	registeredCfg := *cfg
+	registeredCfg.DNSServers = []string{newNameserver(t, 0, 1)}
+
+	stateManager := state.New(cfg, log)
+	processor := New(cfg, log, dns.New(cfg, log), whois.New(cfg, log), notify.New(cfg, log), stateManager)
+	available, registered := processor.dns, dns.New(&registeredCfg, log)
+
+	runs := []struct {
+		checker DNSChecker
+		streak  int
+		posts   int32
+	}{
+		{available, 1, 0},
+		{available, 2, 0},
+		{registered, 0, 0}, // a lookup finding it registered starts over
+		{available, 1, 0},
+		{available, 2, 0},
+		{available, 3, 1},
+		{available, 4, 1}, // already notified
+	}
+	for i, run := range runs {
+		processor.dns = run.checker
+		if err := processor.ProcessDomain(context.Background(), "example.com"); err != nil {
+			t.Fatalf("Run %d: ProcessDomain() returned error: %v", i, err)
+		}
+		if st := stateManager.Load("example.com"); st.AvailableStreak != run.streak {
+			t.Errorf("Run %d: Expected a streak of %d, got %d", i, run.streak, st.AvailableStreak)
+		}
+		if got := posts.Load(); got != run.posts {
+			t.Errorf("Run %d: Expected %d notifications, got %d", i, run.posts, got)
+		}
+	}
 }
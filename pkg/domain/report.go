@@ -0,0 +1,166 @@
+package domain
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mallocator/domain-checker/pkg/dns"
+	"github.com/mallocator/domain-checker/pkg/whois"
+)
+
+// Report summarizes the results of a ProcessAll run
+type Report struct {
+	Started  time.Time `json:"started"`
+	Duration float64   `json:"duration_seconds"`
+
+	Totals  ReportTotals   `json:"totals"`
+	Domains []DomainResult `json:"domains"`
+
+	mu sync.Mutex
+}
+
+// ReportTotals counts the domain results by outcome
+type ReportTotals struct {
+	Checked   int `json:"checked"`
+	Available int `json:"available"`
+	Expiring  int `json:"expiring"`
+	Warnings  int `json:"warnings"`
+	Errors    int `json:"errors"`
+}
+
+// DomainResult is the outcome of checking a single domain
+type DomainResult struct {
+	Domain     string    `json:"domain"`
+	Available  bool      `json:"available"`
+	Expiration time.Time `json:"expiration,omitzero"`
+	DaysLeft   *int      `json:"days_left,omitempty"` // nil when the expiration is unknown
+	Expiring   bool      `json:"expiring,omitempty"`  // within the notification threshold or expired
+	Warning    bool      `json:"warning,omitempty"`   // within WarnThresholdDays, but not yet notified about
+	Source     string    `json:"source,omitempty"`
+	Error      string    `json:"error,omitempty"`
+	ErrorKind  string    `json:"error_kind,omitempty"` // one of the Error* kinds
+	LastAlert  *Alert    `json:"last_alert,omitempty"` // only with NotifyHistory enabled
+	RenewedAt  time.Time `json:"renewed_at,omitzero"`  // when the expiration date was last seen moving forward
+
+	// Renewal risk hints from WHOIS, nil when the WHOIS data doesn't tell
+	TransferLocked *bool `json:"transfer_locked,omitempty"`
+	AutoRenew      *bool `json:"auto_renew,omitempty"`
+
+	// How long the DNS and WHOIS lookups took, zero when the check didn't need them
+	DNSSeconds   float64 `json:"dns_seconds,omitempty"`
+	WhoisSeconds float64 `json:"whois_seconds,omitempty"`
+}
+
+// Kinds of errors in the report, telling the failures callers may want to handle differently apart
+const (
+	ErrorTimeout        = "timeout"         // a lookup or the whole check took too long
+	ErrorDNS            = "dns"             // DNS lookups failed while DNSRequired is set
+	ErrorUnsupportedTLD = "unsupported_tld" // no WHOIS server is known for the TLD
+	ErrorWhoisParse     = "whois_parse"     // the WHOIS response or its expiration date couldn't be read
+	ErrorNoExpiration   = "no_expiration"   // the WHOIS response has no expiration date
+	ErrorOther          = "other"
+)
+
+// errorKind classifies a check error for the report, "" if there's none
+func errorKind(err error) string {
+	switch {
+	case err == nil:
+		return ""
+	case errors.Is(err, context.DeadlineExceeded), errors.Is(err, dns.ErrTimeout), errors.Is(err, whois.ErrTimeout):
+		return ErrorTimeout
+	case errors.Is(err, dns.ErrServerFailure), errors.Is(err, dns.ErrMalformedResponse), errors.Is(err, dns.ErrTruncated),
+		errors.Is(err, dns.ErrNoResolver), errors.Is(err, dns.ErrNoConsensus):
+		return ErrorDNS
+	case errors.Is(err, whois.ErrUnsupportedTLD):
+		return ErrorUnsupportedTLD
+	case errors.Is(err, whois.ErrParse), errors.Is(err, whois.ErrDateFormat):
+		return ErrorWhoisParse
+	case errors.Is(err, whois.ErrNoExpiration):
+		return ErrorNoExpiration
+	default:
+		return ErrorOther
+	}
+}
+
+// Alert is the last notification successfully sent for a domain
+type Alert struct {
+	Time    time.Time `json:"time"`
+	Event   string    `json:"event,omitempty"`
+	Channel string    `json:"channel"`
+}
+
+// newReport starts an empty report
+func newReport() *Report {
+	return &Report{Started: time.Now(), Domains: []DomainResult{}}
+}
+
+// add records the result for a domain and updates the totals
+func (r *Report) add(res DomainResult) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.Domains = append(r.Domains, res)
+	r.Totals.Checked++
+	if res.Available {
+		r.Totals.Available++
+	}
+	if res.Expiring {
+		r.Totals.Expiring++
+	}
+	if res.Warning {
+		r.Totals.Warnings++
+	}
+	if res.Error != "" {
+		r.Totals.Errors++
+	}
+}
+
+// addAlerts sets the last alert of each domain in the report that has one
+func (r *Report) addAlerts(alerts map[string]Alert) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for i := range r.Domains {
+		if alert, ok := alerts[r.Domains[i].Domain]; ok {
+			r.Domains[i].LastAlert = &alert
+		}
+	}
+}
+
+// finish records the duration and sorts the results by domain
+func (r *Report) finish() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.Duration = time.Since(r.Started).Seconds()
+	slices.SortFunc(r.Domains, func(a, b DomainResult) int { return strings.Compare(a.Domain, b.Domain) })
+}
+
+// Summary describes the totals and duration of the run in one line for the log
+func (r *Report) Summary() string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	t := r.Totals
+	duration := time.Duration(r.Duration * float64(time.Second)).Round(time.Millisecond)
+	return fmt.Sprintf("%d domains checked in %s, %d available, %d expiring, %d warnings, %d errors",
+		t.Checked, duration, t.Available, t.Expiring, t.Warnings, t.Errors)
+}
+
+// WriteFile writes the report as indented JSON
+func (r *Report) WriteFile(path string) error {
+	r.mu.Lock()
+	data, err := json.MarshalIndent(r, "", "  ")
+	r.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0644)
+}
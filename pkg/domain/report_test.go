@@ -0,0 +1,188 @@
+package domain
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/mallocator/domain-checker/pkg/config"
+	"github.com/mallocator/domain-checker/pkg/dns"
+	"github.com/mallocator/domain-checker/pkg/logger"
+	"github.com/mallocator/domain-checker/pkg/notify"
+	"github.com/mallocator/domain-checker/pkg/state"
+	"github.com/mallocator/domain-checker/pkg/whois"
+)
+
+func TestResult(t *testing.T) {
+	cfg := config.New(logger.New())
+
+	// Available according to DNS
+	res := result(cfg, time.Now(), "free.com", SourceDNS, state.DomainState{Expiration: time.Now().Add(-time.Hour)})
+	if !res.Available || res.DaysLeft != nil || !res.Expiration.IsZero() {
+		t.Errorf("Expected an available result without expiration, got %+v", res)
+	}
+
+	// Registered with a known expiration
+	expiration := time.Now().Add(10 * 24 * time.Hour)
+	res = result(cfg, time.Now(), "taken.com", SourceWHOIS, state.DomainState{Expiration: expiration})
+	if res.Available || res.DaysLeft == nil || *res.DaysLeft != 10 {
+		t.Errorf("Expected a registered result with 10 days left, got %+v", res)
+	}
+	if res.Expiring {
+		t.Errorf("Expected 10 days left not to be expiring with a %d day threshold, got %+v", cfg.ThresholdDays, res)
+	}
+
+	// Within the notification threshold, or already expired
+	for _, days := range []int{cfg.ThresholdDays, -1} {
+		expiration := time.Now().Add(time.Duration(days)*24*time.Hour + time.Hour)
+		if res = result(cfg, time.Now(), "taken.com", SourceWHOIS, state.DomainState{Expiration: expiration}); !res.Expiring {
+			t.Errorf("Expected %d days left to be expiring, got %+v", days, res)
+		}
+	}
+
+	// Within the warning threshold only when one is set
+	expiration = time.Now().Add(45 * 24 * time.Hour)
+	if res = result(cfg, time.Now(), "taken.com", SourceWHOIS, state.DomainState{Expiration: expiration}); res.Warning {
+		t.Errorf("Expected no warning without a warning threshold, got %+v", res)
+	}
+	cfg.WarnThresholdDays = 60
+	if res = result(cfg, time.Now(), "taken.com", SourceWHOIS, state.DomainState{Expiration: expiration}); !res.Warning {
+		t.Errorf("Expected a warning within the warning threshold, got %+v", res)
+	}
+
+	// Renewal hints from the last WHOIS lookup
+	locked := true
+	res = result(cfg, time.Now(), "taken.com", SourceState, state.DomainState{Expiration: expiration, TransferLocked: &locked})
+	if res.TransferLocked == nil || !*res.TransferLocked || res.AutoRenew != nil {
+		t.Errorf("Expected a transfer locked result without an auto-renew hint, got %+v", res)
+	}
+
+	// Failed lookup
+	res = result(cfg, time.Now(), "broken.com", SourceWHOIS, state.DomainState{LastError: "failed to get WHOIS data"})
+	if res.Available || res.Error == "" || res.DaysLeft != nil {
+		t.Errorf("Expected a failed result, got %+v", res)
+	}
+}
+
+func TestErrorKind(t *testing.T) {
+	tests := []struct {
+		err  error
+		want string
+	}{
+		{nil, ""},
+		{fmt.Errorf("failed to get expiration date: %w", &whois.QueryError{Domain: "example.com", Err: whois.ErrTimeout}), ErrorTimeout},
+		{fmt.Errorf("DNS lookup failed: %w", dns.ErrTimeout), ErrorTimeout},
+		{fmt.Errorf("DNS lookup failed: %w", dns.ErrNoConsensus), ErrorDNS},
+		{fmt.Errorf("expiration date can't be looked up: %w", whois.ErrUnsupportedTLD), ErrorUnsupportedTLD},
+		{fmt.Errorf("failed to get expiration date: %w", whois.ErrParse), ErrorWhoisParse},
+		{fmt.Errorf("failed to get expiration date: %w", whois.ErrDateFormat), ErrorWhoisParse},
+		{fmt.Errorf("failed to get expiration date: %w", whois.ErrNoExpiration), ErrorNoExpiration},
+		{errors.New("lock held by another run"), ErrorOther},
+	}
+	for _, tc := range tests {
+		if got := errorKind(tc.err); got != tc.want {
+			t.Errorf("errorKind(%v) = %q, want %q", tc.err, got, tc.want)
+		}
+	}
+}
+
+func TestReport_Summary(t *testing.T) {
+	report := newReport()
+	report.add(DomainResult{Domain: "taken.com", Expiring: true})
+	report.add(DomainResult{Domain: "free.com", Available: true})
+	report.add(DomainResult{Domain: "broken.com", Error: "failed to get WHOIS data"})
+	report.Duration = 1.5
+
+	want := "3 domains checked in 1.5s, 1 available, 1 expiring, 0 warnings, 1 errors"
+	if got := report.Summary(); got != want {
+		t.Errorf("Summary() = %q, want %q", got, want)
+	}
+}
+
+func TestReport_WriteFile(t *testing.T) {
+	report := newReport()
+	tenDays := 10
+	report.add(DomainResult{Domain: "taken.com", Source: SourceWHOIS, Expiration: time.Now(), DaysLeft: &tenDays, Expiring: true})
+	report.add(DomainResult{Domain: "free.com", Source: SourceDNS, Available: true})
+	report.add(DomainResult{Domain: "broken.com", Source: SourceWHOIS, Error: "failed to get WHOIS data"})
+	report.add(DomainResult{Domain: "soon.com", Source: SourceWHOIS, Warning: true})
+	report.finish()
+
+	path := filepath.Join(t.TempDir(), "report.json")
+	if err := report.WriteFile(path); err != nil {
+		t.Fatalf("WriteFile() returned error: %v", err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got struct {
+		Started  time.Time `json:"started"`
+		Duration *float64  `json:"duration_seconds"`
+		Totals   struct {
+			Checked, Available, Expiring, Warnings, Errors int
+		} `json:"totals"`
+		Domains []map[string]any `json:"domains"`
+	}
+	if err := json.Unmarshal(data, &got); err != nil {
+		t.Fatalf("Report isn't valid JSON: %v", err)
+	}
+
+	if got.Started.IsZero() || got.Duration == nil {
+		t.Errorf("Expected run timestamp and duration, got %s", data)
+	}
+	if got.Totals.Checked != 4 || got.Totals.Available != 1 || got.Totals.Expiring != 1 || got.Totals.Warnings != 1 || got.Totals.Errors != 1 {
+		t.Errorf("Expected totals 4 checked, 1 available, 1 expiring, 1 warning, 1 error, got %+v", got.Totals)
+	}
+	if len(got.Domains) != 4 || got.Domains[0]["domain"] != "broken.com" || got.Domains[3]["domain"] != "taken.com" {
+		t.Fatalf("Expected domains sorted by name, got %v", got.Domains)
+	}
+	if got.Domains[3]["days_left"] != float64(10) {
+		t.Errorf("Expected days_left 10 for taken.com, got %v", got.Domains[3]["days_left"])
+	}
+	if got.Domains[2]["warning"] != true {
+		t.Errorf("Expected a warning for soon.com, got %v", got.Domains[2])
+	}
+	if _, ok := got.Domains[3]["warning"]; ok {
+		t.Errorf("Expected no warning field for taken.com, got %v", got.Domains[3])
+	}
+	if _, ok := got.Domains[1]["days_left"]; ok {
+		t.Errorf("Expected no days_left for an available domain, got %v", got.Domains[1])
+	}
+}
+
+func TestProcessor_AddLastAlerts(t *testing.T) {
+	log := logger.New()
+	cfg := config.New(log)
+	cfg.StateDir = t.TempDir()
+	cfg.NotifyHistory = true
+
+	history := `{"time":"2024-01-01T00:00:00Z","domain":"taken.com","channel":"email","event":"expiring","success":true}
+{"time":"2024-01-02T00:00:00Z","domain":"taken.com","channel":"webhook","event":"expired","success":false,"error":"webhook: unexpected status 500"}
+{"time":"2024-01-03T00:00:00Z","domain":"other.com","channel":"telegram","event":"available","success":true}
+`
+	if err := os.WriteFile(filepath.Join(cfg.StateDir, "notifications.jsonl"), []byte(history), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	p := New(cfg, log, nil, nil, notify.New(cfg, log), nil)
+	p.report = newReport()
+	p.report.add(DomainResult{Domain: "taken.com"})
+	p.report.add(DomainResult{Domain: "quiet.com"})
+	p.addLastAlerts()
+
+	// The failed webhook delivery doesn't count as the last alert
+	alert := p.report.Domains[0].LastAlert
+	want := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	if alert == nil || !alert.Time.Equal(want) || alert.Event != "expiring" || alert.Channel != "email" {
+		t.Errorf("Expected the email alert of 2024-01-01 for taken.com, got %+v", alert)
+	}
+	if p.report.Domains[1].LastAlert != nil {
+		t.Errorf("Expected no last alert for quiet.com, got %+v", p.report.Domains[1].LastAlert)
+	}
+}
@@ -0,0 +1,22 @@
+// Package idn converts internationalized domain names for the domain checker application
+package idn
+
+import (
+	"strings"
+
+	"golang.org/x/net/idna"
+)
+
+// ToASCII converts a domain to its A-label (punycode) form, e.g. münchen.de to xn--mnchen-3ya.de
+// DNS and WHOIS only understand this form; ASCII domains are returned lowercased but otherwise unchanged
+// A leading "*" wildcard label is kept as is
+func ToASCII(domain string) (string, error) {
+	if rest, ok := strings.CutPrefix(domain, "*."); ok {
+		ascii, err := idna.Lookup.ToASCII(rest)
+		if err != nil {
+			return "", err
+		}
+		return "*." + ascii, nil
+	}
+	return idna.Lookup.ToASCII(domain)
+}
@@ -0,0 +1,30 @@
+package idn
+
+import "testing"
+
+func TestToASCII(t *testing.T) {
+	tests := []struct {
+		domain string
+		want   string
+		err    bool
+	}{
+		{"example.com", "example.com", false},
+		{"münchen.de", "xn--mnchen-3ya.de", false},
+		{"MÜNCHEN.de", "xn--mnchen-3ya.de", false},
+		{"xn--mnchen-3ya.de", "xn--mnchen-3ya.de", false},
+		{"*.münchen.de", "*.xn--mnchen-3ya.de", false},
+		{"bad_name.com", "", true},
+		{"a.*.com", "", true},
+	}
+
+	for _, tc := range tests {
+		got, err := ToASCII(tc.domain)
+		if (err != nil) != tc.err {
+			t.Errorf("ToASCII(%q) err = %v, wantErr %v", tc.domain, err, tc.err)
+			continue
+		}
+		if err == nil && got != tc.want {
+			t.Errorf("ToASCII(%q) = %q, want %q", tc.domain, got, tc.want)
+		}
+	}
+}
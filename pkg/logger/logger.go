@@ -3,26 +3,109 @@ package logger
 
 import (
 	"fmt"
+	"io"
 	"os"
 	"strings"
+	"time"
 )
 
+// Level is the minimum severity a logger writes
+type Level int
+
+// Log levels from most to least verbose
+const (
+	LevelDebug Level = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+// ParseLevel converts a level name (debug, info, warn, error) to a Level
+func ParseLevel(name string) (Level, error) {
+	switch strings.ToLower(strings.TrimSpace(name)) {
+	case "debug":
+		return LevelDebug, nil
+	case "info":
+		return LevelInfo, nil
+	case "warn", "warning":
+		return LevelWarn, nil
+	case "error":
+		return LevelError, nil
+	default:
+		return LevelInfo, fmt.Errorf("unknown log level %q", name)
+	}
+}
+
 // Logger is a simple logging interface
 type Logger struct {
-	debugEnabled bool
+	level Level
+
+	// Whether lines start with a timestamp, and its layout
+	timestamps bool
+	timeLayout string
+
+	// Destinations for info and for debug/warning/error lines, nil for os.Stdout and os.Stderr
+	out    io.Writer
+	errOut io.Writer
 }
 
 // New creates a new logger instance
+// The level comes from LOG_LEVEL (default info); DEBUG=true is a shortcut for LOG_LEVEL=debug
+// Lines are timestamped unless LOG_TIMESTAMPS=false, e.g. when the runtime already adds timestamps
 func New() *Logger {
-	return &Logger{
-		debugEnabled: strings.ToLower(os.Getenv("DEBUG")) == "true",
+	l := &Logger{
+		level:      LevelInfo,
+		timestamps: strings.ToLower(os.Getenv("LOG_TIMESTAMPS")) != "false",
+		timeLayout: time.RFC3339,
 	}
+	if v := os.Getenv("LOG_LEVEL"); v != "" {
+		if level, err := ParseLevel(v); err == nil {
+			l.level = level
+		} else {
+			l.Warnf("Ignoring LOG_LEVEL: %v", err)
+		}
+	}
+	if strings.ToLower(os.Getenv("DEBUG")) == "true" {
+		l.level = LevelDebug
+	}
+	return l
+}
+
+// SetOutput sets where info lines and debug/warning/error/fatal lines are written
+// A nil writer restores the default os.Stdout or os.Stderr
+func (l *Logger) SetOutput(out, errOut io.Writer) {
+	l.out = out
+	l.errOut = errOut
+}
+
+// stdout returns the writer for info lines
+func (l *Logger) stdout() io.Writer {
+	if l.out == nil {
+		return os.Stdout
+	}
+	return l.out
+}
+
+// stderr returns the writer for debug, warning, error and fatal lines
+func (l *Logger) stderr() io.Writer {
+	if l.errOut == nil {
+		return os.Stderr
+	}
+	return l.errOut
+}
+
+// prefix returns the start of a log line for the given level
+func (l *Logger) prefix(level string) string {
+	if !l.timestamps {
+		return level + ": "
+	}
+	return time.Now().Format(l.timeLayout) + " " + level + ": "
 }
 
 // Debugf logs debug messages when debug is enabled
 func (l *Logger) Debugf(format string, args ...interface{}) {
-	if l.debugEnabled {
-		if _, err := fmt.Fprintf(os.Stderr, "DEBUG: "+format+"\n", args...); err != nil {
+	if l.level <= LevelDebug {
+		if _, err := fmt.Fprintf(l.stderr(), l.prefix("DEBUG")+format+"\n", args...); err != nil {
 			l.Errorf("Failed to write debug log: %v", err)
 		}
 	}
@@ -30,35 +113,64 @@ func (l *Logger) Debugf(format string, args ...interface{}) {
 
 // Infof logs informational messages
 func (l *Logger) Infof(format string, args ...interface{}) {
-	if _, err := fmt.Fprintf(os.Stdout, "INFO: "+format+"\n", args...); err != nil {
+	if l.level > LevelInfo {
+		return
+	}
+	if _, err := fmt.Fprintf(l.stdout(), l.prefix("INFO")+format+"\n", args...); err != nil {
 		l.Errorf("Failed to write info log: %v", err)
 	}
 }
 
 // Warnf logs warning messages
 func (l *Logger) Warnf(format string, args ...interface{}) {
-	if _, err := fmt.Fprintf(os.Stderr, "WARN: "+format+"\n", args...); err != nil {
+	if l.level > LevelWarn {
+		return
+	}
+	if _, err := fmt.Fprintf(l.stderr(), l.prefix("WARN")+format+"\n", args...); err != nil {
 		l.Errorf("Failed to write warning log: %v", err)
 	}
 }
 
 // Errorf logs error messages
 func (l *Logger) Errorf(format string, args ...interface{}) {
-	if _, err := fmt.Fprintf(os.Stderr, "ERROR: "+format+"\n", args...); err != nil {
+	if l.level > LevelError {
+		return
+	}
+	if _, err := fmt.Fprintf(l.stderr(), l.prefix("ERROR")+format+"\n", args...); err != nil {
 		// Can't use Errorf here to avoid infinite recursion
-		_, _ = fmt.Fprintf(os.Stderr, "ERROR: Failed to write error log: %v\n", err)
+		_, _ = fmt.Fprintf(l.stderr(), l.prefix("ERROR")+"Failed to write error log: %v\n", err)
 	}
 }
 
 // Fatalf logs fatal messages and exits the program
 func (l *Logger) Fatalf(format string, args ...interface{}) {
-	if _, err := fmt.Fprintf(os.Stderr, "FATAL: "+format+"\n", args...); err != nil {
+	if _, err := fmt.Fprintf(l.stderr(), l.prefix("FATAL")+format+"\n", args...); err != nil {
 		l.Errorf("Failed to write fatal log: %v", err)
 	}
 	os.Exit(1)
 }
 
 // SetDebug enables or disables debug logging
+// Disabling it falls back to the info level if debug was the current level
 func (l *Logger) SetDebug(enabled bool) {
-	l.debugEnabled = enabled
-}
\ No newline at end of file
+	if enabled {
+		l.level = LevelDebug
+	} else if l.level == LevelDebug {
+		l.level = LevelInfo
+	}
+}
+
+// SetLevel sets the minimum level that is written; fatal messages are always written
+func (l *Logger) SetLevel(level Level) {
+	l.level = level
+}
+
+// SetTimestamps enables or disables the timestamp at the start of each line
+func (l *Logger) SetTimestamps(enabled bool) {
+	l.timestamps = enabled
+}
+
+// SetTimeLayout sets the time.Format layout of the timestamps, e.g. time.RFC3339Nano
+func (l *Logger) SetTimeLayout(layout string) {
+	l.timeLayout = layout
+}
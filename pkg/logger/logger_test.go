@@ -7,6 +7,7 @@ import (
 	"os"
 	"strings"
 	"testing"
+	"time"
 )
 
 func captureOutput(f func()) (string, string) {
@@ -72,7 +73,7 @@ func TestNew(t *testing.T) {
 		t.Fatalf("Failed to set environment variable: %v", err)
 	}
 	logger := New()
-	if !logger.debugEnabled {
+	if logger.level != LevelDebug {
 		t.Errorf("Expected debugEnabled to be true when DEBUG=true")
 	}
 
@@ -81,7 +82,7 @@ func TestNew(t *testing.T) {
 		t.Fatalf("Failed to set environment variable: %v", err)
 	}
 	logger = New()
-	if logger.debugEnabled {
+	if logger.level == LevelDebug {
 		t.Errorf("Expected debugEnabled to be false when DEBUG=false")
 	}
 
@@ -90,7 +91,7 @@ func TestNew(t *testing.T) {
 		t.Fatalf("Failed to unset environment variable: %v", err)
 	}
 	logger = New()
-	if logger.debugEnabled {
+	if logger.level == LevelDebug {
 		t.Errorf("Expected debugEnabled to be false when DEBUG is not set")
 	}
 }
@@ -100,13 +101,13 @@ func TestSetDebug(t *testing.T) {
 
 	// Test enabling debug
 	logger.SetDebug(true)
-	if !logger.debugEnabled {
+	if logger.level != LevelDebug {
 		t.Errorf("Expected debugEnabled to be true after SetDebug(true)")
 	}
 
 	// Test disabling debug
 	logger.SetDebug(false)
-	if logger.debugEnabled {
+	if logger.level == LevelDebug {
 		t.Errorf("Expected debugEnabled to be false after SetDebug(false)")
 	}
 }
@@ -183,6 +184,163 @@ func TestErrorf(t *testing.T) {
 	}
 }
 
+func TestSetLevel(t *testing.T) {
+	logger := New()
+	logger.SetLevel(LevelWarn)
+
+	stdout, stderr := captureOutput(func() {
+		logger.Debugf("Test debug message")
+		logger.Infof("Test info message")
+	})
+	if stdout != "" || stderr != "" {
+		t.Errorf("Expected no output below warn, got stdout=%q, stderr=%q", stdout, stderr)
+	}
+
+	_, stderr = captureOutput(func() {
+		logger.Warnf("Test warning message")
+		logger.Errorf("Test error message")
+	})
+	if !strings.Contains(stderr, "WARN: Test warning message") || !strings.Contains(stderr, "ERROR: Test error message") {
+		t.Errorf("Expected warnings and errors at warn level, got %q", stderr)
+	}
+
+	logger.SetLevel(LevelError)
+	_, stderr = captureOutput(func() {
+		logger.Warnf("Test warning message")
+	})
+	if stderr != "" {
+		t.Errorf("Expected no warnings at error level, got %q", stderr)
+	}
+}
+
+func TestNew_LogLevel(t *testing.T) {
+	t.Setenv("DEBUG", "")
+	t.Setenv("LOG_LEVEL", "warn")
+	if logger := New(); logger.level != LevelWarn {
+		t.Errorf("Expected warn level from LOG_LEVEL=warn, got %d", logger.level)
+	}
+
+	// DEBUG=true wins over LOG_LEVEL
+	t.Setenv("DEBUG", "true")
+	if logger := New(); logger.level != LevelDebug {
+		t.Errorf("Expected debug level with DEBUG=true, got %d", logger.level)
+	}
+
+	// Unknown levels fall back to info
+	t.Setenv("DEBUG", "")
+	t.Setenv("LOG_LEVEL", "verbose")
+	var logger *Logger
+	_, stderr := captureOutput(func() { logger = New() })
+	if logger.level != LevelInfo {
+		t.Errorf("Expected info level for an unknown LOG_LEVEL, got %d", logger.level)
+	}
+	if !strings.Contains(stderr, "verbose") {
+		t.Errorf("Expected a warning about the unknown level, got %q", stderr)
+	}
+}
+
+func TestParseLevel(t *testing.T) {
+	tests := []struct {
+		name string
+		want Level
+		err  bool
+	}{
+		{"debug", LevelDebug, false},
+		{"INFO", LevelInfo, false},
+		{" warn ", LevelWarn, false},
+		{"warning", LevelWarn, false},
+		{"error", LevelError, false},
+		{"trace", LevelInfo, true},
+	}
+	for _, tc := range tests {
+		got, err := ParseLevel(tc.name)
+		if (err != nil) != tc.err || got != tc.want {
+			t.Errorf("ParseLevel(%q) = %d, %v, want %d, err %v", tc.name, got, err, tc.want, tc.err)
+		}
+	}
+}
+
+func TestTimestamps(t *testing.T) {
+	logger := New()
+
+	// On by default, in RFC3339
+	logger.SetTimestamps(true)
+	stdout, _ := captureOutput(func() {
+		logger.Infof("Test info message")
+	})
+	ts, rest, ok := strings.Cut(strings.TrimSpace(stdout), " ")
+	if !ok || rest != "INFO: Test info message" {
+		t.Fatalf("Expected a timestamp before the message, got %q", stdout)
+	}
+	if _, err := time.Parse(time.RFC3339, ts); err != nil {
+		t.Errorf("Expected an RFC3339 timestamp, got %q: %v", ts, err)
+	}
+
+	// Custom layout
+	logger.SetTimeLayout("2006-01-02")
+	stdout, _ = captureOutput(func() {
+		logger.Infof("Test info message")
+	})
+	if want := time.Now().Format("2006-01-02") + " INFO: Test info message\n"; stdout != want {
+		t.Errorf("Expected %q, got %q", want, stdout)
+	}
+
+	// Disabled
+	logger.SetTimestamps(false)
+	stdout, _ = captureOutput(func() {
+		logger.Infof("Test info message")
+	})
+	if stdout != "INFO: Test info message\n" {
+		t.Errorf("Expected no timestamp, got %q", stdout)
+	}
+}
+
+func TestNew_Timestamps(t *testing.T) {
+	t.Setenv("LOG_TIMESTAMPS", "")
+	if logger := New(); !logger.timestamps {
+		t.Errorf("Expected timestamps to be on by default")
+	}
+
+	t.Setenv("LOG_TIMESTAMPS", "false")
+	if logger := New(); logger.timestamps {
+		t.Errorf("Expected LOG_TIMESTAMPS=false to turn timestamps off")
+	}
+}
+
+func TestSetOutput(t *testing.T) {
+	logger := New()
+	logger.SetTimestamps(false)
+	logger.SetDebug(true)
+
+	var out, errOut bytes.Buffer
+	logger.SetOutput(&out, &errOut)
+
+	logger.Debugf("Test %s message", "debug")
+	logger.Infof("Test %s message", "info")
+	logger.Warnf("Test %s message", "warning")
+	logger.Errorf("Test %s message", "error")
+
+	if got := out.String(); got != "INFO: Test info message\n" {
+		t.Errorf("Expected only the info line in out, got %q", got)
+	}
+	want := "DEBUG: Test debug message\nWARN: Test warning message\nERROR: Test error message\n"
+	if got := errOut.String(); got != want {
+		t.Errorf("Expected %q in errOut, got %q", want, got)
+	}
+
+	// nil restores the OS streams
+	logger.SetOutput(nil, nil)
+	stdout, _ := captureOutput(func() {
+		logger.Infof("Test info message")
+	})
+	if stdout != "INFO: Test info message\n" {
+		t.Errorf("Expected output on stdout after resetting, got %q", stdout)
+	}
+	if out.Len() != len("INFO: Test info message\n") {
+		t.Errorf("Expected no more writes to the old writer, got %q", out.String())
+	}
+}
+
 // Note: We can't fully test Fatalf because it calls os.Exit(1)
 // which would terminate the test. We'll just test that it writes to stderr.
 // This test will not actually call Fatalf to avoid terminating the test.
@@ -0,0 +1,133 @@
+// Package metrics provides Prometheus metrics for the domain checker application
+package metrics
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+)
+
+// Registry holds all domain checker metrics, served by Server
+var Registry = prometheus.NewRegistry()
+
+var (
+	domainsChecked = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "domains_checked_total",
+		Help: "Domain checks completed, including failed ones.",
+	})
+	domainsAvailable = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "domains_available",
+		Help: "Domains found available in the last check cycle.",
+	})
+	domainsWarning = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "domains_warning",
+		Help: "Domains within the warning threshold but not yet notified about in the last check cycle.",
+	})
+	domainExpiryDays = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "domain_expiry_days",
+		Help: "Days until a domain expires.",
+	}, []string{"domain"})
+	dnsErrors = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "dns_errors_total",
+		Help: "DNS lookups that failed.",
+	})
+	whoisErrors = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "whois_errors_total",
+		Help: "WHOIS lookups that failed after all retries or couldn't be parsed.",
+	})
+	lookupDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "lookup_duration_seconds",
+		Help:    "Time taken by DNS and WHOIS lookups, including retries, by lookup.",
+		Buckets: prometheus.ExponentialBuckets(0.01, 2, 12), // 10ms to about 20s
+	}, []string{"lookup"})
+	notificationsSent = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "notifications_sent_total",
+		Help: "Notifications delivered, by channel.",
+	}, []string{"channel"})
+	lastCycle = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "last_cycle_completed_timestamp_seconds",
+		Help: "Unix time the last check cycle went through all domains.",
+	})
+)
+
+// lastCycleAt is when the last check cycle completed in Unix nanoseconds, read by the health check
+var lastCycleAt atomic.Int64
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		domainsChecked,
+		domainsAvailable,
+		domainsWarning,
+		domainExpiryDays,
+		dnsErrors,
+		whoisErrors,
+		lookupDuration,
+		notificationsSent,
+		lastCycle,
+	)
+}
+
+// DomainChecked counts a completed domain check
+func DomainChecked() {
+	domainsChecked.Inc()
+}
+
+// SetDomainsAvailable records how many domains were available in a check cycle
+func SetDomainsAvailable(n int) {
+	domainsAvailable.Set(float64(n))
+}
+
+// SetDomainsWarning records how many domains were within the warning threshold in a check cycle
+func SetDomainsWarning(n int) {
+	domainsWarning.Set(float64(n))
+}
+
+// SetExpiryDays records the days left until a domain expires
+func SetExpiryDays(domain string, days int) {
+	domainExpiryDays.WithLabelValues(domain).Set(float64(days))
+}
+
+// DeleteExpiryDays stops reporting the expiry of a domain, e.g. once it's available
+func DeleteExpiryDays(domain string) {
+	domainExpiryDays.DeleteLabelValues(domain)
+}
+
+// DNSError counts a failed DNS lookup
+func DNSError() {
+	dnsErrors.Inc()
+}
+
+// WhoisError counts a failed WHOIS lookup
+func WhoisError() {
+	whoisErrors.Inc()
+}
+
+// ObserveLookup records how long a lookup (dns, whois) took
+func ObserveLookup(lookup string, d time.Duration) {
+	lookupDuration.WithLabelValues(lookup).Observe(d.Seconds())
+}
+
+// NotificationSent counts a notification delivered through a channel (email, webhook, telegram)
+func NotificationSent(channel string) {
+	notificationsSent.WithLabelValues(channel).Inc()
+}
+
+// CycleCompleted records that a check cycle went through all domains, even if some of them failed
+func CycleCompleted() {
+	now := time.Now()
+	lastCycleAt.Store(now.UnixNano())
+	lastCycle.Set(float64(now.Unix()))
+}
+
+// LastCycle returns when the last check cycle completed, or the zero time if none has yet
+func LastCycle() time.Time {
+	ns := lastCycleAt.Load()
+	if ns == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, ns)
+}
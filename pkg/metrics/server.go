@@ -0,0 +1,93 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	"github.com/mallocator/domain-checker/pkg/config"
+	"github.com/mallocator/domain-checker/pkg/logger"
+)
+
+// Server exposes the metrics over HTTP at /metrics, and a liveness probe at /healthz
+type Server struct {
+	cfg atomic.Pointer[config.Config] // replaced by SetConfig when the daemon reloads its config
+	log *logger.Logger
+
+	// When the server started, the reference for the health check until the first cycle completes
+	started time.Time
+}
+
+// New creates a new metrics server
+func New(cfg *config.Config, log *logger.Logger) *Server {
+	s := &Server{log: log}
+	s.cfg.Store(cfg)
+	return s
+}
+
+// SetConfig makes the health check use the CheckInterval of a reloaded config
+// The server keeps listening on the MetricsAddr it was started with
+func (s *Server) SetConfig(cfg *config.Config) {
+	s.cfg.Store(cfg)
+}
+
+// Start listens on MetricsAddr and serves metrics in the background until ctx is done
+// Returns the address it listens on, useful when MetricsAddr uses port 0
+func (s *Server) Start(ctx context.Context) (string, error) {
+	ln, err := net.Listen("tcp", s.cfg.Load().MetricsAddr)
+	if err != nil {
+		return "", err
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(Registry, promhttp.HandlerOpts{}))
+	mux.HandleFunc("/healthz", s.healthz)
+	s.started = time.Now()
+	srv := &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+
+	go func() {
+		if err := srv.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			s.log.Errorf("Metrics server failed: %v", err)
+		}
+	}()
+
+	context.AfterFunc(ctx, func() {
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := srv.Shutdown(shutdownCtx); err != nil {
+			s.log.Warnf("Failed to shut down metrics server: %v", err)
+		}
+	})
+
+	s.log.Infof("Serving metrics on http://%s/metrics", ln.Addr())
+	return ln.Addr().String(), nil
+}
+
+// healthz responds with 200 while check cycles keep completing and 503 once they stall
+// In daemon mode a cycle starts one CheckInterval after the previous one finished, so a cycle that
+// hasn't completed within twice the interval (counted from startup before the first one) is considered stuck
+// Single runs have no cycle to wait for and are always healthy
+func (s *Server) healthz(w http.ResponseWriter, _ *http.Request) {
+	interval := s.cfg.Load().CheckInterval
+	if interval <= 0 {
+		_, _ = fmt.Fprintln(w, "ok")
+		return
+	}
+
+	last := LastCycle()
+	if last.IsZero() {
+		last = s.started
+	}
+	if age := time.Since(last); age > 2*interval {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		_, _ = fmt.Fprintf(w, "no check cycle completed in %s\n", age.Round(time.Second))
+		return
+	}
+	_, _ = fmt.Fprintln(w, "ok")
+}
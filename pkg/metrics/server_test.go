@@ -0,0 +1,150 @@
+package metrics
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mallocator/domain-checker/pkg/config"
+	"github.com/mallocator/domain-checker/pkg/logger"
+)
+
+func TestServer(t *testing.T) {
+	log := logger.New()
+	cfg := config.New(log)
+	cfg.MetricsAddr = "127.0.0.1:0"
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	addr, err := New(cfg, log).Start(ctx)
+	if err != nil {
+		t.Fatalf("Start() returned error: %v", err)
+	}
+
+	DomainChecked()
+	SetDomainsAvailable(2)
+	SetExpiryDays("example.com", 42)
+	WhoisError()
+	ObserveLookup("dns", 30*time.Millisecond)
+	NotificationSent("webhook")
+
+	resp, err := http.Get("http://" + addr + "/metrics")
+	if err != nil {
+		t.Fatalf("GET /metrics failed: %v", err)
+	}
+	defer func() {
+		if err := resp.Body.Close(); err != nil {
+			t.Errorf("Failed to close response: %v", err)
+		}
+	}()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, want := range []string{
+		"domains_checked_total ",
+		"domains_available 2",
+		`domain_expiry_days{domain="example.com"} 42`,
+		"whois_errors_total ",
+		`lookup_duration_seconds_count{lookup="dns"} `,
+		`notifications_sent_total{channel="webhook"} `,
+	} {
+		if !strings.Contains(string(body), want) {
+			t.Errorf("Expected metrics to contain %q", want)
+		}
+	}
+
+	// Removed domains stop being reported
+	DeleteExpiryDays("example.com")
+	resp2, err := http.Get("http://" + addr + "/metrics")
+	if err != nil {
+		t.Fatalf("GET /metrics failed: %v", err)
+	}
+	defer func() {
+		if err := resp2.Body.Close(); err != nil {
+			t.Errorf("Failed to close response: %v", err)
+		}
+	}()
+	body, err = io.ReadAll(resp2.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(body), `domain="example.com"`) {
+		t.Errorf("Expected example.com expiry to be removed")
+	}
+}
+
+func TestServer_InvalidAddr(t *testing.T) {
+	log := logger.New()
+	cfg := config.New(log)
+	cfg.MetricsAddr = "not-an-address"
+
+	if _, err := New(cfg, log).Start(context.Background()); err == nil {
+		t.Errorf("Expected an error for an invalid address")
+	}
+}
+
+func TestServer_Healthz(t *testing.T) {
+	log := logger.New()
+	cfg := config.New(log)
+	s := New(cfg, log)
+	t.Cleanup(func() { lastCycleAt.Store(0) })
+
+	tests := []struct {
+		name      string
+		interval  time.Duration
+		started   time.Duration // ago
+		lastCycle time.Duration // ago, 0 for none
+		want      int
+	}{
+		{"starting up", time.Hour, time.Minute, 0, http.StatusOK},
+		{"first cycle stuck", time.Hour, 3 * time.Hour, 0, http.StatusServiceUnavailable},
+		{"recent cycle", time.Hour, 5 * time.Hour, 90 * time.Minute, http.StatusOK},
+		{"cycles stalled", time.Hour, 5 * time.Hour, 3 * time.Hour, http.StatusServiceUnavailable},
+		{"single run", 0, 5 * time.Hour, 0, http.StatusOK},
+	}
+
+	for _, tc := range tests {
+		cfg.CheckInterval = tc.interval
+		s.started = time.Now().Add(-tc.started)
+		lastCycleAt.Store(0)
+		if tc.lastCycle > 0 {
+			lastCycleAt.Store(time.Now().Add(-tc.lastCycle).UnixNano())
+		}
+
+		rec := httptest.NewRecorder()
+		s.healthz(rec, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+		if rec.Code != tc.want {
+			t.Errorf("%s: /healthz returned %d, want %d (%s)", tc.name, rec.Code, tc.want, rec.Body.String())
+		}
+	}
+
+	// A reloaded config with a longer interval widens the window
+	lastCycleAt.Store(time.Now().Add(-3 * time.Hour).UnixNano())
+	s.started = time.Now().Add(-5 * time.Hour)
+	reloaded := config.New(log)
+	reloaded.CheckInterval = 2 * time.Hour
+	s.SetConfig(reloaded)
+	rec := httptest.NewRecorder()
+	s.healthz(rec, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("Expected 200 within twice the reloaded interval, got %d", rec.Code)
+	}
+	s.SetConfig(cfg)
+
+	// Completing a cycle makes a stuck instance healthy again
+	cfg.CheckInterval = time.Hour
+	s.started = time.Now().Add(-3 * time.Hour)
+	CycleCompleted()
+	rec = httptest.NewRecorder()
+	s.healthz(rec, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("Expected 200 after a completed cycle, got %d", rec.Code)
+	}
+}
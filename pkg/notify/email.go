@@ -0,0 +1,208 @@
+package notify
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"html/template"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/textproto"
+	"strings"
+	"time"
+)
+
+// emailContent holds the parts of an email before MIME encoding
+type emailContent struct {
+	Subject string
+	Text    string
+	HTML    string
+}
+
+// queuedMessage is a rendered notification waiting for the digest or the end of the quiet hours
+type queuedMessage struct {
+	Notification
+	Message string
+
+	// Channels still to deliver through, all routed channels if empty
+	Channels []string `json:",omitempty"`
+}
+
+// eventHTML lays out a single notification with the domain and days left emphasized
+var eventHTML = template.Must(template.New("event").Parse(`<!DOCTYPE html>
+<html>
+<body style="font-family: Arial, Helvetica, sans-serif; color: #222222;">
+<p style="font-size: 16px;"><strong>{{.Domain}}</strong></p>
+<p>{{.Message}}</p>
+{{- if eq .Event "expiring"}}
+<p>Days left: <span style="background-color: #fff3cd; color: #b45309; font-weight: bold; padding: 2px 6px;">{{.DaysLeft}}</span></p>
+{{- end}}
+{{- if not .Expiration.IsZero}}
+<p>{{if eq .Event "expired"}}Expired{{else if eq .Event "available"}}Registered until{{else}}Expires{{end}}{{if .CertBased}} (TLS certificate){{end}}: {{.Expiration.Format "2006-01-02"}}</p>
+{{- end}}
+</body>
+</html>
+`))
+
+// digestHTML lays out all notifications of a run as a list
+var digestHTML = template.Must(template.New("digest").Parse(`<!DOCTYPE html>
+<html>
+<body style="font-family: Arial, Helvetica, sans-serif; color: #222222;">
+<p style="font-size: 16px;">{{len .}} domain notifications</p>
+<ul>
+{{- range .}}
+<li><strong>{{.Domain}}</strong>: {{.Message}}
+{{- if eq .Event "expiring"}} <span style="background-color: #fff3cd; color: #b45309; font-weight: bold; padding: 0 4px;">{{.DaysLeft}} days</span>{{end}}</li>
+{{- end}}
+</ul>
+</body>
+</html>
+`))
+
+// eventSubject returns a short subject line for a notification
+func eventSubject(ev Notification) string {
+	switch ev.Event {
+	case EventAvailable:
+		return "Domain available: " + ev.Domain
+	case EventExpiring, EventExpired:
+		if ev.CertBased {
+			return "Certificate " + ev.Event + ": " + ev.Domain
+		}
+		return "Domain " + ev.Event + ": " + ev.Domain
+	case EventStatus:
+		return "Domain status change: " + ev.Domain
+	case EventUnknownExpiry:
+		return "Domain expiry unknown: " + ev.Domain
+	case EventTest:
+		return "Domain checker: test notification"
+	default:
+		return "Domain checker: " + ev.Domain
+	}
+}
+
+// eventEmail builds the email content for a single notification
+// A custom body is sent as plain text only, since the HTML layout shows the message instead
+func (n *Notifier) eventEmail(ev Notification, message string) emailContent {
+	data := queuedMessage{Notification: ev, Message: message}
+	content := emailContent{
+		Subject: eventSubject(ev),
+		Text:    message,
+	}
+
+	if n.subjectTmpl != nil {
+		var subject bytes.Buffer
+		if err := n.subjectTmpl.Execute(&subject, data); err != nil {
+			n.log.Warnf("Failed to render email subject for %s, using the default: %v", ev.Domain, err)
+		} else {
+			// Headers are a single line
+			content.Subject = strings.Join(strings.Fields(subject.String()), " ")
+		}
+	}
+
+	if n.bodyTmpl != nil {
+		var body bytes.Buffer
+		if err := n.bodyTmpl.Execute(&body, data); err != nil {
+			n.log.Warnf("Failed to render email body for %s, using the default: %v", ev.Domain, err)
+		} else {
+			content.Text = body.String()
+			return content
+		}
+	}
+
+	var html bytes.Buffer
+	if err := eventHTML.Execute(&html, data); err == nil {
+		content.HTML = html.String()
+	}
+
+	return content
+}
+
+// digestEmail builds the email content for a batch of notifications
+func digestEmail(messages []queuedMessage) emailContent {
+	var text strings.Builder
+	for _, m := range messages {
+		text.WriteString("- " + m.Message + "\r\n")
+	}
+
+	content := emailContent{
+		Subject: fmt.Sprintf("Domain checker: %d notifications", len(messages)),
+		Text:    text.String(),
+	}
+
+	var html bytes.Buffer
+	if err := digestHTML.Execute(&html, messages); err == nil {
+		content.HTML = html.String()
+	}
+
+	return content
+}
+
+// buildEmail encodes the content as a multipart/alternative MIME message
+// The plain text part comes first so clients without HTML support fall back to it
+func (n *Notifier) buildEmail(to string, content emailContent) ([]byte, error) {
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+
+	parts := []struct {
+		contentType string
+		data        string
+	}{
+		{"text/plain; charset=utf-8", content.Text},
+		{"text/html; charset=utf-8", content.HTML},
+	}
+	for _, part := range parts {
+		if part.data == "" {
+			continue
+		}
+
+		w, err := mw.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {part.contentType},
+			"Content-Transfer-Encoding": {"quoted-printable"},
+		})
+		if err != nil {
+			return nil, err
+		}
+		qp := quotedprintable.NewWriter(w)
+		if _, err := qp.Write([]byte(part.data)); err != nil {
+			return nil, err
+		}
+		if err := qp.Close(); err != nil {
+			return nil, err
+		}
+	}
+	if err := mw.Close(); err != nil {
+		return nil, err
+	}
+
+	var msg bytes.Buffer
+	headers := [][2]string{
+		{"From", n.cfg.EmailFrom},
+		{"To", to},
+		{"Subject", mime.QEncoding.Encode("utf-8", content.Subject)},
+		{"Date", time.Now().Format(time.RFC1123Z)},
+		{"Message-ID", messageID(n.cfg.EmailFrom)},
+		{"MIME-Version", "1.0"},
+		{"Content-Type", "multipart/alternative; boundary=" + mw.Boundary()},
+	}
+	for _, h := range headers {
+		fmt.Fprintf(&msg, "%s: %s\r\n", h[0], h[1])
+	}
+	msg.WriteString("\r\n")
+	msg.Write(body.Bytes())
+
+	return msg.Bytes(), nil
+}
+
+// messageID generates a unique Message-ID using the sender's domain
+func messageID(from string) string {
+	host := "domain-checker"
+	if i := strings.LastIndex(from, "@"); i >= 0 && i < len(from)-1 {
+		host = strings.Trim(from[i+1:], "<> ")
+	}
+
+	b := make([]byte, 12)
+	_, _ = rand.Read(b)
+	return fmt.Sprintf("<%d.%s@%s>", time.Now().UnixNano(), hex.EncodeToString(b), host)
+}
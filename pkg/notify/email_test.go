@@ -0,0 +1,159 @@
+package notify
+
+import (
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/mail"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mallocator/domain-checker/pkg/config"
+	"github.com/mallocator/domain-checker/pkg/logger"
+)
+
+func TestBuildEmail(t *testing.T) {
+	log := logger.New()
+	cfg := config.New(log)
+	cfg.EmailFrom = "checker@example.org"
+	cfg.EmailTo = "alerts@example.org"
+	notifier := New(cfg, log)
+
+	ev := Notification{
+		Domain:     "example.com",
+		Event:      EventExpiring,
+		DaysLeft:   5,
+		Expiration: time.Date(2025, 5, 1, 0, 0, 0, 0, time.UTC),
+	}
+	raw, err := notifier.buildEmail(cfg.EmailTo, notifier.eventEmail(ev, "Domain example.com expires in 5 days"))
+	if err != nil {
+		t.Fatalf("buildEmail() returned error: %v", err)
+	}
+
+	msg, err := mail.ReadMessage(strings.NewReader(string(raw)))
+	if err != nil {
+		t.Fatalf("Failed to parse email: %v", err)
+	}
+
+	// Check headers
+	if got := msg.Header.Get("Subject"); got != "Domain expiring: example.com" {
+		t.Errorf("Subject = %q, want %q", got, "Domain expiring: example.com")
+	}
+	if _, err := msg.Header.Date(); err != nil {
+		t.Errorf("Date header invalid: %v", err)
+	}
+	if id := msg.Header.Get("Message-ID"); !strings.HasSuffix(id, "@example.org>") {
+		t.Errorf("Message-ID = %q, want sender domain", id)
+	}
+
+	mediaType, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	if err != nil {
+		t.Fatalf("Content-Type invalid: %v", err)
+	}
+	if mediaType != "multipart/alternative" {
+		t.Fatalf("Content-Type = %q, want multipart/alternative", mediaType)
+	}
+
+	// Check both alternative parts, plain text first
+	mr := multipart.NewReader(msg.Body, params["boundary"])
+	var types []string
+	var text, html string
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Failed to read part: %v", err)
+		}
+		data, err := io.ReadAll(part)
+		if err != nil {
+			t.Fatalf("Failed to read part body: %v", err)
+		}
+		ct := part.Header.Get("Content-Type")
+		types = append(types, ct)
+		if strings.HasPrefix(ct, "text/plain") {
+			text = string(data)
+		} else {
+			html = string(data)
+		}
+	}
+
+	if len(types) != 2 || !strings.HasPrefix(types[0], "text/plain") || !strings.HasPrefix(types[1], "text/html") {
+		t.Fatalf("Parts = %v, want text/plain then text/html", types)
+	}
+	if text != "Domain example.com expires in 5 days" {
+		t.Errorf("Plain text = %q", text)
+	}
+	if !strings.Contains(html, "<strong>example.com</strong>") {
+		t.Errorf("Expected bold domain in HTML, got %q", html)
+	}
+	if !strings.Contains(html, ">5</span>") {
+		t.Errorf("Expected highlighted days left in HTML, got %q", html)
+	}
+}
+
+func TestEventEmail_EscapesHTML(t *testing.T) {
+	log := logger.New()
+	notifier := New(config.New(log), log)
+	content := notifier.eventEmail(Notification{Domain: "<b>evil</b>.com"}, "<script>")
+	if strings.Contains(content.HTML, "<script>") || strings.Contains(content.HTML, "<b>evil") {
+		t.Errorf("Expected HTML to be escaped, got %q", content.HTML)
+	}
+}
+
+func TestEventEmail_Templates(t *testing.T) {
+	log := logger.New()
+	cfg := config.New(log)
+	cfg.EmailFrom = "checker@example.org"
+	cfg.EmailTo = "alerts@example.org"
+	cfg.NotifySubjectTemplate = "[domain-checker] {{.Domain}}\nexpires in {{.DaysLeft}} days"
+	cfg.NotifyBodyTemplate = "{{.Message}}\n\nExpires: {{.Expiration.Format \"2006-01-02\"}}\n"
+	notifier := New(cfg, log)
+
+	ev := Notification{
+		Domain:     "bücher.de",
+		Event:      EventExpiring,
+		DaysLeft:   5,
+		Expiration: time.Date(2025, 5, 1, 0, 0, 0, 0, time.UTC),
+	}
+	content := notifier.eventEmail(ev, "Domain bücher.de expires in 5 days")
+	if content.HTML != "" {
+		t.Errorf("Expected no HTML part with a custom body, got %q", content.HTML)
+	}
+	if want := "Domain bücher.de expires in 5 days\n\nExpires: 2025-05-01\n"; content.Text != want {
+		t.Errorf("Text = %q, want %q", content.Text, want)
+	}
+
+	raw, err := notifier.buildEmail(cfg.EmailTo, content)
+	if err != nil {
+		t.Fatalf("buildEmail() returned error: %v", err)
+	}
+	msg, err := mail.ReadMessage(strings.NewReader(string(raw)))
+	if err != nil {
+		t.Fatalf("Failed to parse email: %v", err)
+	}
+
+	// The subject is folded onto one line and encoded for the non-ASCII domain
+	encoded := msg.Header.Get("Subject")
+	if !strings.HasPrefix(encoded, "=?utf-8?q?") {
+		t.Errorf("Expected an RFC 2047 encoded subject, got %q", encoded)
+	}
+	subject, err := new(mime.WordDecoder).DecodeHeader(encoded)
+	if err != nil {
+		t.Fatalf("Failed to decode subject %q: %v", encoded, err)
+	}
+	if want := "[domain-checker] bücher.de expires in 5 days"; subject != want {
+		t.Errorf("Subject = %q, want %q", subject, want)
+	}
+
+	// A template failing to render falls back to the defaults
+	cfg.NotifySubjectTemplate = "{{.Missing}}"
+	cfg.NotifyBodyTemplate = "{{.Missing}}"
+	notifier = New(cfg, log)
+	content = notifier.eventEmail(ev, "Domain bücher.de expires in 5 days")
+	if content.Subject != "Domain expiring: bücher.de" || content.Text != "Domain bücher.de expires in 5 days" || content.HTML == "" {
+		t.Errorf("Expected the default email, got %+v", content)
+	}
+}
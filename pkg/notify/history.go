@@ -0,0 +1,107 @@
+package notify
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// historyFile is the notification history in the state directory, one JSON object per line
+// It doesn't end in .json, so it's never mistaken for a state file
+const historyFile = "notifications.jsonl"
+
+// maxHistorySize is the size at which the history is rotated; only the previous file is kept
+const maxHistorySize = 1 << 20
+
+// HistoryEntry is a notification delivery recorded in the history
+type HistoryEntry struct {
+	Time    time.Time `json:"time"`
+	Domain  string    `json:"domain"`
+	Channel string    `json:"channel"` // email, webhook or telegram
+	Event   string    `json:"event"`
+	Success bool      `json:"success"`
+	Error   string    `json:"error,omitempty"`
+}
+
+// historyPath returns the path of the current history file
+func (n *Notifier) historyPath() string {
+	return filepath.Join(n.cfg.StateDir, historyFile)
+}
+
+// record appends a delivery through a channel to the history, if NotifyHistory is enabled
+// Failing to write the history is logged but doesn't fail the notification
+func (n *Notifier) record(ev Notification, channel string, sendErr error) {
+	if !n.cfg.NotifyHistory {
+		return
+	}
+
+	entry := HistoryEntry{Time: time.Now(), Domain: ev.Domain, Channel: channel, Event: ev.Event, Success: sendErr == nil}
+	if sendErr != nil {
+		entry.Error = sendErr.Error()
+	}
+	data, err := json.Marshal(entry)
+	if err != nil {
+		n.log.Errorf("Marshal notification history error for %s: %v", ev.Domain, err)
+		return
+	}
+	data = append(data, '\n')
+
+	n.historyMu.Lock()
+	defer n.historyMu.Unlock()
+
+	path := n.historyPath()
+	if info, err := os.Stat(path); err == nil && info.Size()+int64(len(data)) > maxHistorySize {
+		if err := os.Rename(path, path+".1"); err != nil {
+			n.log.Warnf("Failed to rotate notification history: %v", err)
+		}
+	}
+
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		n.log.Warnf("Failed to open notification history: %v", err)
+		return
+	}
+	defer func() {
+		if err := f.Close(); err != nil {
+			n.log.Warnf("Failed to close notification history: %v", err)
+		}
+	}()
+	if _, err := f.Write(data); err != nil {
+		n.log.Warnf("Failed to write notification history: %v", err)
+	}
+}
+
+// History returns the recorded deliveries, oldest first, including those in the last rotated file
+func (n *Notifier) History() ([]HistoryEntry, error) {
+	n.historyMu.Lock()
+	defer n.historyMu.Unlock()
+
+	var entries []HistoryEntry
+	for _, path := range []string{n.historyPath() + ".1", n.historyPath()} {
+		data, err := os.ReadFile(path)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		for _, line := range bytes.Split(data, []byte("\n")) {
+			if len(bytes.TrimSpace(line)) == 0 {
+				continue
+			}
+			var entry HistoryEntry
+			if err := json.Unmarshal(line, &entry); err != nil {
+				// e.g. a line cut short when the process was killed while writing it
+				n.log.Warnf("Skipping invalid notification history entry in %s: %v", path, err)
+				continue
+			}
+			entries = append(entries, entry)
+		}
+	}
+	return entries, nil
+}
@@ -0,0 +1,114 @@
+package notify
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mallocator/domain-checker/pkg/config"
+	"github.com/mallocator/domain-checker/pkg/logger"
+)
+
+func TestHistory_Records(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	log := logger.New()
+	cfg := config.New(log)
+	cfg.StateDir = t.TempDir()
+	cfg.NotifyHistory = true
+	cfg.SMTPHost = "smtp.example.com"
+	cfg.EmailFrom = "from@example.com"
+	cfg.EmailTo = "to@example.com"
+	cfg.WebhookURL = server.URL
+	cfg.Retries = 1
+
+	notifier := New(cfg, log)
+	notifier.sender = &mockSender{}
+
+	// The email got out, so the failed webhook is kept for the next Flush rather than failing the notification
+	if err := notifier.Notify(Notification{Domain: "example.com", Event: EventAvailable}); err != nil {
+		t.Errorf("Notify() returned error: %v", err)
+	}
+
+	history, err := notifier.History()
+	if err != nil {
+		t.Fatalf("History() returned error: %v", err)
+	}
+	if len(history) != 2 {
+		t.Fatalf("Expected 2 history entries, got %d: %+v", len(history), history)
+	}
+
+	email, webhook := history[0], history[1]
+	if email.Domain != "example.com" || email.Channel != "email" || email.Event != EventAvailable || !email.Success || email.Error != "" {
+		t.Errorf("Unexpected email entry: %+v", email)
+	}
+	if webhook.Channel != "webhook" || webhook.Success || !strings.Contains(webhook.Error, "500") {
+		t.Errorf("Unexpected webhook entry: %+v", webhook)
+	}
+	if email.Time.IsZero() {
+		t.Errorf("Expected the entry time to be set")
+	}
+}
+
+func TestHistory_Disabled(t *testing.T) {
+	log := logger.New()
+	cfg := config.New(log)
+	cfg.StateDir = t.TempDir()
+	cfg.SMTPHost = "smtp.example.com"
+	cfg.EmailFrom = "from@example.com"
+	cfg.EmailTo = "to@example.com"
+
+	notifier := New(cfg, log)
+	notifier.sender = &mockSender{}
+
+	if err := notifier.Notify(Notification{Domain: "example.com", Event: EventAvailable}); err != nil {
+		t.Fatalf("Notify() returned error: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(cfg.StateDir, historyFile)); !os.IsNotExist(err) {
+		t.Errorf("Expected no history file without NotifyHistory, got %v", err)
+	}
+
+	history, err := notifier.History()
+	if err != nil || len(history) != 0 {
+		t.Errorf("History() = %v, %v; want no entries", history, err)
+	}
+}
+
+func TestHistory_Rotation(t *testing.T) {
+	log := logger.New()
+	cfg := config.New(log)
+	cfg.StateDir = t.TempDir()
+	cfg.NotifyHistory = true
+
+	// A full history file with one entry and a line cut short
+	path := filepath.Join(cfg.StateDir, historyFile)
+	old := `{"time":"2024-01-01T00:00:00Z","domain":"old.com","channel":"email","event":"expiring","success":true}` + "\n"
+	padding := strings.Repeat("x", maxHistorySize-len(old))
+	if err := os.WriteFile(path, []byte(old+padding), 0644); err != nil {
+		t.Fatalf("Failed to write history: %v", err)
+	}
+
+	notifier := New(cfg, log)
+	notifier.record(Notification{Domain: "new.com", Event: EventExpired}, "telegram", nil)
+
+	if _, err := os.Stat(path + ".1"); err != nil {
+		t.Errorf("Expected the full history to be rotated: %v", err)
+	}
+	if info, err := os.Stat(path); err != nil || info.Size() >= maxHistorySize {
+		t.Errorf("Expected a new small history file, got %v, %v", info, err)
+	}
+
+	history, err := notifier.History()
+	if err != nil {
+		t.Fatalf("History() returned error: %v", err)
+	}
+	if len(history) != 2 || history[0].Domain != "old.com" || history[1].Domain != "new.com" {
+		t.Errorf("Expected old.com then new.com, got %+v", history)
+	}
+}
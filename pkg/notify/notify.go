@@ -2,59 +2,543 @@
 package notify
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"net"
+	"net/http"
 	"net/smtp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
 
+	"github.com/mallocator/domain-checker/pkg/backoff"
 	"github.com/mallocator/domain-checker/pkg/config"
 	"github.com/mallocator/domain-checker/pkg/logger"
+	"github.com/mallocator/domain-checker/pkg/metrics"
 )
 
 // Notifier handles notification operations
 type Notifier struct {
-	cfg *config.Config
-	log *logger.Logger
+	cfg  *config.Config
+	log  *logger.Logger
+	tmpl *template.Template // custom message template, nil for the default messages
+
+	// Custom email subject and body templates, nil for the defaults
+	subjectTmpl *template.Template
+	bodyTmpl    *template.Template
+
+	// Delivers emails, replaceable in tests
+	sender sender
+
+	// Telegram Bot API base URL, replaceable in tests
+	telegramAPI string
+
+	// Transport for the webhook and Telegram requests, nil for http.DefaultTransport
+	transport http.RoundTripper
+
+	// Messages waiting for the digest email
+	mu      sync.Mutex
+	pending []queuedMessage
+
+	// Serializes writes to the notification history
+	historyMu sync.Mutex
+
+	// Serializes access to the notifications held during quiet hours
+	heldMu sync.Mutex
+
+	// Current time for quiet hours, replaceable in tests
+	now func() time.Time
+
+	// Cancels waits between webhook retries and the requests themselves, see SetContext
+	ctx context.Context
+
+	// Jitter for the backoff between webhook retries
+	jitter *backoff.Jitter
 }
 
 // New creates a new notifier
 func New(cfg *config.Config, log *logger.Logger) *Notifier {
-	return &Notifier{
-		cfg: cfg,
-		log: log,
+	n := &Notifier{
+		cfg:         cfg,
+		log:         log,
+		sender:      &smtpSender{cfg: cfg, log: log},
+		telegramAPI: "https://api.telegram.org",
+		transport:   newTransport(cfg, log),
+		now:         time.Now,
+		ctx:         context.Background(),
+		jitter:      backoff.New(),
+	}
+
+	n.tmpl = parseTemplate(log, "notify", cfg.NotifyTemplate)
+	n.subjectTmpl = parseTemplate(log, "subject", cfg.NotifySubjectTemplate)
+	n.bodyTmpl = parseTemplate(log, "body", cfg.NotifyBodyTemplate)
+
+	return n
+}
+
+// SetContext makes webhook deliveries give up, without further retries, once ctx is done
+func (n *Notifier) SetContext(ctx context.Context) {
+	n.ctx = ctx
+}
+
+// parseTemplate parses a custom template, returning nil if there's none or it's invalid
+func parseTemplate(log *logger.Logger, name, text string) *template.Template {
+	if text == "" {
+		return nil
+	}
+	tmpl, err := template.New(name).Parse(text)
+	if err != nil {
+		log.Warnf("Invalid %s template, using the default: %v", name, err)
+		return nil
+	}
+	return tmpl
+}
+
+// newTransport returns a transport sending requests through the configured proxy, or nil without one
+func newTransport(cfg *config.Config, log *logger.Logger) http.RoundTripper {
+	u, err := cfg.ProxyURL()
+	if err != nil || u == nil {
+		return nil // an invalid proxy is rejected by Validate
 	}
+	log.Debugf("Sending notifications through proxy %s", u.Redacted())
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = http.ProxyURL(u)
+	return transport
+}
+
+// httpClient returns the client for the webhook and Telegram requests
+func (n *Notifier) httpClient() *http.Client {
+	return &http.Client{Timeout: n.cfg.Timeout, Transport: n.transport}
+}
+
+// Event types passed to message templates
+const (
+	EventAvailable     = "available"      // domain can be registered
+	EventExpiring      = "expiring"       // domain expires within the threshold
+	EventExpired       = "expired"        // expiration date has passed but the domain is still registered
+	EventStatus        = "status"         // domain entered a deletion status
+	EventTest          = "test"           // test message sent with -test-notify
+	EventUnknownExpiry = "unknown_expiry" // domain is registered but WHOIS has no usable expiration date
+)
+
+// Notification describes an event worth notifying about
+type Notification struct {
+	Domain     string
+	Event      string
+	DaysLeft   int
+	Expiration time.Time // for available, the last one known while the domain was registered, if any
+	Status     string
+
+	// Renewal risk hints from WHOIS, nil when the WHOIS data doesn't tell (expiring and expired)
+	TransferLocked *bool
+	AutoRenew      *bool
+
+	// Expiration is the site's TLS certificate's, since WHOIS has no registration expiry (expiring and expired)
+	CertBased bool
 }
 
-// Send sends an email notification or logs if SMTP is not configured
+// Message renders the notification text from the configured template or the default wording
+func (n *Notifier) Message(ev Notification) (string, error) {
+	if n.tmpl != nil {
+		var buf bytes.Buffer
+		if err := n.tmpl.Execute(&buf, ev); err != nil {
+			return "", fmt.Errorf("render template: %w", err)
+		}
+		return buf.String(), nil
+	}
+
+	switch ev.Event {
+	case EventAvailable:
+		if !ev.Expiration.IsZero() {
+			return fmt.Sprintf("Domain %s is now available again! It was registered until %s", ev.Domain,
+				ev.Expiration.Format(time.DateOnly)), nil
+		}
+		return fmt.Sprintf("Domain %s is now available!", ev.Domain), nil
+	case EventExpiring:
+		return fmt.Sprintf("%s expires in %d days", subject(ev), ev.DaysLeft) + n.renewalInfo(ev), nil
+	case EventExpired:
+		msg := fmt.Sprintf("%s expired %d days ago", subject(ev), -ev.DaysLeft)
+		if ev.DaysLeft == 0 {
+			msg = fmt.Sprintf("%s expired today", subject(ev))
+		}
+		if ev.Status != "" {
+			msg += " and is in " + ev.Status
+		}
+		return msg + n.renewalInfo(ev), nil
+	case EventStatus:
+		return fmt.Sprintf("Domain %s is in %s and may become available soon", ev.Domain, ev.Status), nil
+	case EventUnknownExpiry:
+		return fmt.Sprintf("Domain %s is monitored, but its expiration date can't be determined from WHOIS", ev.Domain), nil
+	default:
+		return fmt.Sprintf("Domain %s: %s", ev.Domain, ev.Event), nil
+	}
+}
+
+// subject names what expires in the default message, the domain or, when CertBased, its TLS certificate
+func subject(ev Notification) string {
+	if ev.CertBased {
+		return fmt.Sprintf("TLS certificate of %s (registration expiry unknown)", ev.Domain)
+	}
+	return "Domain " + ev.Domain
+}
+
+// renewalInfo returns the renewal hints known for an event to append to the default message,
+// e.g. " (auto-renew on, transfer locked)", or "" without NotifyRenewalInfo or any hints
+func (n *Notifier) renewalInfo(ev Notification) string {
+	if !n.cfg.NotifyRenewalInfo {
+		return ""
+	}
+	var hints []string
+	switch {
+	case ev.AutoRenew == nil:
+	case *ev.AutoRenew:
+		hints = append(hints, "auto-renew on")
+	default:
+		hints = append(hints, "auto-renew off")
+	}
+	switch {
+	case ev.TransferLocked == nil:
+	case *ev.TransferLocked:
+		hints = append(hints, "transfer locked")
+	default:
+		hints = append(hints, "transfer unlocked")
+	}
+	if len(hints) == 0 {
+		return ""
+	}
+	return " (" + strings.Join(hints, ", ") + ")"
+}
+
+// Notify renders the message for an event and sends it
+// During QuietHours the message is held instead and sent by the first Flush after them
+func (n *Notifier) Notify(ev Notification) error {
+	message, err := n.Message(ev)
+	if err != nil {
+		return err
+	}
+	if n.holds(ev) {
+		return n.hold(ev, message)
+	}
+	return n.deliver(ev, message)
+}
+
+// Send delivers a notification through every configured channel
 // It takes the domain name and message to send
-func (n *Notifier) Send(domain, message string) {
-	n.log.Infof("Notification for %s: %s", domain, message)
+// A failing channel doesn't prevent the others from being tried; all failures are returned together
+// In digest mode the email is queued until Flush is called
+func (n *Notifier) Send(domain, message string) error {
+	return n.deliver(Notification{Domain: domain}, message)
+}
+
+// deliver sends a rendered message for an event through every configured channel the domain is routed to
+// When only some channels fail, the message is held for those and retried by the next Flush, so the channels
+// that got it don't get it again; the error is only returned when no channel got it
+func (n *Notifier) deliver(ev Notification, message string) error {
+	failed, err := n.deliverVia(ev, message, nil)
+	if err == nil || len(failed) == len(n.channels(ev.Domain)) {
+		return err
+	}
+
+	n.heldMu.Lock()
+	keepErr := n.appendHeld([]queuedMessage{{Notification: ev, Message: message, Channels: failed}})
+	n.heldMu.Unlock()
+	if keepErr != nil {
+		return errors.Join(err, fmt.Errorf("keep failed notification: %w", keepErr))
+	}
+	n.log.Warnf("Keeping notification for %s to retry via %s: %v", ev.Domain, strings.Join(failed, ", "), err)
+	return nil
+}
+
+// channels returns the configured channels notifications about a domain are routed to
+func (n *Notifier) channels(domain string) []string {
+	var channels []string
+	if n.cfg.Notifies(domain, config.ChannelEmail) && n.emailConfigured(n.cfg.EmailToFor(domain)) {
+		channels = append(channels, config.ChannelEmail)
+	}
+	if n.cfg.Notifies(domain, config.ChannelWebhook) && n.cfg.WebhookURL != "" {
+		channels = append(channels, config.ChannelWebhook)
+	}
+	if n.cfg.Notifies(domain, config.ChannelTelegram) && n.cfg.TelegramBotToken != "" && n.cfg.TelegramChatID != "" {
+		channels = append(channels, config.ChannelTelegram)
+	}
+	return channels
+}
+
+// deliverVia is deliver limited to the given channels, or all routed channels if none are given
+// It returns the channels that failed, so only those are tried again
+func (n *Notifier) deliverVia(ev Notification, message string, only []string) ([]string, error) {
+	n.log.Infof("Notification for %s: %s", ev.Domain, message)
+
+	sends := func(channel string) bool {
+		return n.cfg.Notifies(ev.Domain, channel) && (len(only) == 0 || slices.Contains(only, channel))
+	}
+
+	var emailErr, webhookErr, telegramErr error
+	switch {
+	case !sends(config.ChannelEmail):
+	case n.cfg.NotifyDigest:
+		n.queue(ev, message)
+	default:
+		to := n.cfg.EmailToFor(ev.Domain)
+		emailErr = n.sendEmail(ev.Domain, to, n.eventEmail(ev, message))
+		if n.emailConfigured(to) {
+			n.record(ev, "email", emailErr)
+		}
+	}
 
+	if sends(config.ChannelWebhook) {
+		webhookErr = n.sendWebhook(ev.Domain, message)
+		if n.cfg.WebhookURL != "" {
+			n.record(ev, "webhook", webhookErr)
+		}
+	}
+	if sends(config.ChannelTelegram) {
+		telegramErr = n.sendTelegram(ev.Domain, message)
+		if n.cfg.TelegramBotToken != "" && n.cfg.TelegramChatID != "" {
+			n.record(ev, "telegram", telegramErr)
+		}
+	}
+
+	var failed []string
+	if emailErr != nil {
+		failed = append(failed, config.ChannelEmail)
+	}
+	if webhookErr != nil {
+		failed = append(failed, config.ChannelWebhook)
+	}
+	if telegramErr != nil {
+		failed = append(failed, config.ChannelTelegram)
+	}
+	return failed, errors.Join(emailErr, webhookErr, telegramErr)
+}
+
+// queue adds a message to the pending digest
+func (n *Notifier) queue(ev Notification, message string) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.pending = append(n.pending, queuedMessage{Notification: ev, Message: message})
+}
+
+// Flush sends the notifications held during quiet hours once they're over,
+// and all queued messages as a digest email, one per recipient
+// It does nothing when nothing was held or queued
+// Messages of a digest that fails to send are held and retried by the next Flush
+func (n *Notifier) Flush() error {
+	// Released messages are queued for the digest in digest mode, so release them first
+	errs := []error{n.releaseHeld()}
+
+	n.mu.Lock()
+	messages := n.pending
+	n.pending = nil
+	n.mu.Unlock()
+
+	// Group by recipient, keeping the order in which recipients first appeared
+	var recipients []string
+	byRecipient := make(map[string][]queuedMessage)
+	for _, m := range messages {
+		to := n.cfg.EmailToFor(m.Domain)
+		if _, ok := byRecipient[to]; !ok {
+			recipients = append(recipients, to)
+		}
+		byRecipient[to] = append(byRecipient[to], m)
+	}
+
+	var failed []queuedMessage
+	for _, to := range recipients {
+		err := n.sendEmail("digest", to, digestEmail(byRecipient[to]))
+		if n.emailConfigured(to) {
+			for _, m := range byRecipient[to] {
+				n.record(m.Notification, "email", err)
+			}
+		}
+		if err != nil {
+			for _, m := range byRecipient[to] {
+				m.Channels = []string{config.ChannelEmail}
+				failed = append(failed, m)
+			}
+		}
+		errs = append(errs, err)
+	}
+
+	// The checks already count these as sent, so keep them with the held notifications for the next Flush
+	if len(failed) > 0 {
+		n.heldMu.Lock()
+		err := n.appendHeld(failed)
+		n.heldMu.Unlock()
+		if err != nil {
+			errs = append(errs, fmt.Errorf("keep failed digest: %w", err))
+		} else {
+			n.log.Warnf("Keeping %d notifications of the failed digest to retry", len(failed))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// ChannelResult is the outcome of sending a test message through one channel
+type ChannelResult struct {
+	Channel string // email, webhook or telegram
+	Err     error
+}
+
+// Test sends a test message through every configured channel right away, even in digest mode
+// Returns one result per configured channel, so none if no channel is configured
+func (n *Notifier) Test() []ChannelResult {
+	ev := Notification{Domain: "domain-checker", Event: EventTest}
+	message := "This is a test notification from domain-checker"
+
+	var results []ChannelResult
+	if n.cfg.SMTPHost != "" {
+		var err error
+		if n.cfg.EmailFrom == "" || n.cfg.EmailTo == "" {
+			err = errors.New("email: email_from and email_to are required")
+		} else {
+			err = n.sendEmail(ev.Domain, n.cfg.EmailTo, n.eventEmail(ev, message))
+		}
+		results = append(results, ChannelResult{Channel: "email", Err: err})
+	}
+	if n.cfg.WebhookURL != "" {
+		results = append(results, ChannelResult{Channel: "webhook", Err: n.sendWebhook(ev.Domain, message)})
+	}
+	if n.cfg.TelegramBotToken != "" || n.cfg.TelegramChatID != "" {
+		var err error
+		if n.cfg.TelegramBotToken == "" || n.cfg.TelegramChatID == "" {
+			err = errors.New("telegram: telegram_bot_token and telegram_chat_id are required")
+		} else {
+			err = n.sendTelegram(ev.Domain, message)
+		}
+		results = append(results, ChannelResult{Channel: "telegram", Err: err})
+	}
+	return results
+}
+
+// emailConfigured reports whether emails can be sent to the given recipient
+func (n *Notifier) emailConfigured(to string) bool {
+	return n.cfg.SMTPHost != "" && n.cfg.EmailFrom != "" && to != ""
+}
+
+// sendEmail sends an email notification to the given recipient or logs if SMTP is not configured
+func (n *Notifier) sendEmail(domain, to string, content emailContent) error {
 	// Check if SMTP is configured
-	if n.cfg.SMTPHost == "" || n.cfg.EmailFrom == "" || n.cfg.EmailTo == "" {
+	if !n.emailConfigured(to) {
 		n.log.Infof("SMTP not configured, skipping email send")
-		return
+		return nil
 	}
 
-	// Prepare email
-	auth := smtp.PlainAuth("", n.cfg.SMTPUser, n.cfg.SMTPPass, n.cfg.SMTPHost)
-
-	// Format email with headers and body
-	msg := []byte(fmt.Sprintf(
-		"From: %s\r\n"+
-			"To: %s\r\n"+
-			"Subject: %s\r\n"+
-			"\r\n"+
-			"%s\r\n",
-		n.cfg.EmailFrom,
-		n.cfg.EmailTo,
-		message,
-		message,
-	))
+	// Format email with headers and alternative text/HTML bodies
+	msg, err := n.buildEmail(to, content)
+	if err != nil {
+		return fmt.Errorf("email: %w", err)
+	}
 
 	// Send email
-	addr := fmt.Sprintf("%s:%d", n.cfg.SMTPHost, n.cfg.SMTPPort)
-	if err := smtp.SendMail(addr, auth, n.cfg.EmailFrom, []string{n.cfg.EmailTo}, msg); err != nil {
-		n.log.Errorf("Failed to send mail for %s: %v", domain, err)
-	} else {
-		n.log.Infof("Email notification sent successfully for %s", domain)
+	addr := net.JoinHostPort(n.cfg.SMTPHost, strconv.Itoa(n.cfg.SMTPPort))
+	var auth smtp.Auth
+	if n.cfg.SMTPUser != "" {
+		auth = smtp.PlainAuth("", n.cfg.SMTPUser, n.cfg.SMTPPass, n.cfg.SMTPHost)
 	}
-}
\ No newline at end of file
+	if err := n.sender.send(addr, auth, n.cfg.EmailFrom, []string{to}, msg); err != nil {
+		return fmt.Errorf("email: %w", err)
+	}
+
+	n.log.Infof("Email notification sent successfully for %s", domain)
+	metrics.NotificationSent("email")
+	return nil
+}
+
+// webhookPayload is the JSON body posted to the webhook
+type webhookPayload struct {
+	Domain    string    `json:"domain"`
+	Message   string    `json:"message"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// sendWebhook posts the notification as JSON to the configured webhook
+// Network errors and 5xx or 429 responses are retried with exponential backoff up to MaxBackoff,
+// other responses won't change on a retry
+func (n *Notifier) sendWebhook(domain, message string) error {
+	if n.cfg.WebhookURL == "" {
+		return nil
+	}
+
+	body, err := json.Marshal(webhookPayload{Domain: domain, Message: message, Timestamp: time.Now()})
+	if err != nil {
+		return fmt.Errorf("webhook: %w", err)
+	}
+
+	attempts := max(n.cfg.Retries, 1)
+	for i := 0; i < attempts; i++ {
+		if err = n.postWebhook(body); err == nil {
+			n.log.Infof("Webhook notification sent successfully for %s", domain)
+			metrics.NotificationSent("webhook")
+			return nil
+		}
+		if !retryable(err) || n.ctx.Err() != nil {
+			break
+		}
+
+		n.log.Debugf("Webhook retry %d for %s: %v", i+1, domain, err)
+
+		// No point in waiting after the last attempt
+		if i < attempts-1 {
+			if err := backoff.Sleep(n.ctx, n.jitter.Delay(i, n.cfg.Backoff, n.cfg.MaxBackoff)); err != nil {
+				return fmt.Errorf("webhook: %w", err)
+			}
+		}
+	}
+
+	return fmt.Errorf("webhook: %w", err)
+}
+
+// statusError is returned for a response with an unexpected HTTP status
+type statusError struct {
+	status string
+	code   int
+}
+
+func (e *statusError) Error() string {
+	return "unexpected status " + e.status
+}
+
+// retryable reports whether a failed webhook delivery may succeed when tried again
+// Only server errors and rate limiting are; any other status is an answer that won't change
+func retryable(err error) bool {
+	var se *statusError
+	if !errors.As(err, &se) {
+		return true // network error
+	}
+	return se.code >= 500 || se.code == http.StatusTooManyRequests
+}
+
+// postWebhook makes a single webhook delivery attempt
+func (n *Notifier) postWebhook(body []byte) error {
+	req, err := http.NewRequestWithContext(n.ctx, http.MethodPost, n.cfg.WebhookURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for key, value := range n.cfg.WebhookHeaders {
+		req.Header.Set(key, value)
+	}
+
+	resp, err := n.httpClient().Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := resp.Body.Close(); err != nil {
+			n.log.Warnf("Failed to close webhook response: %v", err)
+		}
+	}()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return &statusError{status: resp.Status, code: resp.StatusCode}
+	}
+
+	return nil
+}
@@ -1,7 +1,22 @@
 package notify
 
 import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/mallocator/domain-checker/pkg/config"
 	"github.com/mallocator/domain-checker/pkg/logger"
@@ -46,11 +61,29 @@ func TestSend_NoSMTPConfig(t *testing.T) {
 	domain := "example.com"
 	message := "Test message"
 
-	// This should not panic and just log a message
-	notifier.Send(domain, message)
+	// Nothing is configured, so there's nothing that can fail
+	if err := notifier.Send(domain, message); err != nil {
+		t.Errorf("Send() returned error without any channel configured: %v", err)
+	}
+}
 
-	// We can't easily verify the log output in this test framework
-	// In a real test, we would capture stdout/stderr or use a mock logger
+// mockSender records the emails handed to it instead of delivering them
+type mockSender struct {
+	addr string
+	auth smtp.Auth
+	from string
+	to   []string
+	msgs []string
+	err  error
+}
+
+func (m *mockSender) send(addr string, auth smtp.Auth, from string, to []string, msg []byte) error {
+	m.addr = addr
+	m.auth = auth
+	m.from = from
+	m.to = to
+	m.msgs = append(m.msgs, string(msg))
+	return m.err
 }
 
 func TestSend_WithSMTPConfig(t *testing.T) {
@@ -67,16 +100,816 @@ func TestSend_WithSMTPConfig(t *testing.T) {
 		EmailTo:   "to@example.com",
 	}
 
-	// Create a notifier
+	// Create a notifier that records instead of sending
 	notifier := New(cfg, log)
+	mock := &mockSender{}
+	notifier.sender = mock
 
 	// Call the Send function
 	domain := "example.com"
 	message := "Test message"
 
-	// This should not panic, but will fail to send email with fake settings
-	notifier.Send(domain, message)
+	if err := notifier.Send(domain, message); err != nil {
+		t.Fatalf("Send() returned error: %v", err)
+	}
+
+	// Verify what would have been sent
+	if mock.addr != "smtp.example.com:25" {
+		t.Errorf("Expected addr smtp.example.com:25, got %q", mock.addr)
+	}
+	if mock.auth == nil {
+		t.Errorf("Expected SMTP auth to be set")
+	}
+	if mock.from != "from@example.com" {
+		t.Errorf("Expected from from@example.com, got %q", mock.from)
+	}
+	if len(mock.to) != 1 || mock.to[0] != "to@example.com" {
+		t.Errorf("Expected recipients [to@example.com], got %v", mock.to)
+	}
+	if len(mock.msgs) != 1 {
+		t.Fatalf("Expected 1 message, got %d", len(mock.msgs))
+	}
+	for _, want := range []string{"From: from@example.com\r\n", "To: to@example.com\r\n", "Subject: Domain checker: example.com\r\n", message} {
+		if !strings.Contains(mock.msgs[0], want) {
+			t.Errorf("Expected message to contain %q, got %q", want, mock.msgs[0])
+		}
+	}
+}
+
+func TestSend_SMTPFailure(t *testing.T) {
+	log := logger.New()
+	cfg := &config.Config{
+		SMTPHost:  "smtp.example.com",
+		SMTPPort:  25,
+		EmailFrom: "from@example.com",
+		EmailTo:   "to@example.com",
+	}
+
+	notifier := New(cfg, log)
+	notifier.sender = &mockSender{err: fmt.Errorf("connection refused")}
+
+	if err := notifier.Send("example.com", "Test message"); err == nil {
+		t.Errorf("Send() returned nil error for a failing SMTP server")
+	}
+}
+
+func TestNotify_PartialFailure(t *testing.T) {
+	// Webhook that fails until told otherwise, next to a working email channel
+	webhooks := 0
+	failing := true
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if failing {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		webhooks++
+	}))
+	defer server.Close()
+
+	log := logger.New()
+	cfg := config.New(log)
+	cfg.StateDir = t.TempDir()
+	cfg.SMTPHost = "smtp.example.com"
+	cfg.EmailFrom = "from@example.com"
+	cfg.EmailTo = "to@example.com"
+	cfg.WebhookURL = server.URL
+	cfg.Retries = 1
+
+	notifier := New(cfg, log)
+	mock := &mockSender{}
+	notifier.sender = mock
+
+	// The email got out, so the notification counts as sent and is kept for the webhook only
+	if err := notifier.Notify(Notification{Domain: "taken.com", Event: EventExpiring, DaysLeft: 10}); err != nil {
+		t.Fatalf("Notify() returned error: %v", err)
+	}
+	if len(mock.msgs) != 1 {
+		t.Fatalf("Expected 1 email, got %d", len(mock.msgs))
+	}
+	data, err := os.ReadFile(filepath.Join(cfg.StateDir, heldFile))
+	if err != nil {
+		t.Fatalf("Expected the notification to be kept for the webhook: %v", err)
+	}
+	var held queuedMessage
+	if err := json.Unmarshal(data, &held); err != nil || len(held.Channels) != 1 || held.Channels[0] != config.ChannelWebhook {
+		t.Errorf("Expected taken.com kept for the webhook, got %s (%v)", data, err)
+	}
+
+	// Retries only go to the webhook
+	if err := notifier.Flush(); err == nil {
+		t.Fatal("Expected Flush() to return the webhook error")
+	}
+	failing = false
+	if err := notifier.Flush(); err != nil {
+		t.Fatalf("Flush() returned error: %v", err)
+	}
+	if len(mock.msgs) != 1 || webhooks != 1 {
+		t.Errorf("Expected 1 email and 1 webhook, got %d and %d", len(mock.msgs), webhooks)
+	}
+	if _, err := os.Stat(filepath.Join(cfg.StateDir, heldFile)); !os.IsNotExist(err) {
+		t.Errorf("Expected the kept notification to be removed, got %v", err)
+	}
+}
+
+func TestSend_Webhook(t *testing.T) {
+	var received webhookPayload
+	var authHeader string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authHeader = r.Header.Get("Authorization")
+		if err := json.NewDecoder(r.Body).Decode(&received); err != nil {
+			t.Errorf("Failed to decode webhook payload: %v", err)
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	log := logger.New()
+	cfg := config.New(log)
+	cfg.WebhookURL = server.URL
+	cfg.WebhookHeaders = map[string]string{"Authorization": "Bearer secret"}
+
+	notifier := New(cfg, log)
+	if err := notifier.Send("example.com", "Test message"); err != nil {
+		t.Errorf("Send() returned error: %v", err)
+	}
+
+	if received.Domain != "example.com" {
+		t.Errorf("Expected domain example.com, got %q", received.Domain)
+	}
+	if received.Message != "Test message" {
+		t.Errorf("Expected message %q, got %q", "Test message", received.Message)
+	}
+	if received.Timestamp.IsZero() {
+		t.Errorf("Expected timestamp to be set")
+	}
+	if authHeader != "Bearer secret" {
+		t.Errorf("Expected Authorization header %q, got %q", "Bearer secret", authHeader)
+	}
+}
+
+func TestSendWebhook_Proxy(t *testing.T) {
+	// An HTTP proxy receives the request with the webhook's absolute URL
+	var proxied string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		proxied = r.URL.String()
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer proxy.Close()
+
+	log := logger.New()
+	cfg := config.New(log)
+	cfg.WebhookURL = "http://hooks.example.com/notify"
+	cfg.Proxy = proxy.URL
+
+	notifier := New(cfg, log)
+	transport, ok := notifier.httpClient().Transport.(*http.Transport)
+	if !ok {
+		t.Fatalf("Expected an *http.Transport with a proxy configured, got %T", notifier.httpClient().Transport)
+	}
+	req, _ := http.NewRequest(http.MethodPost, "https://api.telegram.org/bot/sendMessage", nil)
+	if u, err := transport.Proxy(req); err != nil || u == nil || u.String() != proxy.URL {
+		t.Errorf("Expected the transport to use proxy %s, got %v, %v", proxy.URL, u, err)
+	}
+
+	if err := notifier.sendWebhook("example.com", "Test message"); err != nil {
+		t.Fatalf("sendWebhook() returned error: %v", err)
+	}
+	if proxied != cfg.WebhookURL {
+		t.Errorf("Expected the proxy to receive %s, got %q", cfg.WebhookURL, proxied)
+	}
+
+	// Without a proxy the default transport is used
+	cfg.Proxy = ""
+	if transport := New(cfg, log).httpClient().Transport; transport != nil {
+		t.Errorf("Expected the default transport without a proxy, got %T", transport)
+	}
+}
+
+func TestSendWebhook_Retries(t *testing.T) {
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Fail the first attempt only
+		if atomic.AddInt32(&calls, 1) == 1 {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	log := logger.New()
+	cfg := config.New(log)
+	cfg.WebhookURL = server.URL
+	cfg.Retries = 3
+	cfg.Backoff = time.Millisecond
+
+	notifier := New(cfg, log)
+	if err := notifier.sendWebhook("example.com", "Test message"); err != nil {
+		t.Errorf("sendWebhook() returned error: %v", err)
+	}
+	if got := atomic.LoadInt32(&calls); got != 2 {
+		t.Errorf("Expected 2 webhook attempts, got %d", got)
+	}
+}
+
+func TestSendWebhook_GivesUp(t *testing.T) {
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer server.Close()
+
+	log := logger.New()
+	cfg := config.New(log)
+	cfg.WebhookURL = server.URL
+	cfg.Retries = 2
+	cfg.Backoff = time.Millisecond
+
+	notifier := New(cfg, log)
+	if err := notifier.sendWebhook("example.com", "Test message"); err == nil {
+		t.Errorf("sendWebhook() returned nil error for a failing webhook")
+	}
+	if got := atomic.LoadInt32(&calls); got != 2 {
+		t.Errorf("Expected 2 webhook attempts, got %d", got)
+	}
+}
+
+func TestSendWebhook_Statuses(t *testing.T) {
+	tests := []struct {
+		status int
+		want   int32
+	}{
+		{http.StatusBadRequest, 1},
+		{http.StatusNotFound, 1},
+		{http.StatusTooManyRequests, 3},
+		{http.StatusServiceUnavailable, 3},
+	}
+
+	for _, tc := range tests {
+		var calls int32
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			atomic.AddInt32(&calls, 1)
+			w.WriteHeader(tc.status)
+		}))
+
+		log := logger.New()
+		cfg := config.New(log)
+		cfg.WebhookURL = server.URL
+		cfg.Retries = 3
+		cfg.Backoff = time.Millisecond
+
+		if err := New(cfg, log).sendWebhook("example.com", "Test message"); err == nil {
+			t.Errorf("%d: sendWebhook() returned nil error", tc.status)
+		}
+		if got := atomic.LoadInt32(&calls); got != tc.want {
+			t.Errorf("%d: Expected %d webhook attempts, got %d", tc.status, tc.want, got)
+		}
+		server.Close()
+	}
+}
+
+func TestSendWebhook_Cancelled(t *testing.T) {
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer server.Close()
+
+	log := logger.New()
+	cfg := config.New(log)
+	cfg.WebhookURL = server.URL
+	cfg.Retries = 3
+	cfg.Backoff = time.Hour
+	cfg.MaxBackoff = time.Hour
+
+	// The wait before the retry ends with the context
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	notifier := New(cfg, log)
+	notifier.SetContext(ctx)
+
+	start := time.Now()
+	if err := notifier.sendWebhook("example.com", "Test message"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("sendWebhook() error = %v, want context.DeadlineExceeded", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("sendWebhook() took %s after the context was done", elapsed)
+	}
+	if got := atomic.LoadInt32(&calls); got != 1 {
+		t.Errorf("Expected 1 webhook attempt, got %d", got)
+	}
+}
+
+// startFakeSMTP runs a minimal plain-text SMTP server that accepts a single message
+// It returns the listening port and a channel receiving the message data
+func startFakeSMTP(t *testing.T) (int, <-chan string) {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to start fake SMTP server: %v", err)
+	}
+	t.Cleanup(func() { _ = ln.Close() })
+
+	received := make(chan string, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer func() { _ = conn.Close() }()
+
+		r := bufio.NewReader(conn)
+		reply := func(line string) { _, _ = fmt.Fprintf(conn, "%s\r\n", line) }
+		reply("220 localhost ESMTP")
+
+		var data strings.Builder
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			cmd := strings.ToUpper(strings.TrimSpace(line))
+			switch {
+			case strings.HasPrefix(cmd, "EHLO"), strings.HasPrefix(cmd, "HELO"):
+				reply("250 localhost")
+			case strings.HasPrefix(cmd, "MAIL"), strings.HasPrefix(cmd, "RCPT"):
+				reply("250 OK")
+			case cmd == "DATA":
+				reply("354 Go ahead")
+				for {
+					line, err := r.ReadString('\n')
+					if err != nil {
+						return
+					}
+					if line == ".\r\n" {
+						break
+					}
+					data.WriteString(line)
+				}
+				received <- data.String()
+				reply("250 OK")
+			case cmd == "QUIT":
+				reply("221 Bye")
+				return
+			default:
+				reply("502 Not implemented")
+			}
+		}
+	}()
+
+	return ln.Addr().(*net.TCPAddr).Port, received
+}
+
+func TestSendEmail_PlainConnection(t *testing.T) {
+	port, received := startFakeSMTP(t)
+
+	log := logger.New()
+	cfg := config.New(log)
+	cfg.SMTPHost = "127.0.0.1"
+	cfg.SMTPPort = port
+	cfg.SMTPTLS = config.SMTPTLSNone
+	cfg.EmailFrom = "from@example.com"
+	cfg.EmailTo = "to@example.com"
+
+	notifier := New(cfg, log)
+	if err := notifier.sendEmail("example.com", cfg.EmailTo, notifier.eventEmail(Notification{Domain: "example.com"}, "Test message")); err != nil {
+		t.Fatalf("sendEmail() returned error: %v", err)
+	}
+
+	select {
+	case msg := <-received:
+		if !strings.Contains(msg, "Subject: Domain checker: example.com") {
+			t.Errorf("Expected subject in message, got %q", msg)
+		}
+		if !strings.Contains(msg, "Test message") {
+			t.Errorf("Expected body in message, got %q", msg)
+		}
+	case <-time.After(time.Second):
+		t.Errorf("Fake SMTP server didn't receive a message")
+	}
+}
+
+func TestSendEmail_AutoTLS(t *testing.T) {
+	// The fake server doesn't advertise STARTTLS, so the default mode sends without it
+	port, received := startFakeSMTP(t)
+
+	log := logger.New()
+	cfg := config.New(log)
+	cfg.SMTPHost = "127.0.0.1"
+	cfg.SMTPPort = port
+	cfg.EmailFrom = "from@example.com"
+	cfg.EmailTo = "to@example.com"
+
+	notifier := New(cfg, log)
+	if err := notifier.sendEmail("example.com", cfg.EmailTo, notifier.eventEmail(Notification{Domain: "example.com"}, "Test message")); err != nil {
+		t.Fatalf("sendEmail() returned error: %v", err)
+	}
+
+	select {
+	case msg := <-received:
+		if !strings.Contains(msg, "Test message") {
+			t.Errorf("Expected body in message, got %q", msg)
+		}
+	case <-time.After(time.Second):
+		t.Errorf("Fake SMTP server didn't receive a message")
+	}
+}
+
+func TestSendEmail_StartTLSRequired(t *testing.T) {
+	// The fake server doesn't advertise STARTTLS, so the starttls mode must refuse to continue
+	port, received := startFakeSMTP(t)
+
+	log := logger.New()
+	cfg := config.New(log)
+	cfg.SMTPHost = "127.0.0.1"
+	cfg.SMTPPort = port
+	cfg.SMTPTLS = config.SMTPTLSStartTLS
+	cfg.SMTPUser = "user"
+	cfg.SMTPPass = "pass"
+	cfg.EmailFrom = "from@example.com"
+	cfg.EmailTo = "to@example.com"
+
+	notifier := New(cfg, log)
+	if err := notifier.sendEmail("example.com", cfg.EmailTo, notifier.eventEmail(Notification{Domain: "example.com"}, "Test message")); err == nil || !strings.Contains(err.Error(), "STARTTLS") {
+		t.Errorf("sendEmail() error = %v, want STARTTLS error", err)
+	}
+
+	select {
+	case msg := <-received:
+		t.Errorf("Expected no message to be delivered, got %q", msg)
+	default:
+	}
+}
+
+func TestSendEmail_UnknownTLSMode(t *testing.T) {
+	log := logger.New()
+	cfg := config.New(log)
+	cfg.SMTPHost = "127.0.0.1"
+	cfg.SMTPTLS = "bogus"
+	cfg.EmailFrom = "from@example.com"
+	cfg.EmailTo = "to@example.com"
+
+	notifier := New(cfg, log)
+	if err := notifier.sendEmail("example.com", cfg.EmailTo, notifier.eventEmail(Notification{Domain: "example.com"}, "Test message")); err == nil || !strings.Contains(err.Error(), "bogus") {
+		t.Errorf("sendEmail() error = %v, want unknown mode error", err)
+	}
+}
+
+func TestSendEmail_StalledServer(t *testing.T) {
+	// Accepts connections but never greets
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to start stalled SMTP server: %v", err)
+	}
+	t.Cleanup(func() { _ = ln.Close() })
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		t.Cleanup(func() { _ = conn.Close() })
+	}()
+
+	log := logger.New()
+	cfg := config.New(log)
+	cfg.SMTPHost = "127.0.0.1"
+	cfg.SMTPPort = ln.Addr().(*net.TCPAddr).Port
+	cfg.SMTPTLS = config.SMTPTLSNone
+	cfg.Timeout = 200 * time.Millisecond
+	cfg.EmailFrom = "from@example.com"
+	cfg.EmailTo = "to@example.com"
+
+	notifier := New(cfg, log)
+	start := time.Now()
+	if err := notifier.sendEmail("example.com", cfg.EmailTo, notifier.eventEmail(Notification{Domain: "example.com"}, "Test message")); err == nil {
+		t.Errorf("sendEmail() returned nil error for a stalled server")
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("sendEmail() took %s, want about %s", elapsed, cfg.Timeout)
+	}
+}
+
+func TestSend_Digest(t *testing.T) {
+	port, received := startFakeSMTP(t)
+
+	log := logger.New()
+	cfg := config.New(log)
+	cfg.SMTPHost = "127.0.0.1"
+	cfg.SMTPPort = port
+	cfg.SMTPTLS = config.SMTPTLSNone
+	cfg.EmailFrom = "from@example.com"
+	cfg.EmailTo = "to@example.com"
+	cfg.NotifyDigest = true
+
+	notifier := New(cfg, log)
+	if err := notifier.Send("example.com", "Domain example.com is now available!"); err != nil {
+		t.Errorf("Send() returned error: %v", err)
+	}
+	if err := notifier.Send("example.org", "Domain example.org expires in 3 days"); err != nil {
+		t.Errorf("Send() returned error: %v", err)
+	}
+
+	// Nothing should go out before the flush
+	select {
+	case msg := <-received:
+		t.Fatalf("Expected no email before Flush, got %q", msg)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	if err := notifier.Flush(); err != nil {
+		t.Fatalf("Flush() returned error: %v", err)
+	}
+
+	select {
+	case msg := <-received:
+		if !strings.Contains(msg, "Subject: Domain checker: 2 notifications") {
+			t.Errorf("Expected digest subject, got %q", msg)
+		}
+		if !strings.Contains(msg, "example.com is now available") || !strings.Contains(msg, "example.org expires in 3 days") {
+			t.Errorf("Expected both messages in digest, got %q", msg)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Fake SMTP server didn't receive the digest")
+	}
+
+	// A second flush has nothing left to send
+	if err := notifier.Flush(); err != nil {
+		t.Errorf("Flush() on an empty queue returned error: %v", err)
+	}
+}
+
+func TestSend_PerDomainRecipient(t *testing.T) {
+	log := logger.New()
+	cfg := &config.Config{
+		SMTPHost:  "smtp.example.com",
+		SMTPPort:  25,
+		EmailFrom: "from@example.com",
+		EmailTo:   "to@example.com",
+		Domains:   []config.DomainEntry{{Name: "critical.com", EmailTo: "ops@example.com"}},
+	}
+
+	notifier := New(cfg, log)
+	mock := &mockSender{}
+	notifier.sender = mock
 
-	// We can't easily verify the log output in this test framework
-	// In a real test, we would capture stdout/stderr or use a mock logger
+	if err := notifier.Send("critical.com", "Test message"); err != nil {
+		t.Fatalf("Send() returned error: %v", err)
+	}
+	if len(mock.to) != 1 || mock.to[0] != "ops@example.com" {
+		t.Errorf("Expected recipients [ops@example.com], got %v", mock.to)
+	}
+	if len(mock.msgs) != 1 || !strings.Contains(mock.msgs[0], "To: ops@example.com\r\n") {
+		t.Errorf("Expected To header for ops@example.com, got %q", mock.msgs)
+	}
+}
+
+func TestSend_ChannelRouting(t *testing.T) {
+	var webhooks []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var payload webhookPayload
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			t.Errorf("Failed to decode webhook payload: %v", err)
+		}
+		webhooks = append(webhooks, payload.Domain)
+	}))
+	defer server.Close()
+
+	log := logger.New()
+	cfg := &config.Config{
+		SMTPHost:   "smtp.example.com",
+		SMTPPort:   25,
+		EmailFrom:  "from@example.com",
+		EmailTo:    "to@example.com",
+		WebhookURL: server.URL,
+		Domains: []config.DomainEntry{
+			{Name: "team-a.com", Channels: []string{config.ChannelWebhook}},
+			{Name: "team-b.com", Channels: []string{config.ChannelEmail}},
+			{Name: "shared.com"},
+		},
+	}
+
+	notifier := New(cfg, log)
+	mock := &mockSender{}
+	notifier.sender = mock
+
+	for _, domain := range []string{"team-a.com", "team-b.com", "shared.com"} {
+		if err := notifier.Send(domain, "Test message"); err != nil {
+			t.Fatalf("Send(%s) returned error: %v", domain, err)
+		}
+	}
+
+	// A domain routed only to the webhook isn't emailed, and the other way round
+	if len(mock.msgs) != 2 || strings.Contains(mock.msgs[0], "team-a.com") || !strings.Contains(mock.msgs[0], "team-b.com") {
+		t.Errorf("Expected emails for team-b.com and shared.com, got %q", mock.msgs)
+	}
+	if !slices.Equal(webhooks, []string{"team-a.com", "shared.com"}) {
+		t.Errorf("Expected webhooks for team-a.com and shared.com, got %v", webhooks)
+	}
+
+	// Domains without channels of their own follow NotifyChannels
+	cfg.NotifyChannels = []string{config.ChannelWebhook}
+	if err := notifier.Send("shared.com", "Test message"); err != nil {
+		t.Fatalf("Send() returned error: %v", err)
+	}
+	if len(mock.msgs) != 2 || len(webhooks) != 3 {
+		t.Errorf("Expected only a webhook with NotifyChannels, got %d emails and %d webhooks", len(mock.msgs), len(webhooks))
+	}
+}
+
+func TestFlush_DigestPerRecipient(t *testing.T) {
+	log := logger.New()
+	cfg := &config.Config{
+		SMTPHost:     "smtp.example.com",
+		SMTPPort:     25,
+		EmailFrom:    "from@example.com",
+		EmailTo:      "to@example.com",
+		NotifyDigest: true,
+		Domains:      []config.DomainEntry{{Name: "critical.com", EmailTo: "ops@example.com"}},
+	}
+
+	notifier := New(cfg, log)
+	mock := &mockSender{}
+	notifier.sender = mock
+
+	for _, domain := range []string{"example.com", "critical.com", "example.org"} {
+		if err := notifier.Send(domain, "Message for "+domain); err != nil {
+			t.Fatalf("Send() returned error: %v", err)
+		}
+	}
+	if err := notifier.Flush(); err != nil {
+		t.Fatalf("Flush() returned error: %v", err)
+	}
+
+	if len(mock.msgs) != 2 {
+		t.Fatalf("Expected 2 digests, got %d", len(mock.msgs))
+	}
+	if !strings.Contains(mock.msgs[0], "To: to@example.com\r\n") || !strings.Contains(mock.msgs[0], "Message for example.org") {
+		t.Errorf("Expected first digest for to@example.com with example.org, got %q", mock.msgs[0])
+	}
+	if !strings.Contains(mock.msgs[1], "To: ops@example.com\r\n") || strings.Contains(mock.msgs[1], "example.org") {
+		t.Errorf("Expected second digest for ops@example.com with only critical.com, got %q", mock.msgs[1])
+	}
+}
+
+func TestFlush_DigestRetried(t *testing.T) {
+	log := logger.New()
+	cfg := &config.Config{
+		SMTPHost:     "smtp.example.com",
+		SMTPPort:     25,
+		EmailFrom:    "from@example.com",
+		EmailTo:      "to@example.com",
+		NotifyDigest: true,
+		StateDir:     t.TempDir(),
+	}
+
+	notifier := New(cfg, log)
+	mock := &mockSender{err: errors.New("connection refused")}
+	notifier.sender = mock
+
+	if err := notifier.Send("example.com", "Message for example.com"); err != nil {
+		t.Fatalf("Send() returned error: %v", err)
+	}
+	if err := notifier.Flush(); err == nil {
+		t.Fatal("Expected Flush() to return the SMTP error")
+	}
+
+	// A later run, e.g. a new process, sends the kept message with the next digest
+	mock.err = nil
+	later := New(cfg, log)
+	later.sender = mock
+	if err := later.Flush(); err != nil {
+		t.Fatalf("Flush() returned error: %v", err)
+	}
+	if len(mock.msgs) != 2 || !strings.Contains(mock.msgs[1], "Message for example.com") {
+		t.Errorf("Expected the failed digest to be sent again, got %q", mock.msgs)
+	}
+
+	if err := later.Flush(); err != nil {
+		t.Fatalf("Flush() returned error: %v", err)
+	}
+	if len(mock.msgs) != 2 {
+		t.Errorf("Expected the message to be sent once, got %d emails", len(mock.msgs))
+	}
+}
+
+func TestMessage_Default(t *testing.T) {
+	log := logger.New()
+	cfg := config.New(log)
+	notifier := New(cfg, log)
+
+	tests := []struct {
+		ev   Notification
+		want string
+	}{
+		{Notification{Domain: "example.com", Event: EventAvailable}, "Domain example.com is now available!"},
+		{Notification{Domain: "example.com", Event: EventAvailable, Expiration: time.Date(2025, 5, 1, 0, 0, 0, 0, time.UTC)},
+			"Domain example.com is now available again! It was registered until 2025-05-01"},
+		{Notification{Domain: "example.com", Event: EventExpiring, DaysLeft: 5}, "Domain example.com expires in 5 days"},
+		{Notification{Domain: "example.com", Event: EventStatus, Status: "pendingDelete"}, "Domain example.com is in pendingDelete and may become available soon"},
+		{Notification{Domain: "example.com", Event: EventExpired, DaysLeft: -5}, "Domain example.com expired 5 days ago"},
+		{Notification{Domain: "example.com", Event: EventExpired}, "Domain example.com expired today"},
+		{Notification{Domain: "example.com", Event: EventExpired, DaysLeft: -40, Status: "redemptionPeriod"}, "Domain example.com expired 40 days ago and is in redemptionPeriod"},
+		{Notification{Domain: "example.com", Event: EventUnknownExpiry}, "Domain example.com is monitored, but its expiration date can't be determined from WHOIS"},
+	}
+	for _, tc := range tests {
+		got, err := notifier.Message(tc.ev)
+		if err != nil {
+			t.Errorf("Message(%+v) returned error: %v", tc.ev, err)
+			continue
+		}
+		if got != tc.want {
+			t.Errorf("Message(%+v) = %q, want %q", tc.ev, got, tc.want)
+		}
+	}
+}
+
+func TestMessage_RenewalInfo(t *testing.T) {
+	log := logger.New()
+	cfg := config.New(log)
+	notifier := New(cfg, log)
+
+	yes, no := true, false
+	ev := Notification{Domain: "example.com", Event: EventExpiring, DaysLeft: 5, AutoRenew: &no, TransferLocked: &yes}
+
+	// Off by default
+	if got, _ := notifier.Message(ev); got != "Domain example.com expires in 5 days" {
+		t.Errorf("Message() = %q, want no renewal info without NotifyRenewalInfo", got)
+	}
+
+	cfg.NotifyRenewalInfo = true
+	tests := []struct {
+		ev   Notification
+		want string
+	}{
+		{ev, "Domain example.com expires in 5 days (auto-renew off, transfer locked)"},
+		{Notification{Domain: "example.com", Event: EventExpiring, DaysLeft: 5, TransferLocked: &no}, "Domain example.com expires in 5 days (transfer unlocked)"},
+		{Notification{Domain: "example.com", Event: EventExpired, AutoRenew: &yes}, "Domain example.com expired today (auto-renew on)"},
+		{Notification{Domain: "example.com", Event: EventExpiring, DaysLeft: 5}, "Domain example.com expires in 5 days"},
+		{Notification{Domain: "example.com", Event: EventExpiring, DaysLeft: 5, CertBased: true, AutoRenew: &yes},
+			"TLS certificate of example.com (registration expiry unknown) expires in 5 days (auto-renew on)"},
+	}
+	for _, tc := range tests {
+		got, err := notifier.Message(tc.ev)
+		if err != nil {
+			t.Errorf("Message(%+v) returned error: %v", tc.ev, err)
+			continue
+		}
+		if got != tc.want {
+			t.Errorf("Message(%+v) = %q, want %q", tc.ev, got, tc.want)
+		}
+	}
+}
+
+func TestMessage_Template(t *testing.T) {
+	log := logger.New()
+	cfg := config.New(log)
+	cfg.NotifyTemplate = `[{{.Event}}] {{.Domain}} in {{.DaysLeft}}d ({{.Expiration.Format "2006-01-02"}})`
+	notifier := New(cfg, log)
+
+	ev := Notification{
+		Domain:     "example.com",
+		Event:      EventExpiring,
+		DaysLeft:   3,
+		Expiration: time.Date(2025, 5, 1, 0, 0, 0, 0, time.UTC),
+	}
+	got, err := notifier.Message(ev)
+	if err != nil {
+		t.Fatalf("Message() returned error: %v", err)
+	}
+	if want := "[expiring] example.com in 3d (2025-05-01)"; got != want {
+		t.Errorf("Message() = %q, want %q", got, want)
+	}
+}
+
+func TestTest_Channels(t *testing.T) {
+	log := logger.New()
+	cfg := &config.Config{
+		SMTPHost:         "smtp.example.com",
+		SMTPPort:         25,
+		EmailFrom:        "from@example.com",
+		EmailTo:          "to@example.com",
+		NotifyDigest:     true,
+		TelegramBotToken: "token",
+	}
+
+	notifier := New(cfg, log)
+	mock := &mockSender{}
+	notifier.sender = mock
+
+	results := notifier.Test()
+	if len(results) != 2 || results[0].Channel != "email" || results[1].Channel != "telegram" {
+		t.Fatalf("Expected email and telegram results, got %+v", results)
+	}
+	if results[0].Err != nil {
+		t.Errorf("Expected the test email to be sent, got %v", results[0].Err)
+	}
+	if len(mock.msgs) != 1 || !strings.Contains(mock.msgs[0], "Subject: Domain checker: test notification\r\n") {
+		t.Errorf("Expected a test email to be sent right away in digest mode, got %v", mock.msgs)
+	}
+	if results[1].Err == nil {
+		t.Errorf("Expected telegram without a chat ID to fail")
+	}
 }
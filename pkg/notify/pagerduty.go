@@ -0,0 +1,111 @@
+package notify
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/mallocator/domain-checker/pkg/metrics"
+)
+
+// PagerDuty Events API v2 actions
+const (
+	pagerDutyTrigger = "trigger"
+	pagerDutyResolve = "resolve"
+)
+
+// pagerDutyEvent is the request body for the Events API v2
+// The dedup key is the domain, so every run triggering for it updates the same incident
+type pagerDutyEvent struct {
+	RoutingKey  string            `json:"routing_key"`
+	EventAction string            `json:"event_action"`
+	DedupKey    string            `json:"dedup_key"`
+	Payload     *pagerDutyPayload `json:"payload,omitempty"` // only for triggers
+}
+
+// pagerDutyPayload describes the alert of a triggered incident
+type pagerDutyPayload struct {
+	Summary       string         `json:"summary"`
+	Source        string         `json:"source"`
+	Severity      string         `json:"severity"`
+	Timestamp     time.Time      `json:"timestamp"`
+	CustomDetails map[string]any `json:"custom_details,omitempty"`
+}
+
+// PagerDutyConfigured reports whether critical expiries page through PagerDuty
+func (n *Notifier) PagerDutyConfigured() bool {
+	return n.cfg.PagerDutyRoutingKey != "" && n.cfg.CriticalThresholdDays > 0
+}
+
+// Page triggers a critical PagerDuty incident for an expiring or expired domain, deduplicated by the domain
+// Paging isn't held during QuietHours, since on-call schedules decide who is woken up
+func (n *Notifier) Page(ev Notification) error {
+	if n.cfg.PagerDutyRoutingKey == "" {
+		return nil
+	}
+	message, err := n.Message(ev)
+	if err != nil {
+		return err
+	}
+
+	details := map[string]any{"domain": ev.Domain, "event": ev.Event, "days_left": ev.DaysLeft}
+	if !ev.Expiration.IsZero() {
+		details["expiration"] = ev.Expiration
+	}
+	err = n.postPagerDuty(pagerDutyEvent{
+		EventAction: pagerDutyTrigger,
+		DedupKey:    ev.Domain,
+		Payload: &pagerDutyPayload{
+			Summary:       message,
+			Source:        "domain-checker",
+			Severity:      "critical",
+			Timestamp:     n.now(),
+			CustomDetails: details,
+		},
+	})
+	n.record(ev, "pagerduty", err)
+	if err != nil {
+		return err
+	}
+
+	n.log.Infof("PagerDuty incident triggered for %s", ev.Domain)
+	metrics.NotificationSent("pagerduty")
+	return nil
+}
+
+// Resolve resolves the PagerDuty incident of a domain that's no longer critical
+func (n *Notifier) Resolve(domain string) error {
+	if n.cfg.PagerDutyRoutingKey == "" {
+		return nil
+	}
+	if err := n.postPagerDuty(pagerDutyEvent{EventAction: pagerDutyResolve, DedupKey: domain}); err != nil {
+		return err
+	}
+	n.log.Infof("PagerDuty incident resolved for %s", domain)
+	return nil
+}
+
+// postPagerDuty sends an event to the Events API with the configured routing key
+func (n *Notifier) postPagerDuty(event pagerDutyEvent) error {
+	event.RoutingKey = n.cfg.PagerDutyRoutingKey
+	body, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("pagerduty: %w", err)
+	}
+
+	resp, err := n.httpClient().Post(n.cfg.PagerDutyURL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("pagerduty: %w", err)
+	}
+	defer func() {
+		if err := resp.Body.Close(); err != nil {
+			n.log.Warnf("Failed to close PagerDuty response: %v", err)
+		}
+	}()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("pagerduty: unexpected status %s", resp.Status)
+	}
+	return nil
+}
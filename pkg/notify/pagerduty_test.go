@@ -0,0 +1,81 @@
+package notify
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/mallocator/domain-checker/pkg/config"
+	"github.com/mallocator/domain-checker/pkg/logger"
+)
+
+func TestPagerDuty(t *testing.T) {
+	var events []pagerDutyEvent
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var event pagerDutyEvent
+		if err := json.NewDecoder(r.Body).Decode(&event); err != nil {
+			t.Errorf("Failed to decode PagerDuty request: %v", err)
+		}
+		events = append(events, event)
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer server.Close()
+
+	log := logger.New()
+	cfg := config.New(log)
+	cfg.PagerDutyURL = server.URL
+	notifier := New(cfg, log)
+
+	// Nothing is sent without a routing key
+	ev := Notification{Domain: "example.com", Event: EventExpiring, DaysLeft: 2, Expiration: time.Now().Add(48 * time.Hour)}
+	if err := notifier.Page(ev); err != nil || len(events) != 0 {
+		t.Fatalf("Expected nothing to be sent without a routing key, got %v and %+v", err, events)
+	}
+
+	cfg.PagerDutyRoutingKey = "R0UT1NG"
+	if err := notifier.Page(ev); err != nil {
+		t.Fatalf("Page() returned error: %v", err)
+	}
+	if err := notifier.Resolve("example.com"); err != nil {
+		t.Fatalf("Resolve() returned error: %v", err)
+	}
+	if len(events) != 2 {
+		t.Fatalf("Expected a trigger and a resolve, got %+v", events)
+	}
+
+	trigger := events[0]
+	if trigger.RoutingKey != "R0UT1NG" || trigger.EventAction != "trigger" || trigger.DedupKey != "example.com" {
+		t.Errorf("Unexpected trigger %+v", trigger)
+	}
+	if p := trigger.Payload; p == nil || p.Severity != "critical" || p.Summary != "Domain example.com expires in 2 days" || p.Source != "domain-checker" {
+		t.Errorf("Unexpected trigger payload %+v", trigger.Payload)
+	}
+
+	// Resolving only needs the dedup key
+	resolve := events[1]
+	if resolve.RoutingKey != "R0UT1NG" || resolve.EventAction != "resolve" || resolve.DedupKey != "example.com" || resolve.Payload != nil {
+		t.Errorf("Unexpected resolve %+v", resolve)
+	}
+}
+
+func TestPagerDuty_HTTPError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer server.Close()
+
+	log := logger.New()
+	cfg := config.New(log)
+	cfg.PagerDutyURL = server.URL
+	cfg.PagerDutyRoutingKey = "R0UT1NG"
+
+	notifier := New(cfg, log)
+	if err := notifier.Page(Notification{Domain: "example.com", Event: EventExpired}); err == nil {
+		t.Errorf("Expected an error for a rejected trigger")
+	}
+	if err := notifier.Resolve("example.com"); err == nil {
+		t.Errorf("Expected an error for a rejected resolve")
+	}
+}
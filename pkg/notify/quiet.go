@@ -0,0 +1,134 @@
+package notify
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// heldFile keeps the notifications held during quiet hours in the state directory, one JSON object per line
+// It's a file rather than memory so a single run can hold them for the next one
+const heldFile = "held_notifications.jsonl"
+
+// heldPath returns the path of the held notifications
+func (n *Notifier) heldPath() string {
+	return filepath.Join(n.cfg.StateDir, heldFile)
+}
+
+// holds reports whether a notification has to wait for the end of the quiet hours
+func (n *Notifier) holds(ev Notification) bool {
+	if ev.Event == EventAvailable && n.cfg.QuietHoursBypassAvailable {
+		return false
+	}
+	return n.cfg.InQuietHours(n.now())
+}
+
+// hold saves a rendered notification to be delivered after the quiet hours
+func (n *Notifier) hold(ev Notification, message string) error {
+	n.heldMu.Lock()
+	defer n.heldMu.Unlock()
+
+	if err := n.appendHeld([]queuedMessage{{Notification: ev, Message: message}}); err != nil {
+		return fmt.Errorf("hold notification: %w", err)
+	}
+	n.log.Infof("Quiet hours (%s), holding notification for %s: %s", n.cfg.QuietHours, ev.Domain, message)
+	return nil
+}
+
+// encodeHeld renders messages as lines of the held notifications file
+func encodeHeld(messages []queuedMessage) ([]byte, error) {
+	var buf bytes.Buffer
+	for _, m := range messages {
+		data, err := json.Marshal(m)
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(append(data, '\n'))
+	}
+	return buf.Bytes(), nil
+}
+
+// appendHeld adds messages to the held notifications, the caller holds heldMu
+func (n *Notifier) appendHeld(messages []queuedMessage) error {
+	data, err := encodeHeld(messages)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(n.heldPath(), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		_ = f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// releaseHeld delivers the notifications held during quiet hours, unless it's still quiet
+// Delivered notifications are removed; failed ones stay held for the channels that failed and are retried by the next Flush
+func (n *Notifier) releaseHeld() error {
+	if n.cfg.InQuietHours(n.now()) {
+		return nil
+	}
+
+	// Notifications held while these are delivered wait for the lock, so none are lost when the file is rewritten
+	n.heldMu.Lock()
+	defer n.heldMu.Unlock()
+
+	data, err := os.ReadFile(n.heldPath())
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("release held notifications: %w", err)
+	}
+
+	var errs []error
+	var failed []queuedMessage
+	for _, line := range bytes.Split(data, []byte("\n")) {
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+		var m queuedMessage
+		if err := json.Unmarshal(line, &m); err != nil {
+			n.log.Warnf("Skipping invalid held notification: %v", err)
+			continue
+		}
+		n.log.Debugf("Quiet hours over, sending held notification for %s", m.Domain)
+		channels, err := n.deliverVia(m.Notification, m.Message, m.Channels)
+		if err != nil {
+			n.log.Warnf("Keeping held notification for %s to retry via %s", m.Domain, strings.Join(channels, ", "))
+			m.Channels = channels
+			failed = append(failed, m)
+			errs = append(errs, err)
+		}
+	}
+
+	if len(failed) == 0 {
+		if err := os.Remove(n.heldPath()); err != nil {
+			errs = append(errs, fmt.Errorf("release held notifications: %w", err))
+		}
+		return errors.Join(errs...)
+	}
+
+	// Replace the file with the failed notifications in one step, so a crash can't lose them
+	data, err = encodeHeld(failed)
+	tmp := n.heldPath() + ".tmp"
+	if err == nil {
+		err = os.WriteFile(tmp, data, 0644)
+	}
+	if err == nil {
+		err = os.Rename(tmp, n.heldPath())
+	}
+	if err != nil {
+		errs = append(errs, fmt.Errorf("keep failed held notifications: %w", err))
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,154 @@
+package notify
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/mallocator/domain-checker/pkg/config"
+	"github.com/mallocator/domain-checker/pkg/logger"
+)
+
+func TestNotify_QuietHours(t *testing.T) {
+	// Webhook that records the messages
+	var messages []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var payload struct{ Message string }
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			t.Errorf("Failed to decode webhook payload: %v", err)
+		}
+		messages = append(messages, payload.Message)
+	}))
+	defer server.Close()
+
+	log := logger.New()
+	cfg := config.New(log)
+	cfg.StateDir = t.TempDir()
+	cfg.WebhookURL = server.URL
+	cfg.Timezone = "UTC"
+	cfg.QuietHours = "22:00-07:00"
+	cfg.QuietHoursBypassAvailable = true
+
+	now := time.Date(2024, 1, 1, 3, 0, 0, 0, time.UTC)
+	notifier := New(cfg, log)
+	notifier.now = func() time.Time { return now }
+
+	// Expiry reminders are held, available domains are urgent
+	if err := notifier.Notify(Notification{Domain: "taken.com", Event: EventExpiring, DaysLeft: 10}); err != nil {
+		t.Fatalf("Notify() returned error: %v", err)
+	}
+	if err := notifier.Notify(Notification{Domain: "free.com", Event: EventAvailable}); err != nil {
+		t.Fatalf("Notify() returned error: %v", err)
+	}
+	if len(messages) != 1 || messages[0] != "Domain free.com is now available!" {
+		t.Errorf("Expected only the available notification during quiet hours, got %q", messages)
+	}
+
+	// Still quiet, so nothing is released
+	if err := notifier.Flush(); err != nil {
+		t.Fatalf("Flush() returned error: %v", err)
+	}
+	if len(messages) != 1 {
+		t.Errorf("Expected nothing to be released during quiet hours, got %q", messages)
+	}
+
+	// A later run, e.g. a new process, sends the held notification once
+	now = time.Date(2024, 1, 1, 7, 30, 0, 0, time.UTC)
+	later := New(cfg, log)
+	later.now = func() time.Time { return now }
+	if err := later.Flush(); err != nil {
+		t.Fatalf("Flush() returned error: %v", err)
+	}
+	if err := later.Flush(); err != nil {
+		t.Fatalf("Flush() returned error: %v", err)
+	}
+	if len(messages) != 2 || messages[1] != "Domain taken.com expires in 10 days" {
+		t.Errorf("Expected the held notification after quiet hours, got %q", messages)
+	}
+	if _, err := os.Stat(filepath.Join(cfg.StateDir, heldFile)); !os.IsNotExist(err) {
+		t.Errorf("Expected the held notifications to be removed, got %v", err)
+	}
+}
+
+func TestNotify_QuietHoursWithoutBypass(t *testing.T) {
+	log := logger.New()
+	cfg := config.New(log)
+	cfg.StateDir = t.TempDir()
+	cfg.Timezone = "UTC"
+	cfg.QuietHours = "22:00-07:00"
+
+	notifier := New(cfg, log)
+	notifier.now = func() time.Time { return time.Date(2024, 1, 1, 23, 0, 0, 0, time.UTC) }
+
+	if !notifier.holds(Notification{Domain: "free.com", Event: EventAvailable}) {
+		t.Errorf("Expected available notifications to be held without QuietHoursBypassAvailable")
+	}
+
+	notifier.now = func() time.Time { return time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC) }
+	if notifier.holds(Notification{Domain: "taken.com", Event: EventExpiring}) {
+		t.Errorf("Expected notifications outside quiet hours to be sent right away")
+	}
+}
+
+func TestNotify_QuietHoursRetry(t *testing.T) {
+	// Webhook that fails until told otherwise
+	var messages []string
+	failing := true
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if failing {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		var payload struct{ Message string }
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			t.Errorf("Failed to decode webhook payload: %v", err)
+		}
+		messages = append(messages, payload.Message)
+	}))
+	defer server.Close()
+
+	log := logger.New()
+	cfg := config.New(log)
+	cfg.StateDir = t.TempDir()
+	cfg.WebhookURL = server.URL
+	cfg.Retries = 1
+	cfg.Timezone = "UTC"
+	cfg.QuietHours = "22:00-07:00"
+
+	now := time.Date(2024, 1, 1, 3, 0, 0, 0, time.UTC)
+	notifier := New(cfg, log)
+	notifier.now = func() time.Time { return now }
+	if err := notifier.Notify(Notification{Domain: "taken.com", Event: EventExpiring, DaysLeft: 10}); err != nil {
+		t.Fatalf("Notify() returned error: %v", err)
+	}
+
+	// A failed delivery stays held for the channel that failed
+	now = time.Date(2024, 1, 1, 7, 30, 0, 0, time.UTC)
+	if err := notifier.Flush(); err == nil {
+		t.Fatal("Expected Flush() to return the webhook error")
+	}
+	data, err := os.ReadFile(filepath.Join(cfg.StateDir, heldFile))
+	if err != nil {
+		t.Fatalf("Expected the failed notification to stay held: %v", err)
+	}
+	var held queuedMessage
+	if err := json.Unmarshal(data, &held); err != nil || held.Domain != "taken.com" || len(held.Channels) != 1 || held.Channels[0] != config.ChannelWebhook {
+		t.Errorf("Expected taken.com held for the webhook, got %s (%v)", data, err)
+	}
+
+	// The next flush delivers it and removes it
+	failing = false
+	if err := notifier.Flush(); err != nil {
+		t.Fatalf("Flush() returned error: %v", err)
+	}
+	if len(messages) != 1 || messages[0] != "Domain taken.com expires in 10 days" {
+		t.Errorf("Expected the held notification to be retried, got %q", messages)
+	}
+	if _, err := os.Stat(filepath.Join(cfg.StateDir, heldFile)); !os.IsNotExist(err) {
+		t.Errorf("Expected the held notifications to be removed, got %v", err)
+	}
+}
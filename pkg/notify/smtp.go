@@ -0,0 +1,131 @@
+package notify
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/smtp"
+	"strings"
+	"time"
+
+	"github.com/mallocator/domain-checker/pkg/config"
+	"github.com/mallocator/domain-checker/pkg/logger"
+)
+
+// sender delivers a formatted email message
+type sender interface {
+	send(addr string, auth smtp.Auth, from string, to []string, msg []byte) error
+}
+
+// smtpSender delivers mail over SMTP honoring the configured TLS mode
+type smtpSender struct {
+	cfg *config.Config
+	log *logger.Logger
+}
+
+// dial connects to the SMTP server using the configured TLS mode
+func (s *smtpSender) dial(addr string) (*smtp.Client, error) {
+	dialer := &net.Dialer{Timeout: s.cfg.Timeout}
+	tlsConfig := &tls.Config{
+		ServerName:         s.cfg.SMTPHost,
+		InsecureSkipVerify: s.cfg.SMTPInsecureSkipVerify,
+	}
+
+	mode := strings.ToLower(s.cfg.SMTPTLS)
+	switch mode {
+	case config.SMTPTLSImplicit:
+		conn, err := tls.DialWithDialer(dialer, "tcp", addr, tlsConfig)
+		if err != nil {
+			return nil, err
+		}
+		return s.newClient(conn)
+
+	case config.SMTPTLSNone, config.SMTPTLSStartTLS, config.SMTPTLSAuto, "":
+		conn, err := dialer.Dial("tcp", addr)
+		if err != nil {
+			return nil, err
+		}
+		client, err := s.newClient(conn)
+		if err != nil {
+			return nil, err
+		}
+		if mode == config.SMTPTLSNone {
+			return client, nil
+		}
+
+		// Upgrade the connection before any credentials are sent
+		// Only starttls insists on it; auto stays plain with a server that doesn't offer it
+		if ok, _ := client.Extension("STARTTLS"); !ok {
+			if mode != config.SMTPTLSStartTLS {
+				s.log.Debugf("SMTP server %s doesn't offer STARTTLS, sending without TLS", s.cfg.SMTPHost)
+				return client, nil
+			}
+			_ = client.Close()
+			return nil, fmt.Errorf("server %s doesn't support STARTTLS", s.cfg.SMTPHost)
+		}
+		if err := client.StartTLS(tlsConfig); err != nil {
+			_ = client.Close()
+			return nil, fmt.Errorf("STARTTLS failed: %w", err)
+		}
+		return client, nil
+
+	default:
+		return nil, fmt.Errorf("unknown SMTP TLS mode %q", s.cfg.SMTPTLS)
+	}
+}
+
+// newClient starts an SMTP session on conn, closing it if that fails
+// The whole session has to finish within the configured timeout, so a stalled server doesn't block the notification
+func (s *smtpSender) newClient(conn net.Conn) (*smtp.Client, error) {
+	if err := conn.SetDeadline(time.Now().Add(s.cfg.Timeout)); err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	client, err := smtp.NewClient(conn, s.cfg.SMTPHost)
+	if err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	return client, nil
+}
+
+// send delivers a formatted message to the recipients
+func (s *smtpSender) send(addr string, auth smtp.Auth, from string, to []string, msg []byte) error {
+	client, err := s.dial(addr)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := client.Close(); err != nil {
+			s.log.Debugf("Failed to close SMTP connection: %v", err)
+		}
+	}()
+
+	if auth != nil {
+		if err := client.Auth(auth); err != nil {
+			return fmt.Errorf("authentication failed: %w", err)
+		}
+	}
+
+	if err := client.Mail(from); err != nil {
+		return err
+	}
+	for _, rcpt := range to {
+		if err := client.Rcpt(rcpt); err != nil {
+			return err
+		}
+	}
+
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(msg); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+
+	return client.Quit()
+}
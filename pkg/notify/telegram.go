@@ -0,0 +1,53 @@
+package notify
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+
+	"github.com/mallocator/domain-checker/pkg/metrics"
+)
+
+// telegramMessage is the request body for the Bot API sendMessage method
+type telegramMessage struct {
+	ChatID string `json:"chat_id"`
+	Text   string `json:"text"`
+}
+
+// sendTelegram posts the message to the configured Telegram chat
+func (n *Notifier) sendTelegram(domain, message string) error {
+	if n.cfg.TelegramBotToken == "" || n.cfg.TelegramChatID == "" {
+		return nil
+	}
+
+	body, err := json.Marshal(telegramMessage{ChatID: n.cfg.TelegramChatID, Text: message})
+	if err != nil {
+		return fmt.Errorf("telegram: %w", err)
+	}
+
+	endpoint := fmt.Sprintf("%s/bot%s/sendMessage", n.telegramAPI, n.cfg.TelegramBotToken)
+	resp, err := n.httpClient().Post(endpoint, "application/json", bytes.NewReader(body))
+	if err != nil {
+		// The URL contains the bot token, so only report the underlying cause
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			err = urlErr.Err
+		}
+		return fmt.Errorf("telegram: %w", err)
+	}
+	defer func() {
+		if err := resp.Body.Close(); err != nil {
+			n.log.Warnf("Failed to close Telegram response: %v", err)
+		}
+	}()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("telegram: unexpected status %s", resp.Status)
+	}
+
+	n.log.Infof("Telegram notification sent successfully for %s", domain)
+	metrics.NotificationSent("telegram")
+	return nil
+}
@@ -0,0 +1,94 @@
+package notify
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/mallocator/domain-checker/pkg/config"
+	"github.com/mallocator/domain-checker/pkg/logger"
+)
+
+func TestSendTelegram(t *testing.T) {
+	var path string
+	var received telegramMessage
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path = r.URL.Path
+		if err := json.NewDecoder(r.Body).Decode(&received); err != nil {
+			t.Errorf("Failed to decode Telegram request: %v", err)
+		}
+		_, _ = w.Write([]byte(`{"ok":true}`))
+	}))
+	defer server.Close()
+
+	log := logger.New()
+	cfg := config.New(log)
+	cfg.TelegramBotToken = "123:abc"
+	cfg.TelegramChatID = "-1001234"
+
+	notifier := New(cfg, log)
+	notifier.telegramAPI = server.URL
+
+	if err := notifier.Send("example.com", "Domain example.com is now available!"); err != nil {
+		t.Fatalf("Send() returned error: %v", err)
+	}
+
+	if path != "/bot123:abc/sendMessage" {
+		t.Errorf("Expected path /bot123:abc/sendMessage, got %q", path)
+	}
+	if received.ChatID != "-1001234" {
+		t.Errorf("Expected chat_id -1001234, got %q", received.ChatID)
+	}
+	if received.Text != "Domain example.com is now available!" {
+		t.Errorf("Expected text %q, got %q", "Domain example.com is now available!", received.Text)
+	}
+}
+
+func TestSendTelegram_HTTPError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer server.Close()
+
+	log := logger.New()
+	cfg := config.New(log)
+	cfg.TelegramBotToken = "123:abc"
+	cfg.TelegramChatID = "-1001234"
+
+	notifier := New(cfg, log)
+	notifier.telegramAPI = server.URL
+
+	if err := notifier.sendTelegram("example.com", "Test message"); err == nil {
+		t.Errorf("sendTelegram() returned nil error for a failed request")
+	}
+}
+
+func TestSendTelegram_NotConfigured(t *testing.T) {
+	log := logger.New()
+	cfg := config.New(log)
+	notifier := New(cfg, log)
+
+	if err := notifier.sendTelegram("example.com", "Test message"); err != nil {
+		t.Errorf("sendTelegram() without configuration returned error: %v", err)
+	}
+}
+
+func TestSendTelegram_HidesToken(t *testing.T) {
+	log := logger.New()
+	cfg := config.New(log)
+	cfg.TelegramBotToken = "123:secret"
+	cfg.TelegramChatID = "-1001234"
+
+	notifier := New(cfg, log)
+	notifier.telegramAPI = "http://127.0.0.1:1"
+
+	err := notifier.sendTelegram("example.com", "Test message")
+	if err == nil {
+		t.Fatalf("sendTelegram() returned nil error for an unreachable server")
+	}
+	if strings.Contains(err.Error(), "secret") {
+		t.Errorf("Error leaks the bot token: %v", err)
+	}
+}
@@ -0,0 +1,46 @@
+package state
+
+import "time"
+
+// MaxExpirationHistory caps the expiration dates kept in DomainState.ExpirationHistory
+const MaxExpirationHistory = 5
+
+// ExpirationRecord is an expiration date WHOIS reported for a domain and when it was first seen
+type ExpirationRecord struct {
+	Expiration time.Time `json:"expiration"`
+	Seen       time.Time `json:"seen,omitzero"`
+}
+
+// RecordExpiration adds an expiration date seen at the given time to the history, unless it's the
+// latest one already, dropping the oldest entries beyond MaxExpirationHistory
+// Returns the expiration date known before, zero if there was none
+func (s *DomainState) RecordExpiration(expiration, seen time.Time) time.Time {
+	// States saved before the history existed start it with their expiration date, seen at an unknown time
+	if len(s.ExpirationHistory) == 0 && !s.Expiration.IsZero() {
+		s.ExpirationHistory = []ExpirationRecord{{Expiration: s.Expiration}}
+	}
+
+	var previous time.Time
+	if n := len(s.ExpirationHistory); n > 0 {
+		previous = s.ExpirationHistory[n-1].Expiration
+	}
+	if !previous.IsZero() && previous.Equal(expiration) {
+		return previous
+	}
+
+	s.ExpirationHistory = append(s.ExpirationHistory, ExpirationRecord{Expiration: expiration, Seen: seen})
+	if n := len(s.ExpirationHistory); n > MaxExpirationHistory {
+		s.ExpirationHistory = append([]ExpirationRecord(nil), s.ExpirationHistory[n-MaxExpirationHistory:]...)
+	}
+	return previous
+}
+
+// LastRenewal returns when the expiration date was last seen moving forward, zero if it never was
+func (s *DomainState) LastRenewal() time.Time {
+	for i := len(s.ExpirationHistory) - 1; i > 0; i-- {
+		if s.ExpirationHistory[i].Expiration.After(s.ExpirationHistory[i-1].Expiration) {
+			return s.ExpirationHistory[i].Seen
+		}
+	}
+	return time.Time{}
+}
@@ -0,0 +1,90 @@
+package state
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/mallocator/domain-checker/pkg/config"
+	"github.com/mallocator/domain-checker/pkg/logger"
+)
+
+func TestRecordExpiration(t *testing.T) {
+	day := func(n int) time.Time { return time.Date(2025, 1, n, 0, 0, 0, 0, time.UTC) }
+	var st DomainState
+
+	if previous := st.RecordExpiration(day(10), day(1)); !previous.IsZero() {
+		t.Errorf("Expected no previous expiration for an empty history, got %s", previous)
+	}
+	// The same date again isn't a change
+	if previous := st.RecordExpiration(day(10), day(2)); !previous.Equal(day(10)) || len(st.ExpirationHistory) != 1 {
+		t.Errorf("Expected the unchanged date to be skipped, got %s and %+v", previous, st.ExpirationHistory)
+	}
+	if !st.LastRenewal().IsZero() {
+		t.Errorf("Expected no renewal yet, got %s", st.LastRenewal())
+	}
+
+	// Renewed, then shortened
+	if previous := st.RecordExpiration(day(20), day(3)); !previous.Equal(day(10)) {
+		t.Errorf("Expected the previous expiration %s, got %s", day(10), previous)
+	}
+	if previous := st.RecordExpiration(day(15), day(4)); !previous.Equal(day(20)) {
+		t.Errorf("Expected the previous expiration %s, got %s", day(20), previous)
+	}
+	if got := st.LastRenewal(); !got.Equal(day(3)) {
+		t.Errorf("Expected the renewal seen on %s, got %s", day(3), got)
+	}
+
+	// Only the latest entries are kept
+	for n := 21; n < 31; n++ {
+		st.RecordExpiration(day(n), day(n))
+	}
+	if len(st.ExpirationHistory) != MaxExpirationHistory {
+		t.Fatalf("Expected %d entries, got %d", MaxExpirationHistory, len(st.ExpirationHistory))
+	}
+	if first, last := st.ExpirationHistory[0], st.ExpirationHistory[MaxExpirationHistory-1]; !first.Expiration.Equal(day(26)) || !last.Expiration.Equal(day(30)) {
+		t.Errorf("Expected the entries from %s to %s, got %+v", day(26), day(30), st.ExpirationHistory)
+	}
+}
+
+func TestRecordExpiration_WithoutHistory(t *testing.T) {
+	old := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	renewed := old.AddDate(1, 0, 0)
+	seen := time.Date(2025, 1, 2, 0, 0, 0, 0, time.UTC)
+
+	// A state saved before the history existed only has its expiration date
+	st := DomainState{Expiration: old}
+	if previous := st.RecordExpiration(renewed, seen); !previous.Equal(old) {
+		t.Errorf("Expected the previous expiration %s, got %s", old, previous)
+	}
+	if len(st.ExpirationHistory) != 2 || !st.ExpirationHistory[0].Expiration.Equal(old) {
+		t.Errorf("Expected the history to start with the stored expiration, got %+v", st.ExpirationHistory)
+	}
+	if got := st.LastRenewal(); !got.Equal(seen) {
+		t.Errorf("Expected the renewal seen on %s, got %s", seen, got)
+	}
+}
+
+func TestLoad_ExpirationHistory(t *testing.T) {
+	log := logger.New()
+	cfg := config.New(log)
+	cfg.StateDir = t.TempDir()
+	manager := New(cfg, log)
+
+	// State file written before the history existed
+	legacy := `{"expiration":"2025-01-01T00:00:00Z","notified_expiry":true,"notified_available":false}`
+	if err := os.WriteFile(manager.FilePath("old.com"), []byte(legacy), 0644); err != nil {
+		t.Fatalf("failed to write legacy state: %v", err)
+	}
+	st := manager.Load("old.com")
+	if st.ExpirationHistory != nil || !st.NotifiedExpiry {
+		t.Errorf("Expected the legacy state without history, got %+v", st)
+	}
+
+	st.RecordExpiration(st.Expiration.AddDate(1, 0, 0), time.Date(2025, 1, 2, 0, 0, 0, 0, time.UTC))
+	manager.Save("old.com", st)
+	reloaded := manager.Load("old.com")
+	if len(reloaded.ExpirationHistory) != 2 || !reloaded.LastRenewal().Equal(st.LastRenewal()) {
+		t.Errorf("Expected the history to round trip, got %+v", reloaded.ExpirationHistory)
+	}
+}
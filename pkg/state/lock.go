@@ -0,0 +1,139 @@
+package state
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+)
+
+// lockPollInterval is how often a held lock is retried
+const lockPollInterval = 50 * time.Millisecond
+
+// Locker is implemented by backends that can lock a domain's state across processes
+type Locker interface {
+	// Lock blocks until the domain's state is locked and returns a function releasing it
+	Lock(domain string) (unlock func(), err error)
+}
+
+// lockPath returns the sidecar lock file path for a domain
+func (m *Manager) lockPath(domain string) string {
+	return m.FilePath(domain) + ".lock"
+}
+
+// Lock takes an advisory lock on a domain's state using a sidecar .lock file
+// Hold it around Load+Save sequences so overlapping runs don't lose each other's updates
+// It waits up to LockTimeout for the lock; a lock file older than that is considered
+// left behind by a crashed run and is taken over
+// While held, the lock file is touched regularly, so a check running longer than LockTimeout keeps it
+// The file holds an owner token, so neither unlocking nor a takeover removes a lock another run holds
+func (m *Manager) Lock(domain string) (func(), error) {
+	path := m.lockPath(domain)
+	deadline := time.Now().Add(m.cfg.LockTimeout)
+	owner := fmt.Sprintf("%d %s\n", os.Getpid(), rand.Text())
+
+	for {
+		f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+		if err == nil {
+			_, err := f.WriteString(owner)
+			if closeErr := f.Close(); err == nil {
+				err = closeErr
+			}
+			if err != nil {
+				_ = os.Remove(path)
+				return nil, fmt.Errorf("write lock %s: %w", path, err)
+			}
+
+			stop := m.keepLock(path, owner)
+			return func() {
+				stop()
+				removed, err := m.removeLock(path, owner)
+				if err != nil {
+					m.log.Warnf("Failed to release lock %s: %v", path, err)
+				} else if !removed {
+					m.log.Warnf("Lock %s was taken over by another run before it was released", path)
+				}
+			}, nil
+		}
+		if !os.IsExist(err) {
+			return nil, fmt.Errorf("create lock %s: %w", path, err)
+		}
+
+		// Break locks that have been held for longer than anyone should need them
+		if stale, err := os.ReadFile(path); err == nil {
+			if info, err := os.Stat(path); err == nil && time.Since(info.ModTime()) > m.cfg.LockTimeout {
+				m.log.Warnf("Removing stale lock %s", path)
+				if _, err := m.removeLock(path, string(stale)); err != nil {
+					return nil, fmt.Errorf("remove stale lock %s: %w", path, err)
+				}
+				continue
+			}
+		}
+
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("timed out waiting for lock %s", path)
+		}
+		time.Sleep(lockPollInterval)
+	}
+}
+
+// keepLock refreshes the modification time of a held lock file every third of LockTimeout,
+// so other runs don't take it over as stale while it's in use
+// The returned function stops the refreshing and waits for it to finish
+func (m *Manager) keepLock(path, owner string) func() {
+	interval := m.cfg.LockTimeout / 3
+	if interval <= 0 {
+		return func() {}
+	}
+
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				// Leave a lock another run has taken over alone
+				if data, err := os.ReadFile(path); err != nil || string(data) != owner {
+					continue
+				}
+				now := time.Now()
+				if err := os.Chtimes(path, now, now); err != nil && !os.IsNotExist(err) {
+					m.log.Warnf("Failed to refresh lock %s: %v", path, err)
+				}
+			}
+		}
+	}()
+
+	return func() {
+		close(done)
+		<-stopped
+	}
+}
+
+// removeLock removes a lock file if it still belongs to owner and reports whether it did
+// The file is renamed away before it's checked, so a lock taken by another run in the meantime is put back rather than removed
+func (m *Manager) removeLock(path, owner string) (bool, error) {
+	moved := path + "." + rand.Text()
+	if err := os.Rename(path, moved); err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		return false, err
+	}
+
+	data, err := os.ReadFile(moved)
+	if err != nil || string(data) != owner {
+		// Someone else's lock; restoring it fails only if yet another run has locked in the meantime
+		if err := os.Link(moved, path); err != nil {
+			m.log.Warnf("Failed to restore lock %s: %v", path, err)
+		}
+		return false, errors.Join(err, os.Remove(moved))
+	}
+	return true, os.Remove(moved)
+}
@@ -0,0 +1,157 @@
+package state
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mallocator/domain-checker/pkg/config"
+	"github.com/mallocator/domain-checker/pkg/logger"
+)
+
+func TestLock_ConcurrentUpdates(t *testing.T) {
+	log := logger.New()
+	cfg := config.New(log)
+	cfg.StateDir = t.TempDir()
+	manager := New(cfg, log)
+
+	const perWorker = 20
+	domain := "test.com"
+
+	// Two workers each record their own events; without the lock some updates get lost
+	var wg sync.WaitGroup
+	for w := 0; w < 2; w++ {
+		wg.Add(1)
+		go func(worker int) {
+			defer wg.Done()
+			for i := 0; i < perWorker; i++ {
+				unlock, err := manager.Lock(domain)
+				if err != nil {
+					t.Errorf("Lock() returned error: %v", err)
+					return
+				}
+				st := manager.Load(domain)
+				if st.LastNotified == nil {
+					st.LastNotified = make(map[string]time.Time)
+				}
+				st.LastNotified[fmt.Sprintf("worker%d-%d", worker, i)] = time.Now()
+				manager.Save(domain, st)
+				unlock()
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	if got := len(manager.Load(domain).LastNotified); got != 2*perWorker {
+		t.Errorf("Expected %d recorded events, got %d", 2*perWorker, got)
+	}
+	if _, err := os.Stat(manager.lockPath(domain)); !os.IsNotExist(err) {
+		t.Errorf("Expected lock file to be released, got %v", err)
+	}
+}
+
+func TestLock_Timeout(t *testing.T) {
+	log := logger.New()
+	cfg := config.New(log)
+	cfg.StateDir = t.TempDir()
+	cfg.LockTimeout = 200 * time.Millisecond
+	manager := New(cfg, log)
+
+	unlock, err := manager.Lock("test.com")
+	if err != nil {
+		t.Fatalf("Lock() returned error: %v", err)
+	}
+	defer unlock()
+
+	// Keep the held lock fresh so it isn't considered stale
+	if err := os.Chtimes(manager.lockPath("test.com"), time.Now().Add(time.Hour), time.Now().Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Now()
+	if _, err := manager.Lock("test.com"); err == nil {
+		t.Errorf("Lock() on a held lock returned nil error")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Lock() took %s to time out, want about %s", elapsed, cfg.LockTimeout)
+	}
+}
+
+func TestLock_Refreshed(t *testing.T) {
+	log := logger.New()
+	cfg := config.New(log)
+	cfg.StateDir = t.TempDir()
+	cfg.LockTimeout = 150 * time.Millisecond
+	manager := New(cfg, log)
+
+	unlock, err := manager.Lock("test.com")
+	if err != nil {
+		t.Fatalf("Lock() returned error: %v", err)
+	}
+	defer unlock()
+
+	// A check running well past LockTimeout still holds the lock
+	time.Sleep(3 * cfg.LockTimeout)
+	if _, err := manager.Lock("test.com"); err == nil {
+		t.Errorf("Lock() took over a lock that is still held")
+	}
+}
+
+func TestLock_Stale(t *testing.T) {
+	log := logger.New()
+	cfg := config.New(log)
+	cfg.StateDir = t.TempDir()
+	cfg.LockTimeout = time.Second
+	manager := New(cfg, log)
+
+	// Lock file left behind by a crashed run
+	path := manager.lockPath("test.com")
+	if err := os.WriteFile(path, []byte("12345\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	old := time.Now().Add(-time.Hour)
+	if err := os.Chtimes(path, old, old); err != nil {
+		t.Fatal(err)
+	}
+
+	unlock, err := manager.Lock("test.com")
+	if err != nil {
+		t.Fatalf("Lock() didn't take over a stale lock: %v", err)
+	}
+	if data, err := os.ReadFile(path); err != nil || string(data) == "12345\n" {
+		t.Errorf("Expected the lock to have a new owner, got %q (%v)", data, err)
+	}
+	unlock()
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("Expected the lock to be released, got %v", err)
+	}
+}
+
+func TestLock_TakenOver(t *testing.T) {
+	log := logger.New()
+	cfg := config.New(log)
+	cfg.StateDir = t.TempDir()
+	manager := New(cfg, log)
+
+	unlock, err := manager.Lock("test.com")
+	if err != nil {
+		t.Fatalf("Lock() returned error: %v", err)
+	}
+
+	// Another run took the lock over, e.g. because this one stalled for longer than LockTimeout
+	path := manager.lockPath("test.com")
+	if err := os.WriteFile(path, []byte("12345 other\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	unlock()
+	if data, err := os.ReadFile(path); err != nil || string(data) != "12345 other\n" {
+		t.Errorf("Expected the other run's lock to be left in place, got %q (%v)", data, err)
+	}
+	if files, _ := filepath.Glob(path + ".*"); len(files) != 0 {
+		t.Errorf("Expected no leftover lock files, got %v", files)
+	}
+}
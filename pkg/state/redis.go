@@ -0,0 +1,190 @@
+package state
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	"github.com/mallocator/domain-checker/pkg/config"
+	"github.com/mallocator/domain-checker/pkg/logger"
+)
+
+// redisKeyPrefix namespaces state keys so the database can be shared with other apps
+const redisKeyPrefix = "domain-checker:state:"
+
+// RedisBackend stores domain state as JSON blobs in Redis, shared between replicas
+type RedisBackend struct {
+	cfg    *config.Config
+	log    *logger.Logger
+	client *redis.Client
+}
+
+// NewRedis connects to the Redis server at RedisAddr
+func NewRedis(cfg *config.Config, log *logger.Logger) (*RedisBackend, error) {
+	client := redis.NewClient(&redis.Options{
+		Addr:     cfg.RedisAddr,
+		Password: cfg.RedisPassword,
+		DB:       cfg.RedisDB,
+	})
+
+	b := &RedisBackend{
+		cfg:    cfg,
+		log:    log,
+		client: client,
+	}
+
+	ctx, cancel := b.context()
+	defer cancel()
+	if err := client.Ping(ctx).Err(); err != nil {
+		_ = client.Close()
+		return nil, fmt.Errorf("connect to redis %s: %w", cfg.RedisAddr, err)
+	}
+
+	return b, nil
+}
+
+// context returns a context bounded by the configured timeout
+func (b *RedisBackend) context() (context.Context, context.CancelFunc) {
+	if b.cfg.Timeout <= 0 {
+		return context.WithCancel(context.Background())
+	}
+	return context.WithTimeout(context.Background(), b.cfg.Timeout)
+}
+
+// key returns the Redis key for a domain
+func (b *RedisBackend) key(domain string) string {
+	return redisKeyPrefix + domain
+}
+
+// Load reads state for a domain, logs errors
+// A missing key yields a zero state, like a missing state file
+func (b *RedisBackend) Load(domain string) DomainState {
+	ctx, cancel := b.context()
+	defer cancel()
+
+	var st DomainState
+	data, err := b.client.Get(ctx, b.key(domain)).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return st
+	}
+	if err != nil {
+		b.log.Warnf("Read state error for %s: %v", domain, err)
+		return st
+	}
+	if err := json.Unmarshal(data, &st); err != nil {
+		b.log.Warnf("Parse state error for %s: %v", domain, err)
+	}
+	return st
+}
+
+// Save writes the state for a domain, expiring it after RedisTTL if set
+func (b *RedisBackend) Save(domain string, st DomainState) {
+	st.Domain = domain
+	data, err := json.Marshal(st)
+	if err != nil {
+		b.log.Errorf("Marshal state error for %s: %v", domain, err)
+		return
+	}
+
+	ctx, cancel := b.context()
+	defer cancel()
+	if err := b.client.Set(ctx, b.key(domain), data, b.cfg.RedisTTL).Err(); err != nil {
+		b.log.Warnf("Write state error for %s: %v", domain, err)
+	}
+}
+
+// Update loads, modifies and saves the state for a domain in a transaction that only succeeds if no other
+// client changed the state in between, retrying otherwise until the timeout
+func (b *RedisBackend) Update(domain string, fn func(*DomainState) bool) error {
+	ctx, cancel := b.context()
+	defer cancel()
+
+	key := b.key(domain)
+	txf := func(tx *redis.Tx) error {
+		var st DomainState
+		data, err := tx.Get(ctx, key).Bytes()
+		switch {
+		case errors.Is(err, redis.Nil):
+		case err != nil:
+			return err
+		default:
+			if err := json.Unmarshal(data, &st); err != nil {
+				b.log.Warnf("Parse state error for %s: %v", domain, err)
+			}
+		}
+
+		if !fn(&st) {
+			return nil
+		}
+		st.Domain = domain
+		if data, err = json.Marshal(st); err != nil {
+			return fmt.Errorf("marshal: %w", err)
+		}
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			return pipe.Set(ctx, key, data, b.cfg.RedisTTL).Err()
+		})
+		return err
+	}
+
+	for {
+		err := b.client.Watch(ctx, txf, key)
+		if !errors.Is(err, redis.TxFailedErr) {
+			return err
+		}
+		if ctx.Err() != nil {
+			return fmt.Errorf("state of %s kept changing: %w", domain, err)
+		}
+	}
+}
+
+// Cleanup deletes keys for domains not in the current domain list, once kept for CleanupRetention
+func (b *RedisBackend) Cleanup(now time.Time) {
+	domains, err := b.List()
+	if err != nil {
+		b.log.Warnf("Could not list redis state: %v", err)
+		return
+	}
+
+	keep := make(map[string]struct{}, len(b.cfg.Domains))
+	for _, d := range b.cfg.NormalizedDomainNames() {
+		keep[d] = struct{}{}
+	}
+
+	for _, domain := range domains {
+		if _, ok := keep[domain]; ok || keepOrphan(b, b.cfg, b.log, domain, now) {
+			continue
+		}
+
+		ctx, cancel := b.context()
+		err := b.client.Del(ctx, b.key(domain)).Err()
+		cancel()
+		if err != nil {
+			b.log.Warnf("Failed to remove stale state for %s: %v", domain, err)
+		} else {
+			b.log.Infof("Removed stale state for %s", domain)
+		}
+	}
+}
+
+// List returns the domains with a state key
+func (b *RedisBackend) List() ([]string, error) {
+	ctx, cancel := b.context()
+	defer cancel()
+
+	var domains []string
+	iter := b.client.Scan(ctx, 0, redisKeyPrefix+"*", 100).Iterator()
+	for iter.Next(ctx) {
+		domains = append(domains, strings.TrimPrefix(iter.Val(), redisKeyPrefix))
+	}
+	return domains, iter.Err()
+}
+
+// Close closes the Redis connection
+func (b *RedisBackend) Close() error {
+	return b.client.Close()
+}
@@ -0,0 +1,100 @@
+package state
+
+import (
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+
+	"github.com/mallocator/domain-checker/pkg/config"
+	"github.com/mallocator/domain-checker/pkg/logger"
+)
+
+func TestRedisLoadSave(t *testing.T) {
+	server := miniredis.RunT(t)
+
+	log := logger.New()
+	cfg := config.New(log)
+	cfg.RedisAddr = server.Addr()
+	cfg.RedisTTL = time.Hour
+
+	backend, err := NewRedis(cfg, log)
+	if err != nil {
+		t.Fatalf("NewRedis() returned error: %v", err)
+	}
+	defer func() {
+		if err := backend.Close(); err != nil {
+			t.Errorf("Close() returned error: %v", err)
+		}
+	}()
+
+	// Missing keys load as a zero state
+	if st := backend.Load("missing.com"); st.NotifiedAvailable || !st.Expiration.IsZero() {
+		t.Errorf("Load of missing domain = %+v, want zero state", st)
+	}
+
+	exp := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	backend.Save("test.com", DomainState{Expiration: exp, NotifiedExpiry: true})
+
+	st := backend.Load("test.com")
+	if !st.Expiration.Equal(exp) || !st.NotifiedExpiry {
+		t.Errorf("Load = %+v, want saved state", st)
+	}
+
+	if !server.Exists("domain-checker:state:test.com") {
+		t.Errorf("Expected key domain-checker:state:test.com to exist")
+	}
+	if ttl := server.TTL("domain-checker:state:test.com"); ttl != time.Hour {
+		t.Errorf("Expected key TTL of 1h, got %s", ttl)
+	}
+}
+
+func TestRedisCleanup(t *testing.T) {
+	server := miniredis.RunT(t)
+
+	log := logger.New()
+	cfg := config.New(log)
+	cfg.RedisAddr = server.Addr()
+	cfg.Domains = []config.DomainEntry{{Name: "example.com"}, {Name: "test.com"}}
+
+	backend, err := NewRedis(cfg, log)
+	if err != nil {
+		t.Fatalf("NewRedis() returned error: %v", err)
+	}
+	defer func() {
+		if err := backend.Close(); err != nil {
+			t.Errorf("Close() returned error: %v", err)
+		}
+	}()
+
+	for _, domain := range []string{"example.com", "test.com", "other.com"} {
+		backend.Save(domain, DomainState{NotifiedAvailable: true})
+	}
+
+	// Keys from other applications must survive
+	if err := server.Set("unrelated:key", "value"); err != nil {
+		t.Fatal(err)
+	}
+
+	backend.Cleanup(time.Now())
+
+	if server.Exists("domain-checker:state:other.com") {
+		t.Errorf("Expected stale key for other.com to be removed")
+	}
+	for _, key := range []string{"domain-checker:state:example.com", "domain-checker:state:test.com", "unrelated:key"} {
+		if !server.Exists(key) {
+			t.Errorf("Expected key %s to still exist", key)
+		}
+	}
+}
+
+func TestNewRedis_Unreachable(t *testing.T) {
+	log := logger.New()
+	cfg := config.New(log)
+	cfg.RedisAddr = "127.0.0.1:1"
+	cfg.Timeout = time.Second
+
+	if _, err := NewRedis(cfg, log); err == nil {
+		t.Errorf("NewRedis() returned nil error for an unreachable server")
+	}
+}
@@ -0,0 +1,221 @@
+package state
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+
+	_ "modernc.org/sqlite" // registers the pure Go "sqlite" driver
+
+	"github.com/mallocator/domain-checker/pkg/config"
+	"github.com/mallocator/domain-checker/pkg/logger"
+)
+
+// sqliteSchema creates the state table
+// The full state is kept as JSON; the other columns make it easy to query by hand
+const sqliteSchema = `CREATE TABLE IF NOT EXISTS domain_state (
+	domain             TEXT PRIMARY KEY,
+	expiration         TIMESTAMP,
+	notified_expiry    BOOLEAN NOT NULL DEFAULT 0,
+	notified_available BOOLEAN NOT NULL DEFAULT 0,
+	last_checked       TIMESTAMP,
+	state              TEXT NOT NULL
+)`
+
+// SQLiteBackend stores domain state in a SQLite database
+type SQLiteBackend struct {
+	cfg *config.Config
+	log *logger.Logger
+	db  *sql.DB
+}
+
+// NewSQLite opens the SQLite database at StateDSN, or state.db in StateDir if unset
+func NewSQLite(cfg *config.Config, log *logger.Logger) (*SQLiteBackend, error) {
+	dsn := cfg.StateDSN
+	if dsn == "" {
+		dsn = filepath.Join(cfg.StateDir, "state.db")
+	}
+
+	// Wait for other processes writing the database rather than failing right away
+	sep := "?"
+	if strings.Contains(dsn, "?") {
+		sep = "&"
+	}
+	db, err := sql.Open("sqlite", fmt.Sprintf("%s%s_pragma=busy_timeout(%d)", dsn, sep, cfg.LockTimeout.Milliseconds()))
+	if err != nil {
+		return nil, fmt.Errorf("open sqlite state %s: %w", dsn, err)
+	}
+	if _, err := db.Exec(sqliteSchema); err != nil {
+		_ = db.Close()
+		return nil, fmt.Errorf("create sqlite schema: %w", err)
+	}
+
+	return &SQLiteBackend{
+		cfg: cfg,
+		log: log,
+		db:  db,
+	}, nil
+}
+
+// Load reads state for a domain, logs errors
+func (b *SQLiteBackend) Load(domain string) DomainState {
+	var st DomainState
+	var data string
+	err := b.db.QueryRow(`SELECT state FROM domain_state WHERE domain = ?`, domain).Scan(&data)
+	if err == sql.ErrNoRows {
+		return st
+	}
+	if err != nil {
+		b.log.Warnf("Read state error for %s: %v", domain, err)
+		return st
+	}
+	if err := json.Unmarshal([]byte(data), &st); err != nil {
+		b.log.Warnf("Parse state error for %s: %v", domain, err)
+	}
+	return st
+}
+
+// Save writes the state row for a domain
+func (b *SQLiteBackend) Save(domain string, st DomainState) {
+	if err := b.write(context.Background(), b.db, domain, st); err != nil {
+		b.log.Warnf("Write state error for %s: %v", domain, err)
+	}
+}
+
+// execer runs statements on the database or a single connection
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+}
+
+// write upserts the state row for a domain
+func (b *SQLiteBackend) write(ctx context.Context, db execer, domain string, st DomainState) error {
+	st.Domain = domain
+	data, err := json.Marshal(st)
+	if err != nil {
+		return fmt.Errorf("marshal: %w", err)
+	}
+
+	_, err = db.ExecContext(ctx, `INSERT INTO domain_state (domain, expiration, notified_expiry, notified_available, last_checked, state)
+		VALUES (?, ?, ?, ?, ?, ?)
+		ON CONFLICT(domain) DO UPDATE SET
+			expiration = excluded.expiration,
+			notified_expiry = excluded.notified_expiry,
+			notified_available = excluded.notified_available,
+			last_checked = excluded.last_checked,
+			state = excluded.state`,
+		domain, nullTime(st.Expiration), st.NotifiedExpiry, st.NotifiedAvailable, nullTime(st.LastChecked), string(data))
+	return err
+}
+
+// Update loads, modifies and saves the state row for a domain in a single transaction
+// The transaction takes the database's write lock up front, so concurrent updates from other processes wait
+// for it, up to LockTimeout, instead of working on the same state
+func (b *SQLiteBackend) Update(domain string, fn func(*DomainState) bool) (err error) {
+	ctx := context.Background()
+	conn, err := b.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if cerr := conn.Close(); cerr != nil {
+			b.log.Warnf("Failed to close sqlite connection: %v", cerr)
+		}
+	}()
+
+	if _, err := conn.ExecContext(ctx, fmt.Sprintf("PRAGMA busy_timeout = %d", b.cfg.LockTimeout.Milliseconds())); err != nil {
+		return err
+	}
+	if _, err := conn.ExecContext(ctx, "BEGIN IMMEDIATE"); err != nil {
+		return fmt.Errorf("begin: %w", err)
+	}
+	defer func() {
+		if err != nil {
+			if _, rerr := conn.ExecContext(ctx, "ROLLBACK"); rerr != nil {
+				b.log.Warnf("Failed to roll back state update for %s: %v", domain, rerr)
+			}
+		}
+	}()
+
+	var st DomainState
+	var data string
+	err = conn.QueryRowContext(ctx, `SELECT state FROM domain_state WHERE domain = ?`, domain).Scan(&data)
+	switch {
+	case err == sql.ErrNoRows:
+	case err != nil:
+		return err
+	default:
+		if err := json.Unmarshal([]byte(data), &st); err != nil {
+			b.log.Warnf("Parse state error for %s: %v", domain, err)
+		}
+	}
+
+	if fn(&st) {
+		if err := b.write(ctx, conn, domain, st); err != nil {
+			return err
+		}
+	}
+	_, err = conn.ExecContext(ctx, "COMMIT")
+	return err
+}
+
+// Cleanup deletes rows for domains not in the current domain list, once kept for CleanupRetention
+func (b *SQLiteBackend) Cleanup(now time.Time) {
+	domains, err := b.List()
+	if err != nil {
+		b.log.Warnf("Could not list sqlite state: %v", err)
+		return
+	}
+
+	keep := make(map[string]struct{}, len(b.cfg.Domains))
+	for _, d := range b.cfg.NormalizedDomainNames() {
+		keep[d] = struct{}{}
+	}
+
+	for _, domain := range domains {
+		if _, ok := keep[domain]; ok || keepOrphan(b, b.cfg, b.log, domain, now) {
+			continue
+		}
+		if _, err := b.db.Exec(`DELETE FROM domain_state WHERE domain = ?`, domain); err != nil {
+			b.log.Warnf("Failed to remove stale state for %s: %v", domain, err)
+		} else {
+			b.log.Infof("Removed stale state for %s", domain)
+		}
+	}
+}
+
+// List returns the domains with a state row
+func (b *SQLiteBackend) List() ([]string, error) {
+	rows, err := b.db.Query(`SELECT domain FROM domain_state ORDER BY domain`)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			b.log.Warnf("Failed to close sqlite rows: %v", err)
+		}
+	}()
+
+	var domains []string
+	for rows.Next() {
+		var domain string
+		if err := rows.Scan(&domain); err != nil {
+			return nil, err
+		}
+		domains = append(domains, domain)
+	}
+	return domains, rows.Err()
+}
+
+// Close closes the database
+func (b *SQLiteBackend) Close() error {
+	return b.db.Close()
+}
+
+// nullTime stores zero times as NULL
+func nullTime(t time.Time) sql.NullTime {
+	return sql.NullTime{Time: t, Valid: !t.IsZero()}
+}
@@ -0,0 +1,121 @@
+package state
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/mallocator/domain-checker/pkg/config"
+	"github.com/mallocator/domain-checker/pkg/logger"
+)
+
+func TestSQLiteLoadSave(t *testing.T) {
+	log := logger.New()
+	cfg := config.New(log)
+
+	tmpDir, err := os.MkdirTemp("", "sqlite_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := os.RemoveAll(tmpDir); err != nil {
+			t.Errorf("failed to remove temp directory: %v", err)
+		}
+	}()
+
+	cfg.StateDir = tmpDir
+	backend, err := NewSQLite(cfg, log)
+	if err != nil {
+		t.Fatalf("NewSQLite() returned error: %v", err)
+	}
+	defer func() {
+		if err := backend.Close(); err != nil {
+			t.Errorf("Close() returned error: %v", err)
+		}
+	}()
+
+	// Missing domains load as a zero state
+	if st := backend.Load("missing.com"); st.NotifiedAvailable || !st.Expiration.IsZero() {
+		t.Errorf("Load of missing domain = %+v, want zero state", st)
+	}
+
+	exp := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	backend.Save("test.com", DomainState{Expiration: exp, NotifiedExpiry: true, NotifiedStatus: "pendingDelete"})
+
+	// Saving again updates the existing row
+	st := backend.Load("test.com")
+	st.NotifiedAvailable = true
+	backend.Save("test.com", st)
+
+	st = backend.Load("test.com")
+	if !st.Expiration.Equal(exp) || !st.NotifiedExpiry || !st.NotifiedAvailable || st.NotifiedStatus != "pendingDelete" {
+		t.Errorf("Load = %+v, want saved state", st)
+	}
+
+	if _, err := os.Stat(filepath.Join(tmpDir, "state.db")); err != nil {
+		t.Errorf("Expected default database in state dir: %v", err)
+	}
+}
+
+func TestSQLiteCleanup(t *testing.T) {
+	log := logger.New()
+	cfg := config.New(log)
+	cfg.StateDSN = filepath.Join(t.TempDir(), "custom.db")
+	cfg.Domains = []config.DomainEntry{{Name: "example.com"}, {Name: " test.com"}}
+
+	backend, err := NewSQLite(cfg, log)
+	if err != nil {
+		t.Fatalf("NewSQLite() returned error: %v", err)
+	}
+	defer func() {
+		if err := backend.Close(); err != nil {
+			t.Errorf("Close() returned error: %v", err)
+		}
+	}()
+
+	for _, domain := range []string{"example.com", "test.com", "other.com"} {
+		backend.Save(domain, DomainState{NotifiedAvailable: true})
+	}
+
+	backend.Cleanup(time.Now())
+
+	domains, err := backend.List()
+	if err != nil {
+		t.Fatalf("List() returned error: %v", err)
+	}
+	if len(domains) != 2 || domains[0] != "example.com" || domains[1] != "test.com" {
+		t.Errorf("List() after Cleanup = %v, want [example.com test.com]", domains)
+	}
+}
+
+func TestOpen(t *testing.T) {
+	log := logger.New()
+	cfg := config.New(log)
+	cfg.StateDir = t.TempDir()
+
+	backend, err := Open(cfg, log)
+	if err != nil {
+		t.Fatalf("Open() with the default backend returned error: %v", err)
+	}
+	if _, ok := backend.(*Manager); !ok {
+		t.Errorf("Open() default backend = %T, want *Manager", backend)
+	}
+
+	cfg.StateBackend = BackendSQLite
+	backend, err = Open(cfg, log)
+	if err != nil {
+		t.Fatalf("Open() with the sqlite backend returned error: %v", err)
+	}
+	if _, ok := backend.(*SQLiteBackend); !ok {
+		t.Errorf("Open() sqlite backend = %T, want *SQLiteBackend", backend)
+	}
+	if err := backend.Close(); err != nil {
+		t.Errorf("Close() returned error: %v", err)
+	}
+
+	cfg.StateBackend = "bogus"
+	if _, err := Open(cfg, log); err == nil {
+		t.Errorf("Open() with an unknown backend returned nil error")
+	}
+}
@@ -2,7 +2,10 @@
 package state
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"fmt"
 	"os"
 	"path/filepath"
 	"strings"
@@ -12,19 +15,130 @@ import (
 	"github.com/mallocator/domain-checker/pkg/logger"
 )
 
+// Supported state backends
+const (
+	BackendFile   = "file"   // one JSON file per domain in StateDir
+	BackendSQLite = "sqlite" // single SQLite database at StateDSN
+	BackendRedis  = "redis"  // Redis server at RedisAddr, shared between replicas
+)
+
+// Backend persists domain state
+type Backend interface {
+	// Load returns the state for a domain, or a zero state if there is none
+	Load(domain string) DomainState
+	// Save stores the state for a domain
+	Save(domain string, st DomainState)
+	// Cleanup removes state for domains no longer in the configuration, judging CleanupRetention at now
+	Cleanup(now time.Time)
+	// List returns the domains with stored state
+	List() ([]string, error)
+	// Close releases resources held by the backend
+	Close() error
+}
+
+// keepOrphan reports whether Cleanup keeps the state of a domain that's no longer configured
+// The first Cleanup to find it marks it orphaned, and it's kept until CleanupRetention has passed since
+// The state of domains matching ExcludeDomains is always kept, since they're only skipped for now
+func keepOrphan(b Backend, cfg *config.Config, log *logger.Logger, domain string, now time.Time) bool {
+	if cfg.Excluded(domain) {
+		return true
+	}
+	if cfg.CleanupRetention <= 0 {
+		return false
+	}
+
+	st := b.Load(domain)
+	if st.OrphanedAt.IsZero() {
+		st.OrphanedAt = now
+		b.Save(domain, st)
+		log.Infof("Keeping state of removed domain %s for %s", domain, cfg.CleanupRetention)
+		return true
+	}
+	return now.Sub(st.OrphanedAt) < cfg.CleanupRetention
+}
+
+// Open creates the state backend selected in the configuration
+func Open(cfg *config.Config, log *logger.Logger) (Backend, error) {
+	switch cfg.StateBackend {
+	case BackendFile, "":
+		return New(cfg, log), nil
+	case BackendSQLite:
+		return NewSQLite(cfg, log)
+	case BackendRedis:
+		return NewRedis(cfg, log)
+	default:
+		return nil, fmt.Errorf("unknown state backend %q", cfg.StateBackend)
+	}
+}
+
+// Schema marks state files as written by this application
+// The part after the slash is bumped when the format changes incompatibly
+const Schema = "domain-checker/v1"
+
+// schemaPrefix identifies state files of any version
+const schemaPrefix = "domain-checker/"
+
 // DomainState holds per-domain flags and expiry
 type DomainState struct {
+	// Ownership marker, see Schema
+	Schema string `json:"_schema,omitempty"`
+
+	// Domain the state belongs to, filled in on save
+	Domain string `json:"domain,omitempty"`
+
 	// Domain expiration date
 	Expiration time.Time `json:"expiration"`
 
+	// Expiration dates seen over time, oldest first and at most MaxExpirationHistory of them
+	// Missing from state files written before it existed
+	ExpirationHistory []ExpirationRecord `json:"expiration_history,omitempty"`
+
+	// Expiration of the site's TLS certificate, used instead while WHOIS has no expiration date
+	CertExpiration time.Time `json:"cert_expiration,omitzero"`
+
 	// Whether we've already notified about expiry
 	NotifiedExpiry bool `json:"notified_expiry"`
 
+	// Threshold tiers (days before expiration) that have already been notified
+	NotifiedTiers []int `json:"notified_tiers,omitempty"`
+
+	// Whether we've already notified that the expiration date passed while the domain is still registered
+	NotifiedExpired bool `json:"notified_expired,omitempty"`
+
 	// Whether we've already notified about availability
 	NotifiedAvailable bool `json:"notified_available"`
+
+	// Consecutive checks that found the domain available, reset once it's found registered
+	AvailableStreak int `json:"available_streak,omitempty"`
+
+	// Whether we've already notified that the expiration date can't be determined
+	NotifiedUnknownExpiry bool `json:"notified_unknown_expiry,omitempty"`
+
+	// Renewal risk hints from the last WHOIS lookup, nil when the WHOIS data doesn't tell
+	TransferLocked *bool `json:"transfer_locked,omitempty"`
+	AutoRenew      *bool `json:"auto_renew,omitempty"`
+
+	// Whether a PagerDuty incident is open for the domain being within CriticalThresholdDays
+	PagerDutyTriggered bool `json:"pagerduty_triggered,omitempty"`
+
+	// Deletion status (e.g. pendingDelete) we've last notified about, empty if none
+	NotifiedStatus string `json:"notified_status,omitempty"`
+
+	// When the domain was last checked and which lookup decided the outcome (dns, whois, cert or state)
+	LastChecked time.Time `json:"last_checked,omitzero"`
+	LastSource  string    `json:"last_source,omitempty"`
+
+	// Error that stopped the last check, empty if it succeeded
+	LastError string `json:"last_error,omitempty"`
+
+	// When each event type was last notified, used to enforce the notification cooldown
+	LastNotified map[string]time.Time `json:"last_notified,omitempty"`
+
+	// When Cleanup first found the domain missing from the config, zero while it's configured
+	OrphanedAt time.Time `json:"orphaned_at,omitzero"`
 }
 
-// Manager handles domain state operations
+// Manager handles domain state operations using one JSON file per domain
 type Manager struct {
 	cfg *config.Config
 	log *logger.Logger
@@ -38,81 +152,291 @@ func New(cfg *config.Config, log *logger.Logger) *Manager {
 	}
 }
 
-// FilePath returns the JSON path for a domain
+// maxNameLength caps the readable part of state file names, keeping them within file system limits
+const maxNameLength = 200
+
+// FilePath returns the JSON path for a domain, named after FileName
 func (m *Manager) FilePath(domain string) string {
-	safe := strings.ReplaceAll(domain, ".", "_")
-	return filepath.Join(m.cfg.StateDir, safe+".json")
+	return filepath.Join(m.cfg.StateDir, FileName(domain)+".json")
+}
+
+// FileName returns the base name, without extension, of the files kept for a domain in the state dir
+// The name is the domain with anything but letters, digits and dashes replaced by underscores, for
+// readability, followed by a hash of the domain, so names that sanitize the same don't collide
+func FileName(domain string) string {
+	safe := []byte(domain)
+	for i, c := range safe {
+		if (c < 'a' || c > 'z') && (c < 'A' || c > 'Z') && (c < '0' || c > '9') && c != '-' {
+			safe[i] = '_'
+		}
+	}
+	if len(safe) > maxNameLength {
+		safe = safe[:maxNameLength]
+	}
+	sum := sha256.Sum256([]byte(domain))
+	return string(safe) + "." + hex.EncodeToString(sum[:8])
+}
+
+// legacyFilePath returns the path older versions used for a domain, which collides for names like
+// example.com and example_com
+func (m *Manager) legacyFilePath(domain string) string {
+	return filepath.Join(m.cfg.StateDir, strings.ReplaceAll(domain, ".", "_")+".json")
 }
 
 // Load reads state for a domain, logs errors
+// State in a file of an older version is moved to the current file name and schema
+// Load doesn't lock; wrap Load+Save sequences in Lock when other runs may share the state dir
 func (m *Manager) Load(domain string) DomainState {
 	path := m.FilePath(domain)
-	var st DomainState
 	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return m.loadLegacy(domain)
+	}
+
+	var st DomainState
 	if err == nil {
 		if err := json.Unmarshal(data, &st); err != nil {
 			m.log.Warnf("Parse state error for %s: %v", domain, err)
+		} else if st.Schema == "" {
+			// Files from before the schema marker are rewritten with it
+			m.log.Debugf("Migrating state file %s to %s", path, Schema)
+			m.Save(domain, st)
 		}
 	}
 	return st
 }
 
+// loadLegacy reads state for a domain from the file name older versions used and moves it to FilePath
+// A legacy file recording a different domain is left alone, it belongs to the domain it collided with
+func (m *Manager) loadLegacy(domain string) DomainState {
+	path := m.legacyFilePath(domain)
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		// Versions before names were normalized used the name as configured, e.g. Example_com.json
+		if path = m.findLegacyFile(filepath.Base(path)); path != "" {
+			data, err = os.ReadFile(path)
+		}
+	}
+	if err != nil {
+		return DomainState{}
+	}
+
+	var st DomainState
+	if err := json.Unmarshal(data, &st); err != nil {
+		m.log.Warnf("Parse state error for %s: %v", domain, err)
+		return DomainState{}
+	}
+	if st.Domain != "" && normalizeName(st.Domain) != domain {
+		return DomainState{}
+	}
+
+	m.log.Debugf("Migrating state file %s to %s", path, m.FilePath(domain))
+	st.Domain = domain
+	m.Save(domain, st)
+	if err := os.Remove(path); err != nil {
+		m.log.Warnf("Failed to remove migrated state file %s: %v", path, err)
+	}
+	return st
+}
+
+// findLegacyFile returns the path of a state file named like name apart from case, or "" if there is none
+func (m *Manager) findLegacyFile(name string) string {
+	files, err := os.ReadDir(m.cfg.StateDir)
+	if err != nil {
+		return ""
+	}
+	for _, f := range files {
+		if strings.EqualFold(f.Name(), name) {
+			return filepath.Join(m.cfg.StateDir, f.Name())
+		}
+	}
+	return ""
+}
+
 // Save writes state file for a domain
+// Save doesn't lock; see Lock for guarding read-modify-write sequences
 func (m *Manager) Save(domain string, st DomainState) {
+	st.Schema = Schema
+	st.Domain = domain
 	data, err := json.MarshalIndent(st, "", "  ")
 	if err != nil {
 		m.log.Errorf("Marshal state error for %s: %v", domain, err)
 		return
 	}
-	if err := os.WriteFile(m.FilePath(domain), data, 0644); err != nil {
+	if err := m.writeFileAtomic(m.FilePath(domain), data); err != nil {
 		m.log.Warnf("Write state error for %s: %v", domain, err)
 	}
 }
 
+// tempPrefix marks in-progress state writes, so orphans left by a crash can be recognized
+const tempPrefix = ".state-tmp-"
+
+// writeFileAtomic writes data to a temp file in the same directory and renames it into place
+// Readers see either the old or the new file, never a partially written one
+func (m *Manager) writeFileAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), tempPrefix+"*")
+	if err != nil {
+		return err
+	}
+	tmpPath := tmp.Name()
+
+	// Remove the temp file unless it was successfully renamed
+	renamed := false
+	defer func() {
+		if !renamed {
+			if err := os.Remove(tmpPath); err != nil && !os.IsNotExist(err) {
+				m.log.Warnf("Failed to remove temp file %s: %v", tmpPath, err)
+			}
+		}
+	}()
+
+	if _, err := tmp.Write(data); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmpPath, 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		return err
+	}
+
+	renamed = true
+	return nil
+}
+
+// List returns the domains that have a state file
+func (m *Manager) List() ([]string, error) {
+	files, err := os.ReadDir(m.cfg.StateDir)
+	if err != nil {
+		return nil, err
+	}
+
+	var domains []string
+	for _, f := range files {
+		if !strings.HasSuffix(f.Name(), ".json") {
+			continue
+		}
+
+		path := filepath.Join(m.cfg.StateDir, f.Name())
+		if !m.IsAppGeneratedFile(path) {
+			continue
+		}
+		domains = append(domains, fileDomain(path))
+	}
+
+	return domains, nil
+}
+
+// fileDomain returns the normalized domain recorded in a state file
+// Files written before the domain was recorded fall back to the name derived from their legacy file name
+func fileDomain(path string) string {
+	if data, err := os.ReadFile(path); err == nil {
+		var st DomainState
+		if json.Unmarshal(data, &st) == nil && st.Domain != "" {
+			return normalizeName(st.Domain)
+		}
+	}
+	return normalizeName(strings.ReplaceAll(strings.TrimSuffix(filepath.Base(path), ".json"), "_", "."))
+}
+
+// normalizeName returns a domain recovered from stored state as config.NormalizeDomain does for configured ones,
+// or unchanged if it isn't valid
+// Versions before names were normalized stored them as configured, e.g. Example.com
+func normalizeName(name string) string {
+	if normalized, err := config.NormalizeDomain(name); err == nil {
+		return normalized
+	}
+	return name
+}
+
+// Close is a no-op for the file backend
+func (m *Manager) Close() error {
+	return nil
+}
+
 // IsAppGeneratedFile checks if a file was generated by this application
-// by attempting to parse it as a DomainState JSON
+// Files are recognized by their schema marker; files written before the marker existed
+// must have all the fields those versions always wrote
 func (m *Manager) IsAppGeneratedFile(path string) bool {
 	data, err := os.ReadFile(path)
 	if err != nil {
 		return false
 	}
 
-	var state DomainState
-	if err := json.Unmarshal(data, &state); err != nil {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
 		return false
 	}
 
-	// Additional validation could be added here if needed
-	// For example, checking if specific fields have valid values
+	if raw, ok := fields["_schema"]; ok {
+		var schema string
+		if err := json.Unmarshal(raw, &schema); err != nil {
+			return false
+		}
+		return strings.HasPrefix(schema, schemaPrefix)
+	}
 
-	return true
+	// Legacy files without a marker
+	for _, key := range []string{"expiration", "notified_expiry", "notified_available"} {
+		if _, ok := fields[key]; !ok {
+			return false
+		}
+	}
+	var state DomainState
+	return json.Unmarshal(data, &state) == nil
 }
 
-// Cleanup removes files not in current domain list
-func (m *Manager) Cleanup() {
+// Cleanup removes files not in current domain list, once kept for CleanupRetention
+func (m *Manager) Cleanup(now time.Time) {
 	files, err := os.ReadDir(m.cfg.StateDir)
 	if err != nil {
 		m.log.Warnf("Could not read state dir %s: %v", m.cfg.StateDir, err)
 		return
 	}
-	
+
 	keep := make(map[string]struct{}, len(m.cfg.Domains))
-	for _, d := range m.cfg.Domains {
-		keep[strings.ReplaceAll(strings.TrimSpace(d), ".", "_")] = struct{}{}
+	for _, d := range m.cfg.NormalizedDomainNames() {
+		keep[d] = struct{}{}
 	}
-	
+
 	for _, f := range files {
+		// Remove temp files orphaned by an interrupted write
+		// Newer ones may belong to a write another run is doing right now
+		if strings.HasPrefix(f.Name(), tempPrefix) {
+			path := filepath.Join(m.cfg.StateDir, f.Name())
+			if info, err := f.Info(); err != nil || now.Sub(info.ModTime()) <= m.cfg.LockTimeout {
+				continue
+			}
+			if err := os.Remove(path); err != nil {
+				m.log.Warnf("Failed to remove orphaned temp file %s: %v", path, err)
+			} else {
+				m.log.Infof("Removed orphaned temp file %s", path)
+			}
+			continue
+		}
+
 		// Only process files with .json extension
 		if !strings.HasSuffix(f.Name(), ".json") {
 			continue
 		}
 
-		base := strings.TrimSuffix(f.Name(), ".json")
-		if _, ok := keep[base]; !ok {
-			path := filepath.Join(m.cfg.StateDir, f.Name())
-
+		// Match on the domain recorded in the file, whatever its name
+		path := filepath.Join(m.cfg.StateDir, f.Name())
+		domain := fileDomain(path)
+		if _, ok := keep[domain]; !ok {
 			// Verify this is a file created by our app by checking if it's a valid DomainState JSON
 			if m.IsAppGeneratedFile(path) {
+				if keepOrphan(m, m.cfg, m.log, domain, now) {
+					continue
+				}
 				if err := os.Remove(path); err != nil {
 					m.log.Warnf("Failed to remove stale %s: %v", path, err)
 				} else {
@@ -123,4 +447,4 @@ func (m *Manager) Cleanup() {
 			}
 		}
 	}
-}
\ No newline at end of file
+}
@@ -1,8 +1,11 @@
 package state
 
 import (
+	"fmt"
 	"os"
 	"path/filepath"
+	"slices"
+	"strings"
 	"testing"
 	"time"
 
@@ -104,7 +107,7 @@ func TestCleanupState(t *testing.T) {
 	cfg.StateDir = tmpDir
 
 	// Set the domains for the test
-	cfg.Domains = []string{"example.com", "test.com"}
+	cfg.Domains = []config.DomainEntry{{Name: "example.com"}, {Name: "test.com"}}
 
 	manager := New(cfg, log)
 
@@ -143,7 +146,7 @@ func TestCleanupState(t *testing.T) {
 	}
 
 	// Run the Cleanup function
-	manager.Cleanup()
+	manager.Cleanup(time.Now())
 
 	// Check that the files for domains in the config still exist
 	if _, err := os.Stat(validFile1); err != nil {
@@ -186,3 +189,438 @@ func TestCleanupState(t *testing.T) {
 		}
 	}
 }
+
+func TestLoadLegacyState(t *testing.T) {
+	log := logger.New()
+	cfg := config.New(log)
+
+	tmpDir, err := os.MkdirTemp("", "state_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := os.RemoveAll(tmpDir); err != nil {
+			t.Errorf("failed to remove temp directory: %v", err)
+		}
+	}()
+
+	cfg.StateDir = tmpDir
+	manager := New(cfg, log)
+
+	// State file written before the check metadata existed
+	legacy := `{"expiration":"2025-01-01T00:00:00Z","notified_expiry":true,"notified_available":false}`
+	if err := os.WriteFile(manager.FilePath("old.com"), []byte(legacy), 0644); err != nil {
+		t.Fatalf("failed to write legacy state: %v", err)
+	}
+
+	st := manager.Load("old.com")
+	if !st.NotifiedExpiry {
+		t.Errorf("Load NotifiedExpiry = %v, want true", st.NotifiedExpiry)
+	}
+	if !st.LastChecked.IsZero() || st.LastSource != "" || st.LastError != "" {
+		t.Errorf("Expected empty check metadata, got LastChecked=%s LastSource=%q LastError=%q",
+			st.LastChecked, st.LastSource, st.LastError)
+	}
+
+	// Round trip the new fields
+	st.LastChecked = time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC)
+	st.LastSource = "whois"
+	st.LastError = "WHOIS parse failed"
+	manager.Save("old.com", st)
+
+	reloaded := manager.Load("old.com")
+	if !reloaded.LastChecked.Equal(st.LastChecked) || reloaded.LastSource != "whois" || reloaded.LastError != "WHOIS parse failed" {
+		t.Errorf("Load = %+v, want check metadata %+v", reloaded, st)
+	}
+}
+
+func TestSaveAtomic(t *testing.T) {
+	log := logger.New()
+	cfg := config.New(log)
+
+	tmpDir, err := os.MkdirTemp("", "state_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := os.RemoveAll(tmpDir); err != nil {
+			t.Errorf("failed to remove temp directory: %v", err)
+		}
+	}()
+
+	cfg.StateDir = tmpDir
+	manager := New(cfg, log)
+
+	manager.Save("test.com", DomainState{NotifiedExpiry: true})
+	manager.Save("test.com", DomainState{NotifiedAvailable: true})
+
+	// Only the final state file should remain, no temp files
+	files, err := os.ReadDir(tmpDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := filepath.Base(manager.FilePath("test.com"))
+	if len(files) != 1 || files[0].Name() != want {
+		var names []string
+		for _, f := range files {
+			names = append(names, f.Name())
+		}
+		t.Errorf("Expected only %s in state dir, got %v", want, names)
+	}
+
+	info, err := os.Stat(manager.FilePath("test.com"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0644 {
+		t.Errorf("State file mode = %v, want 0644", info.Mode().Perm())
+	}
+
+	st := manager.Load("test.com")
+	if st.NotifiedExpiry || !st.NotifiedAvailable {
+		t.Errorf("Load = %+v, want only NotifiedAvailable", st)
+	}
+}
+
+func TestCleanupOrphanedTempFiles(t *testing.T) {
+	log := logger.New()
+	cfg := config.New(log)
+
+	tmpDir, err := os.MkdirTemp("", "cleanup_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := os.RemoveAll(tmpDir); err != nil {
+			t.Errorf("failed to remove temp directory: %v", err)
+		}
+	}()
+
+	cfg.StateDir = tmpDir
+	manager := New(cfg, log)
+
+	// Simulate a write that was interrupted before the rename
+	orphan := filepath.Join(tmpDir, tempPrefix+"12345")
+	if err := os.WriteFile(orphan, []byte(`{"expiration":`), 0600); err != nil {
+		t.Fatalf("failed to write orphan: %v", err)
+	}
+	old := time.Now().Add(-2 * cfg.LockTimeout)
+	if err := os.Chtimes(orphan, old, old); err != nil {
+		t.Fatal(err)
+	}
+
+	// And a write another run is doing right now
+	inProgress := filepath.Join(tmpDir, tempPrefix+"67890")
+	if err := os.WriteFile(inProgress, []byte(`{"expiration":`), 0600); err != nil {
+		t.Fatalf("failed to write temp file: %v", err)
+	}
+
+	manager.Cleanup(time.Now())
+
+	if _, err := os.Stat(orphan); !os.IsNotExist(err) {
+		t.Errorf("Expected orphaned temp file %q to be removed, got %v", orphan, err)
+	}
+	if _, err := os.Stat(inProgress); err != nil {
+		t.Errorf("Expected the recent temp file %q to be kept, got %v", inProgress, err)
+	}
+}
+
+func TestList(t *testing.T) {
+	log := logger.New()
+	cfg := config.New(log)
+	cfg.StateDir = t.TempDir()
+	manager := New(cfg, log)
+
+	manager.Save("my-site.com", DomainState{})
+
+	// Legacy file without the domain recorded
+	legacy := `{"expiration":"2025-01-01T00:00:00Z","notified_expiry":false,"notified_available":true}`
+	if err := os.WriteFile(filepath.Join(cfg.StateDir, "old_org.json"), []byte(legacy), 0644); err != nil {
+		t.Fatalf("failed to write legacy state: %v", err)
+	}
+
+	// Files that aren't state
+	if err := os.WriteFile(filepath.Join(cfg.StateDir, "notes.txt"), []byte("hello"), 0644); err != nil {
+		t.Fatalf("failed to write non-state file: %v", err)
+	}
+
+	domains, err := manager.List()
+	if err != nil {
+		t.Fatalf("List() returned error: %v", err)
+	}
+	if len(domains) != 2 || domains[0] != "my-site.com" || domains[1] != "old.org" {
+		t.Errorf("List() = %v, want [my-site.com old.org]", domains)
+	}
+}
+
+func TestIsAppGeneratedFile_Schema(t *testing.T) {
+	log := logger.New()
+	cfg := config.New(log)
+	cfg.StateDir = t.TempDir()
+	manager := New(cfg, log)
+
+	tests := []struct {
+		name    string
+		content string
+		want    bool
+	}{
+		{"current schema", `{"_schema":"domain-checker/v1","notified_available":true}`, true},
+		{"future schema", `{"_schema":"domain-checker/v7","some_new_field":1}`, true},
+		{"other app", `{"_schema":"other-app/v1","expiration":"2025-01-01T00:00:00Z","notified_expiry":false,"notified_available":true}`, false},
+		{"legacy state", `{"expiration":"2025-01-01T00:00:00Z","notified_expiry":false,"notified_available":true}`, true},
+		{"foreign json", `{"name":"something else"}`, false},
+		{"empty object", `{}`, false},
+		{"non-object json", `[1, 2, 3]`, false},
+	}
+	for _, tc := range tests {
+		path := filepath.Join(cfg.StateDir, "file.json")
+		if err := os.WriteFile(path, []byte(tc.content), 0644); err != nil {
+			t.Fatalf("failed to write file: %v", err)
+		}
+		if got := manager.IsAppGeneratedFile(path); got != tc.want {
+			t.Errorf("%s: IsAppGeneratedFile() = %v, want %v", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestLoadMigratesSchema(t *testing.T) {
+	log := logger.New()
+	cfg := config.New(log)
+	cfg.StateDir = t.TempDir()
+	manager := New(cfg, log)
+
+	legacy := `{"expiration":"2025-01-01T00:00:00Z","notified_expiry":true,"notified_available":false}`
+	if err := os.WriteFile(manager.FilePath("old.com"), []byte(legacy), 0644); err != nil {
+		t.Fatalf("failed to write legacy state: %v", err)
+	}
+
+	if st := manager.Load("old.com"); !st.NotifiedExpiry {
+		t.Errorf("Load NotifiedExpiry = %v, want true", st.NotifiedExpiry)
+	}
+
+	data, err := os.ReadFile(manager.FilePath("old.com"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), `"_schema": "domain-checker/v1"`) {
+		t.Errorf("Expected state file to be rewritten with the schema marker, got %s", data)
+	}
+}
+
+func TestFilePath_Collisions(t *testing.T) {
+	log := logger.New()
+	cfg := config.New(log)
+	cfg.StateDir = t.TempDir()
+	manager := New(cfg, log)
+
+	// Names the legacy scheme mapped to the same file
+	domains := []string{"example.com", "example_com", "a.b-c.com", "a-b.c.com", "a.b.c.com", "a_b.c.com"}
+	seen := make(map[string]string)
+	for _, d := range domains {
+		path := manager.FilePath(d)
+		if other, ok := seen[path]; ok {
+			t.Errorf("FilePath(%q) = FilePath(%q) = %s", d, other, path)
+		}
+		seen[path] = d
+		if path != manager.FilePath(d) {
+			t.Errorf("FilePath(%q) isn't stable", d)
+		}
+		if !strings.HasPrefix(filepath.Base(path), strings.NewReplacer(".", "_").Replace(d)+".") {
+			t.Errorf("FilePath(%q) = %s, want the sanitized domain first", d, path)
+		}
+	}
+
+	// Each domain keeps its own state
+	manager.Save("example.com", DomainState{NotifiedAvailable: true})
+	manager.Save("example_com", DomainState{NotifiedExpiry: true})
+	if st := manager.Load("example.com"); !st.NotifiedAvailable || st.NotifiedExpiry {
+		t.Errorf("Load(example.com) = %+v, want only NotifiedAvailable", st)
+	}
+
+	// Very long names stay within file system limits
+	long := strings.Repeat("a", 63) + "." + strings.Repeat("b", 63) + "." + strings.Repeat("c", 63) + "." + strings.Repeat("d", 57) + ".com"
+	if name := filepath.Base(manager.FilePath(long)); len(name) > 255 {
+		t.Errorf("FilePath() of a %d character domain is %d characters long", len(long), len(name))
+	}
+}
+
+func TestLoadMigratesFileName(t *testing.T) {
+	log := logger.New()
+	cfg := config.New(log)
+	cfg.StateDir = t.TempDir()
+	manager := New(cfg, log)
+
+	// Legacy file without the domain recorded
+	legacy := filepath.Join(cfg.StateDir, "example_com.json")
+	content := `{"expiration":"2025-01-01T00:00:00Z","notified_expiry":true,"notified_available":false}`
+	if err := os.WriteFile(legacy, []byte(content), 0644); err != nil {
+		t.Fatalf("failed to write legacy state: %v", err)
+	}
+
+	if st := manager.Load("example.com"); !st.NotifiedExpiry {
+		t.Errorf("Load NotifiedExpiry = %v, want true", st.NotifiedExpiry)
+	}
+	if _, err := os.Stat(legacy); !os.IsNotExist(err) {
+		t.Errorf("Expected legacy file to be removed, got %v", err)
+	}
+	if st := manager.Load("example.com"); !st.NotifiedExpiry || st.Domain != "example.com" {
+		t.Errorf("Expected migrated state with the domain recorded, got %+v", st)
+	}
+
+	// A legacy file recording another domain isn't taken over
+	content = `{"_schema":"domain-checker/v1","domain":"example_org","expiration":"2025-01-01T00:00:00Z","notified_expiry":true,"notified_available":false}`
+	legacy = filepath.Join(cfg.StateDir, "example_org.json")
+	if err := os.WriteFile(legacy, []byte(content), 0644); err != nil {
+		t.Fatalf("failed to write legacy state: %v", err)
+	}
+	if st := manager.Load("example.org"); st.NotifiedExpiry {
+		t.Errorf("Expected example.org to have no state, got %+v", st)
+	}
+	if _, err := os.Stat(legacy); err != nil {
+		t.Errorf("Expected the other domain's legacy file to be kept, got %v", err)
+	}
+}
+
+func TestCleanup_RecordedDomain(t *testing.T) {
+	log := logger.New()
+	cfg := config.New(log)
+	cfg.StateDir = t.TempDir()
+	cfg.Domains = []config.DomainEntry{{Name: "example.com"}}
+	manager := New(cfg, log)
+
+	// Only the configured domain survives, even though the other one sanitizes to the same name
+	manager.Save("example.com", DomainState{})
+	manager.Save("example_com", DomainState{})
+	manager.Cleanup(time.Now())
+
+	domains, err := manager.List()
+	if err != nil {
+		t.Fatalf("List() returned error: %v", err)
+	}
+	if len(domains) != 1 || domains[0] != "example.com" {
+		t.Errorf("List() after Cleanup() = %v, want [example.com]", domains)
+	}
+}
+
+// TestCleanup_MixedCase tests that state files of versions that kept names as configured match the normalized names
+func TestCleanup_MixedCase(t *testing.T) {
+	log := logger.New()
+	cfg := config.New(log)
+	cfg.StateDir = t.TempDir()
+	cfg.Domains = []config.DomainEntry{{Name: "Example.com"}}
+	manager := New(cfg, log)
+
+	configured := filepath.Join(cfg.StateDir, "Example_com.json")
+	removed := filepath.Join(cfg.StateDir, "Removed_com.json")
+	for path, domain := range map[string]string{configured: "Example.com", removed: "Removed.com"} {
+		content := `{"_schema":"domain-checker/v1","domain":"` + domain + `","expiration":"2025-01-01T00:00:00Z","notified_expiry":true,"notified_available":false}`
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("failed to write legacy state: %v", err)
+		}
+	}
+
+	manager.Cleanup(time.Now())
+	if _, err := os.Stat(configured); err != nil {
+		t.Errorf("Expected the configured domain's state to be kept, got %v", err)
+	}
+	if _, err := os.Stat(removed); !os.IsNotExist(err) {
+		t.Errorf("Expected the removed domain's state to be removed, got %v", err)
+	}
+
+	// Loading the normalized name moves the state to its current file
+	if st := manager.Load("example.com"); !st.NotifiedExpiry || st.Domain != "example.com" {
+		t.Errorf("Expected the migrated state of example.com, got %+v", st)
+	}
+	if _, err := os.Stat(configured); !os.IsNotExist(err) {
+		t.Errorf("Expected the legacy file to be removed, got %v", err)
+	}
+	if domains, err := manager.List(); err != nil || len(domains) != 1 || domains[0] != "example.com" {
+		t.Errorf("List() = %v (%v), want [example.com]", domains, err)
+	}
+}
+
+func TestCleanup_Retention(t *testing.T) {
+	log := logger.New()
+	cfg := config.New(log)
+	cfg.StateDir = t.TempDir()
+	cfg.Domains = []config.DomainEntry{{Name: "example.com"}}
+	cfg.CleanupRetention = time.Hour
+
+	sqlite, err := NewSQLite(cfg, log)
+	if err != nil {
+		t.Fatalf("NewSQLite() returned error: %v", err)
+	}
+	defer func() {
+		if err := sqlite.Close(); err != nil {
+			t.Errorf("Close() returned error: %v", err)
+		}
+	}()
+
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	for _, backend := range []Backend{New(cfg, log), sqlite} {
+		name := fmt.Sprintf("%T", backend)
+		backend.Save("example.com", DomainState{})
+		backend.Save("removed.com", DomainState{NotifiedAvailable: true})
+
+		// Kept and marked orphaned at first
+		backend.Cleanup(now)
+		st := backend.Load("removed.com")
+		if !st.NotifiedAvailable || !st.OrphanedAt.Equal(now) {
+			t.Errorf("%s: Expected the removed domain's state to be kept and marked orphaned at %s, got %+v", name, now, st)
+		}
+
+		// Still kept within the retention, without moving the mark
+		backend.Cleanup(now.Add(59 * time.Minute))
+		if st := backend.Load("removed.com"); !st.OrphanedAt.Equal(now) {
+			t.Errorf("%s: Expected OrphanedAt to stay at %s, got %s", name, now, st.OrphanedAt)
+		}
+
+		// Removed once the retention passed
+		backend.Cleanup(now.Add(time.Hour))
+		domains, err := backend.List()
+		if err != nil {
+			t.Fatalf("%s: List() returned error: %v", name, err)
+		}
+		if len(domains) != 1 || domains[0] != "example.com" {
+			t.Errorf("%s: List() after the retention = %v, want [example.com]", name, domains)
+		}
+	}
+}
+
+// TestCleanup_Excluded tests that Cleanup keeps the state of excluded domains, configured or not
+func TestCleanup_Excluded(t *testing.T) {
+	log := logger.New()
+	cfg := config.New(log)
+	cfg.StateDir = t.TempDir()
+	cfg.Domains = []config.DomainEntry{{Name: "example.com"}}
+	cfg.ExcludeDomains = []string{"paused.com", "*.example.org"}
+
+	sqlite, err := NewSQLite(cfg, log)
+	if err != nil {
+		t.Fatalf("NewSQLite() returned error: %v", err)
+	}
+	defer func() {
+		if err := sqlite.Close(); err != nil {
+			t.Errorf("Close() returned error: %v", err)
+		}
+	}()
+
+	for _, backend := range []Backend{New(cfg, log), sqlite} {
+		name := fmt.Sprintf("%T", backend)
+		for _, domain := range []string{"example.com", "paused.com", "shop.example.org", "removed.com"} {
+			backend.Save(domain, DomainState{})
+		}
+
+		backend.Cleanup(time.Now())
+		domains, err := backend.List()
+		if err != nil {
+			t.Fatalf("%s: List() returned error: %v", name, err)
+		}
+		if want := []string{"example.com", "paused.com", "shop.example.org"}; !slices.Equal(domains, want) {
+			t.Errorf("%s: List() after Cleanup = %v, want %v", name, domains, want)
+		}
+		if st := backend.Load("paused.com"); !st.OrphanedAt.IsZero() {
+			t.Errorf("%s: Expected an excluded domain not to be marked orphaned, got %s", name, st.OrphanedAt)
+		}
+	}
+}
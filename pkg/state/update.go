@@ -0,0 +1,22 @@
+package state
+
+// Updater is implemented by backends that can read, modify and write a domain's state as one atomic step
+type Updater interface {
+	// Update loads the domain's state and passes it to fn, saving the result if fn returns true
+	Update(domain string, fn func(*DomainState) bool) error
+}
+
+// Update applies fn to the stored state of a domain, saving the result if fn returns true
+// Backends without an Updater, like the file backend, do a Load and a Save, which is only atomic while
+// the caller holds the domain's Lock
+func Update(b Backend, domain string, fn func(*DomainState) bool) error {
+	if u, ok := b.(Updater); ok {
+		return u.Update(domain, fn)
+	}
+
+	st := b.Load(domain)
+	if fn(&st) {
+		b.Save(domain, st)
+	}
+	return nil
+}
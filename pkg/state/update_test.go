@@ -0,0 +1,83 @@
+package state
+
+import (
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+
+	"github.com/mallocator/domain-checker/pkg/config"
+	"github.com/mallocator/domain-checker/pkg/logger"
+)
+
+// TestUpdate_Overlapping tests that overlapping updates from separate processes don't lose each other's changes
+func TestUpdate_Overlapping(t *testing.T) {
+	const runs, updates = 4, 25
+	server := miniredis.RunT(t)
+
+	log := logger.New()
+	cfg := config.New(log)
+	cfg.StateDir = t.TempDir()
+	cfg.StateDSN = filepath.Join(cfg.StateDir, "state.db")
+	cfg.RedisAddr = server.Addr()
+	cfg.LockTimeout = 10 * time.Second
+
+	// Each run opens its own backend, like separate processes sharing the state
+	open := map[string]func() (Backend, error){
+		BackendFile:   func() (Backend, error) { return New(cfg, log), nil },
+		BackendSQLite: func() (Backend, error) { return NewSQLite(cfg, log) },
+		BackendRedis:  func() (Backend, error) { return NewRedis(cfg, log) },
+	}
+	for name, openBackend := range open {
+		var wg sync.WaitGroup
+		for range runs {
+			backend, err := openBackend()
+			if err != nil {
+				t.Fatalf("%s: Failed to open backend: %v", name, err)
+			}
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				defer func() {
+					if err := backend.Close(); err != nil {
+						t.Errorf("%s: Close() returned error: %v", name, err)
+					}
+				}()
+
+				for i := range updates {
+					// The file backend's updates are only atomic under the domain's lock
+					unlock := func() {}
+					if locker, ok := backend.(Locker); ok {
+						if unlock, err = locker.Lock("example.com"); err != nil {
+							t.Errorf("%s: Lock() returned error: %v", name, err)
+							return
+						}
+					}
+					err := Update(backend, "example.com", func(st *DomainState) bool {
+						st.AvailableStreak++
+						return i%5 != 0 // some updates don't change anything
+					})
+					unlock()
+					if err != nil {
+						t.Errorf("%s: Update() returned error: %v", name, err)
+					}
+				}
+			}()
+		}
+		wg.Wait()
+
+		backend, err := openBackend()
+		if err != nil {
+			t.Fatalf("%s: Failed to open backend: %v", name, err)
+		}
+		want := runs * updates * 4 / 5
+		if got := backend.Load("example.com").AvailableStreak; got != want {
+			t.Errorf("%s: Expected %d saved updates, got %d", name, want, got)
+		}
+		if err := backend.Close(); err != nil {
+			t.Errorf("%s: Close() returned error: %v", name, err)
+		}
+	}
+}
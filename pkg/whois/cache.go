@@ -0,0 +1,151 @@
+package whois
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/mallocator/domain-checker/pkg/state"
+)
+
+// cacheSuffix marks WHOIS cache files so they're never mistaken for state files
+const cacheSuffix = ".whois"
+
+// cacheEntry is the on-disk representation of a cached WHOIS response
+type cacheEntry struct {
+	Domain    string    `json:"domain"`
+	Raw       string    `json:"raw"`
+	FetchedAt time.Time `json:"fetched_at"`
+}
+
+// cacheKey returns the cache key of a query for domain to server, or to the registry if server is empty
+func cacheKey(domain, server string) string {
+	if server == "" {
+		return domain
+	}
+	return domain + "@" + server
+}
+
+// cachePath returns the cache file path for a cache key, the domain or "domain@server" for a referral
+// It's named like the domain's state file, so keys that differ only in punctuation don't collide
+func (c *Checker) cachePath(key string) string {
+	return filepath.Join(c.cfg.StateDir, state.FileName(key)+cacheSuffix)
+}
+
+// loadCache returns the cached raw WHOIS data if it's fresher than the TTL
+// ForceWhois skips it, while the fresh responses are still cached
+func (c *Checker) loadCache(key string) (string, bool) {
+	if c.cfg.WhoisCacheTTL <= 0 || c.cfg.ForceWhois {
+		return "", false
+	}
+
+	data, err := os.ReadFile(c.cachePath(key))
+	if err != nil {
+		return "", false
+	}
+
+	var entry cacheEntry
+	if err := json.Unmarshal(data, &entry); err != nil {
+		c.log.Warnf("Parse WHOIS cache error for %s: %v", key, err)
+		return "", false
+	}
+
+	if entry.Raw == "" || time.Since(entry.FetchedAt) > c.cfg.WhoisCacheTTL {
+		return "", false
+	}
+
+	return entry.Raw, true
+}
+
+// saveCache writes the raw WHOIS data for a cache key to the cache
+func (c *Checker) saveCache(key, raw string) {
+	if c.cfg.WhoisCacheTTL <= 0 {
+		return
+	}
+
+	data, err := json.MarshalIndent(cacheEntry{Domain: key, Raw: raw, FetchedAt: time.Now()}, "", "  ")
+	if err != nil {
+		c.log.Errorf("Marshal WHOIS cache error for %s: %v", key, err)
+		return
+	}
+	if err := os.WriteFile(c.cachePath(key), data, 0644); err != nil {
+		c.log.Warnf("Write WHOIS cache error for %s: %v", key, err)
+	}
+}
+
+// dropCache removes the cached response of a query for domain to server, or to the registry if server is empty
+// domain may be given as configured, it's looked up by the name WHOIS is asked about
+func (c *Checker) dropCache(domain, server string) {
+	if c.cfg.WhoisCacheTTL <= 0 {
+		return
+	}
+	name, err := lookupName(domain)
+	if err != nil {
+		return
+	}
+	key := cacheKey(name, server)
+	if err := os.Remove(c.cachePath(key)); err != nil && !os.IsNotExist(err) {
+		c.log.Warnf("Remove WHOIS cache error for %s: %v", key, err)
+	}
+}
+
+// Cleanup removes the cached WHOIS responses and the raw responses saved with SaveWhoisRaw
+// of domains that are no longer configured, and ones under the file names older versions used
+// Cached responses for excluded domains are kept, like their state
+func (c *Checker) Cleanup() {
+	files, err := os.ReadDir(c.cfg.StateDir)
+	if err != nil {
+		c.log.Warnf("Could not read state dir %s: %v", c.cfg.StateDir, err)
+		return
+	}
+
+	keep := make(map[string]bool, len(c.cfg.Domains))
+	keepRaw := make(map[string]bool, len(c.cfg.Domains))
+	for _, d := range c.cfg.NormalizedDomainNames() {
+		if name, err := lookupName(d); err == nil {
+			keep[name] = true
+		}
+		keepRaw[state.FileName(d)+rawSuffix] = true
+	}
+
+	for _, f := range files {
+		path := filepath.Join(c.cfg.StateDir, f.Name())
+		switch {
+		case strings.HasSuffix(f.Name(), rawSuffix):
+			if !keepRaw[f.Name()] {
+				c.removeStale(path)
+			}
+		case strings.HasSuffix(f.Name(), cacheSuffix):
+			if c.staleCache(path, keep) {
+				c.removeStale(path)
+			}
+		}
+	}
+}
+
+// staleCache reports whether a file is a WHOIS cache entry for a domain not in keep or under an old file name
+// Files that aren't cache entries are never stale
+func (c *Checker) staleCache(path string, keep map[string]bool) bool {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return false
+	}
+	var entry cacheEntry
+	if err := json.Unmarshal(data, &entry); err != nil || entry.Domain == "" {
+		return false
+	}
+
+	domain, _, _ := strings.Cut(entry.Domain, "@")
+	return (!keep[domain] && !c.cfg.Excluded(domain)) || path != c.cachePath(entry.Domain)
+}
+
+// removeStale removes a WHOIS file found stale by Cleanup
+func (c *Checker) removeStale(path string) {
+	if err := os.Remove(path); err != nil {
+		c.log.Warnf("Failed to remove stale WHOIS file %s: %v", path, err)
+	} else {
+		c.log.Infof("Removed stale WHOIS file %s", path)
+	}
+}
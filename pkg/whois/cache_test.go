@@ -0,0 +1,266 @@
+package whois
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mallocator/domain-checker/pkg/config"
+	"github.com/mallocator/domain-checker/pkg/logger"
+)
+
+func TestQueryWithRetries_UsesCache(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "whois_cache_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := os.RemoveAll(tmpDir); err != nil {
+			t.Errorf("failed to remove temp directory: %v", err)
+		}
+	}()
+
+	log := logger.New()
+	cfg := config.New(log)
+	cfg.StateDir = tmpDir
+	cfg.WhoisCacheTTL = time.Hour
+	checker := New(cfg, log)
+
+	calls := 0
+	checker.query = func(domain, server string) (string, error) {
+		calls++
+		return "raw whois data", nil
+	}
+
+	for i := 0; i < 3; i++ {
+		if raw, err := checker.QueryWithRetries(context.Background(), "example.com"); err != nil || raw != "raw whois data" {
+			t.Errorf("QueryWithRetries() = %q, %v, want %q", raw, err, "raw whois data")
+		}
+	}
+	if calls != 1 {
+		t.Errorf("Expected 1 network query, got %d", calls)
+	}
+
+	// The cache file must not look like a state file
+	path := checker.cachePath("example.com")
+	if strings.HasSuffix(path, ".json") {
+		t.Errorf("Cache path %q shouldn't use the state file extension", path)
+	}
+	if _, err := os.Stat(path); err != nil {
+		t.Errorf("Expected cache file %q to exist: %v", path, err)
+	}
+}
+
+func TestQueryWithRetries_ExpiredCache(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "whois_cache_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := os.RemoveAll(tmpDir); err != nil {
+			t.Errorf("failed to remove temp directory: %v", err)
+		}
+	}()
+
+	log := logger.New()
+	cfg := config.New(log)
+	cfg.StateDir = tmpDir
+	cfg.WhoisCacheTTL = time.Hour
+	checker := New(cfg, log)
+
+	// Write a cache entry that's older than the TTL
+	data, err := json.Marshal(cacheEntry{Domain: "example.com", Raw: "stale data", FetchedAt: time.Now().Add(-2 * time.Hour)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(checker.cachePath("example.com"), data, 0644); err != nil {
+		t.Fatalf("failed to write cache file: %v", err)
+	}
+
+	checker.query = func(domain, server string) (string, error) {
+		return "fresh data", nil
+	}
+
+	if raw, err := checker.QueryWithRetries(context.Background(), "example.com"); err != nil || raw != "fresh data" {
+		t.Errorf("QueryWithRetries() = %q, %v, want %q", raw, err, "fresh data")
+	}
+}
+
+func TestQueryWithRetries_CacheDisabled(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "whois_cache_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := os.RemoveAll(tmpDir); err != nil {
+			t.Errorf("failed to remove temp directory: %v", err)
+		}
+	}()
+
+	log := logger.New()
+	cfg := config.New(log)
+	cfg.StateDir = tmpDir
+	checker := New(cfg, log)
+
+	calls := 0
+	checker.query = func(domain, server string) (string, error) {
+		calls++
+		return "raw whois data", nil
+	}
+
+	_, _ = checker.QueryWithRetries(context.Background(), "example.com")
+	_, _ = checker.QueryWithRetries(context.Background(), "example.com")
+
+	if calls != 2 {
+		t.Errorf("Expected 2 network queries with the cache disabled, got %d", calls)
+	}
+	if _, err := os.Stat(checker.cachePath("example.com")); !os.IsNotExist(err) {
+		t.Errorf("Expected no cache file to be written, got %v", err)
+	}
+}
+
+func TestQueryWithRetries_ForceWhois(t *testing.T) {
+	log := logger.New()
+	cfg := config.New(log)
+	cfg.StateDir = t.TempDir()
+	cfg.WhoisCacheTTL = time.Hour
+	cfg.ForceWhois = true
+	checker := New(cfg, log)
+
+	calls := 0
+	checker.query = func(domain, server string) (string, error) {
+		calls++
+		return "raw whois data", nil
+	}
+
+	_, _ = checker.QueryWithRetries(context.Background(), "example.com")
+	_, _ = checker.QueryWithRetries(context.Background(), "example.com")
+
+	if calls != 2 {
+		t.Errorf("Expected 2 network queries with ForceWhois, got %d", calls)
+	}
+	// Fresh responses are still cached for runs without it
+	if _, err := os.Stat(checker.cachePath("example.com")); err != nil {
+		t.Errorf("Expected the cache file to be written: %v", err)
+	}
+}
+
+func TestGetDomainInfo_CachesReferral(t *testing.T) {
+	log := logger.New()
+	cfg := config.New(log)
+	cfg.StateDir = t.TempDir()
+	cfg.WhoisCacheTTL = time.Hour
+	checker := New(cfg, log)
+
+	calls := 0
+	checker.query = func(domain, server string) (string, error) {
+		calls++
+		if server == "" {
+			return thinRegistryResponse, nil
+		}
+		return registrarResponse, nil
+	}
+
+	for i := 0; i < 2; i++ {
+		info, err := checker.GetDomainInfo(context.Background(), "google.com")
+		if err != nil || info.ExpirationDate.IsZero() {
+			t.Fatalf("GetDomainInfo() = %+v, %v", info, err)
+		}
+	}
+	if calls != 2 {
+		t.Errorf("Expected the registry and registrar to be queried once each, got %d queries", calls)
+	}
+	for _, key := range []string{"google.com", "google.com@whois.markmonitor.com"} {
+		if _, err := os.Stat(checker.cachePath(key)); err != nil {
+			t.Errorf("Expected cache file for %s: %v", key, err)
+		}
+	}
+}
+
+func TestGetDomainInfo_DoesNotCacheUnusable(t *testing.T) {
+	responses := map[string]string{
+		"limit exceeded": "Your query limit has been exceeded, please try again later\n",
+		"no expiration":  "Domain Name: EXAMPLE.COM\nRegistrar: Example Registrar, Inc.\n",
+	}
+	for name, response := range responses {
+		t.Run(name, func(t *testing.T) {
+			log := logger.New()
+			cfg := config.New(log)
+			cfg.StateDir = t.TempDir()
+			cfg.WhoisCacheTTL = time.Hour
+			checker := New(cfg, log)
+
+			calls := 0
+			checker.query = func(domain, server string) (string, error) {
+				calls++
+				return response, nil
+			}
+
+			for i := 0; i < 2; i++ {
+				_, _ = checker.GetDomainInfo(context.Background(), "example.com")
+			}
+			if calls != 2 {
+				t.Errorf("Expected WHOIS to be asked again, got %d queries", calls)
+			}
+			if _, err := os.Stat(checker.cachePath("example.com")); !os.IsNotExist(err) {
+				t.Errorf("Expected no cache file for an unusable response, got %v", err)
+			}
+		})
+	}
+}
+
+func TestCleanup_Cache(t *testing.T) {
+	log := logger.New()
+	cfg := config.New(log)
+	cfg.StateDir = t.TempDir()
+	cfg.WhoisCacheTTL = time.Hour
+	cfg.Domains = []config.DomainEntry{{Name: "www.example.com"}, {Name: "skipped.net"}}
+	cfg.ExcludeDomains = []string{"skipped.net"}
+	checker := New(cfg, log)
+
+	kept := []string{"example.com", "example.com@whois.markmonitor.com", "skipped.net"}
+	for _, key := range append([]string{"removed.org", "removed.org@whois.markmonitor.com"}, kept...) {
+		checker.saveCache(key, "raw whois data")
+	}
+
+	// Files of older versions, one of them a cache entry under its old name
+	legacy := filepath.Join(cfg.StateDir, "example_com"+cacheSuffix)
+	if err := os.WriteFile(legacy, []byte(`{"domain":"example.com","raw":"raw whois data"}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	other := filepath.Join(cfg.StateDir, "notes"+cacheSuffix)
+	if err := os.WriteFile(other, []byte("not a cache entry"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	checker.Cleanup()
+
+	for _, key := range kept {
+		if _, err := os.Stat(checker.cachePath(key)); err != nil {
+			t.Errorf("Expected the cache of %s to be kept, got %v", key, err)
+		}
+	}
+	for _, path := range []string{checker.cachePath("removed.org"), checker.cachePath("removed.org@whois.markmonitor.com"), legacy} {
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Errorf("Expected %s to be removed, got %v", path, err)
+		}
+	}
+	if _, err := os.Stat(other); err != nil {
+		t.Errorf("Expected a file that isn't a cache entry to be kept, got %v", err)
+	}
+}
+
+func TestCachePath_Collisions(t *testing.T) {
+	log := logger.New()
+	cfg := config.New(log)
+	cfg.StateDir = t.TempDir()
+	checker := New(cfg, log)
+
+	if checker.cachePath("example.com") == checker.cachePath("example_com") {
+		t.Errorf("Expected distinct cache files for example.com and example_com, got %s", checker.cachePath("example.com"))
+	}
+}
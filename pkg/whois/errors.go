@@ -0,0 +1,21 @@
+package whois
+
+import "errors"
+
+// Errors WHOIS lookups wrap, so callers can tell failures apart with errors.Is
+var (
+	// ErrTimeout is returned when a WHOIS query didn't finish within Timeout
+	ErrTimeout = errors.New("WHOIS query timed out")
+
+	// ErrUnsupportedTLD is returned when no WHOIS server is known for the domain's TLD
+	ErrUnsupportedTLD = errors.New("unsupported TLD")
+
+	// ErrParse is returned when a WHOIS response can't be parsed
+	ErrParse = errors.New("WHOIS parse failed")
+
+	// ErrDateFormat is returned when a WHOIS date has none of the known layouts
+	ErrDateFormat = errors.New("unrecognized date format")
+
+	// ErrNoExpiration is returned when a WHOIS response has no expiration date
+	ErrNoExpiration = errors.New("no expiration date in WHOIS data")
+)
@@ -0,0 +1,39 @@
+package whois
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/mallocator/domain-checker/pkg/config"
+	"github.com/mallocator/domain-checker/pkg/logger"
+)
+
+func TestGetDomainInfo_Errors(t *testing.T) {
+	log := logger.New()
+	cfg := config.New(log)
+	cfg.Retries = 1
+	checker := New(cfg, log)
+
+	tests := []struct {
+		name string
+		raw  string
+		want error
+	}{
+		{"unparsable response", "", ErrParse},
+		{"unknown date format", "Domain Name: EXAMPLE.COM\nRegistry Expiry Date: sometime next year\n", ErrDateFormat},
+	}
+	for _, tc := range tests {
+		checker.query = func(domain, server string) (string, error) { return tc.raw, nil }
+		if _, err := checker.GetDomainInfo(context.Background(), "example.com"); !errors.Is(err, tc.want) {
+			t.Errorf("%s: GetDomainInfo() error = %v, want %v", tc.name, err, tc.want)
+		}
+	}
+
+	checker.query = func(domain, server string) (string, error) {
+		return "Domain Name: EXAMPLE.COM\nRegistrar: Example Registrar\n", nil
+	}
+	if _, err := checker.GetExpirationDate(context.Background(), "example.com"); !errors.Is(err, ErrNoExpiration) {
+		t.Errorf("GetExpirationDate() error = %v, want %v", err, ErrNoExpiration)
+	}
+}
@@ -0,0 +1,33 @@
+package whois
+
+import "strings"
+
+// expiryField returns the WHOIS field WhoisExpiryFields names for the TLD of domain, or "" if there's none
+// TLDs are matched ignoring case and a leading dot, the longest one first, so "co.uk" wins over "uk"
+func (c *Checker) expiryField(domain string) string {
+	domain = strings.ToLower(strings.TrimSuffix(domain, "."))
+	field, matched := "", ""
+	for tld, name := range c.cfg.WhoisExpiryFields {
+		tld = strings.ToLower(strings.Trim(tld, ". "))
+		if tld == "" || len(tld) <= len(matched) || (domain != tld && !strings.HasSuffix(domain, "."+tld)) {
+			continue
+		}
+		field, matched = strings.TrimSpace(name), tld
+	}
+	return field
+}
+
+// rawField returns the first non-empty value of a field in a raw WHOIS response, matching its name ignoring case,
+// or "" if the response doesn't have it
+func rawField(raw, name string) string {
+	for _, line := range strings.Split(raw, "\n") {
+		key, value, ok := strings.Cut(line, ":")
+		if !ok || !strings.EqualFold(strings.TrimSpace(key), name) {
+			continue
+		}
+		if value = strings.TrimSpace(value); value != "" {
+			return value
+		}
+	}
+	return ""
+}
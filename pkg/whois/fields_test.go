@@ -0,0 +1,107 @@
+package whois
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/mallocator/domain-checker/pkg/config"
+	"github.com/mallocator/domain-checker/pkg/logger"
+)
+
+func TestExpiryField(t *testing.T) {
+	log := logger.New()
+	cfg := config.New(log)
+	cfg.WhoisExpiryFields = map[string]string{"uk": "Expiry date", ".CO.UK": "Renewal date", "example": "Valid Until"}
+	checker := New(cfg, log)
+
+	tests := []struct {
+		domain string
+		want   string
+	}{
+		{"example.uk", "Expiry date"},
+		{"example.co.uk", "Renewal date"},
+		{"Sub.Example.CO.UK.", "Renewal date"},
+		{"example.com", ""},
+		{"example.notuk", ""},
+	}
+	for _, tc := range tests {
+		if got := checker.expiryField(tc.domain); got != tc.want {
+			t.Errorf("expiryField(%q) = %q, want %q", tc.domain, got, tc.want)
+		}
+	}
+}
+
+func TestGetDomainInfo_ExpiryField(t *testing.T) {
+	log := logger.New()
+	cfg := config.New(log)
+	checker := New(cfg, log)
+
+	tests := []struct {
+		name   string
+		domain string
+		fields map[string]string
+		raw    string
+		want   string
+	}{
+		{
+			name:   "standard field blank",
+			domain: "example.test",
+			fields: map[string]string{"test": "Valid Until"},
+			raw:    "Domain Name: EXAMPLE.TEST\nRegistrar: Example Registrar\nExpiration Date:\nValid Until: 2026-03-01\n",
+			want:   "2026-03-01T00:00:00Z",
+		},
+		{
+			name:   "registrar's date preferred",
+			domain: "example.com",
+			fields: map[string]string{"com": "Registrar Registration Expiration Date"},
+			raw: "Domain Name: EXAMPLE.COM\nRegistry Expiry Date: 2025-08-13T04:00:00Z\n" +
+				"Registrar Registration Expiration Date: 2026-08-13T04:00:00Z\n",
+			want: "2026-08-13T04:00:00Z",
+		},
+		{
+			name:   "falls back to the standard field",
+			domain: "example.com",
+			fields: map[string]string{"com": "Valid Until"},
+			raw:    "Domain Name: EXAMPLE.COM\nRegistry Expiry Date: 2025-08-13T04:00:00Z\n",
+			want:   "2025-08-13T04:00:00Z",
+		},
+		{
+			name:   "unparsable field falls back to the standard field",
+			domain: "example.com",
+			fields: map[string]string{"com": "Valid Until"},
+			raw:    "Domain Name: EXAMPLE.COM\nRegistry Expiry Date: 2025-08-13T04:00:00Z\nValid Until: see registrar\n",
+			want:   "2025-08-13T04:00:00Z",
+		},
+		{
+			name:   "no override for the TLD",
+			domain: "example.test",
+			fields: map[string]string{"com": "Valid Until"},
+			raw:    "Domain Name: EXAMPLE.TEST\nRegistrar: Example Registrar\nValid Until: 2026-03-01\n",
+			want:   "0001-01-01T00:00:00Z",
+		},
+	}
+
+	for _, tc := range tests {
+		cfg.WhoisExpiryFields = tc.fields
+		checker.query = func(domain, server string) (string, error) { return tc.raw, nil }
+
+		info, err := checker.GetDomainInfo(context.Background(), tc.domain)
+		if err != nil {
+			t.Errorf("%s: GetDomainInfo() returned error: %v", tc.name, err)
+			continue
+		}
+		if got := info.ExpirationDate.Format(time.RFC3339); got != tc.want {
+			t.Errorf("%s: ExpirationDate = %s, want %s", tc.name, got, tc.want)
+		}
+	}
+
+	// Without a standard date to fall back to, the unparsable value is an error
+	cfg.WhoisExpiryFields = map[string]string{"org": "Valid Until"}
+	checker.query = func(domain, server string) (string, error) {
+		return "Domain Name: EXAMPLE.ORG\nValid Until: see registrar\n", nil
+	}
+	if _, err := checker.GetDomainInfo(context.Background(), "example.org"); err == nil {
+		t.Errorf("GetDomainInfo() with an unparsable field and no standard date returned nil error")
+	}
+}
@@ -0,0 +1,63 @@
+package whois
+
+import (
+	"bufio"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+
+	"golang.org/x/net/proxy"
+)
+
+// proxyDialer returns a dialer connecting through the proxy at u, using forward to reach the proxy
+func proxyDialer(u *url.URL, forward *net.Dialer) (proxy.Dialer, error) {
+	if u.Scheme == "http" {
+		return &connectDialer{proxy: u, forward: forward}, nil
+	}
+	return proxy.FromURL(u, forward)
+}
+
+// connectDialer tunnels TCP connections through an HTTP proxy with the CONNECT method
+type connectDialer struct {
+	proxy   *url.URL
+	forward *net.Dialer
+}
+
+// Dial connects to addr through the proxy
+func (d *connectDialer) Dial(network, addr string) (net.Conn, error) {
+	conn, err := d.forward.Dial(network, d.proxy.Host)
+	if err != nil {
+		return nil, err
+	}
+
+	req := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Opaque: addr},
+		Host:   addr,
+		Header: make(http.Header),
+	}
+	if user := d.proxy.User; user != nil {
+		password, _ := user.Password()
+		credentials := base64.StdEncoding.EncodeToString([]byte(user.Username() + ":" + password))
+		req.Header.Set("Proxy-Authorization", "Basic "+credentials)
+	}
+	if err := req.Write(conn); err != nil {
+		_ = conn.Close()
+		return nil, fmt.Errorf("proxy: %w", err)
+	}
+
+	// WHOIS servers only talk after the query, so nothing past the response is buffered and lost
+	resp, err := http.ReadResponse(bufio.NewReader(conn), req)
+	if err != nil {
+		_ = conn.Close()
+		return nil, fmt.Errorf("proxy: %w", err)
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		_ = conn.Close()
+		return nil, fmt.Errorf("proxy: CONNECT to %s failed: %s", addr, resp.Status)
+	}
+	return conn, nil
+}
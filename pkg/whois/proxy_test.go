@@ -0,0 +1,94 @@
+package whois
+
+import (
+	"bufio"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/mallocator/domain-checker/pkg/config"
+	"github.com/mallocator/domain-checker/pkg/logger"
+)
+
+// connectRequest is what the fake proxy received
+type connectRequest struct {
+	method, host, auth, query string
+}
+
+// newConnectProxy starts an HTTP proxy on loopback that answers a CONNECT request with status
+// and, once connected, answers the WHOIS query with response
+func newConnectProxy(t *testing.T, status int, response string) (string, <-chan connectRequest) {
+	ln, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Skipf("tcp4 loopback not available: %v", err)
+	}
+	t.Cleanup(func() { _ = ln.Close() })
+
+	requests := make(chan connectRequest, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer func() { _ = conn.Close() }()
+
+		r := bufio.NewReader(conn)
+		req, err := http.ReadRequest(r)
+		if err != nil {
+			t.Errorf("Proxy failed to read the request: %v", err)
+			return
+		}
+		got := connectRequest{method: req.Method, host: req.Host, auth: req.Header.Get("Proxy-Authorization")}
+		_, _ = fmt.Fprintf(conn, "HTTP/1.1 %d %s\r\n\r\n", status, http.StatusText(status))
+		if status == http.StatusOK {
+			got.query, _ = r.ReadString('\n')
+			_, _ = conn.Write([]byte(response))
+		}
+		requests <- got
+	}()
+
+	return ln.Addr().String(), requests
+}
+
+func TestNewClient_HTTPProxy(t *testing.T) {
+	addr, requests := newConnectProxy(t, http.StatusOK, "Domain Name: EXAMPLE.COM\r\n")
+
+	log := logger.New()
+	cfg := config.New(log)
+	cfg.Proxy = "http://user:pass@" + addr
+	query := queryWith(newClient(cfg, log))
+
+	// The proxy resolves the server, so it doesn't have to exist
+	raw, err := query("example.com", "whois.example.invalid")
+	if err != nil {
+		t.Fatalf("query through the proxy returned error: %v", err)
+	}
+	if !strings.Contains(raw, "EXAMPLE.COM") {
+		t.Errorf("Expected the WHOIS response through the proxy, got %q", raw)
+	}
+
+	got := <-requests
+	wantAuth := "Basic " + base64.StdEncoding.EncodeToString([]byte("user:pass"))
+	if got.method != http.MethodConnect || got.host != "whois.example.invalid:43" || got.auth != wantAuth {
+		t.Errorf("Expected CONNECT whois.example.invalid:43 with credentials, got %+v", got)
+	}
+	if got.query != "example.com\r\n" {
+		t.Errorf("Expected the query to be tunneled, got %q", got.query)
+	}
+}
+
+func TestNewClient_HTTPProxyRefused(t *testing.T) {
+	addr, _ := newConnectProxy(t, http.StatusProxyAuthRequired, "")
+
+	log := logger.New()
+	cfg := config.New(log)
+	cfg.Proxy = "http://" + addr
+
+	_, err := queryWith(newClient(cfg, log))("example.com", "whois.example.invalid")
+	if err == nil || !strings.Contains(err.Error(), "407") {
+		t.Errorf("Expected the refused CONNECT to fail the query, got %v", err)
+	}
+}
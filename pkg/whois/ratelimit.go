@@ -0,0 +1,96 @@
+package whois
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mallocator/domain-checker/pkg/backoff"
+)
+
+// rateLimiter spaces out queries per WHOIS server so registries aren't flooded
+// It's safe for concurrent use
+type rateLimiter struct {
+	mu       sync.Mutex
+	interval time.Duration
+	next     map[string]time.Time
+
+	// Slots reserved by waits that were cancelled, handed out again before new ones
+	released map[string][]time.Time
+}
+
+// newRateLimiter creates a limiter allowing perMinute queries per server
+// A non-positive rate disables limiting
+func newRateLimiter(perMinute int) *rateLimiter {
+	var interval time.Duration
+	if perMinute > 0 {
+		interval = time.Minute / time.Duration(perMinute)
+	}
+	return &rateLimiter{
+		interval: interval,
+		next:     make(map[string]time.Time),
+		released: make(map[string][]time.Time),
+	}
+}
+
+// Wait blocks until the next query slot for the given server is available
+// It returns early with ctx's error if ctx is done first, giving the slot back for the next caller
+func (r *rateLimiter) Wait(ctx context.Context, server string) error {
+	if r.interval <= 0 {
+		return nil
+	}
+
+	// Reserve a slot under the lock, then sleep outside of it
+	r.mu.Lock()
+	slot := r.reserve(server, time.Now())
+	r.mu.Unlock()
+
+	if err := backoff.Sleep(ctx, time.Until(slot)); err != nil {
+		r.mu.Lock()
+		r.release(server, slot)
+		r.mu.Unlock()
+		return err
+	}
+	return nil
+}
+
+// reserve returns the earliest free slot for a server, the caller holds mu
+func (r *rateLimiter) reserve(server string, now time.Time) time.Time {
+	// A released slot that already passed can't be used without crowding the next reserved one
+	free := slices.DeleteFunc(r.released[server], func(s time.Time) bool { return s.Before(now) })
+	if len(free) > 0 {
+		slot := slices.MinFunc(free, time.Time.Compare)
+		r.released[server] = slices.DeleteFunc(free, slot.Equal)
+		return slot
+	}
+	r.released[server] = free
+
+	slot := r.next[server]
+	if slot.Before(now) {
+		slot = now
+	}
+	r.next[server] = slot.Add(r.interval)
+	return slot
+}
+
+// release gives back a slot that won't be used, the caller holds mu
+func (r *rateLimiter) release(server string, slot time.Time) {
+	if r.next[server].Equal(slot.Add(r.interval)) {
+		// The latest reservation, so the next caller can simply have it
+		r.next[server] = slot
+		return
+	}
+	r.released[server] = append(r.released[server], slot)
+}
+
+// serverKey derives the rate limit key for a domain
+// Each TLD is served by a single registry WHOIS server, so the TLD identifies it
+func serverKey(domain string) string {
+	domain = strings.ToLower(strings.TrimSuffix(domain, "."))
+	if i := strings.LastIndex(domain, "."); i >= 0 {
+		return domain[i+1:]
+	}
+	return domain
+}
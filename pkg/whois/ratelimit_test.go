@@ -0,0 +1,133 @@
+package whois
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRateLimiter_SpacesQueries(t *testing.T) {
+	// 600 per minute is one query every 100ms
+	limiter := newRateLimiter(600)
+
+	start := time.Now()
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := limiter.Wait(context.Background(), "com"); err != nil {
+				t.Errorf("Wait() returned error: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	// The first query runs immediately, the other three wait one interval each
+	if elapsed := time.Since(start); elapsed < 280*time.Millisecond {
+		t.Errorf("4 queries completed in %s, want at least 300ms", elapsed)
+	}
+}
+
+func TestRateLimiter_IndependentServers(t *testing.T) {
+	limiter := newRateLimiter(60)
+
+	start := time.Now()
+	if err := limiter.Wait(context.Background(), "com"); err != nil {
+		t.Errorf("Wait() returned error: %v", err)
+	}
+	if err := limiter.Wait(context.Background(), "net"); err != nil {
+		t.Errorf("Wait() returned error: %v", err)
+	}
+	if err := limiter.Wait(context.Background(), "org"); err != nil {
+		t.Errorf("Wait() returned error: %v", err)
+	}
+
+	if elapsed := time.Since(start); elapsed > 100*time.Millisecond {
+		t.Errorf("Queries to different servers took %s, want no waiting", elapsed)
+	}
+}
+
+func TestRateLimiter_Disabled(t *testing.T) {
+	limiter := newRateLimiter(0)
+
+	start := time.Now()
+	for i := 0; i < 10; i++ {
+		if err := limiter.Wait(context.Background(), "com"); err != nil {
+			t.Errorf("Wait() returned error: %v", err)
+		}
+	}
+
+	if elapsed := time.Since(start); elapsed > 100*time.Millisecond {
+		t.Errorf("Disabled limiter took %s, want no waiting", elapsed)
+	}
+}
+
+func TestRateLimiter_Cancelled(t *testing.T) {
+	limiter := newRateLimiter(1)
+	if err := limiter.Wait(context.Background(), "com"); err != nil {
+		t.Fatalf("Wait() returned error: %v", err)
+	}
+
+	// The next slot is a minute away, cancelling must not wait for it
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	if err := limiter.Wait(ctx, "com"); err == nil {
+		t.Errorf("Wait() returned nil error after the context was done")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Wait() took %s after the context was done", elapsed)
+	}
+}
+
+func TestRateLimiter_CancelReleasesSlot(t *testing.T) {
+	// 60 per minute is one query every second
+	limiter := newRateLimiter(60)
+	start := time.Now()
+	if err := limiter.Wait(context.Background(), "com"); err != nil {
+		t.Fatalf("Wait() returned error: %v", err)
+	}
+
+	// Two waits for the next slots give up, e.g. because DNS found their domains available
+	var wg sync.WaitGroup
+	for _, timeout := range []time.Duration{10 * time.Millisecond, 50 * time.Millisecond} {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(context.Background(), timeout)
+			defer cancel()
+			if err := limiter.Wait(ctx, "com"); err == nil {
+				t.Errorf("Wait() returned nil error after the context was done")
+			}
+		}()
+	}
+	wg.Wait()
+
+	// The next query gets the first slot back instead of waiting behind the cancelled ones
+	if err := limiter.Wait(context.Background(), "com"); err != nil {
+		t.Fatalf("Wait() returned error: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 1500*time.Millisecond {
+		t.Errorf("Wait() took %s, want about one interval", elapsed)
+	}
+}
+
+func TestServerKey(t *testing.T) {
+	tests := []struct {
+		domain string
+		want   string
+	}{
+		{"example.com", "com"},
+		{"sub.example.co.uk", "uk"},
+		{"EXAMPLE.DE.", "de"},
+		{"localhost", "localhost"},
+	}
+	for _, tc := range tests {
+		if got := serverKey(tc.domain); got != tc.want {
+			t.Errorf("serverKey(%q) = %q, want %q", tc.domain, got, tc.want)
+		}
+	}
+}
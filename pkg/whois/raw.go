@@ -0,0 +1,41 @@
+package whois
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/mallocator/domain-checker/pkg/state"
+)
+
+// rawSuffix marks the raw WHOIS responses saved with SaveWhoisRaw
+const rawSuffix = ".whois.txt"
+
+// maxDebugRaw caps the raw response logged when it couldn't be used, the full one is saved with SaveWhoisRaw
+const maxDebugRaw = 2048
+
+// rawPath returns the file the raw WHOIS response of a domain is saved to, named like the domain's state file
+func (c *Checker) rawPath(domain string) string {
+	return filepath.Join(c.cfg.StateDir, state.FileName(domain)+rawSuffix)
+}
+
+// saveRaw writes the raw WHOIS response of a domain to the state dir if SaveWhoisRaw is set
+// The response is saved as is and may contain the registrant's contact details
+func (c *Checker) saveRaw(domain, raw string) {
+	if !c.cfg.SaveWhoisRaw {
+		return
+	}
+	path := c.rawPath(domain)
+	if err := os.WriteFile(path, []byte(raw), 0600); err != nil {
+		c.log.Warnf("Failed to save raw WHOIS response for %s: %v", domain, err)
+		return
+	}
+	c.log.Debugf("Saved raw WHOIS response for %s to %s", domain, path)
+}
+
+// debugRaw logs the raw WHOIS response of a domain that couldn't be used, truncated to maxDebugRaw
+func (c *Checker) debugRaw(domain, raw string) {
+	if len(raw) > maxDebugRaw {
+		raw = raw[:maxDebugRaw] + "\n[truncated, set SAVE_WHOIS_RAW to keep the full response]"
+	}
+	c.log.Debugf("Raw WHOIS response for %s:\n%s", domain, raw)
+}
@@ -0,0 +1,118 @@
+package whois
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mallocator/domain-checker/pkg/config"
+	"github.com/mallocator/domain-checker/pkg/logger"
+)
+
+func TestSaveRaw(t *testing.T) {
+	log := logger.New()
+	cfg := config.New(log)
+	cfg.StateDir = t.TempDir()
+	checker := New(cfg, log)
+
+	raw := "Domain Name: EXAMPLE.COM\nRegistry Expiry Date: 2025-08-13T04:00:00Z\nRegistrant Email: owner@example.com\n"
+	checker.query = func(domain, server string) (string, error) {
+		return raw, nil
+	}
+
+	// Off by default
+	if _, err := checker.GetDomainInfo(context.Background(), "example.com"); err != nil {
+		t.Fatalf("GetDomainInfo() returned error: %v", err)
+	}
+	if _, err := os.Stat(checker.rawPath("example.com")); !os.IsNotExist(err) {
+		t.Errorf("Expected no raw response file without SaveWhoisRaw, got %v", err)
+	}
+
+	// Saved unredacted and readable by the owner only
+	cfg.SaveWhoisRaw = true
+	if _, err := checker.GetDomainInfo(context.Background(), "example.com"); err != nil {
+		t.Fatalf("GetDomainInfo() returned error: %v", err)
+	}
+	data, err := os.ReadFile(checker.rawPath("example.com"))
+	if err != nil {
+		t.Fatalf("Expected the raw response to be saved: %v", err)
+	}
+	if string(data) != raw {
+		t.Errorf("Saved raw response = %q, want %q", data, raw)
+	}
+	if info, err := os.Stat(checker.rawPath("example.com")); err == nil && info.Mode().Perm() != 0600 {
+		t.Errorf("Raw response file mode = %v, want 0600", info.Mode().Perm())
+	}
+}
+
+func TestGetExpirationDate_DebugRaw(t *testing.T) {
+	var errOut bytes.Buffer
+	log := logger.New()
+	log.SetDebug(true)
+	log.SetOutput(&bytes.Buffer{}, &errOut)
+	cfg := config.New(log)
+	checker := New(cfg, log)
+
+	tests := []struct {
+		name string
+		raw  string
+	}{
+		{"unknown date format", "Domain Name: EXAMPLE.COM\nRegistry Expiry Date: next tuesday\n"},
+		{"no expiration date", "Domain Name: EXAMPLE.COM\nRegistrar: Example Registrar\n"},
+	}
+	for _, tc := range tests {
+		errOut.Reset()
+		checker.query = func(domain, server string) (string, error) {
+			return tc.raw, nil
+		}
+		if _, err := checker.GetExpirationDate(context.Background(), "example.com"); err == nil {
+			t.Errorf("%s: Expected GetExpirationDate() to fail", tc.name)
+		}
+		if !strings.Contains(errOut.String(), "Raw WHOIS response for example.com:\n"+tc.raw) {
+			t.Errorf("%s: Expected the raw response in the debug log, got %q", tc.name, errOut.String())
+		}
+	}
+
+	// Long responses are truncated
+	errOut.Reset()
+	checker.query = func(domain, server string) (string, error) {
+		return "Domain Name: EXAMPLE.COM\n" + strings.Repeat("Remarks: padding\n", 500), nil
+	}
+	if _, err := checker.GetExpirationDate(context.Background(), "example.com"); err == nil {
+		t.Errorf("Expected GetExpirationDate() to fail without an expiration date")
+	}
+	if !strings.Contains(errOut.String(), "[truncated, set SAVE_WHOIS_RAW") || errOut.Len() > maxDebugRaw+500 {
+		t.Errorf("Expected a truncated raw response in the debug log, got %d bytes", errOut.Len())
+	}
+}
+
+func TestCleanup_Raw(t *testing.T) {
+	log := logger.New()
+	cfg := config.New(log)
+	cfg.StateDir = t.TempDir()
+	cfg.SaveWhoisRaw = true
+	cfg.Domains = []config.DomainEntry{{Name: "example.com"}}
+	checker := New(cfg, log)
+
+	for _, domain := range []string{"example.com", "removed.org"} {
+		checker.saveRaw(domain, "raw whois data")
+	}
+	legacy := filepath.Join(cfg.StateDir, "example_com"+rawSuffix)
+	if err := os.WriteFile(legacy, []byte("raw whois data"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	checker.Cleanup()
+
+	if _, err := os.Stat(checker.rawPath("example.com")); err != nil {
+		t.Errorf("Expected the raw response of example.com to be kept, got %v", err)
+	}
+	for _, path := range []string{checker.rawPath("removed.org"), legacy} {
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Errorf("Expected %s to be removed, got %v", path, err)
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package whois
+
+import (
+	"slices"
+	"strings"
+)
+
+// transferLockStatuses are the EPP statuses that keep a domain from being transferred to another registrar
+var transferLockStatuses = []string{"clientTransferProhibited", "serverTransferProhibited"}
+
+// autoRenewKeys are the registrar fields stating whether auto-renew is on, lowercased without separators
+var autoRenewKeys = []string{"autorenew", "autorenewal", "autorenewstatus", "autorenewalstatus"}
+
+// transferLocked reports whether the statuses lock the domain against transfers
+// Returns nil without any statuses, since a registry that reports none doesn't tell either way
+func transferLocked(statuses []string) *bool {
+	if len(statuses) == 0 {
+		return nil
+	}
+	return ptr(slices.ContainsFunc(statuses, func(status string) bool {
+		return slices.ContainsFunc(transferLockStatuses, func(lock string) bool { return strings.EqualFold(status, lock) })
+	}))
+}
+
+// autoRenew reports whether the domain is set to renew automatically
+// Some registrars state it in a field like "Auto Renew: enabled", the last of which wins; without one,
+// the autoRenewPeriod status shows the registry just renewed it automatically
+// Returns nil if neither is there
+func autoRenew(statuses []string, raw string) *bool {
+	var renew *bool
+	for _, line := range strings.Split(raw, "\n") {
+		key, value, ok := strings.Cut(line, ":")
+		if !ok || !isAutoRenewKey(key) {
+			continue
+		}
+		switch strings.ToLower(strings.TrimSpace(value)) {
+		case "yes", "true", "on", "enabled", "active":
+			renew = ptr(true)
+		case "no", "false", "off", "disabled", "inactive":
+			renew = ptr(false)
+		}
+	}
+	if renew != nil {
+		return renew
+	}
+
+	if slices.ContainsFunc(statuses, func(status string) bool { return strings.EqualFold(status, "autoRenewPeriod") }) {
+		return ptr(true)
+	}
+	return nil
+}
+
+// isAutoRenewKey reports whether a WHOIS field name is one of autoRenewKeys, ignoring case, spaces, dashes and underscores
+func isAutoRenewKey(key string) bool {
+	key = strings.NewReplacer(" ", "", "-", "", "_", "").Replace(strings.ToLower(key))
+	return slices.Contains(autoRenewKeys, key)
+}
+
+// ptr returns a pointer to v
+func ptr(v bool) *bool {
+	return &v
+}
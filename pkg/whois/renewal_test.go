@@ -0,0 +1,128 @@
+package whois
+
+import (
+	"context"
+	"testing"
+
+	"github.com/mallocator/domain-checker/pkg/config"
+	"github.com/mallocator/domain-checker/pkg/logger"
+)
+
+// Registry response of a domain the registry just renewed automatically
+const autoRenewedResponse = `Domain Name: EXAMPLE.NET
+Registry Domain ID: 4585133_DOMAIN_NET-VRSN
+Registrar WHOIS Server: whois.example-registrar.com
+Updated Date: 2024-10-02T09:12:44Z
+Creation Date: 2003-10-01T18:30:05Z
+Registry Expiry Date: 2025-10-01T18:30:05Z
+Registrar: Example Registrar, LLC
+Domain Status: autoRenewPeriod https://icann.org/epp#autoRenewPeriod
+Domain Status: clientTransferProhibited https://icann.org/epp#clientTransferProhibited
+DNSSEC: unsigned
+`
+
+// Registrar response stating auto-renew is off
+const autoRenewOffResponse = `Domain Name: example.net
+Registrar WHOIS Server: whois.example-registrar.com
+Registrar Registration Expiration Date: 2025-10-01T18:30:05Z
+Registrar: Example Registrar, LLC
+Domain Status: clientTransferProhibited https://icann.org/epp#clientTransferProhibited
+Auto-Renew: disabled
+`
+
+// Registry response of an unlocked domain without any renewal hints
+const unlockedResponse = `Domain Name: EXAMPLE.ORG
+Registry Domain ID: 2fa7d5b3c9e64a8f_DOMAIN-LROR
+Updated Date: 2024-05-20T11:02:17Z
+Creation Date: 1995-04-25T04:00:00Z
+Registry Expiry Date: 2026-04-26T04:00:00Z
+Registrar: Example Registrar, LLC
+Domain Status: ok https://icann.org/epp#ok
+`
+
+// Registry response without any statuses, as some ccTLDs send
+const noStatusResponse = `Domain Name: example.io
+Registry Expiry Date: 2025-06-30T12:00:00Z
+Registrar: Example Registrar, LLC
+`
+
+func TestGetDomainInfo_Renewal(t *testing.T) {
+	yes, no := true, false
+	tests := []struct {
+		name       string
+		registry   string
+		registrar  string // referral response, empty for none
+		wantLocked *bool
+		wantRenew  *bool
+	}{
+		{"auto renewed", autoRenewedResponse, "", &yes, &yes},
+		{"registrar says off", autoRenewedResponse, autoRenewOffResponse, &yes, &no},
+		{"unlocked", unlockedResponse, "", &no, nil},
+		{"no statuses", noStatusResponse, "", nil, nil},
+	}
+
+	for _, tc := range tests {
+		log := logger.New()
+		cfg := config.New(log)
+		cfg.Retries = 1
+		checker := New(cfg, log)
+		checker.query = func(domain, server string) (string, error) {
+			if server != "" {
+				return tc.registrar, nil
+			}
+			return tc.registry, nil
+		}
+
+		info, err := checker.GetDomainInfo(context.Background(), "example.net")
+		if err != nil {
+			t.Errorf("%s: GetDomainInfo() returned error: %v", tc.name, err)
+			continue
+		}
+		if !equalHint(info.TransferLocked, tc.wantLocked) {
+			t.Errorf("%s: TransferLocked = %s, want %s", tc.name, hint(info.TransferLocked), hint(tc.wantLocked))
+		}
+		if !equalHint(info.AutoRenew, tc.wantRenew) {
+			t.Errorf("%s: AutoRenew = %s, want %s", tc.name, hint(info.AutoRenew), hint(tc.wantRenew))
+		}
+	}
+}
+
+func TestAutoRenew(t *testing.T) {
+	yes, no := true, false
+	tests := []struct {
+		raw  string
+		want *bool
+	}{
+		{"Auto Renew: enabled\n", &yes},
+		{"AUTO_RENEW: Yes\n", &yes},
+		{"autorenewal status: inactive\n", &no},
+		{"Auto-Renew: on\nAuto-Renew: off\n", &no},
+		{"Auto Renew: unknown\n", nil},
+		{"Renewal Date: 2025-01-01\n", nil},
+	}
+
+	for _, tc := range tests {
+		if got := autoRenew(nil, tc.raw); !equalHint(got, tc.want) {
+			t.Errorf("autoRenew(%q) = %s, want %s", tc.raw, hint(got), hint(tc.want))
+		}
+	}
+}
+
+// equalHint reports whether two optional hints are both unknown or both the same value
+func equalHint(a, b *bool) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
+
+// hint formats an optional hint for test messages
+func hint(v *bool) string {
+	if v == nil {
+		return "unknown"
+	}
+	if *v {
+		return "true"
+	}
+	return "false"
+}
@@ -2,73 +2,434 @@
 package whois
 
 import (
+	"context"
+	"errors"
 	"fmt"
-	"math/rand"
+	"net"
+	"strings"
 	"time"
 
 	"github.com/likexian/whois"
 	whoisparser "github.com/likexian/whois-parser"
-	
+
+	"github.com/mallocator/domain-checker/pkg/backoff"
 	"github.com/mallocator/domain-checker/pkg/config"
+	"github.com/mallocator/domain-checker/pkg/idn"
 	"github.com/mallocator/domain-checker/pkg/logger"
+	"github.com/mallocator/domain-checker/pkg/metrics"
 )
 
 // Checker handles WHOIS operations
 type Checker struct {
-	cfg *config.Config
-	log *logger.Logger
+	cfg     *config.Config
+	log     *logger.Logger
+	query   func(domain, server string) (string, error) // an empty server asks the registry
+	limiter *rateLimiter
+
+	// Jitter for the backoff between retries
+	jitter *backoff.Jitter
 }
 
 // New creates a new WHOIS checker
 func New(cfg *config.Config, log *logger.Logger) *Checker {
 	return &Checker{
-		cfg: cfg,
-		log: log,
+		cfg:     cfg,
+		log:     log,
+		query:   queryWith(newClient(cfg, log)),
+		limiter: newRateLimiter(cfg.WhoisRatePerMinute),
+		jitter:  backoff.New(),
+	}
+}
+
+// newClient returns a client that doesn't follow referrals on its own, so referrals are retried, rate limited
+// and cached like any other query
+// Queries stop at the configured timeout, so one queryWithTimeout gave up on doesn't keep its connection open
+// With a proxy configured, the client connects through it
+func newClient(cfg *config.Config, log *logger.Logger) *whois.Client {
+	client := whois.NewClient().SetDisableReferral(true).SetTimeout(cfg.Timeout)
+
+	u, err := cfg.ProxyURL()
+	if err != nil || u == nil {
+		return client // an invalid proxy is rejected by Validate
+	}
+	dialer, err := proxyDialer(u, &net.Dialer{Timeout: cfg.Timeout})
+	if err != nil {
+		log.Warnf("Not using the proxy for WHOIS: %v", err)
+		return client
 	}
+	return client.SetDialer(dialer)
+}
+
+// queryWith returns a function sending a single WHOIS query for domain to server with client,
+// or to the domain's registry if server is empty
+func queryWith(client *whois.Client) func(domain, server string) (string, error) {
+	return func(domain, server string) (string, error) {
+		if server == "" {
+			return client.Whois(domain)
+		}
+		return client.Whois(domain, server)
+	}
+}
+
+// QueryError is returned when a WHOIS query failed
+// Permanent errors, like a TLD without a known WHOIS server, aren't retried since they'd fail again
+type QueryError struct {
+	Domain    string
+	Attempts  int
+	Permanent bool
+	Err       error
+}
+
+func (e *QueryError) Error() string {
+	if e.Permanent {
+		return fmt.Sprintf("WHOIS for %s failed permanently: %v", e.Domain, e.Err)
+	}
+	return fmt.Sprintf("WHOIS for %s failed after %d attempts: %v", e.Domain, e.Attempts, e.Err)
+}
+
+func (e *QueryError) Unwrap() error {
+	return e.Err
+}
+
+// IsPermanent reports whether err is a WHOIS failure that retrying won't fix
+func IsPermanent(err error) bool {
+	var qe *QueryError
+	return errors.As(err, &qe) && qe.Permanent
+}
+
+// permanent reports whether a single query error will fail the same way on every retry
+func permanent(err error) bool {
+	return errors.Is(err, whois.ErrWhoisServerNotFound) || errors.Is(err, whois.ErrDomainEmpty)
 }
 
 // QueryWithRetries performs WHOIS lookup with retries and exponential backoff
-// Returns the raw WHOIS data or empty string if all retries failed
-func (c *Checker) QueryWithRetries(domain string) string {
-	var raw string
-	var err error
+// Returns the raw WHOIS data, ctx's error if it was cancelled, or a *QueryError if the lookup failed
+// Cached data is returned without a network query when it's fresher than WhoisCacheTTL
+func (c *Checker) QueryWithRetries(ctx context.Context, domain string) (string, error) {
+	name, err := lookupName(domain)
+	if err != nil {
+		return "", &QueryError{Domain: domain, Permanent: true, Err: fmt.Errorf("invalid domain name: %w", err)}
+	}
+	return c.queryWithRetries(ctx, name, "")
+}
 
-	for i, backoff := 0, c.cfg.Backoff; i < c.cfg.Retries; i, backoff = i+1, backoff*2 {
-		raw, err = whois.Whois(domain)
+// lookupName returns the name WHOIS is asked about for a domain
+// Registration data belongs to the registrable domain, not to hosts below it, and
+// WHOIS servers only understand the punycode form of internationalized names
+func lookupName(domain string) (string, error) {
+	return idn.ToASCII(config.RegisteredDomain(domain))
+}
+
+// queryWithRetries queries server, or the registry if it's empty, for the raw WHOIS data of domain
+// Responses of referred servers are cached and rate limited separately from the registry's
+func (c *Checker) queryWithRetries(ctx context.Context, domain, server string) (string, error) {
+	key, limiterKey := cacheKey(domain, server), serverKey(domain)
+	if server != "" {
+		limiterKey = server
+	}
+
+	if raw, ok := c.loadCache(key); ok {
+		c.log.Debugf("Using cached WHOIS data for %s", key)
+		return raw, nil
+	}
+
+	err := errors.New("no attempts configured")
+	attempts := 0
+	for i := 0; i < c.cfg.Retries; i++ {
+		if err := c.limiter.Wait(ctx, limiterKey); err != nil {
+			return "", err
+		}
+		attempts++
+		var raw string
+		raw, err = c.queryWithTimeout(ctx, domain, server)
 		if err == nil {
-			return raw
+			c.log.Debugf("Fetched WHOIS data for %s on attempt %d", key, attempts)
+			c.saveCache(key, raw)
+			return raw, nil
+		}
+		if ctx.Err() != nil {
+			c.log.Debugf("WHOIS for %s cancelled: %v", key, err)
+			return "", ctx.Err()
+		}
+		if errors.Is(err, whois.ErrWhoisServerNotFound) {
+			err = fmt.Errorf("%w: %w", ErrUnsupportedTLD, err)
+		}
+		if permanent(err) {
+			return "", &QueryError{Domain: domain, Attempts: attempts, Permanent: true, Err: err}
 		}
 
-		c.log.Debugf("WHOIS retry %d for %s: %v", i+1, domain, err)
+		c.log.Debugf("WHOIS retry %d for %s: %v", i+1, key, err)
+
+		// No point in waiting after the last attempt
+		if i == c.cfg.Retries-1 {
+			break
+		}
+
+		if err := backoff.Sleep(ctx, c.backoff(i)); err != nil {
+			c.log.Debugf("WHOIS for %s cancelled: %v", key, err)
+			return "", err
+		}
+	}
+
+	return "", &QueryError{Domain: domain, Attempts: attempts, Err: err}
+}
+
+// backoff returns the wait after the given failed attempt, starting at 0
+// The delay doubles with each attempt up to MaxBackoff, minus some jitter
+func (c *Checker) backoff(attempt int) time.Duration {
+	return c.jitter.Delay(attempt, c.cfg.Backoff, c.cfg.MaxBackoff)
+}
+
+// queryWithTimeout runs a single WHOIS query bounded by the configured timeout and ctx
+// The underlying library call isn't context-aware, so it runs in a goroutine that
+// is abandoned if the deadline passes or ctx is cancelled first
+func (c *Checker) queryWithTimeout(ctx context.Context, domain, server string) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, c.cfg.Timeout)
+	defer cancel()
 
-		// Add jitter to backoff to prevent thundering herd
-		jitter := time.Duration(rand.Intn(1000)) * time.Millisecond
-		time.Sleep(backoff + jitter)
+	type result struct {
+		raw string
+		err error
 	}
+	done := make(chan result, 1) // buffered so an abandoned query doesn't leak blocked
+	go func() {
+		raw, err := c.query(domain, server)
+		done <- result{raw: raw, err: err}
+	}()
+
+	select {
+	case res := <-done:
+		return res.raw, res.err
+	case <-ctx.Done():
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return "", fmt.Errorf("%w after %s: %w", ErrTimeout, c.cfg.Timeout, ctx.Err())
+		}
+		return "", ctx.Err()
+	}
+}
 
-	c.log.Warnf("WHOIS failed for %s after %d retries: %v", domain, c.cfg.Retries, err)
-	return ""
+// dateLayouts lists the date formats seen in WHOIS responses, most common first
+var dateLayouts = []string{
+	time.RFC3339,                // 2025-05-01T12:34:56Z (gTLDs, .de, .ru)
+	"2006-01-02T15:04:05Z0700",  // 2025-05-01T12:34:56+0000
+	"2006-01-02T15:04:05",       // 2025-05-01T12:34:56
+	"2006-01-02 15:04:05Z07:00", // 2025-05-01 12:34:56+02:00
+	"2006-01-02 15:04:05 MST",   // 2025-05-01 12:34:56 UTC
+	"2006-01-02 15:04:05",       // 2025-05-01 12:34:56
+	"2006-01-02",                // 2025-05-01
+	"2006.01.02 15:04:05",       // 2025.05.01 12:34:56
+	"2006.01.02",                // 2025.05.01 (.ru, .kr)
+	"2006/01/02 15:04:05 (MST)", // 2025/05/01 12:34:56 (JST) (.jp)
+	"2006/01/02 15:04:05 MST",   // 2025/05/01 12:34:56 JST
+	"2006/01/02 15:04:05",       // 2025/05/01 12:34:56
+	"2006/01/02",                // 2025/05/01 (.jp)
+	"02-Jan-2006 15:04:05",      // 01-May-2025 12:34:56
+	"02-Jan-2006",               // 01-May-2025 (.uk)
+	"02.01.2006 15:04:05",       // 01.05.2025 12:34:56
+	"02.01.2006",                // 01.05.2025
+	"January 2 2006",            // May 1 2025
+	time.UnixDate,               // Thu May  1 12:34:56 UTC 2025
 }
 
-// ParseExpiration tries RFC3339 then date-only formats
+// ParseExpiration parses a WHOIS date by trying each known layout in order
 func (c *Checker) ParseExpiration(raw string) (time.Time, error) {
-	if t, err := time.Parse(time.RFC3339, raw); err == nil {
-		return t, nil
+	value := strings.TrimSpace(raw)
+	for _, layout := range dateLayouts {
+		if t, err := time.Parse(layout, value); err == nil {
+			c.log.Debugf("Parsed WHOIS date %q with layout %q", value, layout)
+			return t, nil
+		}
 	}
-	return time.Parse("2006-01-02", raw)
+	return time.Time{}, fmt.Errorf("%w %q", ErrDateFormat, raw)
 }
 
-// GetExpirationDate gets the expiration date for a domain
-func (c *Checker) GetExpirationDate(domain string) (time.Time, error) {
-	raw := c.QueryWithRetries(domain)
-	if raw == "" {
-		return time.Time{}, fmt.Errorf("failed to get WHOIS data")
+// DomainInfo holds the registration details parsed from a WHOIS response
+// Dates the registry doesn't report are left zero-valued
+type DomainInfo struct {
+	ExpirationDate time.Time
+	CreationDate   time.Time
+	UpdatedDate    time.Time
+	Registrar      string
+	Statuses       []string
+
+	// Renewal risk hints, nil when the WHOIS data doesn't tell
+	TransferLocked *bool
+	AutoRenew      *bool
+}
+
+// lookup queries WHOIS for a domain and parses the raw response
+// The raw response returned is followed by the registrar's when a referral was followed
+func (c *Checker) lookup(ctx context.Context, domain string) (whoisparser.WhoisInfo, string, error) {
+	raw, err := c.QueryWithRetries(ctx, domain)
+	if err != nil {
+		if ctx.Err() == nil {
+			metrics.WhoisError()
+		}
+		return whoisparser.WhoisInfo{}, "", err
 	}
 
+	// Responses that don't tell the expiration, like rate limit replies, aren't worth caching
 	parsed, err := whoisparser.Parse(raw)
+	if err != nil || !c.hasExpiration(domain, raw, parsed) {
+		c.dropCache(domain, "")
+	}
 	if err != nil {
-		return time.Time{}, fmt.Errorf("WHOIS parse failed: %w", err)
+		metrics.WhoisError()
+		c.saveRaw(domain, raw)
+		c.debugRaw(domain, raw)
+		return whoisparser.WhoisInfo{}, "", fmt.Errorf("%w: %w", ErrParse, err)
+	}
+
+	if c.cfg.FollowReferral && parsed.Domain != nil {
+		if referred := c.followReferral(ctx, domain, raw, &parsed); referred != "" {
+			raw += "\n" + referred
+		}
+	}
+
+	c.saveRaw(domain, raw)
+	return parsed, raw, nil
+}
+
+// followReferral queries the registrar's WHOIS server a thin registry refers to and takes the expiration
+// date from its response, which can be more accurate than the registry's
+// The registry's data is kept if there's no referral or the registrar can't be queried
+// Returns the registrar's raw response, or "" if it wasn't used
+func (c *Checker) followReferral(ctx context.Context, domain, raw string, parsed *whoisparser.WhoisInfo) string {
+	server := registrarServer(raw)
+	if server == "" {
+		return ""
 	}
 
-	return c.ParseExpiration(parsed.Domain.ExpirationDate)
-}
\ No newline at end of file
+	name, err := lookupName(domain)
+	if err != nil {
+		return ""
+	}
+	referred, err := c.queryWithRetries(ctx, name, server)
+	if err != nil {
+		c.log.Debugf("Ignoring WHOIS referral to %s for %s: %v", server, name, err)
+		return ""
+	}
+	registrar, err := whoisparser.Parse(referred)
+	if err != nil {
+		c.dropCache(name, server)
+		c.log.Debugf("Ignoring WHOIS referral to %s for %s: %v", server, name, err)
+		return ""
+	}
+	if !c.hasExpiration(domain, referred, registrar) {
+		c.dropCache(name, server)
+	}
+
+	if registrar.Domain != nil && registrar.Domain.ExpirationDate != "" {
+		c.log.Debugf("Using the expiration date of %s from %s", name, server)
+		parsed.Domain.ExpirationDate = registrar.Domain.ExpirationDate
+	}
+	return referred
+}
+
+// hasExpiration reports whether a parsed WHOIS response has an expiration date for domain,
+// either the standard one or the field configured for its TLD
+func (c *Checker) hasExpiration(domain, raw string, parsed whoisparser.WhoisInfo) bool {
+	if parsed.Domain != nil && parsed.Domain.ExpirationDate != "" {
+		return true
+	}
+	field := c.expiryField(domain)
+	return field != "" && rawField(raw, field) != ""
+}
+
+// registrarServer returns the registrar's WHOIS server named in a registry response, or "" if there's none
+func registrarServer(raw string) string {
+	// Some registries give a URL instead of a host name
+	value := rawField(raw, "Registrar WHOIS Server")
+	for _, scheme := range []string{"whois://", "https://", "http://"} {
+		value = strings.TrimPrefix(value, scheme)
+	}
+	return strings.Trim(value, "/")
+}
+
+// GetDomainInfo gets the registration dates, registrar and renewal hints for a domain
+func (c *Checker) GetDomainInfo(ctx context.Context, domain string) (DomainInfo, error) {
+	parsed, raw, err := c.lookup(ctx, domain)
+	if err != nil {
+		return DomainInfo{}, err
+	}
+
+	var info DomainInfo
+	if parsed.Registrar != nil {
+		info.Registrar = parsed.Registrar.Name
+	}
+	if parsed.Domain == nil {
+		return info, nil
+	}
+	info.Statuses = parsed.Domain.Status
+	info.TransferLocked = transferLocked(info.Statuses)
+	info.AutoRenew = autoRenew(info.Statuses, raw)
+
+	// A field configured for the TLD replaces the standard expiration date, if the response has it
+	// A value that can't be parsed only counts when there's no standard date to fall back to
+	expiration := parsed.Domain.ExpirationDate
+	if field := c.expiryField(domain); field != "" {
+		value := rawField(raw, field)
+		_, parseErr := c.ParseExpiration(value)
+		switch {
+		case value == "":
+			c.log.Debugf("No %q field in the WHOIS response for %s, using the standard expiration date", field, domain)
+		case parseErr != nil && expiration != "":
+			c.log.Debugf("Can't parse the %q field of the WHOIS response for %s, using the standard expiration date: %v",
+				field, domain, parseErr)
+		default:
+			c.log.Debugf("Using the %q field of the WHOIS response for %s as its expiration date", field, domain)
+			expiration = value
+		}
+	}
+
+	// An expiration date we can't read is an error, since that's what we alert on
+	if expiration == "" {
+		c.log.Debugf("No expiration date found in the WHOIS response for %s", domain)
+		c.debugRaw(domain, raw)
+	} else if info.ExpirationDate, err = c.ParseExpiration(expiration); err != nil {
+		c.debugRaw(domain, raw)
+		return DomainInfo{}, err
+	}
+
+	// The remaining dates are informational only
+	if parsed.Domain.CreatedDate != "" {
+		if info.CreationDate, err = c.ParseExpiration(parsed.Domain.CreatedDate); err != nil {
+			c.log.Debugf("Ignoring creation date for %s: %v", domain, err)
+		}
+	}
+	if parsed.Domain.UpdatedDate != "" {
+		if info.UpdatedDate, err = c.ParseExpiration(parsed.Domain.UpdatedDate); err != nil {
+			c.log.Debugf("Ignoring updated date for %s: %v", domain, err)
+		}
+	}
+
+	return info, nil
+}
+
+// GetStatuses gets the EPP status codes (e.g. clientTransferProhibited, pendingDelete) for a domain
+func (c *Checker) GetStatuses(ctx context.Context, domain string) ([]string, error) {
+	parsed, _, err := c.lookup(ctx, domain)
+	if err != nil {
+		return nil, err
+	}
+
+	if parsed.Domain == nil {
+		return nil, nil
+	}
+
+	return parsed.Domain.Status, nil
+}
+
+// GetExpirationDate gets the expiration date for a domain
+func (c *Checker) GetExpirationDate(ctx context.Context, domain string) (time.Time, error) {
+	info, err := c.GetDomainInfo(ctx, domain)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	if info.ExpirationDate.IsZero() {
+		return time.Time{}, ErrNoExpiration
+	}
+
+	return info.ExpirationDate, nil
+}
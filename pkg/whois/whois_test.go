@@ -1,9 +1,18 @@
 package whois
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"syscall"
 	"testing"
 	"time"
 
+	"github.com/likexian/whois"
+
 	"github.com/mallocator/domain-checker/pkg/config"
 	"github.com/mallocator/domain-checker/pkg/logger"
 )
@@ -34,22 +43,452 @@ func TestParseExpiration(t *testing.T) {
 	checker := New(cfg, log)
 
 	tests := []struct {
+		name string
 		raw  string
 		want string
 		err  bool
 	}{
-		{"2025-05-01T12:34:56Z", "2025-05-01T12:34:56Z", false},
-		{"2025-05-01", "2025-05-01T00:00:00Z", false},
-		{"invalid", "", true},
+		{"rfc3339", "2025-05-01T12:34:56Z", "2025-05-01T12:34:56Z", false},
+		{"date only", "2025-05-01", "2025-05-01T00:00:00Z", false},
+		{"surrounding whitespace", "  2025-05-01\r\n", "2025-05-01T00:00:00Z", false},
+		{".de changed", "2025-05-01T12:34:56+02:00", "2025-05-01T12:34:56+02:00", false},
+		{".uk expiry", "01-May-2025", "2025-05-01T00:00:00Z", false},
+		{".jp expiry", "2025/05/01", "2025-05-01T00:00:00Z", false},
+		{".jp with timezone", "2025/05/01 12:34:56 (JST)", "2025-05-01T12:34:56Z", false},
+		{".jp with time", "2025/05/01 12:34:56", "2025-05-01T12:34:56Z", false},
+		{".ru paid-till", "2025-05-01T21:00:00Z", "2025-05-01T21:00:00Z", false},
+		{".ru dotted", "2025.05.01", "2025-05-01T00:00:00Z", false},
+		{"space separated with zone", "2025-05-01 12:34:56 UTC", "2025-05-01T12:34:56Z", false},
+		{"invalid", "invalid", "", true},
+		{"empty", "", "", true},
 	}
 	for _, tc := range tests {
 		got, err := checker.ParseExpiration(tc.raw)
 		if (err != nil) != tc.err {
-			t.Errorf("ParseExpiration(%q) err = %v, wantErr %v", tc.raw, err, tc.err)
+			t.Errorf("%s: ParseExpiration(%q) err = %v, wantErr %v", tc.name, tc.raw, err, tc.err)
 			continue
 		}
+		if err != nil && !strings.Contains(err.Error(), tc.raw) {
+			t.Errorf("%s: ParseExpiration(%q) error %q doesn't mention the raw value", tc.name, tc.raw, err)
+		}
 		if err == nil && got.Format(time.RFC3339) != tc.want {
-			t.Errorf("ParseExpiration(%q) = %s, want %s", tc.raw, got.Format(time.RFC3339), tc.want)
+			t.Errorf("%s: ParseExpiration(%q) = %s, want %s", tc.name, tc.raw, got.Format(time.RFC3339), tc.want)
+		}
+	}
+}
+
+func TestQueryWithRetries_Timeout(t *testing.T) {
+	log := logger.New()
+	cfg := config.New(log)
+	cfg.Retries = 1
+	cfg.Timeout = 100 * time.Millisecond
+	checker := New(cfg, log)
+
+	// Simulate a WHOIS server that hangs well past the timeout
+	checker.query = func(domain, server string) (string, error) {
+		time.Sleep(5 * time.Second)
+		return "too late", nil
+	}
+
+	start := time.Now()
+	raw, err := checker.QueryWithRetries(context.Background(), "example.com")
+	elapsed := time.Since(start)
+
+	if raw != "" || !errors.Is(err, context.DeadlineExceeded) || !errors.Is(err, ErrTimeout) {
+		t.Errorf("QueryWithRetries() = %q, %v, want a timeout error", raw, err)
+	}
+	if elapsed > time.Second {
+		t.Errorf("QueryWithRetries() took %s, want roughly %s", elapsed, cfg.Timeout)
+	}
+}
+
+func TestQueryWithRetries_IDN(t *testing.T) {
+	log := logger.New()
+	cfg := config.New(log)
+	checker := New(cfg, log)
+
+	var queried string
+	checker.query = func(domain, server string) (string, error) {
+		queried = domain
+		return "raw whois data", nil
+	}
+
+	if raw, err := checker.QueryWithRetries(context.Background(), "münchen.de"); err != nil || raw != "raw whois data" {
+		t.Errorf("QueryWithRetries() = %q, %v, want %q", raw, err, "raw whois data")
+	}
+	if queried != "xn--mnchen-3ya.de" {
+		t.Errorf("Expected WHOIS query for xn--mnchen-3ya.de, got %q", queried)
+	}
+}
+
+func TestQueryWithRetries_Subdomain(t *testing.T) {
+	log := logger.New()
+	cfg := config.New(log)
+	checker := New(cfg, log)
+
+	var queried []string
+	checker.query = func(domain, server string) (string, error) {
+		queried = append(queried, domain)
+		return "raw whois data", nil
+	}
+
+	_, _ = checker.QueryWithRetries(context.Background(), "www.example.co.uk")
+	_, _ = checker.QueryWithRetries(context.Background(), "*.example.com")
+	_, _ = checker.QueryWithRetries(context.Background(), "foo.bar.com.au")
+	if !slices.Equal(queried, []string{"example.co.uk", "example.com", "bar.com.au"}) {
+		t.Errorf("Expected WHOIS queries for the registrable domains, got %v", queried)
+	}
+}
+
+func TestQueryWithRetries_Cancelled(t *testing.T) {
+	log := logger.New()
+	cfg := config.New(log)
+	cfg.Retries = 5
+	cfg.Backoff = 10 * time.Second
+	cfg.Timeout = 10 * time.Second
+	checker := New(cfg, log)
+
+	// Every query fails, so the checker would back off for a long time
+	var calls int
+	checker.query = func(domain, server string) (string, error) {
+		calls++
+		return "", fmt.Errorf("connection refused")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	_, err := checker.GetDomainInfo(ctx, "example.com")
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("GetDomainInfo() took %s after the context was done", elapsed)
+	}
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("GetDomainInfo() error = %v, want the context error", err)
+	}
+	if calls != 1 {
+		t.Errorf("Expected 1 query before cancellation, got %d", calls)
+	}
+}
+
+func TestQueryWithRetries_CancelledDuringBackoff(t *testing.T) {
+	log := logger.New()
+	cfg := config.New(log)
+	cfg.Retries = 3
+	cfg.Backoff = time.Minute
+	cfg.MaxBackoff = time.Minute
+	checker := New(cfg, log)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// Cancel shortly after the first attempt failed, while the checker waits out its backoff
+	checker.query = func(domain, server string) (string, error) {
+		time.AfterFunc(50*time.Millisecond, cancel)
+		return "", fmt.Errorf("connection refused")
+	}
+
+	start := time.Now()
+	_, err := checker.QueryWithRetries(ctx, "example.com")
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("QueryWithRetries() took %s after the context was cancelled", elapsed)
+	}
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("QueryWithRetries() error = %v, want context.Canceled", err)
+	}
+}
+
+func TestQueryWithRetries_PermanentError(t *testing.T) {
+	log := logger.New()
+	cfg := config.New(log)
+	cfg.Retries = 5
+	cfg.Backoff = 10 * time.Second
+	checker := New(cfg, log)
+
+	var calls int
+	checker.query = func(domain, server string) (string, error) {
+		calls++
+		return "", fmt.Errorf("%w: %s", whois.ErrWhoisServerNotFound, domain)
+	}
+
+	_, err := checker.QueryWithRetries(context.Background(), "example.unknowntld")
+	var qe *QueryError
+	if !errors.As(err, &qe) || !qe.Permanent || !IsPermanent(err) {
+		t.Fatalf("Expected a permanent *QueryError, got %v", err)
+	}
+	if calls != 1 || qe.Attempts != 1 {
+		t.Errorf("Expected a single attempt without retries, got %d calls and %d attempts", calls, qe.Attempts)
+	}
+	if !errors.Is(err, whois.ErrWhoisServerNotFound) || !errors.Is(err, ErrUnsupportedTLD) {
+		t.Errorf("Expected the query error to be wrapped, got %v", err)
+	}
+
+	// Malformed names fail before any query
+	if _, err := checker.QueryWithRetries(context.Background(), "bad_name..com"); !IsPermanent(err) || calls != 1 {
+		t.Errorf("Expected a permanent error without a query for a malformed name, got %v after %d calls", err, calls)
+	}
+}
+
+func TestQueryWithRetries_TransientError(t *testing.T) {
+	log := logger.New()
+	cfg := config.New(log)
+	cfg.Retries = 3
+	cfg.Backoff = time.Millisecond
+	checker := New(cfg, log)
+
+	var calls int
+	checker.query = func(domain, server string) (string, error) {
+		calls++
+		return "", syscall.ECONNRESET
+	}
+
+	_, err := checker.QueryWithRetries(context.Background(), "example.com")
+	var qe *QueryError
+	if !errors.As(err, &qe) || qe.Permanent || IsPermanent(err) {
+		t.Fatalf("Expected a transient *QueryError, got %v", err)
+	}
+	if calls != 3 || qe.Attempts != 3 {
+		t.Errorf("Expected 3 attempts, got %d calls and %d attempts", calls, qe.Attempts)
+	}
+	if !errors.Is(err, syscall.ECONNRESET) {
+		t.Errorf("Expected the last query error to be wrapped, got %v", err)
+	}
+}
+
+func TestQueryWithRetries_RetriesAfterTimeout(t *testing.T) {
+	log := logger.New()
+	cfg := config.New(log)
+	cfg.Retries = 2
+	cfg.Backoff = time.Millisecond
+	cfg.Timeout = 50 * time.Millisecond
+	checker := New(cfg, log)
+
+	// The first attempt hangs, the second one answers immediately
+	var calls int32
+	checker.query = func(domain, server string) (string, error) {
+		if atomic.AddInt32(&calls, 1) == 1 {
+			time.Sleep(time.Second)
+		}
+		return "raw whois data", nil
+	}
+
+	if raw, err := checker.QueryWithRetries(context.Background(), "example.com"); err != nil || raw != "raw whois data" {
+		t.Errorf("QueryWithRetries() = %q, %v, want %q", raw, err, "raw whois data")
+	}
+	if got := atomic.LoadInt32(&calls); got != 2 {
+		t.Errorf("Expected 2 WHOIS attempts, got %d", got)
+	}
+}
+
+func TestGetDomainInfo(t *testing.T) {
+	log := logger.New()
+	cfg := config.New(log)
+	checker := New(cfg, log)
+
+	checker.query = func(domain, server string) (string, error) {
+		return "Domain Name: EXAMPLE.COM\n" +
+			"Registrar: Example Registrar, Inc.\n" +
+			"Creation Date: 1995-08-14T04:00:00Z\n" +
+			"Updated Date: 2024-08-14T07:01:34Z\n" +
+			"Registry Expiry Date: 2025-08-13T04:00:00Z\n" +
+			"Domain Status: clientTransferProhibited https://icann.org/epp#clientTransferProhibited\n", nil
+	}
+
+	info, err := checker.GetDomainInfo(context.Background(), "example.com")
+	if err != nil {
+		t.Fatalf("GetDomainInfo() returned error: %v", err)
+	}
+	if len(info.Statuses) != 1 || info.Statuses[0] != "clientTransferProhibited" {
+		t.Errorf("Statuses = %v, want [clientTransferProhibited]", info.Statuses)
+	}
+	if got := info.ExpirationDate.Format(time.RFC3339); got != "2025-08-13T04:00:00Z" {
+		t.Errorf("ExpirationDate = %s, want 2025-08-13T04:00:00Z", got)
+	}
+	if got := info.CreationDate.Format(time.RFC3339); got != "1995-08-14T04:00:00Z" {
+		t.Errorf("CreationDate = %s, want 1995-08-14T04:00:00Z", got)
+	}
+	if got := info.UpdatedDate.Format(time.RFC3339); got != "2024-08-14T07:01:34Z" {
+		t.Errorf("UpdatedDate = %s, want 2024-08-14T07:01:34Z", got)
+	}
+	if info.Registrar != "Example Registrar, Inc." {
+		t.Errorf("Registrar = %q, want %q", info.Registrar, "Example Registrar, Inc.")
+	}
+
+	expDate, err := checker.GetExpirationDate(context.Background(), "example.com")
+	if err != nil {
+		t.Fatalf("GetExpirationDate() returned error: %v", err)
+	}
+	if !expDate.Equal(info.ExpirationDate) {
+		t.Errorf("GetExpirationDate() = %s, want %s", expDate, info.ExpirationDate)
+	}
+}
+
+func TestGetDomainInfo_MissingFields(t *testing.T) {
+	log := logger.New()
+	cfg := config.New(log)
+	checker := New(cfg, log)
+
+	// Registry that only reports the expiration date
+	checker.query = func(domain, server string) (string, error) {
+		return "Domain Name: EXAMPLE.COM\n" +
+			"Registry Expiry Date: 2025-08-13T04:00:00Z\n", nil
+	}
+
+	info, err := checker.GetDomainInfo(context.Background(), "example.com")
+	if err != nil {
+		t.Fatalf("GetDomainInfo() returned error: %v", err)
+	}
+	if info.ExpirationDate.IsZero() {
+		t.Errorf("Expected ExpirationDate to be set")
+	}
+	if !info.CreationDate.IsZero() {
+		t.Errorf("Expected CreationDate to be zero, got %s", info.CreationDate)
+	}
+	if !info.UpdatedDate.IsZero() {
+		t.Errorf("Expected UpdatedDate to be zero, got %s", info.UpdatedDate)
+	}
+	if info.Registrar != "" {
+		t.Errorf("Expected empty Registrar, got %q", info.Registrar)
+	}
+}
+
+func TestGetStatuses(t *testing.T) {
+	log := logger.New()
+	cfg := config.New(log)
+	checker := New(cfg, log)
+
+	checker.query = func(domain, server string) (string, error) {
+		return "Domain Name: EXAMPLE.COM\n" +
+			"Registry Expiry Date: 2025-08-13T04:00:00Z\n" +
+			"Domain Status: redemptionPeriod https://icann.org/epp#redemptionPeriod\n" +
+			"Domain Status: pendingDelete https://icann.org/epp#pendingDelete\n", nil
+	}
+
+	statuses, err := checker.GetStatuses(context.Background(), "example.com")
+	if err != nil {
+		t.Fatalf("GetStatuses() returned error: %v", err)
+	}
+
+	want := []string{"redemptionPeriod", "pendingDelete"}
+	if len(statuses) != len(want) {
+		t.Fatalf("GetStatuses() = %v, want %v", statuses, want)
+	}
+	for i := range want {
+		if statuses[i] != want[i] {
+			t.Errorf("GetStatuses()[%d] = %q, want %q", i, statuses[i], want[i])
+		}
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	log := logger.New()
+	cfg := config.New(log)
+	cfg.Backoff = time.Second
+	cfg.MaxBackoff = 10 * time.Second
+	checker := New(cfg, log)
+
+	for attempt := 0; attempt < 100; attempt++ {
+		want := min(cfg.Backoff<<min(attempt, 10), cfg.MaxBackoff)
+		for range 20 {
+			d := checker.backoff(attempt)
+			if d > cfg.MaxBackoff {
+				t.Fatalf("backoff(%d) = %v, exceeds MaxBackoff %v", attempt, d, cfg.MaxBackoff)
+			}
+			if d < want/2 || d > want {
+				t.Fatalf("backoff(%d) = %v, want between %v and %v", attempt, d, want/2, want)
+			}
+		}
+	}
+}
+
+// thinRegistryResponse is a registry response for a thin registry, shortened from the .com registry
+const thinRegistryResponse = `   Domain Name: GOOGLE.COM
+   Registry Domain ID: 2138514_DOMAIN_COM-VRSN
+   Registrar WHOIS Server: whois.markmonitor.com
+   Registrar URL: http://www.markmonitor.com
+   Updated Date: 2019-09-09T15:39:04Z
+   Creation Date: 1997-09-15T04:00:00Z
+   Registry Expiry Date: 2028-09-14T04:00:00Z
+   Registrar: MarkMonitor Inc.
+   Registrar IANA ID: 292
+   Domain Status: clientDeleteProhibited https://icann.org/epp#clientDeleteProhibited
+   Name Server: NS1.GOOGLE.COM
+   DNSSEC: unsigned
+>>> Last update of whois database: 2024-05-01T12:00:00Z <<<
+`
+
+// registrarResponse is the response of the registrar the thin registry refers to
+const registrarResponse = `Domain Name: google.com
+Registry Domain ID: 2138514_DOMAIN_COM-VRSN
+Registrar WHOIS Server: whois.markmonitor.com
+Updated Date: 2024-08-02T02:17:33+0000
+Creation Date: 1997-09-15T07:00:00+0000
+Registrar Registration Expiration Date: 2028-09-13T07:00:00+0000
+Registrar: MarkMonitor, Inc.
+Domain Status: clientDeleteProhibited (https://www.icann.org/epp#clientDeleteProhibited)
+`
+
+func TestGetDomainInfo_Referral(t *testing.T) {
+	tests := []struct {
+		name        string
+		follow      bool
+		registrar   func() (string, error)
+		wantExpiry  string
+		wantServers []string
+	}{
+		{"followed", true, func() (string, error) { return registrarResponse, nil },
+			"2028-09-13T07:00:00Z", []string{"", "whois.markmonitor.com"}},
+		{"disabled", false, nil,
+			"2028-09-14T04:00:00Z", []string{""}},
+		{"registrar failing", true, func() (string, error) { return "", errors.New("connection refused") },
+			"2028-09-14T04:00:00Z", []string{"", "whois.markmonitor.com"}},
+	}
+
+	for _, tc := range tests {
+		log := logger.New()
+		cfg := config.New(log)
+		cfg.Retries = 1
+		cfg.FollowReferral = tc.follow
+		checker := New(cfg, log)
+
+		var servers []string
+		checker.query = func(domain, server string) (string, error) {
+			servers = append(servers, server)
+			if server == "" {
+				return thinRegistryResponse, nil
+			}
+			return tc.registrar()
+		}
+
+		info, err := checker.GetDomainInfo(context.Background(), "google.com")
+		if err != nil {
+			t.Errorf("%s: GetDomainInfo() returned error: %v", tc.name, err)
+			continue
+		}
+		if got := info.ExpirationDate.UTC().Format(time.RFC3339); got != tc.wantExpiry {
+			t.Errorf("%s: ExpirationDate = %s, want %s", tc.name, got, tc.wantExpiry)
+		}
+		if !slices.Equal(servers, tc.wantServers) {
+			t.Errorf("%s: Queried servers %q, want %q", tc.name, servers, tc.wantServers)
+		}
+	}
+}
+
+func TestRegistrarServer(t *testing.T) {
+	tests := []struct {
+		raw  string
+		want string
+	}{
+		{thinRegistryResponse, "whois.markmonitor.com"},
+		{"Registrar WHOIS Server: whois://whois.example.net/\n", "whois.example.net"},
+		{"registrar whois server: whois.example.net:4343\n", "whois.example.net:4343"},
+		{"Registrar WHOIS Server:\n", ""},
+		{"Domain Name: EXAMPLE.ORG\nRegistrar: Example Registrar\n", ""},
+	}
+
+	for _, tc := range tests {
+		if got := registrarServer(tc.raw); got != tc.want {
+			t.Errorf("registrarServer(%q) = %q, want %q", tc.raw, got, tc.want)
 		}
 	}
 }
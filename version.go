@@ -0,0 +1,39 @@
+package main
+
+import (
+	"fmt"
+	"runtime"
+	"runtime/debug"
+)
+
+// Build information, set with -ldflags "-X main.version=v1.2.3 -X main.commit=... -X main.date=..."
+var (
+	version = "dev"
+	commit  = ""
+	date    = ""
+)
+
+// versionString describes the running build, e.g. "v1.2.3 (commit 1a2b3c4, built 2025-05-01T12:00:00Z, go1.24.2)"
+// Without ldflags the commit comes from the VCS information Go embeds when building from a checkout
+func versionString() string {
+	rev := commit
+	if info, ok := debug.ReadBuildInfo(); ok && rev == "" {
+		for _, s := range info.Settings {
+			if s.Key == "vcs.revision" {
+				rev = s.Value
+			}
+		}
+	}
+	if len(rev) > 7 {
+		rev = rev[:7]
+	}
+
+	details := ""
+	if rev != "" {
+		details += "commit " + rev + ", "
+	}
+	if date != "" {
+		details += "built " + date + ", "
+	}
+	return fmt.Sprintf("%s (%s%s)", version, details, runtime.Version())
+}